@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/olimci/tohru/pkg/store"
+	"github.com/urfave/cli/v3"
+)
+
+func catCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "cat",
+		Usage:     "print the content the loaded profile declares for a path",
+		ArgsUsage: "<dest>",
+		Action:    catAction,
+	}
+}
+
+func catAction(_ context.Context, cmd *cli.Command) error {
+	args := cmd.Args().Slice()
+	if len(args) != 1 {
+		return fmt.Errorf("cat expects exactly one destination path")
+	}
+
+	s, err := store.OpenDefault()
+	if err != nil {
+		return err
+	}
+
+	declared, err := s.Lookup(args[0])
+	if err != nil {
+		return err
+	}
+
+	switch declared.Kind {
+	case "link":
+		_, err = fmt.Println(declared.Source)
+		return err
+	case "file":
+		if declared.Source == "" {
+			_, err = io.WriteString(os.Stdout, declared.Content)
+			return err
+		}
+		f, err := os.Open(declared.Source)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(os.Stdout, f)
+		return err
+	default:
+		return fmt.Errorf("%s is a %s entry, there is no file content to print", declared.Dest, declared.Kind)
+	}
+}
@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/muesli/termenv"
+	"github.com/urfave/cli/v3"
+)
+
+const (
+	colorGreen  = lipgloss.Color("42")
+	colorYellow = lipgloss.Color("214")
+	colorRed    = lipgloss.Color("203")
+)
+
+// colorFlag is the --color flag of commands with colored output.
+func colorFlag() *cli.StringFlag {
+	return &cli.StringFlag{
+		Name:  "color",
+		Usage: "color mode: auto|always|never (auto colors terminals unless NO_COLOR is set or TERM=dumb)",
+		Value: "auto",
+		Validator: func(mode string) error {
+			switch strings.ToLower(strings.TrimSpace(mode)) {
+			case "", "auto", "always", "never":
+				return nil
+			default:
+				return fmt.Errorf("invalid color mode %q (expected auto, always or never)", mode)
+			}
+		},
+	}
+}
+
+// colorEnabled reports whether output to stdout is colored under mode. Auto
+// colors only terminals, and never when NO_COLOR is set or TERM is dumb.
+func colorEnabled(mode string, stdout *os.File) bool {
+	switch strings.ToLower(strings.TrimSpace(mode)) {
+	case "always":
+		return true
+	case "never":
+		return false
+	default:
+		if strings.TrimSpace(os.Getenv("NO_COLOR")) != "" {
+			return false
+		}
+		return isTTY(stdout) && strings.ToLower(strings.TrimSpace(os.Getenv("TERM"))) != "dumb"
+	}
+}
+
+func isTTY(stdout *os.File) bool {
+	if stdout == nil {
+		return false
+	}
+	info, err := stdout.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// newColorRenderer returns a renderer that emits colors exactly when color is
+// set. lipgloss would otherwise detect the terminal itself, dropping colors
+// that --color=always asked for when stdout is a pipe.
+func newColorRenderer(color bool) *lipgloss.Renderer {
+	r := lipgloss.NewRenderer(io.Discard)
+	if color {
+		r.SetColorProfile(termenv.ANSI256)
+	} else {
+		r.SetColorProfile(termenv.Ascii)
+	}
+	return r
+}
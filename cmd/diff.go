@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/olimci/tohru/pkg/store"
+	"github.com/urfave/cli/v3"
+)
+
+func diffCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "diff",
+		Usage:     "show what loading a profile would change compared to what is loaded, without loading it",
+		ArgsUsage: "<profile>",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "json",
+				Usage: "print the differences as JSON",
+			},
+		},
+		Action: diffAction,
+	}
+}
+
+func diffAction(_ context.Context, cmd *cli.Command) error {
+	if cmd.Args().Len() != 1 {
+		return usageError("diff expects exactly one profile argument")
+	}
+
+	s, err := store.OpenDefault()
+	if err != nil {
+		return err
+	}
+
+	diff, err := s.DiffSource(cmd.Args().First())
+	if err != nil {
+		return err
+	}
+	if cmd.Bool("json") {
+		return printJSON(diff)
+	}
+	printSourceDiff(diff)
+	return nil
+}
+
+// printSourceDiff prints one line per changed destination of diff, followed
+// by the content diff of overwritten files. Like unload --plan, it prints
+// even with --quiet.
+func printSourceDiff(diff store.SourceDiff) {
+	header := "loading " + diff.ProfileName
+	if diff.Replaces != "" {
+		header += " in place of " + diff.Replaces
+	}
+	if len(diff.Paths) == 0 {
+		fmt.Printf("%s would change nothing (%d destination(s) already match)\n", header, diff.UnchangedCount)
+		return
+	}
+
+	fmt.Printf("%s would:\n", header)
+	for _, path := range diff.Paths {
+		var action string
+		switch path.Change {
+		case store.DiffNew:
+			action = "create"
+		case store.DiffOverwrite:
+			action = "overwrite"
+		case store.DiffAbandon:
+			action = "unload"
+		}
+		note := path.Kind
+		if path.Change == store.DiffOverwrite && !path.Tracked {
+			note += ", not managed by tohru"
+		}
+		fmt.Printf("  %s %s (%s)\n", action, path.Path, note)
+		for line := range strings.Lines(path.Diff) {
+			fmt.Printf("      %s", line)
+		}
+	}
+	if diff.UnchangedCount > 0 {
+		fmt.Printf("%d destination(s) already match\n", diff.UnchangedCount)
+	}
+}
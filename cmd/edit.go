@@ -0,0 +1,155 @@
+package cmd
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/olimci/tohru/pkg/manifest"
+	"github.com/olimci/tohru/pkg/store"
+	"github.com/urfave/cli/v3"
+)
+
+const defaultEditor = "vi"
+
+func editCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "edit",
+		Usage: "open the loaded profile manifest (or config) in $EDITOR",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "config",
+				Usage: "edit the store config instead of the profile manifest",
+			},
+			&cli.BoolFlag{
+				Name:  "reload",
+				Usage: "reload the profile after a successful edit",
+			},
+			&cli.BoolFlag{
+				Name:    "force",
+				Aliases: []string{"f"},
+				Usage:   "with --reload, allow clobbering existing paths",
+				Sources: cli.EnvVars("TOHRU_FORCE"),
+			},
+			&cli.BoolFlag{
+				Name:    "discard-changes",
+				Usage:   "with --reload, allow replacing modified managed files without enabling full force behavior",
+				Sources: cli.EnvVars("TOHRU_DISCARD_CHANGES"),
+			},
+			&cli.BoolFlag{
+				Name:    "force-backup",
+				Usage:   "with --reload, back up drifted managed files before overwriting or removing them",
+				Sources: cli.EnvVars("TOHRU_FORCE_BACKUP"),
+			},
+			&cli.StringFlag{
+				Name:    "umask",
+				Usage:   "with --reload, octal mask applied to created files and directories (e.g. 077)",
+				Sources: cli.EnvVars("TOHRU_UMASK"),
+			},
+			&cli.StringFlag{
+				Name:    "rollback",
+				Value:   string(store.RollbackStrict),
+				Usage:   "with --reload, how to undo a failure part-way: strict, best-effort or leave",
+				Sources: cli.EnvVars("TOHRU_ROLLBACK"),
+			},
+			&cli.IntFlag{
+				Name:    "retries",
+				Usage:   "with --reload, retry filesystem changes failing with transient errors this many times",
+				Sources: cli.EnvVars("TOHRU_RETRIES"),
+			},
+			&cli.BoolFlag{
+				Name:  "parents",
+				Value: true,
+				Usage: "with --reload, create missing destination parent directories (--parents=false fails instead)",
+			},
+		},
+		Action: editAction,
+	}
+}
+
+func editAction(ctx context.Context, cmd *cli.Command) error {
+	args := cmd.Args().Slice()
+	if len(args) > 0 {
+		return usageError("edit does not accept arguments")
+	}
+
+	s, err := store.OpenDefault()
+	if err != nil {
+		return err
+	}
+	if !s.IsInstalled() {
+		return fmt.Errorf("tohru is not installed, run `tohru install` first")
+	}
+
+	if cmd.Bool("config") {
+		if err := runEditor(ctx, s.ConfigPath()); err != nil {
+			return err
+		}
+		if _, err := s.LoadConfig(); err != nil {
+			return fmt.Errorf("config is invalid: %w", err)
+		}
+		printf(cmd, "config %s is valid\n", s.ConfigPath())
+		return nil
+	}
+
+	lck, err := s.LoadState()
+	if err != nil {
+		return err
+	}
+	profileDir := strings.TrimSpace(lck.Profile.Path)
+	if strings.ToLower(lck.Profile.State) != "loaded" || profileDir == "" {
+		return fmt.Errorf("no profile is loaded")
+	}
+	// The manifest of an archive or remote profile is extracted into the
+	// store, and reloading extracts it again over any edit.
+	if from := cmp.Or(lck.Profile.URL, lck.Profile.Archive); from != "" {
+		return fmt.Errorf("the loaded profile was extracted from %s, edit its source and load it again instead", from)
+	}
+
+	manifestPath, _, err := manifest.Locate(profileDir)
+	if err != nil {
+		return err
+	}
+	if err := runEditor(ctx, manifestPath); err != nil {
+		return err
+	}
+	if _, _, err := manifest.Load(profileDir); err != nil {
+		return fmt.Errorf("manifest is invalid: %w", err)
+	}
+	printf(cmd, "manifest %s is valid\n", manifestPath)
+
+	if !cmd.Bool("reload") {
+		return nil
+	}
+	opts := cmdOptions(cmd)
+	res, err := s.Reload(opts)
+	if err != nil {
+		return err
+	}
+	printReload(cmd, opts, res)
+	return nil
+}
+
+// runEditor opens path in $VISUAL, then $EDITOR, falling back to vi.
+func runEditor(ctx context.Context, path string) error {
+	editor := strings.TrimSpace(os.Getenv("VISUAL"))
+	if editor == "" {
+		editor = strings.TrimSpace(os.Getenv("EDITOR"))
+	}
+	if editor == "" {
+		editor = defaultEditor
+	}
+
+	fields := strings.Fields(editor)
+	c := exec.CommandContext(ctx, fields[0], append(fields[1:], path)...)
+	c.Stdin = os.Stdin
+	c.Stdout = os.Stdout
+	c.Stderr = os.Stderr
+	if err := c.Run(); err != nil {
+		return fmt.Errorf("run editor %q: %w", editor, err)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/olimci/tohru/pkg/manifest"
+	"github.com/olimci/tohru/pkg/store"
+)
+
+func TestEditReloadTakesReloadFlags(t *testing.T) {
+	t.Setenv("TOHRU_STORE_DIR", t.TempDir())
+	t.Setenv("VISUAL", "")
+	t.Setenv("EDITOR", "true")
+
+	home := t.TempDir()
+	profile := t.TempDir()
+	m := manifest.Manifest{
+		Schema:  manifest.SchemaVersion,
+		Profile: manifest.Profile{Slug: "test"},
+		Roots: []manifest.Root{{
+			Source:   "home",
+			Dest:     home,
+			Defaults: &manifest.Defaults{Type: "copy"},
+			Tree:     manifest.Tree{".zshrc": manifest.FileNode()},
+		}},
+	}
+	if err := manifest.Write(filepath.Join(profile, manifest.Name), m); err != nil {
+		t.Fatalf("manifest.Write() error = %v", err)
+	}
+	if err := os.MkdirAll(filepath.Join(profile, "home"), 0o755); err != nil {
+		t.Fatalf("MkdirAll() error = %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(profile, "home", "dot_zshrc"), []byte("managed\n"), 0o644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	run := func(args ...string) error {
+		return Execute(context.Background(), append([]string{"tohru", "--quiet"}, args...))
+	}
+	if err := run("install"); err != nil {
+		t.Fatalf("install error = %v", err)
+	}
+	if err := run("load", profile); err != nil {
+		t.Fatalf("load error = %v", err)
+	}
+
+	// A drifted managed file makes the reload refuse unless told otherwise.
+	zshrc := filepath.Join(home, ".zshrc")
+	if err := os.WriteFile(zshrc, []byte("edited\n"), 0o644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(profile, "home", "dot_zshrc"), []byte("updated\n"), 0o644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if err := run("edit", "--reload"); ExitCode(err) != ExitNeedsForce {
+		t.Fatalf("edit --reload error = %v, want it refused without --discard-changes", err)
+	}
+	if err := run("edit", "--reload", "--discard-changes", "--rollback", "best-effort", "--retries", "1"); err != nil {
+		t.Fatalf("edit --reload --discard-changes error = %v", err)
+	}
+	raw, err := os.ReadFile(zshrc)
+	if err != nil || string(raw) != "updated\n" {
+		t.Fatalf(".zshrc = %q, %v, want %q", raw, err, "updated\n")
+	}
+
+	// Reloading extracts an archive again, so its manifest isn't edited.
+	s, err := store.OpenDefault()
+	if err != nil {
+		t.Fatalf("OpenDefault() error = %v", err)
+	}
+	lck, err := s.LoadState()
+	if err != nil {
+		t.Fatalf("LoadState() error = %v", err)
+	}
+	lck.Profile.Archive = filepath.Join(t.TempDir(), "dotfiles.tar.gz")
+	if err := s.SaveState(lck); err != nil {
+		t.Fatalf("SaveState() error = %v", err)
+	}
+	if err := run("edit"); err == nil || !strings.Contains(err.Error(), "extracted from") {
+		t.Fatalf("edit of an archive profile error = %v, want it refused", err)
+	}
+}
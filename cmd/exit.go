@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/olimci/tohru/pkg/store"
+	"github.com/urfave/cli/v3"
+)
+
+// Exit codes tohru exits with, for scripts to branch on.
+const (
+	ExitOK           = 0
+	ExitFailure      = 1 // any error not covered below
+	ExitUsage        = 2 // bad arguments or flags
+	ExitNotInstalled = 3 // the store isn't installed
+	ExitProblems     = 4 // status --exit-code found a problem selected by --fail-on
+	ExitNeedsForce   = 5 // load, reload or unload refused without --force
+)
+
+// ExitError is an error that makes tohru exit with Code.
+type ExitError struct {
+	Code int
+	Err  error
+}
+
+func (e *ExitError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ExitError) Unwrap() error {
+	return e.Err
+}
+
+// usageError reports bad arguments or flags.
+func usageError(format string, args ...any) error {
+	return &ExitError{Code: ExitUsage, Err: fmt.Errorf(format, args...)}
+}
+
+// ExitCode returns the code to exit with after Execute returned err.
+func ExitCode(err error) int {
+	var exitErr *ExitError
+	switch {
+	case err == nil:
+		return ExitOK
+	case errors.As(err, &exitErr):
+		return exitErr.Code
+	case errors.Is(err, store.ErrNotInstalled):
+		return ExitNotInstalled
+	case errors.Is(err, store.ErrNeedsForce):
+		return ExitNeedsForce
+	default:
+		return ExitFailure
+	}
+}
+
+// onUsageError makes flag parsing errors of cmd and its subcommands exit
+// with ExitUsage.
+func onUsageError(cmd *cli.Command) {
+	cmd.OnUsageError = func(_ context.Context, _ *cli.Command, err error, _ bool) error {
+		return &ExitError{Code: ExitUsage, Err: err}
+	}
+	for _, sub := range cmd.Commands {
+		onUsageError(sub)
+	}
+}
@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"context"
+	"strings"
+
+	"github.com/olimci/tohru/pkg/store"
+	"github.com/urfave/cli/v3"
+)
+
+func exportCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "export",
+		Usage: "pack the loaded profile for another machine",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "bundle",
+				Usage: "write the loaded profile's source, config and state to this .tar.gz, for import --bundle",
+			},
+		},
+		Action: exportAction,
+	}
+}
+
+func exportAction(_ context.Context, cmd *cli.Command) error {
+	if cmd.Args().Len() > 0 {
+		return usageError("export does not accept arguments")
+	}
+	bundle := strings.TrimSpace(cmd.String("bundle"))
+	if bundle == "" {
+		return usageError("export needs --bundle")
+	}
+
+	s, err := store.OpenDefault()
+	if err != nil {
+		return err
+	}
+
+	res, err := s.ExportBundle(bundle)
+	if err != nil {
+		return err
+	}
+
+	printf(cmd, "bundled %s (%d file(s)) into %s\n", res.ProfileName, res.FileCount, res.Path)
+	printWarnings(cmd, res.Warnings)
+	return nil
+}
@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"context"
+
+	"github.com/olimci/tohru/pkg/store"
+	"github.com/urfave/cli/v3"
+)
+
+func gcCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "gc",
+		Usage: "remove broken and unreferenced backups, leftover temporary files and stale caches",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "list what would be removed without removing it",
+			},
+		},
+		Action: gcAction,
+	}
+}
+
+func gcAction(_ context.Context, cmd *cli.Command) error {
+	args := cmd.Args().Slice()
+	if len(args) > 0 {
+		return usageError("gc does not accept arguments")
+	}
+
+	s, err := store.OpenDefault()
+	if err != nil {
+		return err
+	}
+
+	dryRun := cmd.Bool("dry-run")
+	res, err := s.GC(store.GCOptions{DryRun: dryRun})
+	if err != nil {
+		return err
+	}
+
+	verb := "removed"
+	if dryRun {
+		verb = "would remove"
+		for _, path := range res.RemovedPaths {
+			printf(cmd, "would remove %s\n", path)
+		}
+	}
+	printf(cmd, "%s %d broken backup(s), %d unreferenced backup(s), %d temporary file(s), %d stale source cache entr(ies)\n",
+		verb, res.BrokenBackupCount, res.UnreferencedBackupCount, res.TempCount, res.SourceCacheCount)
+	printWarnings(cmd, res.Warnings)
+	printChanges(cmd, res.ChangedPaths)
+	return nil
+}
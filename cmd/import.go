@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"context"
+	"strings"
+
+	"github.com/olimci/tohru/pkg/store"
+	"github.com/urfave/cli/v3"
+)
+
+func importCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "import",
+		Usage: "load a profile packed by export",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "bundle",
+				Usage: "check this bundle from export --bundle, apply its config and load its profile",
+			},
+			&cli.BoolFlag{
+				Name:    "force",
+				Aliases: []string{"f"},
+				Usage:   "overwrite existing files or modified managed files",
+				Sources: cli.EnvVars("TOHRU_FORCE"),
+			},
+			&cli.BoolFlag{
+				Name:    "discard-changes",
+				Usage:   "allow replacing modified managed files without enabling full force behavior",
+				Sources: cli.EnvVars("TOHRU_DISCARD_CHANGES"),
+			},
+			&cli.BoolFlag{
+				Name:    "force-backup",
+				Usage:   "back up drifted managed files before overwriting or removing them",
+				Sources: cli.EnvVars("TOHRU_FORCE_BACKUP"),
+			},
+			&cli.BoolFlag{
+				Name:    "keep-state-backup",
+				Usage:   "copy the state file to state.json.bak before changing it, for state restore",
+				Sources: cli.EnvVars("TOHRU_KEEP_STATE_BACKUP"),
+			},
+			&cli.BoolFlag{
+				Name:  "no-backup",
+				Usage: "don't back up existing destinations before replacing them (entries flagged backup still are)",
+			},
+		},
+		Action: importAction,
+	}
+}
+
+func importAction(_ context.Context, cmd *cli.Command) error {
+	if cmd.Args().Len() > 0 {
+		return usageError("import does not accept arguments")
+	}
+	bundle := strings.TrimSpace(cmd.String("bundle"))
+	if bundle == "" {
+		return usageError("import needs --bundle")
+	}
+
+	s, err := store.OpenDefault()
+	if err != nil {
+		return err
+	}
+
+	res, err := s.ImportBundle(bundle, cmdOptions(cmd))
+	if err != nil {
+		return err
+	}
+
+	if res.Skipped {
+		printf(cmd, "%s is already loaded and up to date (%d tracked object(s))\n", res.ProfileName, res.TrackedCount)
+		printWarnings(cmd, res.Warnings)
+		return nil
+	}
+	if res.UnloadedProfileName != "" || res.UnloadedTrackedCount > 0 {
+		name := res.UnloadedProfileName
+		if name == "" {
+			name = "previous profile"
+		}
+		printf(cmd, "unloaded %s (%d managed object(s))\n", name, res.UnloadedTrackedCount)
+	}
+	printf(cmd, "imported %s (%d tracked object(s))\n", res.ProfileName, res.TrackedCount)
+	printStashed(cmd, res.StashedPaths)
+	printWarnings(cmd, res.Warnings)
+	printChanges(cmd, res.ChangedPaths)
+	return nil
+}
@@ -19,6 +19,34 @@ func installCommand() *cli.Command {
 				Name:    "force",
 				Aliases: []string{"f"},
 				Usage:   "treat an existing install as success and still process the optional profile",
+				Sources: cli.EnvVars("TOHRU_FORCE"),
+			},
+			&cli.BoolFlag{
+				Name:  "ensure",
+				Usage: "create whatever the store is missing, succeeding if it is already installed",
+			},
+			&cli.BoolFlag{
+				Name:  "check",
+				Usage: "only report whether tohru is installed, exiting 3 if it isn't; nothing is created",
+			},
+			&cli.BoolFlag{
+				Name:  "ignore-version",
+				Usage: "load profiles that require a newer minor or patch version of tohru",
+			},
+			&cli.StringFlag{
+				Name:    "umask",
+				Usage:   "octal mask applied to created files and directories (e.g. 077)",
+				Sources: cli.EnvVars("TOHRU_UMASK"),
+			},
+			&cli.IntFlag{
+				Name:    "retries",
+				Usage:   "retry filesystem changes failing with transient errors (EAGAIN, ESTALE) this many times",
+				Sources: cli.EnvVars("TOHRU_RETRIES"),
+			},
+			&cli.BoolFlag{
+				Name:  "parents",
+				Value: true,
+				Usage: "create missing destination parent directories (--parents=false fails instead)",
 			},
 		},
 	}
@@ -29,58 +57,103 @@ func installAction(_ context.Context, cmd *cli.Command) error {
 	profile := ""
 
 	if len(args) > 1 {
-		return fmt.Errorf("install accepts at most one optional profile argument")
+		return usageError("install accepts at most one optional profile argument")
 	}
 	if len(args) == 1 {
 		profile = args[0]
 	}
 	opts := cmdOptions(cmd)
+	if cmd.Bool("check") && (cmd.Bool("ensure") || profile != "") {
+		return usageError("install --check takes no profile and can't be combined with --ensure")
+	}
 
-	s, err := store.DefaultStore()
+	s, err := store.OpenDefault()
 	if err != nil {
 		return err
 	}
 
 	alreadyInstalled := s.IsInstalled()
+	if cmd.Bool("check") {
+		if !alreadyInstalled {
+			return fmt.Errorf("%w in %s", store.ErrNotInstalled, s.Root)
+		}
+		printf(cmd, "tohru is installed in %s\n", s.Root)
+		return nil
+	}
+	if cmd.Bool("ensure") {
+		return ensureInstalled(cmd, s, profile, opts)
+	}
 	if alreadyInstalled && !opts.Force {
 		return fmt.Errorf("tohru is already installed in %s", s.Root)
 	}
 
+	var installed store.InstallResult
 	var res store.LoadResult
 	switch {
 	case alreadyInstalled && profile != "":
 		res, err = s.Load(profile, opts)
 	case alreadyInstalled:
-		fmt.Printf("tohru is already installed in %s\n", s.Root)
+		printf(cmd, "tohru is already installed in %s\n", s.Root)
 		return nil
 	default:
-		res, err = s.InstallAndLoad(profile, opts)
+		installed, res, err = s.InstallAndLoad(profile, opts)
 	}
 	if err != nil {
 		return err
 	}
 
 	if !alreadyInstalled {
-		fmt.Printf("initialized tohru store in %s\n", s.Root)
-		printChanges(cmd, []string{s.BackupsPath(), s.ProfilesPath(), s.ConfigPath(), s.StatePath(), s.ProfilesFilePath()})
+		printf(cmd, "initialized tohru store in %s\n", s.Root)
+		printChanges(cmd, installed.CreatedPaths)
+	}
+
+	if profile == "" {
+		return nil
+	}
+	printInstallLoad(cmd, res)
+	return nil
+}
+
+// ensureInstalled creates what the store is missing, listing each path it
+// created, then loads profile if one was given.
+func ensureInstalled(cmd *cli.Command, s store.Store, profile string, opts store.Options) error {
+	installed, err := s.EnsureInstalled()
+	if err != nil {
+		return err
+	}
+	if len(installed.CreatedPaths) == 0 {
+		printf(cmd, "tohru is already installed in %s\n", s.Root)
+	} else {
+		printf(cmd, "created %d missing store path(s) in %s\n", len(installed.CreatedPaths), s.Root)
+		for _, path := range installed.CreatedPaths {
+			printf(cmd, "  %s\n", path)
+		}
 	}
 
 	if profile == "" {
 		return nil
 	}
+	res, err := s.Load(profile, opts)
+	if err != nil {
+		return err
+	}
+	printInstallLoad(cmd, res)
+	return nil
+}
+
+func printInstallLoad(cmd *cli.Command, res store.LoadResult) {
 
 	if res.UnloadedProfileName != "" || res.UnloadedTrackedCount > 0 {
 		name := res.UnloadedProfileName
 		if name == "" {
 			name = "previous profile"
 		}
-		fmt.Printf("unloaded %s (%d managed object(s))\n", name, res.UnloadedTrackedCount)
+		printf(cmd, "unloaded %s (%d managed object(s))\n", name, res.UnloadedTrackedCount)
 	}
-	fmt.Printf("loaded %s (%d tracked object(s))\n", res.ProfileName, res.TrackedCount)
+	printf(cmd, "loaded %s (%d tracked object(s))\n", res.ProfileName, res.TrackedCount)
 	if res.RemovedBackupCount > 0 {
-		fmt.Printf("cleaned %d unreferenced backup object(s)\n", res.RemovedBackupCount)
+		printf(cmd, "cleaned %d unreferenced backup object(s)\n", res.RemovedBackupCount)
 	}
-	printWarnings(res.Warnings)
+	printWarnings(cmd, res.Warnings)
 	printChanges(cmd, res.ChangedPaths)
-	return nil
 }
@@ -1,70 +1,244 @@
 package cmd
 
 import (
+	"bufio"
 	"context"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
 
+	"github.com/olimci/tohru/pkg/manifest"
 	"github.com/olimci/tohru/pkg/store"
 	"github.com/urfave/cli/v3"
 )
 
+const envCeilingDir = "TOHRU_CEILING_DIR"
+
 func loadCommand() *cli.Command {
 	return &cli.Command{
 		Name:      "load",
 		Aliases:   []string{"switch"},
 		Usage:     "load a profile",
-		ArgsUsage: "<profile>",
+		ArgsUsage: "[profile]",
 		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "add",
+				Usage: "load alongside the loaded profiles instead of replacing them; destinations must not overlap",
+			},
 			&cli.BoolFlag{
 				Name:    "force",
 				Aliases: []string{"f"},
 				Usage:   "overwrite existing files or modified managed files",
+				Sources: cli.EnvVars("TOHRU_FORCE"),
+			},
+			&cli.BoolFlag{
+				Name:    "interactive",
+				Aliases: []string{"i"},
+				Usage:   "ask what to do with each existing destination; ignored unless stdin is a terminal",
+			},
+			&cli.BoolFlag{
+				Name:    "discard-changes",
+				Usage:   "allow replacing modified managed files without enabling full force behavior",
+				Sources: cli.EnvVars("TOHRU_DISCARD_CHANGES"),
+			},
+			&cli.BoolFlag{
+				Name:    "force-backup",
+				Usage:   "back up drifted managed files before overwriting or removing them",
+				Sources: cli.EnvVars("TOHRU_FORCE_BACKUP"),
+			},
+			&cli.BoolFlag{
+				Name:  "sort",
+				Usage: "apply operations in destination order",
+			},
+			&cli.BoolFlag{
+				Name:  "ignore-version",
+				Usage: "load profiles that require a newer minor or patch version of tohru",
+			},
+			&cli.StringFlag{
+				Name:    "umask",
+				Usage:   "octal mask applied to created files and directories (e.g. 077)",
+				Sources: cli.EnvVars("TOHRU_UMASK"),
+			},
+			&cli.StringFlag{
+				Name:    "rollback",
+				Value:   string(store.RollbackStrict),
+				Usage:   "how to undo a failure part-way: strict, best-effort (restore what it can and report the rest) or leave (keep the partial state)",
+				Sources: cli.EnvVars("TOHRU_ROLLBACK"),
+			},
+			&cli.IntFlag{
+				Name:    "retries",
+				Usage:   "retry filesystem changes failing with transient errors (EAGAIN, ESTALE) this many times",
+				Sources: cli.EnvVars("TOHRU_RETRIES"),
+			},
+			&cli.BoolFlag{
+				Name:    "keep-state-backup",
+				Usage:   "copy the state file to state.json.bak before changing it, for state restore",
+				Sources: cli.EnvVars("TOHRU_KEEP_STATE_BACKUP"),
+			},
+			&cli.StringFlag{
+				Name:  "expect-name",
+				Usage: "fail unless the profile's slug or name matches (case-insensitive)",
+			},
+			&cli.BoolFlag{
+				Name:  "insecure",
+				Usage: "allow downloading the profile archive over plain http",
+			},
+			&cli.StringFlag{
+				Name:  "manifest-dir",
+				Usage: "load the manifest in this directory of the source, e.g. tools/dotfiles",
+			},
+			&cli.BoolFlag{
+				Name:  "allow-empty",
+				Usage: "load a profile that declares nothing, unloading everything it replaces",
+			},
+			&cli.BoolFlag{
+				Name:  "parents",
+				Value: true,
+				Usage: "create missing destination parent directories (--parents=false fails instead)",
+			},
+			&cli.BoolFlag{
+				Name:  "rename-conflicts",
+				Usage: "move existing destinations aside to <path>.tohru-bak-<time> instead of backing them up or refusing",
+			},
+			&cli.BoolFlag{
+				Name:  "no-backup",
+				Usage: "don't back up existing destinations before replacing them (entries flagged backup still are)",
 			},
 			&cli.BoolFlag{
-				Name:  "discard-changes",
-				Usage: "allow replacing modified managed files without enabling full force behavior",
+				Name:  "stat",
+				Usage: "summarise the operations applied and the bytes copied and backed up",
+			},
+			&cli.BoolFlag{
+				Name:  "json",
+				Usage: "print the result, including every applied operation, as JSON",
 			},
 		},
 		Action: loadAction,
 	}
 }
 
+// discoverProfile finds the profile enclosing the working directory. The
+// search stops at $TOHRU_CEILING_DIR if set, and at the home directory
+// otherwise.
+func discoverProfile() (string, error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return "", fmt.Errorf("get working directory: %w", err)
+	}
+
+	var manifestPath string
+	if ceiling := strings.TrimSpace(os.Getenv(envCeilingDir)); ceiling != "" {
+		manifestPath, err = manifest.DiscoverUntil(wd, ceiling)
+	} else {
+		manifestPath, err = manifest.Discover(wd)
+	}
+	if err != nil {
+		return "", err
+	}
+	return filepath.Dir(manifestPath), nil
+}
+
+// profileArg resolves a profile argument. "." is the profile enclosing the
+// working directory; no argument is options.default_source, or the working
+// directory's profile when no default is configured.
+func profileArg(s store.Store, arg string) (string, error) {
+	if arg == "" {
+		source, err := s.DefaultSource()
+		if err != nil || source != "" {
+			return source, err
+		}
+	}
+	if arg == "" || arg == "." {
+		return discoverProfile()
+	}
+	return arg, nil
+}
+
 func loadAction(_ context.Context, cmd *cli.Command) error {
 	args := cmd.Args().Slice()
 	profile := cmd.Args().First()
 
-	if profile == "" {
-		return fmt.Errorf("load requires a profile argument")
-	}
-
 	if len(args) > 1 {
-		return fmt.Errorf("load accepts exactly one profile argument")
+		return usageError("load accepts at most one profile argument")
 	}
 	opts := cmdOptions(cmd)
+	if cmd.Bool("interactive") && isTTY(os.Stdin) {
+		opts.Resolve = promptConflict(bufio.NewReader(os.Stdin), os.Stderr)
+	}
 
-	s, err := store.DefaultStore()
+	s, err := store.OpenDefault()
 	if err != nil {
 		return err
 	}
+	if profile, err = profileArg(s, profile); err != nil {
+		return err
+	}
 
 	res, err := s.Load(profile, opts)
 	if err != nil {
 		return err
 	}
 
+	if cmd.Bool("json") {
+		return printJSON(res)
+	}
+	if res.Skipped {
+		printf(cmd, "%s is already loaded and up to date (%d tracked object(s))\n", res.ProfileName, res.TrackedCount)
+		printWarnings(cmd, res.Warnings)
+		return nil
+	}
+
 	if res.UnloadedProfileName != "" || res.UnloadedTrackedCount > 0 {
 		name := res.UnloadedProfileName
 		if name == "" {
 			name = "previous profile"
 		}
-		fmt.Printf("unloaded %s (%d managed object(s))\n", name, res.UnloadedTrackedCount)
+		printf(cmd, "unloaded %s (%d managed object(s))\n", name, res.UnloadedTrackedCount)
 	}
 
-	fmt.Printf("loaded %s (%d tracked object(s))\n", res.ProfileName, res.TrackedCount)
+	printf(cmd, "loaded %s (%d tracked object(s))\n", res.ProfileName, res.TrackedCount)
+	for _, op := range res.Operations {
+		if op.Action == store.ActionSkipped {
+			printf(cmd, "skipped %s, left in place\n", op.Path)
+		}
+	}
 	if res.RemovedBackupCount > 0 {
-		fmt.Printf("cleaned %d unreferenced backup object(s)\n", res.RemovedBackupCount)
+		printf(cmd, "cleaned %d unreferenced backup object(s)\n", res.RemovedBackupCount)
 	}
-	printWarnings(res.Warnings)
+	printStat(cmd, res)
+	printRenamed(cmd, res.Operations)
+	printStashed(cmd, res.StashedPaths)
+	printWarnings(cmd, res.Warnings)
 	printChanges(cmd, res.ChangedPaths)
 	return nil
 }
+
+// promptConflict returns a resolver that asks on out what to do with each
+// conflicting destination, reading answers from in. Running out of input
+// aborts the load.
+func promptConflict(in *bufio.Reader, out io.Writer) store.ConflictResolver {
+	return func(c store.Conflict) (store.Resolution, error) {
+		for {
+			fmt.Fprintf(out, "%s already exists (%s)\n  [o]verwrite, [b]ack up and overwrite, [r]ename aside, [s]kip, [a]bort? ", c.Path, c.Existing)
+			line, err := in.ReadString('\n')
+			switch strings.ToLower(strings.TrimSpace(line)) {
+			case "o", "overwrite":
+				return store.ResolveOverwrite, nil
+			case "b", "backup", "back up":
+				return store.ResolveBackup, nil
+			case "r", "rename":
+				return store.ResolveRename, nil
+			case "s", "skip":
+				return store.ResolveSkip, nil
+			case "a", "abort":
+				return store.ResolveAbort, nil
+			}
+			if err != nil {
+				fmt.Fprintln(out)
+				return store.ResolveAbort, nil
+			}
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"context"
+	"time"
+
+	"github.com/olimci/tohru/pkg/store"
+	"github.com/urfave/cli/v3"
+)
+
+func logCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "log",
+		Usage: "show recent load, unload and maintenance operations",
+		Flags: []cli.Flag{
+			&cli.IntFlag{
+				Name:    "limit",
+				Aliases: []string{"n"},
+				Value:   20,
+				Usage:   "number of entries to show, 0 for all",
+			},
+			&cli.BoolFlag{
+				Name:  "json",
+				Usage: "print entries as JSON",
+			},
+		},
+		Action: logAction,
+	}
+}
+
+func logAction(_ context.Context, cmd *cli.Command) error {
+	args := cmd.Args().Slice()
+	if len(args) > 0 {
+		return usageError("log does not accept arguments")
+	}
+
+	s, err := store.OpenDefault()
+	if err != nil {
+		return err
+	}
+	if !s.IsInstalled() {
+		return store.ErrNotInstalled
+	}
+
+	entries, err := s.History()
+	if err != nil {
+		return err
+	}
+	if limit := cmd.Int("limit"); limit > 0 && len(entries) > limit {
+		entries = entries[len(entries)-limit:]
+	}
+
+	if cmd.Bool("json") {
+		if entries == nil {
+			entries = []store.HistoryEntry{}
+		}
+		return printJSON(entries)
+	}
+	if len(entries) == 0 {
+		printf(cmd, "no operations logged yet\n")
+		return nil
+	}
+	for _, entry := range entries {
+		profile := entry.Profile
+		if profile == "" {
+			profile = "-"
+		}
+		printf(cmd, "%s  %-7s %s (%d tracked, %d changed)\n", entry.Time.Local().Format(time.DateTime), entry.Command, profile, entry.Tracked, entry.Changed)
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"context"
+
+	"github.com/olimci/tohru/pkg/store"
+	"github.com/urfave/cli/v3"
+)
+
+func moveStoreCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "move-store",
+		Usage:     "move the store to a new directory, updating the paths it records inside itself",
+		ArgsUsage: "<newdir>",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "json",
+				Usage: "print the result as JSON",
+			},
+		},
+		Action: moveStoreAction,
+	}
+}
+
+func moveStoreAction(_ context.Context, cmd *cli.Command) error {
+	args := cmd.Args().Slice()
+	if len(args) != 1 {
+		return usageError("move-store requires exactly one new directory argument")
+	}
+
+	s, err := store.OpenDefault()
+	if err != nil {
+		return err
+	}
+
+	moved, res, err := s.Relocate(args[0])
+	if err != nil {
+		return err
+	}
+
+	if cmd.Bool("json") {
+		return printJSON(res)
+	}
+
+	printf(cmd, "moved tohru store from %s to %s\n", res.From, res.To)
+	for _, path := range res.MovedPaths {
+		printf(cmd, "  %s\n", path)
+	}
+	for _, path := range res.RewrittenPaths {
+		printf(cmd, "updated paths recorded in %s\n", path)
+	}
+	printWarnings(cmd, res.Warnings)
+
+	if home, err := store.HomeStore(); err == nil && home.Root == moved.Root {
+		printf(cmd, "unset TOHRU_STORE_DIR to use it\n")
+	} else {
+		printf(cmd, "set TOHRU_STORE_DIR=%s to use it\n", moved.Root)
+	}
+	return nil
+}
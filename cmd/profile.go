@@ -60,16 +60,16 @@ func profileAction(_ context.Context, cmd *cli.Command) error {
 	if len(cmd.Args().Slice()) > 0 {
 		return fmt.Errorf("unknown profile subcommand")
 	}
-	return fmt.Errorf("profile requires a subcommand (try: profile list|new|add|tidy)")
+	return usageError("profile requires a subcommand (try: profile list|new|add|tidy)")
 }
 
 func profileListAction(_ context.Context, cmd *cli.Command) error {
 	args := cmd.Args().Slice()
 	if len(args) > 0 {
-		return fmt.Errorf("profile list does not accept arguments")
+		return usageError("profile list does not accept arguments")
 	}
 
-	s, err := store.DefaultStore()
+	s, err := store.OpenDefault()
 	if err != nil {
 		return err
 	}
@@ -102,7 +102,7 @@ func profileListAction(_ context.Context, cmd *cli.Command) error {
 func profileNewAction(_ context.Context, cmd *cli.Command) error {
 	args := cmd.Args().Slice()
 	if len(args) != 1 {
-		return fmt.Errorf("profile new requires exactly one slug argument")
+		return usageError("profile new requires exactly one slug argument")
 	}
 
 	slug, err := profileutils.ValidateSlug(args[0], "profile slug", false)
@@ -110,7 +110,7 @@ func profileNewAction(_ context.Context, cmd *cli.Command) error {
 		return err
 	}
 
-	s, err := store.DefaultStore()
+	s, err := store.OpenDefault()
 	if err != nil {
 		return err
 	}
@@ -154,7 +154,7 @@ func profileNewAction(_ context.Context, cmd *cli.Command) error {
 		return fmt.Errorf("save profiles cache: %w", err)
 	}
 
-	fmt.Printf("created profile %s at %s\n", slug, profileDir)
+	printf(cmd, "created profile %s at %s\n", slug, profileDir)
 	printChanges(cmd, []string{profileDir, manifestPath, s.ProfilesFilePath()})
 	return nil
 }
@@ -162,7 +162,7 @@ func profileNewAction(_ context.Context, cmd *cli.Command) error {
 func profileAddAction(_ context.Context, cmd *cli.Command) error {
 	args := cmd.Args().Slice()
 	if len(args) != 2 {
-		return fmt.Errorf("profile add requires exactly two arguments: <slug> <path>")
+		return usageError("profile add requires exactly two arguments: <slug> <path>")
 	}
 
 	slug, err := profileutils.ValidateSlug(args[0], "profile slug", false)
@@ -179,7 +179,7 @@ func profileAddAction(_ context.Context, cmd *cli.Command) error {
 		return fmt.Errorf("stat source path %s: %w", localPath, err)
 	}
 
-	s, err := store.DefaultStore()
+	s, err := store.OpenDefault()
 	if err != nil {
 		return err
 	}
@@ -195,7 +195,7 @@ func profileAddAction(_ context.Context, cmd *cli.Command) error {
 		return err
 	}
 
-	fmt.Printf("added %s to profile %s\n", localPath, slug)
+	printf(cmd, "added %s to profile %s\n", localPath, slug)
 	printChanges(cmd, changed)
 	return nil
 }
@@ -203,7 +203,7 @@ func profileAddAction(_ context.Context, cmd *cli.Command) error {
 func profileTidyAction(_ context.Context, cmd *cli.Command) error {
 	args := cmd.Args().Slice()
 	if len(args) != 1 {
-		return fmt.Errorf("profile tidy requires exactly one slug argument")
+		return usageError("profile tidy requires exactly one slug argument")
 	}
 
 	slug, err := profileutils.ValidateSlug(args[0], "profile slug", false)
@@ -211,7 +211,7 @@ func profileTidyAction(_ context.Context, cmd *cli.Command) error {
 		return err
 	}
 
-	s, err := store.DefaultStore()
+	s, err := store.OpenDefault()
 	if err != nil {
 		return err
 	}
@@ -244,16 +244,19 @@ func profileTidyAction(_ context.Context, cmd *cli.Command) error {
 		return err
 	}
 	if merges == 0 {
-		fmt.Printf("profile %s is already tidy\n", slug)
+		printf(cmd, "profile %s is already tidy\n", slug)
 		return nil
 	}
 
-	manifestPath := filepath.Join(profileDir, manifest.Name)
+	manifestPath, _, err := manifest.Locate(profileDir)
+	if err != nil {
+		return err
+	}
 	if err := manifest.Write(manifestPath, m); err != nil {
 		return err
 	}
 
-	fmt.Printf("tidied profile %s (%d merge(s))\n", slug, merges)
+	printf(cmd, "tidied profile %s (%d merge(s))\n", slug, merges)
 	printChanges(cmd, []string{manifestPath})
 	return nil
 }
@@ -479,7 +482,10 @@ func addPath(s store.Store, slug, localPath string, info os.FileInfo) ([]string,
 		return nil, err
 	}
 
-	manifestPath := filepath.Join(profileDir, manifest.Name)
+	manifestPath, _, err := manifest.Locate(profileDir)
+	if err != nil {
+		return nil, err
+	}
 	if err := writeManifest(manifestPath, m); err != nil {
 		if rollbackErr := rollbackSources(); rollbackErr != nil {
 			return nil, fmt.Errorf("write manifest %s: %w (rollback failed: %v)", manifestPath, err, rollbackErr)
@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	"context"
+
+	"github.com/olimci/tohru/pkg/digest"
+	"github.com/olimci/tohru/pkg/store"
+	"github.com/urfave/cli/v3"
+)
+
+func rehashCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "rehash",
+		Usage: "re-digest tracked objects and backups with a single algorithm",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "algorithm",
+				Usage: "digest algorithm to migrate to",
+				Value: digest.AlgorithmSHA256,
+			},
+		},
+		Action: rehashAction,
+	}
+}
+
+func rehashAction(_ context.Context, cmd *cli.Command) error {
+	args := cmd.Args().Slice()
+	if len(args) > 0 {
+		return usageError("rehash does not accept arguments")
+	}
+
+	s, err := store.OpenDefault()
+	if err != nil {
+		return err
+	}
+
+	res, err := s.Rehash(cmd.String("algorithm"))
+	if err != nil {
+		return err
+	}
+
+	printf(cmd, "rehashed %d tracked object(s), relabeled %d backup object(s)\n", res.RehashedCount, res.RelabeledBackupCount)
+	printWarnings(cmd, res.Warnings)
+	printChanges(cmd, res.ChangedPaths)
+	return nil
+}
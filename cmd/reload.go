@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/olimci/tohru/pkg/store"
 	"github.com/urfave/cli/v3"
@@ -18,30 +19,128 @@ func reloadCommand() *cli.Command {
 				Name:    "force",
 				Aliases: []string{"f"},
 				Usage:   "allow clobbering existing paths when reloading",
+				Sources: cli.EnvVars("TOHRU_FORCE"),
 			},
 			&cli.BoolFlag{
-				Name:  "discard-changes",
-				Usage: "allow replacing modified managed files without enabling full force behavior",
+				Name:    "discard-changes",
+				Usage:   "allow replacing modified managed files without enabling full force behavior",
+				Sources: cli.EnvVars("TOHRU_DISCARD_CHANGES"),
+			},
+			&cli.BoolFlag{
+				Name:    "force-backup",
+				Usage:   "back up drifted managed files before overwriting or removing them",
+				Sources: cli.EnvVars("TOHRU_FORCE_BACKUP"),
+			},
+			&cli.BoolFlag{
+				Name:  "sort",
+				Usage: "apply operations in destination order",
+			},
+			&cli.BoolFlag{
+				Name:  "ignore-version",
+				Usage: "load profiles that require a newer minor or patch version of tohru",
+			},
+			&cli.StringFlag{
+				Name:    "umask",
+				Usage:   "octal mask applied to created files and directories (e.g. 077)",
+				Sources: cli.EnvVars("TOHRU_UMASK"),
+			},
+			&cli.StringFlag{
+				Name:    "rollback",
+				Value:   string(store.RollbackStrict),
+				Usage:   "how to undo a failure part-way: strict, best-effort (restore what it can and report the rest) or leave (keep the partial state)",
+				Sources: cli.EnvVars("TOHRU_ROLLBACK"),
+			},
+			&cli.IntFlag{
+				Name:    "retries",
+				Usage:   "retry filesystem changes failing with transient errors (EAGAIN, ESTALE) this many times",
+				Sources: cli.EnvVars("TOHRU_RETRIES"),
+			},
+			&cli.BoolFlag{
+				Name:    "keep-state-backup",
+				Usage:   "copy the state file to state.json.bak before changing it, for state restore",
+				Sources: cli.EnvVars("TOHRU_KEEP_STATE_BACKUP"),
+			},
+			&cli.StringFlag{
+				Name:  "expect-name",
+				Usage: "fail unless the profile's slug or name matches (case-insensitive)",
+			},
+			&cli.BoolFlag{
+				Name:  "insecure",
+				Usage: "allow downloading the profile archive over plain http",
+			},
+			&cli.StringFlag{
+				Name:  "manifest-dir",
+				Usage: "load the manifest in this directory of the source, e.g. tools/dotfiles",
+			},
+			&cli.BoolFlag{
+				Name:  "allow-empty",
+				Usage: "load a profile that declares nothing, unloading everything it replaces",
+			},
+			&cli.BoolFlag{
+				Name:  "parents",
+				Value: true,
+				Usage: "create missing destination parent directories (--parents=false fails instead)",
+			},
+			&cli.BoolFlag{
+				Name:  "repair",
+				Usage: "rewrite every managed path even if nothing changed, to restore modes reset outside tohru",
+			},
+			&cli.StringFlag{
+				Name:  "source",
+				Usage: "reload the loaded profile from a new location (e.g. after moving it)",
+			},
+			&cli.BoolFlag{
+				Name:  "rename-conflicts",
+				Usage: "move existing destinations aside to <path>.tohru-bak-<time> instead of backing them up or refusing",
+			},
+			&cli.BoolFlag{
+				Name:  "no-backup",
+				Usage: "don't back up existing destinations before replacing them (entries flagged backup still are)",
+			},
+			&cli.BoolFlag{
+				Name:  "stat",
+				Usage: "summarise the operations applied and the bytes copied and backed up",
+			},
+			&cli.BoolFlag{
+				Name:  "json",
+				Usage: "print the result, including every applied operation, as JSON",
+			},
+			&cli.BoolFlag{
+				Name:  "watch",
+				Usage: "keep running and reload whenever the profile source changes or a managed path drifts",
+			},
+			&cli.DurationFlag{
+				Name:  "watch-interval",
+				Value: time.Second,
+				Usage: "how often --watch polls; a change is applied once it is stable for one interval",
 			},
 		},
 		Action: reloadAction,
 	}
 }
 
-func reloadAction(_ context.Context, cmd *cli.Command) error {
+func reloadAction(ctx context.Context, cmd *cli.Command) error {
 	args := cmd.Args().Slice()
 
 	if len(args) > 0 {
-		return fmt.Errorf("reload does not accept arguments")
+		return usageError("reload does not accept arguments")
+	}
+	if cmd.Bool("watch") && cmd.Bool("json") {
+		return usageError("--watch can't be combined with --json")
 	}
 	opts := cmdOptions(cmd)
 
-	s, err := store.DefaultStore()
+	s, err := store.OpenDefault()
 	if err != nil {
 		return err
 	}
 
-	res, err := s.Reload(opts)
+	var res store.LoadResult
+	if source := cmd.String("source"); source != "" {
+		res, err = s.ReloadFrom(source, opts)
+	} else {
+		res, err = s.Reload(opts)
+	}
 	if err != nil {
 		if errors.Is(err, store.ErrNotInstalled) {
 			return fmt.Errorf("tohru is not installed, run `tohru install` first")
@@ -49,19 +148,41 @@ func reloadAction(_ context.Context, cmd *cli.Command) error {
 		return err
 	}
 
+	if cmd.Bool("json") {
+		return printJSON(res)
+	}
+	printReload(cmd, opts, res)
+	if cmd.Bool("watch") {
+		return watchReload(ctx, cmd, s, opts, cmd.Duration("watch-interval"))
+	}
+	return nil
+}
+
+func printReload(cmd *cli.Command, opts store.Options, res store.LoadResult) {
+	if res.Skipped {
+		printf(cmd, "%s is already loaded and up to date (%d tracked object(s))\n", res.ProfileName, res.TrackedCount)
+		printWarnings(cmd, res.Warnings)
+		return
+	}
+
 	if res.UnloadedProfileName != "" || res.UnloadedTrackedCount > 0 {
 		name := res.UnloadedProfileName
 		if name == "" {
 			name = "current profile"
 		}
-		fmt.Printf("unloaded %s (%d managed object(s))\n", name, res.UnloadedTrackedCount)
+		printf(cmd, "unloaded %s (%d managed object(s))\n", name, res.UnloadedTrackedCount)
 	}
 
-	fmt.Printf("reloaded %s (%d tracked object(s))\n", res.ProfileName, res.TrackedCount)
+	printf(cmd, "reloaded %s (%d tracked object(s))\n", res.ProfileName, res.TrackedCount)
+	if opts.Repair {
+		printf(cmd, "rewrote %d path(s)\n", res.RewrittenCount)
+	}
 	if res.RemovedBackupCount > 0 {
-		fmt.Printf("cleaned %d unreferenced backup object(s)\n", res.RemovedBackupCount)
+		printf(cmd, "cleaned %d unreferenced backup object(s)\n", res.RemovedBackupCount)
 	}
-	printWarnings(res.Warnings)
+	printStat(cmd, res)
+	printRenamed(cmd, res.Operations)
+	printStashed(cmd, res.StashedPaths)
+	printWarnings(cmd, res.Warnings)
 	printChanges(cmd, res.ChangedPaths)
-	return nil
 }
@@ -20,24 +20,49 @@ func Execute(ctx context.Context, args []string) error {
 				Name:  "verbose",
 				Usage: "show changed filesystem paths",
 			},
+			&cli.BoolFlag{
+				Name:    "quiet",
+				Aliases: []string{"q"},
+				Usage:   "print nothing on success; errors are still reported",
+			},
+		},
+		Before: func(ctx context.Context, cmd *cli.Command) (context.Context, error) {
+			if cmd.Bool("quiet") && cmd.Bool("verbose") {
+				return ctx, usageError("--quiet and --verbose cannot be used together")
+			}
+			return ctx, nil
 		},
 		Commands: []*cli.Command{
 			versionCommand(),
+			schemaCommand(),
+			validateCommand(),
 
 			// application management
 			installCommand(),
 			uninstallCommand(),
 			tidyCommand(),
+			gcCommand(),
+			rehashCommand(),
 			statusCommand(),
+			logCommand(),
+			stateCommand(),
+			exportCommand(),
+			importCommand(),
+			moveStoreCommand(),
 
 			// profile management
 			profileCommand(),
 			loadCommand(),
 			reloadCommand(),
 			unloadCommand(),
+			editCommand(),
+			catCommand(),
+			diffCommand(),
 		},
 	}
 
+	onUsageError(app)
+
 	if len(args) <= 1 {
 		fmt.Println(version.Banner(repoLink) + "\n")
 		args = append(args, "help")
@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/olimci/tohru/pkg/store"
+)
+
+func TestExecuteRejectsQuietWithVerbose(t *testing.T) {
+	t.Setenv("TOHRU_STORE_DIR", t.TempDir())
+
+	err := Execute(context.Background(), []string{"tohru", "--quiet", "--verbose", "version"})
+	if err == nil || !strings.Contains(err.Error(), "cannot be used together") {
+		t.Fatalf("Execute() error = %v, want quiet/verbose conflict", err)
+	}
+}
+
+func TestExecuteExitCodes(t *testing.T) {
+	t.Setenv("TOHRU_STORE_DIR", t.TempDir())
+
+	tests := []struct {
+		args []string
+		want int
+	}{
+		{[]string{"version"}, ExitOK},
+		{[]string{"--quiet", "--verbose", "version"}, ExitUsage},
+		{[]string{"status", "--no-such-flag"}, ExitUsage},
+		{[]string{"status", "extra"}, ExitUsage},
+		{[]string{"status", "--fail-on", "typo"}, ExitUsage},
+		{[]string{"status"}, ExitNotInstalled},
+		{[]string{"state", "show"}, ExitNotInstalled},
+		{[]string{"install", "--check"}, ExitNotInstalled},
+		{[]string{"install", "--check", "--ensure"}, ExitUsage},
+		{[]string{"install", "--ensure"}, ExitOK},
+		{[]string{"install", "--ensure"}, ExitOK},
+		{[]string{"install", "--check"}, ExitOK},
+		{[]string{"install"}, ExitFailure},
+	}
+	for _, tt := range tests {
+		err := Execute(context.Background(), append([]string{"tohru", "--quiet"}, tt.args...))
+		if got := ExitCode(err); got != tt.want {
+			t.Errorf("Execute(%q) exit code = %d (error %v), want %d", tt.args, got, err, tt.want)
+		}
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	problems := checkStatus(store.StatusSnapshot{Tracked: []store.TrackedStatus{{Path: "/a", Drifted: true}}}, []string{failDrift})
+
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{nil, ExitOK},
+		{errors.New("boom"), ExitFailure},
+		{problems, ExitProblems},
+		{fmt.Errorf("load: %w", &store.RollbackError{Err: fmt.Errorf("file /a: %w", store.ErrNeedsForce)}), ExitNeedsForce},
+		{fmt.Errorf("open: %w", store.ErrNotInstalled), ExitNotInstalled},
+		{fmt.Errorf("wrapped: %w", usageError("bad")), ExitUsage},
+	}
+	for _, tt := range tests {
+		if got := ExitCode(tt.err); got != tt.want {
+			t.Errorf("ExitCode(%v) = %d, want %d", tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package cmd
+
+import (
+	"context"
+	"os"
+
+	"github.com/olimci/tohru/pkg/manifest"
+	"github.com/urfave/cli/v3"
+)
+
+func schemaCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "schema",
+		Usage: "print the JSON Schema for tohru.json manifests",
+		Action: func(_ context.Context, _ *cli.Command) error {
+			raw, err := manifest.SchemaJSON()
+			if err != nil {
+				return err
+			}
+			_, err = os.Stdout.Write(raw)
+			return err
+		},
+	}
+}
@@ -0,0 +1,153 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/olimci/tohru/pkg/digest"
+	"github.com/olimci/tohru/pkg/store"
+	"github.com/olimci/tohru/pkg/store/state"
+	"github.com/urfave/cli/v3"
+)
+
+func stateCommand() *cli.Command {
+	return &cli.Command{
+		Name:    "state",
+		Aliases: []string{"lock"},
+		Usage:   "inspect the state file tohru keeps of what it manages",
+		Commands: []*cli.Command{
+			{
+				Name:  "show",
+				Usage: "print the decoded state",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "json",
+						Usage: "print the state as JSON",
+					},
+				},
+				Action: stateShowAction,
+			},
+			{
+				Name:   "path",
+				Usage:  "print the location of the state file",
+				Action: statePathAction,
+			},
+			{
+				Name:   "restore",
+				Usage:  "swap the state file with the backup kept by --keep-state-backup",
+				Action: stateRestoreAction,
+			},
+		},
+		Action: stateAction,
+	}
+}
+
+func stateAction(_ context.Context, cmd *cli.Command) error {
+	if len(cmd.Args().Slice()) > 0 {
+		return fmt.Errorf("unknown state subcommand")
+	}
+	return usageError("state requires a subcommand (try: state show|path|restore)")
+}
+
+func stateShowAction(_ context.Context, cmd *cli.Command) error {
+	if len(cmd.Args().Slice()) > 0 {
+		return usageError("state show does not accept arguments")
+	}
+
+	s, err := store.OpenDefault()
+	if err != nil {
+		return err
+	}
+	if !s.IsInstalled() {
+		return store.ErrNotInstalled
+	}
+	st, err := s.LoadState()
+	if err != nil {
+		return err
+	}
+
+	if cmd.Bool("json") {
+		return printJSON(st)
+	}
+
+	printLayer(cmd, s, "profile", state.Layer{Profile: st.Profile, Files: st.Files, Dirs: st.Dirs})
+	for _, layer := range st.Added {
+		printLayer(cmd, s, "added profile", layer)
+	}
+	if len(st.Stashed) > 0 {
+		printf(cmd, "stashed (%d):\n", len(st.Stashed))
+		for _, stash := range st.Stashed {
+			printf(cmd, "  %s\n", stash.Path)
+			printf(cmd, "    backup  %s\n", stash.Backup.Digest)
+		}
+	}
+	return nil
+}
+
+// printLayer prints a loaded profile with the files and dirs it tracks.
+func printLayer(cmd *cli.Command, s store.Store, label string, layer state.Layer) {
+	p := layer.Profile
+	printf(cmd, "%s: %s\n", label, p.State)
+	for _, field := range [][2]string{
+		{"kind", p.Kind},
+		{"path", p.Path},
+		{"archive", p.Archive},
+		{"url", p.URL},
+		{"sha256", p.SHA256},
+		{"slug", p.Slug},
+		{"name", p.Name},
+		{"fingerprint", p.Fingerprint},
+	} {
+		if field[1] != "" {
+			printf(cmd, "  %-12s %s\n", field[0]+":", field[1])
+		}
+	}
+
+	printf(cmd, "files (%d):\n", len(layer.Files))
+	for _, f := range layer.Files {
+		printf(cmd, "  %s\n", f.Path)
+		printf(cmd, "    curr  %s\n", f.Current.Digest)
+		switch {
+		case f.Previous != nil && f.Previous.Digest == string(digest.KindNull):
+			printf(cmd, "    prev  null (nothing was there, unload only removes it)\n")
+		case f.Previous != nil && f.Previous.Digest != "":
+			printf(cmd, "    prev  %s (backup %s)\n", f.Previous.Digest, s.BackupPath(f.Previous.Digest))
+		}
+	}
+
+	if len(layer.Dirs) > 0 {
+		printf(cmd, "auto-created dirs (%d):\n", len(layer.Dirs))
+		for _, d := range layer.Dirs {
+			printf(cmd, "  %s\n", d.Path)
+		}
+	}
+}
+
+func statePathAction(_ context.Context, cmd *cli.Command) error {
+	if len(cmd.Args().Slice()) > 0 {
+		return usageError("state path does not accept arguments")
+	}
+
+	s, err := store.OpenDefault()
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Println(s.StatePath())
+	return err
+}
+
+func stateRestoreAction(_ context.Context, cmd *cli.Command) error {
+	if len(cmd.Args().Slice()) > 0 {
+		return usageError("state restore does not accept arguments")
+	}
+
+	s, err := store.OpenDefault()
+	if err != nil {
+		return err
+	}
+	if err := s.RestoreStateBackup(); err != nil {
+		return err
+	}
+	printf(cmd, "restored the state from %s (the replaced state is kept there)\n", s.StateBackupPath())
+	return nil
+}
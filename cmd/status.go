@@ -2,11 +2,14 @@ package cmd
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
+	"io"
 	"os"
+	"slices"
+	"strings"
 
 	"github.com/olimci/tohru/pkg/store"
+	"github.com/olimci/tohru/pkg/utils/fileutils"
 	"github.com/urfave/cli/v3"
 )
 
@@ -27,11 +30,39 @@ func statusCommand() *cli.Command {
 				Name:  "flat",
 				Usage: "show compact flat status output",
 			},
+			&cli.BoolFlag{
+				Name:  "roots",
+				Usage: "group tracked objects by the manifest root that declared them",
+			},
+			&cli.StringSliceFlag{
+				Name:  "include",
+				Usage: "only show tracked paths matching this glob, ** matches any depth (repeatable)",
+			},
+			&cli.StringSliceFlag{
+				Name:  "exclude",
+				Usage: "hide tracked paths matching this glob (repeatable)",
+			},
 			&cli.StringFlag{
-				Name:  "color",
-				Usage: "color mode: auto|always|never",
-				Value: "auto",
+				Name:  "paths-from",
+				Usage: "only report on the paths listed one per line in this file, or - for stdin",
+			},
+			&cli.BoolFlag{
+				Name:  "quick",
+				Usage: "check tracked directories by modification time instead of hashing their contents",
+			},
+			&cli.BoolFlag{
+				Name:  "deep-links",
+				Usage: "also check whether what each tracked symlink points to changed since it was loaded",
+			},
+			&cli.BoolFlag{
+				Name:  "exit-code",
+				Usage: "exit non-zero when status finds a problem selected by --fail-on",
+			},
+			&cli.StringSliceFlag{
+				Name:  "fail-on",
+				Usage: "problems that fail --exit-code: drift, missing, backup (default all; implies --exit-code)",
 			},
+			colorFlag(),
 		},
 		Action: statusAction,
 	}
@@ -40,23 +71,142 @@ func statusCommand() *cli.Command {
 func statusAction(_ context.Context, cmd *cli.Command) error {
 	args := cmd.Args().Slice()
 	if len(args) > 0 {
-		return fmt.Errorf("status does not accept arguments")
+		return usageError("status does not accept arguments")
+	}
+
+	var failOn []string
+	if cmd.Bool("exit-code") || cmd.IsSet("fail-on") {
+		var err error
+		if failOn, err = parseFailOn(cmd.StringSlice("fail-on")); err != nil {
+			return err
+		}
 	}
 
-	s, err := store.DefaultStore()
+	s, err := store.OpenDefault()
 	if err != nil {
 		return err
 	}
 
-	snapshot, err := s.Status()
+	snapshot, err := s.StatusWithOptions(store.StatusOptions{
+		SkipDirHash: cmd.Bool("quick"),
+		DeepLinks:   cmd.Bool("deep-links"),
+	})
 	if err != nil {
 		return err
 	}
+	snapshot, err = filterStatus(snapshot, cmd.StringSlice("include"), cmd.StringSlice("exclude"))
+	if err != nil {
+		return err
+	}
+
+	if source := cmd.String("paths-from"); source != "" {
+		paths, err := readPaths(source)
+		if err != nil {
+			return err
+		}
+		var statuses []pathStatus
+		snapshot, statuses = scopeStatus(snapshot, paths)
+		if err := printPathStatuses(cmd, statuses); err != nil {
+			return err
+		}
+		return checkStatus(snapshot, failOn)
+	}
+
+	if err := printStatus(cmd, s, snapshot); err != nil {
+		return err
+	}
+	return checkStatus(snapshot, failOn)
+}
+
+// pathStatus is the status of one path asked about with --paths-from.
+type pathStatus struct {
+	Path  string
+	Code  string // status code as shown by --flat, or "?" when untracked
+	State string // e.g. "drifted", or "untracked"
+}
+
+// readPaths reads the newline-separated paths in source, or stdin for "-",
+// made absolute like tracked paths. Blank lines are skipped.
+func readPaths(source string) ([]string, error) {
+	var raw []byte
+	var err error
+	if source == "-" {
+		raw, err = io.ReadAll(os.Stdin)
+	} else {
+		raw, err = os.ReadFile(source)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read paths from %s: %w", source, err)
+	}
+
+	var paths []string
+	for line := range strings.Lines(string(raw)) {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		path, err := fileutils.AbsPath(line)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+// scopeStatus reports the status of each of paths, in order, and narrows
+// snapshot to the tracked ones so --exit-code only considers them.
+func scopeStatus(snapshot store.StatusSnapshot, paths []string) (store.StatusSnapshot, []pathStatus) {
+	byPath := make(map[string]store.TrackedStatus, len(snapshot.Tracked))
+	for _, item := range snapshot.Tracked {
+		byPath[item.Path] = item
+	}
+
+	statuses := make([]pathStatus, 0, len(paths))
+	var tracked []store.TrackedStatus
+	for _, path := range paths {
+		item, ok := byPath[path]
+		if !ok {
+			statuses = append(statuses, pathStatus{Path: path, Code: "?", State: "untracked"})
+			continue
+		}
+		state := trackedStateFor(item)
+		statuses = append(statuses, pathStatus{Path: path, Code: state.Code, State: state.Label})
+		tracked = append(tracked, item)
+	}
+
+	var refs []store.BackupRefStatus
+	for _, ref := range snapshot.BackupRefs {
+		if slices.ContainsFunc(ref.Paths, func(path string) bool { return slices.Contains(paths, path) }) {
+			refs = append(refs, ref)
+		}
+	}
+
+	snapshot.Tracked = tracked
+	snapshot.BackupRefs = refs
+	snapshot.BrokenBackups = nil
+	return snapshot, statuses
+}
+
+func printPathStatuses(cmd *cli.Command, statuses []pathStatus) error {
+	if cmd.Bool("json") {
+		return printJSON(statuses)
+	}
+	for _, status := range statuses {
+		printf(cmd, "%s %s\n", status.Code, status.Path)
+	}
+	return nil
+}
 
+func printStatus(cmd *cli.Command, s store.Store, snapshot store.StatusSnapshot) error {
 	if cmd.Bool("json") {
-		enc := json.NewEncoder(os.Stdout)
-		enc.SetIndent("", "  ")
-		return enc.Encode(snapshot)
+		return printJSON(struct {
+			store.StatusSnapshot
+			Summary store.StatusSummary
+		}{snapshot, snapshot.Summary()})
+	}
+	if isQuiet(cmd) {
+		return nil
 	}
 
 	backups := cmd.Bool("backups")
@@ -73,6 +223,23 @@ func statusAction(_ context.Context, cmd *cli.Command) error {
 		return err
 	}
 
+	if cmd.Bool("roots") {
+		roots, err := s.StatusByRoot(snapshot)
+		if err != nil {
+			return fmt.Errorf("group status by root: %w", err)
+		}
+		output, err := renderStatusByRoot(snapshot, roots, statusRenderOptions{
+			Flat:      cmd.Bool("flat"),
+			ColorMode: cmd.String("color"),
+			Stdout:    os.Stdout,
+		})
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprint(os.Stdout, output)
+		return err
+	}
+
 	output, err := renderStatus(snapshot, statusRenderOptions{
 		Flat:      cmd.Bool("flat"),
 		ColorMode: cmd.String("color"),
@@ -84,3 +251,128 @@ func statusAction(_ context.Context, cmd *cli.Command) error {
 	_, err = fmt.Fprint(os.Stdout, output)
 	return err
 }
+
+const (
+	failDrift   = "drift"
+	failMissing = "missing"
+	failBackup  = "backup"
+)
+
+// parseFailOn validates the --fail-on conditions, defaulting to all of them.
+func parseFailOn(raw []string) ([]string, error) {
+	if len(raw) == 0 {
+		return []string{failDrift, failMissing, failBackup}, nil
+	}
+
+	var conditions []string
+	for _, value := range raw {
+		for part := range strings.SplitSeq(value, ",") {
+			condition := strings.ToLower(strings.TrimSpace(part))
+			switch condition {
+			case failDrift, failMissing, failBackup:
+				if !slices.Contains(conditions, condition) {
+					conditions = append(conditions, condition)
+				}
+			default:
+				return nil, usageError("--fail-on: unknown condition %q (expected drift, missing or backup)", part)
+			}
+		}
+	}
+	return conditions, nil
+}
+
+// checkStatus returns an error describing the problems in snapshot that match
+// the failOn conditions, so the command exits non-zero.
+func checkStatus(snapshot store.StatusSnapshot, failOn []string) error {
+	summary := snapshot.Summary()
+	backups := summary.MissingBackups + summary.BrokenBackups
+
+	var problems []string
+	if slices.Contains(failOn, failDrift) && summary.Drifted > 0 {
+		problems = append(problems, fmt.Sprintf("%d drifted path(s)", summary.Drifted))
+	}
+	// A path that can't be checked may have drifted.
+	if slices.Contains(failOn, failDrift) && summary.Inaccessible > 0 {
+		problems = append(problems, fmt.Sprintf("%d inaccessible path(s)", summary.Inaccessible))
+	}
+	if slices.Contains(failOn, failMissing) && summary.Missing > 0 {
+		problems = append(problems, fmt.Sprintf("%d missing path(s)", summary.Missing))
+	}
+	if slices.Contains(failOn, failBackup) && backups > 0 {
+		problems = append(problems, fmt.Sprintf("%d missing or broken backup(s)", backups))
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	return &ExitError{Code: ExitProblems, Err: fmt.Errorf("status check failed: %s", strings.Join(problems, ", "))}
+}
+
+// filterStatus scopes the tracked objects and backup references in snapshot
+// to paths selected by the include and exclude globs. Patterns are normalized
+// like tracked paths, so "~/.config/**" works.
+func filterStatus(snapshot store.StatusSnapshot, include, exclude []string) (store.StatusSnapshot, error) {
+	if len(include) == 0 && len(exclude) == 0 {
+		return snapshot, nil
+	}
+
+	normalize := func(patterns []string) ([]string, error) {
+		out := make([]string, 0, len(patterns))
+		for _, pattern := range patterns {
+			abs, err := fileutils.AbsPath(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("filter %q: %w", pattern, err)
+			}
+			out = append(out, abs)
+		}
+		return out, nil
+	}
+	include, err := normalize(include)
+	if err != nil {
+		return snapshot, err
+	}
+	exclude, err = normalize(exclude)
+	if err != nil {
+		return snapshot, err
+	}
+
+	selected := func(path string) (bool, error) {
+		ok, err := fileutils.MatchFilters(include, exclude, path)
+		if err != nil {
+			return false, fmt.Errorf("filter %s: %w", path, err)
+		}
+		return ok, nil
+	}
+
+	tracked := make([]store.TrackedStatus, 0, len(snapshot.Tracked))
+	for _, item := range snapshot.Tracked {
+		ok, err := selected(item.Path)
+		if err != nil {
+			return snapshot, err
+		}
+		if ok {
+			tracked = append(tracked, item)
+		}
+	}
+
+	refs := make([]store.BackupRefStatus, 0, len(snapshot.BackupRefs))
+	for _, ref := range snapshot.BackupRefs {
+		paths := make([]string, 0, len(ref.Paths))
+		for _, path := range ref.Paths {
+			ok, err := selected(path)
+			if err != nil {
+				return snapshot, err
+			}
+			if ok {
+				paths = append(paths, path)
+			}
+		}
+		if len(paths) > 0 {
+			ref.Paths = paths
+			refs = append(refs, ref)
+		}
+	}
+
+	snapshot.Tracked = tracked
+	snapshot.BackupRefs = refs
+	return snapshot, nil
+}
@@ -6,6 +6,7 @@ import (
 	"path/filepath"
 	"slices"
 	"strings"
+	"time"
 
 	"github.com/charmbracelet/lipgloss"
 	"github.com/olimci/tohru/pkg/digest"
@@ -53,37 +54,133 @@ func renderStatus(snapshot store.StatusSnapshot, opts statusRenderOptions) (stri
 
 	b.WriteString(renderProfileHeader(snapshot, styles))
 	b.WriteString("\n")
+	b.WriteString(renderVerdict(snapshot.Summary(), styles))
+	b.WriteString("\n")
 	b.WriteString(styles.muted.Render(renderSummary(snapshot)))
 	b.WriteString("\n")
+	b.WriteString(renderAlgorithmWarning(snapshot, styles))
 	b.WriteString("\n")
 	b.WriteString(styles.title.Render("Tracked objects:"))
 	b.WriteString("\n")
+	renderTrackedSection(&b, snapshot.Tracked, "", opts.Flat, styles)
+	renderAutoDirs(&b, snapshot.AutoDirs, styles)
+	renderHardlinks(&b, snapshot.Hardlinks, styles)
+
+	return b.String(), nil
+}
 
-	if len(snapshot.Tracked) == 0 {
+func renderHardlinks(b *strings.Builder, groups [][]string, styles statusStyles) {
+	if len(groups) == 0 {
+		return
+	}
+
+	b.WriteString("\n")
+	b.WriteString(styles.title.Render("Hard-linked tracked paths:"))
+	b.WriteString("\n")
+	for _, group := range groups {
 		b.WriteString("  ")
-		b.WriteString(styles.muted.Render("(none)"))
+		b.WriteString(strings.Join(group, styles.muted.Render(" = ")))
 		b.WriteString("\n")
-		return b.String(), nil
+	}
+}
+
+func renderAutoDirs(b *strings.Builder, dirs []store.AutoDirStatus, styles statusStyles) {
+	if len(dirs) == 0 {
+		return
 	}
 
-	if opts.Flat {
-		for _, tracked := range snapshot.Tracked {
-			b.WriteString(renderTrackedLine("  ", filepath.Base(tracked.Path), tracked, styles))
+	b.WriteString("\n")
+	b.WriteString(styles.title.Render("Auto-created directories:"))
+	b.WriteString("\n")
+	for _, dir := range dirs {
+		b.WriteString("  ")
+		switch {
+		case dir.Missing:
+			b.WriteString(styles.muted.Render("missing"))
+		case dir.Inaccessible:
+			b.WriteString(styles.alert.Render("denied "))
+		case dir.OnlyManaged:
+			b.WriteString(styles.ok.Render("managed"))
+		default:
+			b.WriteString(styles.warn.Render("kept   "))
+		}
+		b.WriteString("  ")
+		b.WriteString(dir.Path)
+		if len(dir.Unmanaged) > 0 {
 			b.WriteString("  ")
-			b.WriteString(styles.muted.Render(tracked.Path))
-			b.WriteString("\n")
+			b.WriteString(styles.muted.Render(fmt.Sprintf("(%d unmanaged path(s), unload will leave it)", len(dir.Unmanaged))))
 		}
+		b.WriteString("\n")
+	}
+}
+
+// renderStatusByRoot renders tracked objects grouped under the manifest root
+// that declared them.
+func renderStatusByRoot(snapshot store.StatusSnapshot, roots []store.RootStatus, opts statusRenderOptions) (string, error) {
+	styles := newStatusStyles(colorEnabled(opts.ColorMode, opts.Stdout))
+	var b strings.Builder
+
+	b.WriteString(renderProfileHeader(snapshot, styles))
+	b.WriteString("\n")
+	b.WriteString(renderVerdict(snapshot.Summary(), styles))
+	b.WriteString("\n")
+	b.WriteString(styles.muted.Render(renderSummary(snapshot)))
+	b.WriteString("\n")
+	b.WriteString(renderAlgorithmWarning(snapshot, styles))
+
+	if len(roots) == 0 {
+		b.WriteString("\n")
+		b.WriteString(styles.title.Render("Tracked objects:"))
+		b.WriteString("\n")
+		renderTrackedSection(&b, nil, "", opts.Flat, styles)
 		return b.String(), nil
 	}
 
-	root := buildStatusTree(snapshot.Tracked)
-	for i, child := range root.Children {
-		renderTreeNode(&b, child, "", i == len(root.Children)-1, styles, true)
+	for _, root := range roots {
+		b.WriteString("\n")
+		switch {
+		case root.Profile != "" && root.Index < 0:
+			b.WriteString(styles.title.Render(fmt.Sprintf("Added profile %s, not declared by its manifest (%s):", root.Profile, root.Source)))
+		case root.Profile != "":
+			b.WriteString(styles.title.Render(fmt.Sprintf("Added profile %s roots[%d] %s -> %s:", root.Profile, root.Index, root.Source, root.Dest)))
+		case root.Index < 0:
+			b.WriteString(styles.title.Render("Not declared by the current manifest:"))
+		default:
+			b.WriteString(styles.title.Render(fmt.Sprintf("roots[%d] %s -> %s:", root.Index, root.Source, root.Dest)))
+		}
+		b.WriteString("\n")
+		renderTrackedSection(&b, root.Tracked, "  ", opts.Flat, styles)
 	}
+	renderAutoDirs(&b, snapshot.AutoDirs, styles)
+	renderHardlinks(&b, snapshot.Hardlinks, styles)
 
 	return b.String(), nil
 }
 
+func renderTrackedSection(b *strings.Builder, tracked []store.TrackedStatus, indent string, flat bool, styles statusStyles) {
+	if len(tracked) == 0 {
+		b.WriteString(indent + "  ")
+		b.WriteString(styles.muted.Render("(none)"))
+		b.WriteString("\n")
+		return
+	}
+
+	if flat {
+		for _, item := range tracked {
+			b.WriteString(renderTrackedLine(indent+"  ", filepath.Base(item.Path), item, styles))
+			b.WriteString("  ")
+			b.WriteString(styles.muted.Render(item.Path))
+			b.WriteString("\n")
+		}
+		return
+	}
+
+	root := buildStatusTree(tracked)
+	for i, child := range root.Children {
+		renderTreeNode(b, child, indent, i == len(root.Children)-1, styles, true)
+	}
+}
+
 func renderBackups(snapshot store.StatusSnapshot, opts statusRenderOptions) (string, error) {
 	styles := newStatusStyles(colorEnabled(opts.ColorMode, opts.Stdout))
 	var b strings.Builder
@@ -107,6 +204,15 @@ func renderBackups(snapshot store.StatusSnapshot, opts statusRenderOptions) (str
 			b.WriteString("  ")
 			b.WriteString(styles.digest.Render(ref.Digest))
 			b.WriteString("\n")
+			if ref.Meta != nil {
+				b.WriteString("       ")
+				taken := "taken from " + ref.Meta.Path
+				if !ref.Meta.Taken.IsZero() {
+					taken += " on " + ref.Meta.Taken.Format(time.DateTime)
+				}
+				b.WriteString(styles.muted.Render(fmt.Sprintf("%s (%s, modified %s)", taken, ref.Meta.Mode, ref.Meta.ModTime.Format(time.DateTime))))
+				b.WriteString("\n")
+			}
 			for _, path := range ref.Paths {
 				b.WriteString("       ")
 				b.WriteString(styles.muted.Render(path))
@@ -115,6 +221,28 @@ func renderBackups(snapshot store.StatusSnapshot, opts statusRenderOptions) (str
 		}
 	}
 
+	if len(snapshot.Stashed) > 0 {
+		b.WriteString("\n")
+		b.WriteString(styles.title.Render("Stashed drifted content:"))
+		b.WriteString("\n")
+		for _, stash := range snapshot.Stashed {
+			stateLabel := "missing"
+			lineStyle := styles.warn
+			if stash.Present {
+				stateLabel = "present"
+				lineStyle = styles.ok
+			}
+			b.WriteString("  ")
+			b.WriteString(lineStyle.Render(stateLabel))
+			b.WriteString("  ")
+			b.WriteString(styles.digest.Render(stash.Digest))
+			b.WriteString("\n")
+			b.WriteString("       ")
+			b.WriteString(styles.muted.Render(stash.Path))
+			b.WriteString("\n")
+		}
+	}
+
 	b.WriteString("\n")
 	b.WriteString(styles.title.Render("Unreferenced backup objects:"))
 	b.WriteString("\n")
@@ -145,13 +273,38 @@ func renderBackups(snapshot store.StatusSnapshot, opts statusRenderOptions) (str
 		}
 	}
 
+	if len(snapshot.InaccessibleBackups) > 0 {
+		b.WriteString("\n")
+		b.WriteString(styles.title.Render("Unreadable backup directories:"))
+		b.WriteString("\n")
+		for _, dir := range snapshot.InaccessibleBackups {
+			b.WriteString("  ")
+			b.WriteString(styles.alert.Render("denied"))
+			b.WriteString("  ")
+			b.WriteString(dir)
+			b.WriteString("\n")
+		}
+	}
+
 	return b.String(), nil
 }
 
 func renderProfileHeader(snapshot store.StatusSnapshot, styles statusStyles) string {
+	var added []string
+	for _, p := range snapshot.Added {
+		added = append(added, profileutils.DisplayName(p.Slug, p.Name, p.Path))
+	}
+
 	profileState := strings.ToLower(snapshot.Profile.State)
 	if profileState == "loaded" && strings.TrimSpace(snapshot.Profile.Path) != "" {
-		return styles.title.Render("On profile " + profileutils.DisplayName(snapshot.Profile.Slug, snapshot.Profile.Name, snapshot.Profile.Path))
+		header := "On profile " + profileutils.DisplayName(snapshot.Profile.Slug, snapshot.Profile.Name, snapshot.Profile.Path)
+		if len(added) > 0 {
+			header += " with " + strings.Join(added, ", ")
+		}
+		return styles.title.Render(header)
+	}
+	if len(added) > 0 {
+		return styles.title.Render("On added profiles " + strings.Join(added, ", "))
 	}
 	return styles.title.Render("No profile loaded")
 }
@@ -163,12 +316,13 @@ func renderSummary(snapshot store.StatusSnapshot) string {
 		"T": 0,
 		"B": 0,
 		"!": 0,
+		"D": 0,
 	}
 	for _, tracked := range snapshot.Tracked {
 		counts[trackedStateFor(tracked).Code]++
 	}
 
-	return fmt.Sprintf(
+	line := fmt.Sprintf(
 		"%d tracked  %d drifted  %d missing  %d new  %d backed up  %d backup-missing",
 		len(snapshot.Tracked),
 		counts["M"],
@@ -177,6 +331,35 @@ func renderSummary(snapshot store.StatusSnapshot) string {
 		counts["B"],
 		counts["!"],
 	)
+	if counts["D"] > 0 {
+		line += fmt.Sprintf("  %d inaccessible", counts["D"])
+	}
+	return line
+}
+
+// renderVerdict renders the one-glance clean/dirty line shown above the
+// detailed lists.
+func renderVerdict(summary store.StatusSummary, styles statusStyles) string {
+	verdict := styles.ok.Render("clean")
+	if !summary.Clean {
+		verdict = styles.err.Render("dirty")
+	}
+	line := fmt.Sprintf("%d tracked, %d drifted, %d missing, %d backups (%d missing)",
+		summary.Tracked, summary.Drifted, summary.Missing, summary.Backups, summary.MissingBackups)
+	if summary.BrokenBackups > 0 {
+		line += fmt.Sprintf(", %d broken", summary.BrokenBackups)
+	}
+	if summary.Inaccessible > 0 {
+		line += fmt.Sprintf(", %d inaccessible", summary.Inaccessible)
+	}
+	return verdict + "  " + line
+}
+
+func renderAlgorithmWarning(snapshot store.StatusSnapshot, styles statusStyles) string {
+	if len(snapshot.Algorithms) < 2 {
+		return ""
+	}
+	return styles.warn.Render(fmt.Sprintf("state mixes digest algorithms (%s), run `tohru rehash` to migrate", strings.Join(snapshot.Algorithms, ", "))) + "\n"
 }
 
 func renderTreeNode(b *strings.Builder, node *statusTreeNode, prefix string, isLast bool, styles statusStyles, isRoot bool) {
@@ -226,34 +409,42 @@ func renderTrackedLine(prefix, label string, tracked store.TrackedStatus, styles
 	}
 
 	parts = append(parts, trackedLineStyle(state.Code, styles).Render(formatTrackedLabel(label, tracked)))
+	switch {
+	case tracked.Inaccessible:
+		parts = append(parts, styles.alert.Render("(permission denied)"))
+	case tracked.TargetMissing:
+		parts = append(parts, styles.err.Render("(target missing)"))
+	case tracked.TargetChanged:
+		parts = append(parts, styles.warn.Render("(target changed)"))
+	}
 	return strings.Join(parts, " ")
 }
 
 func trackedStateFor(tracked store.TrackedStatus) trackedState {
 	switch {
+	case tracked.Inaccessible:
+		return trackedState{Code: "D", Label: "inaccessible", Icon: "⊘"}
 	case tracked.Drifted && tracked.Missing:
-		return trackedState{Code: "X", Label: "missing", Icon: "!T"}
+		return trackedState{Code: "X", Label: "missing", Icon: "✗"}
 	case tracked.Drifted:
-		return trackedState{Code: "M", Label: "drifted", Icon: "?T"}
+		return trackedState{Code: "M", Label: "drifted", Icon: "●"}
 	case tracked.PrevDigest == "":
-		return trackedState{Code: "T", Label: "new", Icon: "."}
+		return trackedState{Code: "T", Label: "new", Icon: "◌"}
 	case tracked.BackupPresent:
-		return trackedState{Code: "B", Label: "backed up", Icon: "+"}
+		return trackedState{Code: "B", Label: "backed up", Icon: "↺"}
 	default:
-		return trackedState{Code: "!", Label: "backup missing", Icon: "!B"}
+		return trackedState{Code: "!", Label: "backup missing", Icon: "⚠"}
 	}
 }
 
 func trackedLineStyle(code string, styles statusStyles) lipgloss.Style {
 	switch code {
 	case "B":
-		return styles.ok
-	case "M":
 		return styles.warn
-	case "X":
+	case "M", "X":
 		return styles.err
 	case "T":
-		return styles.info
+		return styles.ok
 	default:
 		return styles.alert
 	}
@@ -262,9 +453,9 @@ func trackedLineStyle(code string, styles statusStyles) lipgloss.Style {
 func operationIcon(operation string) string {
 	switch operation {
 	case "copy":
-		return "C"
+		return "⎘"
 	case "link":
-		return "L"
+		return "↗"
 	default:
 		return ""
 	}
@@ -375,35 +566,10 @@ func statusPathParts(path string) []string {
 	return parts
 }
 
-func colorEnabled(mode string, stdout *os.File) bool {
-	switch strings.ToLower(strings.TrimSpace(mode)) {
-	case "", "auto":
-		if strings.TrimSpace(os.Getenv("NO_COLOR")) != "" {
-			return false
-		}
-		return isTTY(stdout) && strings.ToLower(strings.TrimSpace(os.Getenv("TERM"))) != "dumb"
-	case "always":
-		return true
-	case "never":
-		return false
-	default:
-		return isTTY(stdout)
-	}
-}
-
-func isTTY(stdout *os.File) bool {
-	if stdout == nil {
-		return false
-	}
-	info, err := stdout.Stat()
-	if err != nil {
-		return false
-	}
-	return info.Mode()&os.ModeCharDevice != 0
-}
-
+// newStatusStyles returns the styles status output is rendered with: drifted
+// and missing paths in red, backed-up ones in yellow and clean ones in green.
 func newStatusStyles(color bool) statusStyles {
-	makeStyle := func() lipgloss.Style { return lipgloss.NewStyle() }
+	makeStyle := newColorRenderer(color).NewStyle
 	if !color {
 		return statusStyles{
 			title: makeStyle().Bold(true),
@@ -420,6 +586,7 @@ func newStatusStyles(color bool) statusStyles {
 				"X": makeStyle().Bold(true),
 				"T": makeStyle().Bold(true),
 				"!": makeStyle().Bold(true),
+				"D": makeStyle().Bold(true),
 			},
 			kindBadge: makeStyle(),
 			digest:    makeStyle(),
@@ -430,17 +597,18 @@ func newStatusStyles(color bool) statusStyles {
 		title: makeStyle().Bold(true),
 		muted: makeStyle().Foreground(lipgloss.Color("241")),
 		node:  makeStyle().Foreground(lipgloss.Color("247")),
-		ok:    makeStyle().Foreground(lipgloss.Color("42")),
-		warn:  makeStyle().Foreground(lipgloss.Color("214")),
-		err:   makeStyle().Foreground(lipgloss.Color("203")),
+		ok:    makeStyle().Foreground(colorGreen),
+		warn:  makeStyle().Foreground(colorYellow),
+		err:   makeStyle().Foreground(colorRed),
 		info:  makeStyle().Foreground(lipgloss.Color("75")),
 		alert: makeStyle().Foreground(lipgloss.Color("177")),
 		statusBadge: map[string]lipgloss.Style{
-			"B": makeStyle().Bold(true).Foreground(lipgloss.Color("42")),
-			"M": makeStyle().Bold(true).Foreground(lipgloss.Color("214")),
-			"X": makeStyle().Bold(true).Foreground(lipgloss.Color("203")),
-			"T": makeStyle().Bold(true).Foreground(lipgloss.Color("75")),
+			"B": makeStyle().Bold(true).Foreground(colorYellow),
+			"M": makeStyle().Bold(true).Foreground(colorRed),
+			"X": makeStyle().Bold(true).Foreground(colorRed),
+			"T": makeStyle().Bold(true).Foreground(colorGreen),
 			"!": makeStyle().Bold(true).Foreground(lipgloss.Color("177")),
+			"D": makeStyle().Bold(true).Foreground(lipgloss.Color("177")),
 		},
 		kindBadge: makeStyle().Foreground(lipgloss.Color("109")),
 		digest:    makeStyle().Foreground(lipgloss.Color("109")),
@@ -1,6 +1,8 @@
 package cmd
 
 import (
+	"os"
+	"slices"
 	"strings"
 	"testing"
 
@@ -116,3 +118,232 @@ func TestRenderStatusFoldsDirectoryChains(t *testing.T) {
 		t.Fatalf("renderStatus() output missing folded leaf\noutput:\n%s", got)
 	}
 }
+
+func TestRenderStatusByRoot(t *testing.T) {
+	home := store.TrackedStatus{Path: "/Users/test/.zshrc", ManagedKind: digest.KindFile, Operation: "copy"}
+	etc := store.TrackedStatus{Path: "/etc/hosts", ManagedKind: digest.KindFile, Operation: "copy"}
+	stale := store.TrackedStatus{Path: "/Users/test/.old", ManagedKind: digest.KindFile, Operation: "copy"}
+	tool := store.TrackedStatus{Path: "/Users/test/.toolrc", ManagedKind: digest.KindFile, Operation: "copy", Profile: "tools"}
+	snapshot := store.StatusSnapshot{Tracked: []store.TrackedStatus{etc, stale, home, tool}}
+	roots := []store.RootStatus{
+		{Index: 0, Source: "home", Dest: "~", Tracked: []store.TrackedStatus{home}},
+		{Index: 1, Source: "etc", Dest: "/etc", Tracked: []store.TrackedStatus{etc}},
+		{Index: -1, Tracked: []store.TrackedStatus{stale}},
+		{Index: 0, Profile: "tools", Source: "home", Dest: "~", Tracked: []store.TrackedStatus{tool}},
+	}
+
+	got, err := renderStatusByRoot(snapshot, roots, statusRenderOptions{Flat: true, ColorMode: "never"})
+	if err != nil {
+		t.Fatalf("renderStatusByRoot() error = %v", err)
+	}
+
+	homeAt := strings.Index(got, "roots[0] home -> ~:")
+	etcAt := strings.Index(got, "roots[1] etc -> /etc:")
+	staleAt := strings.Index(got, "Not declared by the current manifest:")
+	toolsAt := strings.Index(got, "Added profile tools roots[0] home -> ~:")
+	if homeAt < 0 || etcAt < homeAt || staleAt < etcAt || toolsAt < staleAt {
+		t.Fatalf("renderStatusByRoot() root headers missing or out of order\noutput:\n%s", got)
+	}
+	if at := strings.Index(got, "/Users/test/.zshrc"); at < homeAt || at > etcAt {
+		t.Fatalf("renderStatusByRoot() .zshrc not under roots[0]\noutput:\n%s", got)
+	}
+	if at := strings.Index(got, "/Users/test/.old"); at < staleAt || at > toolsAt {
+		t.Fatalf("renderStatusByRoot() .old not under undeclared section\noutput:\n%s", got)
+	}
+	if at := strings.Index(got, "/Users/test/.toolrc"); at < toolsAt {
+		t.Fatalf("renderStatusByRoot() .toolrc not under the added profile's root\noutput:\n%s", got)
+	}
+}
+
+func TestFilterStatus(t *testing.T) {
+	snapshot := store.StatusSnapshot{
+		Tracked: []store.TrackedStatus{
+			{Path: "/home/u/.config/nvim/init.lua"},
+			{Path: "/home/u/.config/kitty/kitty.conf"},
+			{Path: "/home/u/.zshrc"},
+		},
+		BackupRefs: []store.BackupRefStatus{
+			{Digest: "file:sha256:a", Paths: []string{"/home/u/.zshrc"}},
+			{Digest: "file:sha256:b", Paths: []string{"/home/u/.config/nvim/init.lua"}},
+		},
+	}
+
+	got, err := filterStatus(snapshot, []string{"/home/u/.config/**"}, []string{"/home/u/.config/kitty/**"})
+	if err != nil {
+		t.Fatalf("filterStatus() error = %v", err)
+	}
+	if len(got.Tracked) != 1 || got.Tracked[0].Path != "/home/u/.config/nvim/init.lua" {
+		t.Fatalf("filterStatus() tracked = %#v", got.Tracked)
+	}
+	if len(got.BackupRefs) != 1 || got.BackupRefs[0].Digest != "file:sha256:b" {
+		t.Fatalf("filterStatus() backup refs = %#v", got.BackupRefs)
+	}
+}
+
+func TestScopeStatus(t *testing.T) {
+	snapshot := store.StatusSnapshot{
+		Tracked: []store.TrackedStatus{
+			{Path: "/home/u/.zshrc", PrevDigest: "file:sha256:a", Drifted: true},
+			{Path: "/home/u/.gitconfig"},
+		},
+		BackupRefs: []store.BackupRefStatus{
+			{Digest: "file:sha256:a", Paths: []string{"/home/u/.zshrc"}},
+		},
+		BrokenBackups: []string{"file:sha256:c"},
+	}
+
+	got, statuses := scopeStatus(snapshot, []string{"/home/u/.vimrc", "/home/u/.zshrc"})
+	want := []pathStatus{
+		{Path: "/home/u/.vimrc", Code: "?", State: "untracked"},
+		{Path: "/home/u/.zshrc", Code: "M", State: "drifted"},
+	}
+	if !slices.Equal(statuses, want) {
+		t.Fatalf("scopeStatus() statuses = %#v, want %#v", statuses, want)
+	}
+	if len(got.Tracked) != 1 || len(got.BackupRefs) != 1 || len(got.BrokenBackups) != 0 {
+		t.Fatalf("scopeStatus() snapshot = %#v, want only .zshrc and its backup", got)
+	}
+}
+
+func TestCheckStatus(t *testing.T) {
+	snapshot := store.StatusSnapshot{
+		Tracked: []store.TrackedStatus{
+			{Path: "/home/u/.zshrc", Drifted: true},
+			{Path: "/home/u/.gitconfig"},
+		},
+		BackupRefs: []store.BackupRefStatus{
+			{Digest: "file:sha256:a", Paths: []string{"/home/u/.zshrc"}, Present: true},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		failOn  []string
+		wantErr string
+	}{
+		{name: "disabled"},
+		{name: "default", failOn: []string{}, wantErr: "1 drifted path(s)"},
+		{name: "missing only", failOn: []string{"missing"}},
+		{name: "comma separated", failOn: []string{"missing,drift"}, wantErr: "1 drifted path(s)"},
+		{name: "unknown", failOn: []string{"stale"}, wantErr: `unknown condition "stale"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var err error
+			if tt.failOn != nil {
+				var failOn []string
+				if failOn, err = parseFailOn(tt.failOn); err == nil {
+					err = checkStatus(snapshot, failOn)
+				}
+			}
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("error = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRenderStatusAutoDirs(t *testing.T) {
+	snapshot := store.StatusSnapshot{
+		AutoDirs: []store.AutoDirStatus{
+			{Path: "/home/u/.config", OnlyManaged: true},
+			{Path: "/home/u/.local", Unmanaged: []string{"/home/u/.local/share"}},
+		},
+	}
+
+	got, err := renderStatus(snapshot, statusRenderOptions{ColorMode: "never"})
+	if err != nil {
+		t.Fatalf("renderStatus() error = %v", err)
+	}
+	for _, want := range []string{
+		"Auto-created directories:",
+		"managed  /home/u/.config",
+		"kept     /home/u/.local  (1 unmanaged path(s), unload will leave it)",
+	} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("renderStatus() output missing %q\noutput:\n%s", want, got)
+		}
+	}
+}
+
+func TestRenderStatusVerdict(t *testing.T) {
+	clean := store.StatusSnapshot{
+		Tracked: []store.TrackedStatus{
+			{Path: "/home/u/.zshrc", PrevDigest: "abc", BackupPresent: true},
+		},
+		BackupRefs: []store.BackupRefStatus{
+			{Digest: "file:sha256:a", Paths: []string{"/home/u/.zshrc"}, Present: true},
+		},
+	}
+	dirty := clean
+	dirty.Tracked = append(slices.Clone(clean.Tracked),
+		store.TrackedStatus{Path: "/home/u/.gitconfig", Drifted: true},
+		store.TrackedStatus{Path: "/home/u/.vimrc", Drifted: true, Missing: true},
+	)
+	dirty.BackupRefs = append(slices.Clone(clean.BackupRefs),
+		store.BackupRefStatus{Digest: "file:sha256:b", Paths: []string{"/home/u/.gitconfig"}},
+	)
+
+	tests := []struct {
+		name     string
+		snapshot store.StatusSnapshot
+		want     string
+	}{
+		{name: "clean", snapshot: clean, want: "clean  1 tracked, 0 drifted, 0 missing, 1 backups (0 missing)"},
+		{name: "dirty", snapshot: dirty, want: "dirty  3 tracked, 1 drifted, 1 missing, 2 backups (1 missing)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := renderStatus(tt.snapshot, statusRenderOptions{ColorMode: "never"})
+			if err != nil {
+				t.Fatalf("renderStatus() error = %v", err)
+			}
+			verdict := strings.Index(got, tt.want)
+			if verdict < 0 {
+				t.Fatalf("renderStatus() output missing %q\noutput:\n%s", tt.want, got)
+			}
+			if verdict > strings.Index(got, "Tracked objects:") {
+				t.Fatalf("renderStatus() verdict not above the tracked list\noutput:\n%s", got)
+			}
+		})
+	}
+}
+
+func TestRenderStatusColorModes(t *testing.T) {
+	snapshot := store.StatusSnapshot{
+		Tracked: []store.TrackedStatus{
+			{Path: "/tmp/example", PrevDigest: "abc", Drifted: true, ManagedKind: digest.KindFile, Operation: "copy"},
+		},
+	}
+	pipe, err := os.CreateTemp(t.TempDir(), "stdout")
+	if err != nil {
+		t.Fatalf("CreateTemp() error = %v", err)
+	}
+	defer pipe.Close()
+
+	for _, tt := range []struct {
+		mode    string
+		noColor string
+		want    bool
+	}{
+		{mode: "always", want: true},
+		{mode: "always", noColor: "1", want: true},
+		{mode: "never"},
+		{mode: "auto"}, // stdout isn't a terminal
+	} {
+		t.Setenv("NO_COLOR", tt.noColor)
+		got, err := renderStatus(snapshot, statusRenderOptions{ColorMode: tt.mode, Stdout: pipe})
+		if err != nil {
+			t.Fatalf("renderStatus() error = %v", err)
+		}
+		if ansi := strings.Contains(got, "\x1b["); ansi != tt.want {
+			t.Fatalf("renderStatus(--color=%s, NO_COLOR=%q) colored = %t, want %t", tt.mode, tt.noColor, ansi, tt.want)
+		}
+	}
+}
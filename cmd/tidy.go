@@ -2,7 +2,6 @@ package cmd
 
 import (
 	"context"
-	"fmt"
 
 	"github.com/olimci/tohru/pkg/store"
 	"github.com/urfave/cli/v3"
@@ -10,8 +9,34 @@ import (
 
 func tidyCommand() *cli.Command {
 	return &cli.Command{
-		Name:   "tidy",
-		Usage:  "remove untracked backups",
+		Name:  "tidy",
+		Usage: "remove untracked and broken backups",
+		Flags: []cli.Flag{
+			&cli.StringSliceFlag{
+				Name:  "include",
+				Usage: "only remove backups taken from a path matching this glob (repeatable)",
+			},
+			&cli.StringSliceFlag{
+				Name:  "exclude",
+				Usage: "keep backups taken from a path matching this glob (repeatable)",
+			},
+			&cli.BoolFlag{
+				Name:  "stashed",
+				Usage: "also drop stashed backups of drifted content",
+			},
+			&cli.BoolFlag{
+				Name:  "orphans",
+				Usage: "remove backups nothing refers to (status: orphaned backups)",
+			},
+			&cli.BoolFlag{
+				Name:  "broken",
+				Usage: "remove backup entries with no object in them (status: broken backup entries)",
+			},
+			&cli.BoolFlag{
+				Name:  "corrupted",
+				Usage: "remove backups whose object no longer matches its CID, even if referenced",
+			},
+		},
 		Action: tidyAction,
 	}
 }
@@ -19,20 +44,34 @@ func tidyCommand() *cli.Command {
 func tidyAction(_ context.Context, cmd *cli.Command) error {
 	args := cmd.Args().Slice()
 	if len(args) > 0 {
-		return fmt.Errorf("tidy does not accept arguments")
+		return usageError("tidy does not accept arguments")
 	}
 
-	s, err := store.DefaultStore()
+	s, err := store.OpenDefault()
 	if err != nil {
 		return err
 	}
 
-	res, err := s.Tidy()
-	if err != nil {
+	res, err := s.Tidy(store.TidyOptions{
+		Include:   cmd.StringSlice("include"),
+		Exclude:   cmd.StringSlice("exclude"),
+		Stashed:   cmd.Bool("stashed"),
+		Orphans:   cmd.Bool("orphans"),
+		Broken:    cmd.Bool("broken"),
+		Corrupted: cmd.Bool("corrupted"),
+	})
+	// A partial tidy still reports what it removed before failing.
+	if err != nil && len(res.ChangedPaths) == 0 {
 		return err
 	}
 
-	fmt.Printf("tidied backups (%d object(s) removed)\n", res.RemovedCount)
+	printf(cmd, "tidied backups (%d object(s) removed)\n", res.RemovedCount)
+	if res.RemovedBrokenCount > 0 {
+		printf(cmd, "removed %d broken backup(s)\n", res.RemovedBrokenCount)
+	}
+	if res.RemovedCorruptedCount > 0 {
+		printf(cmd, "removed %d corrupted backup(s)\n", res.RemovedCorruptedCount)
+	}
 	printChanges(cmd, res.ChangedPaths)
-	return nil
+	return err
 }
@@ -2,7 +2,6 @@ package cmd
 
 import (
 	"context"
-	"fmt"
 
 	"github.com/olimci/tohru/pkg/store"
 	"github.com/urfave/cli/v3"
@@ -17,10 +16,16 @@ func uninstallCommand() *cli.Command {
 				Name:    "force",
 				Aliases: []string{"f"},
 				Usage:   "force unload of modified managed files before uninstalling",
+				Sources: cli.EnvVars("TOHRU_FORCE"),
 			},
 			&cli.BoolFlag{
-				Name:  "discard-changes",
-				Usage: "allow uninstall to remove modified managed files without full force behavior",
+				Name:    "discard-changes",
+				Usage:   "allow uninstall to remove modified managed files without full force behavior",
+				Sources: cli.EnvVars("TOHRU_DISCARD_CHANGES"),
+			},
+			&cli.BoolFlag{
+				Name:  "keep-files",
+				Usage: "leave managed files in place instead of removing them",
 			},
 		},
 		Action: uninstallAction,
@@ -31,33 +36,40 @@ func uninstallAction(_ context.Context, cmd *cli.Command) error {
 	args := cmd.Args().Slice()
 
 	if len(args) > 0 {
-		return fmt.Errorf("uninstall does not accept arguments")
+		return usageError("uninstall does not accept arguments")
 	}
 	opts := cmdOptions(cmd)
 
-	s, err := store.DefaultStore()
+	s, err := store.OpenDefault()
 	if err != nil {
 		return err
 	}
 
-	unloadRes, err := s.UnloadAndUninstall(opts)
+	unloadRes, removed, err := s.UnloadAndUninstall(opts)
 	if err != nil {
 		return err
 	}
-	if unloadRes.ProfileName != "" || unloadRes.RemovedCount > 0 {
+	if unloadRes.ProfileName != "" || unloadRes.RemovedCount > 0 || unloadRes.UntrackedCount > 0 {
 		name := unloadRes.ProfileName
 		if name == "" {
 			name = "profile"
 		}
-		fmt.Printf("unloaded %s (%d managed object(s))\n", name, unloadRes.RemovedCount)
+		if unloadRes.UntrackedCount > 0 {
+			printf(cmd, "unloaded %s (%d managed object(s) left in place)\n", name, unloadRes.UntrackedCount)
+		} else {
+			printf(cmd, "unloaded %s (%d managed object(s))\n", name, unloadRes.RemovedCount)
+		}
+	}
+	if unloadRes.RestoredCount > 0 {
+		printf(cmd, "restored %d backup object(s)\n", unloadRes.RestoredCount)
 	}
 	if unloadRes.RemovedBackupCount > 0 {
-		fmt.Printf("cleaned %d unreferenced backup object(s)\n", unloadRes.RemovedBackupCount)
+		printf(cmd, "cleaned %d unreferenced backup object(s)\n", unloadRes.RemovedBackupCount)
 	}
-	printWarnings(unloadRes.Warnings)
+	printWarnings(cmd, unloadRes.Warnings)
 	printChanges(cmd, unloadRes.ChangedPaths)
-	printChanges(cmd, []string{s.Root})
+	printChanges(cmd, removed.RemovedPaths)
 
-	fmt.Printf("uninstalled tohru store from %s\n", s.Root)
+	printf(cmd, "uninstalled tohru store from %s\n", s.Root)
 	return nil
 }
@@ -11,17 +11,53 @@ import (
 
 func unloadCommand() *cli.Command {
 	return &cli.Command{
-		Name:  "unload",
-		Usage: "unload the currently loaded profile",
+		Name:      "unload",
+		Usage:     "unload the loaded profiles, or just the one named",
+		ArgsUsage: "[profile]",
 		Flags: []cli.Flag{
 			&cli.BoolFlag{
 				Name:    "force",
 				Aliases: []string{"f"},
 				Usage:   "force unload, even with missing/changed paths or restore conflicts",
+				Sources: cli.EnvVars("TOHRU_FORCE"),
 			},
 			&cli.BoolFlag{
-				Name:  "discard-changes",
-				Usage: "allow removing modified managed files without enabling full force behavior",
+				Name:    "discard-changes",
+				Usage:   "allow removing modified managed files without enabling full force behavior",
+				Sources: cli.EnvVars("TOHRU_DISCARD_CHANGES"),
+			},
+			&cli.BoolFlag{
+				Name:    "force-backup",
+				Usage:   "back up drifted managed files before overwriting or removing them",
+				Sources: cli.EnvVars("TOHRU_FORCE_BACKUP"),
+			},
+			&cli.StringFlag{
+				Name:    "rollback",
+				Value:   string(store.RollbackStrict),
+				Usage:   "how to undo a failure part-way: strict, best-effort (restore what it can and report the rest) or leave (keep the partial state)",
+				Sources: cli.EnvVars("TOHRU_ROLLBACK"),
+			},
+			&cli.IntFlag{
+				Name:    "retries",
+				Usage:   "retry filesystem changes failing with transient errors (EAGAIN, ESTALE) this many times",
+				Sources: cli.EnvVars("TOHRU_RETRIES"),
+			},
+			&cli.BoolFlag{
+				Name:    "keep-state-backup",
+				Usage:   "copy the state file to state.json.bak before changing it, for state restore",
+				Sources: cli.EnvVars("TOHRU_KEEP_STATE_BACKUP"),
+			},
+			&cli.BoolFlag{
+				Name:  "keep-files",
+				Usage: "stop tracking managed files but leave them in place",
+			},
+			&cli.BoolFlag{
+				Name:  "plan",
+				Usage: "print what unloading would remove and restore, and where it would fail, without doing it",
+			},
+			&cli.BoolFlag{
+				Name:  "json",
+				Usage: "print the result, including every applied operation, as JSON",
 			},
 		},
 		Action: unloadAction,
@@ -30,43 +66,115 @@ func unloadCommand() *cli.Command {
 
 func unloadAction(_ context.Context, cmd *cli.Command) error {
 	args := cmd.Args().Slice()
-	if len(args) > 0 {
-		return fmt.Errorf("unload does not accept arguments")
+	if len(args) > 1 {
+		return usageError("unload accepts at most one profile argument")
 	}
 	opts := cmdOptions(cmd)
 
-	s, err := store.DefaultStore()
+	s, err := store.OpenDefault()
 	if err != nil {
 		return err
 	}
 
 	if !s.IsInstalled() {
-		return fmt.Errorf("tohru is not installed")
+		return store.ErrNotInstalled
 	}
 
 	lck, err := s.LoadState()
 	if err != nil {
 		return err
 	}
-	if strings.ToLower(lck.Profile.State) != "loaded" && len(lck.Files) == 0 {
-		fmt.Println("nothing to unload")
+	if strings.ToLower(lck.Profile.State) != "loaded" && len(lck.Files) == 0 && len(lck.Added) == 0 {
+		if cmd.Bool("json") {
+			return printJSON(store.UnloadResult{})
+		}
+		printf(cmd, "nothing to unload\n")
 		return nil
 	}
 
-	res, err := s.Unload(opts)
+	if cmd.Bool("plan") {
+		plan, err := s.UnloadPlan(cmd.Args().First(), opts)
+		if err != nil {
+			return err
+		}
+		if cmd.Bool("json") {
+			if err := printJSON(plan); err != nil {
+				return err
+			}
+		} else {
+			printUnloadPlan(plan)
+		}
+		if plan.Failing() {
+			return fmt.Errorf("unloading %s would fail", plan.ProfileName)
+		}
+		return nil
+	}
+
+	var res store.UnloadResult
+	if profile := cmd.Args().First(); profile != "" {
+		res, err = s.UnloadProfile(profile, opts)
+	} else {
+		res, err = s.Unload(opts)
+	}
 	if err != nil {
 		return err
 	}
 
+	if cmd.Bool("json") {
+		return printJSON(res)
+	}
+
 	name := res.ProfileName
 	if name == "" {
 		name = "profile"
 	}
-	fmt.Printf("unloaded %s (%d managed object(s))\n", name, res.RemovedCount)
+	if res.UntrackedCount > 0 {
+		printf(cmd, "unloaded %s (%d managed object(s) left in place)\n", name, res.UntrackedCount)
+	} else {
+		printf(cmd, "unloaded %s (%d managed object(s))\n", name, res.RemovedCount)
+	}
+	if res.RestoredCount > 0 {
+		printf(cmd, "restored %d backup object(s)\n", res.RestoredCount)
+	}
 	if res.RemovedBackupCount > 0 {
-		fmt.Printf("cleaned %d unreferenced backup object(s)\n", res.RemovedBackupCount)
+		printf(cmd, "cleaned %d unreferenced backup object(s)\n", res.RemovedBackupCount)
 	}
-	printWarnings(res.Warnings)
+	printStashed(cmd, res.StashedPaths)
+	printWarnings(cmd, res.Warnings)
 	printChanges(cmd, res.ChangedPaths)
 	return nil
 }
+
+// printUnloadPlan prints one line per tracked path of plan. It prints even
+// with --quiet, since the plan is the whole point of the command.
+func printUnloadPlan(plan store.UnloadPlan) {
+	fmt.Printf("unloading %s would:\n", plan.ProfileName)
+	for _, path := range plan.Paths {
+		if path.Problem != "" {
+			fmt.Printf("  fail at %s: %s\n", path.Path, path.Problem)
+			continue
+		}
+
+		action := "leave in place"
+		switch {
+		case path.Remove:
+			action = "remove"
+		case path.Restore != "":
+			action = "put back"
+		}
+		var notes []string
+		if path.Stash {
+			notes = append(notes, "backing up drifted content")
+		} else if path.Drifted && path.Remove {
+			notes = append(notes, "discarding drifted content")
+		}
+		if path.Restore != "" {
+			notes = append(notes, "restoring "+path.Restore)
+		}
+		line := fmt.Sprintf("  %s %s", action, path.Path)
+		if len(notes) > 0 {
+			line += " (" + strings.Join(notes, ", ") + ")"
+		}
+		fmt.Println(line)
+	}
+}
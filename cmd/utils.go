@@ -1,7 +1,10 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
+	"os"
+	"strings"
 
 	"github.com/olimci/tohru/pkg/store"
 	"github.com/urfave/cli/v3"
@@ -9,20 +12,48 @@ import (
 
 func cmdOptions(cmd *cli.Command) store.Options {
 	return store.Options{
-		Force:          cmd.Bool("force"),
-		DiscardChanges: cmd.Bool("discard-changes"),
+		Force:           cmd.Bool("force"),
+		DiscardChanges:  cmd.Bool("discard-changes"),
+		SortByDest:      cmd.Bool("sort"),
+		IgnoreVersion:   cmd.Bool("ignore-version"),
+		Umask:           cmd.String("umask"),
+		KeepFiles:       cmd.Bool("keep-files"),
+		BackupDrifted:   cmd.Bool("force-backup"),
+		NoParents:       cmd.IsSet("parents") && !cmd.Bool("parents"),
+		Repair:          cmd.Bool("repair"),
+		Retries:         cmd.Int("retries"),
+		ExpectName:      cmd.String("expect-name"),
+		Add:             cmd.Bool("add"),
+		AllowEmpty:      cmd.Bool("allow-empty"),
+		Insecure:        cmd.Bool("insecure"),
+		ManifestDir:     cmd.String("manifest-dir"),
+		NoBackup:        cmd.Bool("no-backup"),
+		Rollback:        store.RollbackPolicy(cmd.String("rollback")),
+		RenameConflicts: cmd.Bool("rename-conflicts"),
+		KeepStateBackup: cmd.Bool("keep-state-backup"),
 	}
 }
 
-func printChanges(cmd *cli.Command, paths []string) {
-	if len(paths) == 0 {
+// isVerbose reports whether --verbose was set on the command or the root.
+func isVerbose(cmd *cli.Command) bool {
+	return cmd.Bool("verbose") || cmd.Root().Bool("verbose")
+}
+
+// isQuiet reports whether --quiet was set on the command or the root.
+func isQuiet(cmd *cli.Command) bool {
+	return cmd.Bool("quiet") || cmd.Root().Bool("quiet")
+}
+
+// printf prints a success message unless --quiet is set.
+func printf(cmd *cli.Command, format string, args ...any) {
+	if isQuiet(cmd) {
 		return
 	}
-	isVerbose := cmd.Bool("verbose")
-	if !isVerbose {
-		isVerbose = cmd.Root().Bool("verbose")
-	}
-	if !isVerbose {
+	fmt.Printf(format, args...)
+}
+
+func printChanges(cmd *cli.Command, paths []string) {
+	if len(paths) == 0 || !isVerbose(cmd) {
 		return
 	}
 	fmt.Println("changed paths:")
@@ -31,11 +62,86 @@ func printChanges(cmd *cli.Command, paths []string) {
 	}
 }
 
-func printWarnings(warnings []string) {
+func printStashed(cmd *cli.Command, paths []string) {
+	for _, path := range paths {
+		printf(cmd, "backed up drifted %s (see `tohru status --backups`)\n", path)
+	}
+}
+
+func printRenamed(cmd *cli.Command, ops []store.AppliedOp) {
+	for _, op := range ops {
+		if op.RenamedTo != "" {
+			printf(cmd, "moved existing %s aside to %s\n", op.Path, op.RenamedTo)
+		}
+	}
+}
+
+// printStat prints the --stat summary of a load or reload: what its
+// operations did and how many bytes they copied and backed up.
+func printStat(cmd *cli.Command, res store.LoadResult) {
+	if !cmd.Bool("stat") {
+		return
+	}
+	printf(cmd, "%s\n", statLine(res))
+}
+
+func statLine(res store.LoadResult) string {
+	kinds := map[string]int{}
+	actions := map[string]int{}
+	for _, op := range res.Operations {
+		actions[op.Action]++
+		if op.Action != store.ActionRemoved && op.Action != store.ActionRestored {
+			kinds[op.Kind]++
+		}
+	}
+
+	count := func(order []string, counts map[string]int) string {
+		var parts []string
+		for _, key := range order {
+			if counts[key] > 0 {
+				parts = append(parts, fmt.Sprintf("%d %s", counts[key], key))
+			}
+		}
+		if len(parts) == 0 {
+			return "none"
+		}
+		return strings.Join(parts, ", ")
+	}
+	return fmt.Sprintf("stat: %s (%s); %s copied, %s backed up",
+		count([]string{"file", "link", "copy", "dir"}, kinds),
+		count([]string{
+			store.ActionCreated, store.ActionReplaced, store.ActionAdopted, store.ActionKept,
+			store.ActionSkipped, store.ActionRemoved, store.ActionRestored,
+		}, actions),
+		formatBytes(res.BytesCopied), formatBytes(res.BytesBackedUp))
+}
+
+// formatBytes formats n in binary units, e.g. "1.5 KiB".
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func printWarnings(cmd *cli.Command, warnings []string) {
 	for _, warning := range warnings {
 		if warning == "" {
 			continue
 		}
-		fmt.Printf("warning: %s\n", warning)
+		printf(cmd, "warning: %s\n", warning)
 	}
 }
+
+// printJSON writes v to stdout as indented JSON, regardless of --quiet.
+func printJSON(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
@@ -0,0 +1,27 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/olimci/tohru/pkg/store"
+)
+
+func TestStatLine(t *testing.T) {
+	res := store.LoadResult{
+		BytesCopied:   1536,
+		BytesBackedUp: 12,
+		Operations: []store.AppliedOp{
+			{Kind: "file", Action: store.ActionCreated},
+			{Kind: "file", Action: store.ActionReplaced},
+			{Kind: "link", Action: store.ActionAdopted},
+			{Kind: "symlink", Action: store.ActionRemoved},
+		},
+	}
+	want := "stat: 2 file, 1 link (1 created, 1 replaced, 1 adopted, 1 removed); 1.5 KiB copied, 12 B backed up"
+	if got := statLine(res); got != want {
+		t.Fatalf("statLine() = %q, want %q", got, want)
+	}
+	if got := statLine(store.LoadResult{}); got != "stat: none (none); 0 B copied, 0 B backed up" {
+		t.Fatalf("statLine() of an empty result = %q", got)
+	}
+}
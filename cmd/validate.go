@@ -0,0 +1,103 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/olimci/tohru/pkg/manifest"
+	"github.com/olimci/tohru/pkg/store"
+	"github.com/olimci/tohru/pkg/version"
+	"github.com/urfave/cli/v3"
+)
+
+func validateCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "validate",
+		Usage:     "check a profile manifest and report every problem in it",
+		ArgsUsage: "[profile]",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "fix-version",
+				Usage: "once the manifest is valid, update its requires.tohru to this version of tohru",
+			},
+		},
+		Action: validateAction,
+	}
+}
+
+func validateAction(_ context.Context, cmd *cli.Command) error {
+	args := cmd.Args().Slice()
+	if len(args) > 1 {
+		return usageError("validate accepts at most one profile argument")
+	}
+
+	s, err := store.OpenDefault()
+	if err != nil {
+		return err
+	}
+	profile, err := profileArg(s, cmd.Args().First())
+	if err != nil {
+		return err
+	}
+
+	m, dir, err := manifest.Read(profile)
+	if err != nil {
+		return err
+	}
+
+	problems := m.Validate()
+	if len(problems) > 0 {
+		for _, problem := range problems {
+			fmt.Fprintf(os.Stderr, "  %v\n", problem)
+		}
+		return fmt.Errorf("manifest in %s has %d problem(s)", dir, len(problems))
+	}
+
+	if err := m.Resolve(); err != nil {
+		return err
+	}
+	if m.Plan.Len() == 0 {
+		printWarnings(cmd, []string{"manifest declares no links, files or dirs, so loading it would unload everything"})
+	}
+	overlaps, err := s.StoreOverlaps(m, dir)
+	if err != nil {
+		return err
+	}
+	printWarnings(cmd, overlaps)
+
+	printf(cmd, "manifest in %s is valid\n", dir)
+	if cmd.Bool("fix-version") {
+		return fixVersion(cmd, profile, m.Requires.Tohru)
+	}
+	return nil
+}
+
+// fixVersion updates the requires.tohru of the manifest for profile, which
+// declares required, to the running version. Requirements this version can't
+// satisfy are left alone rather than lowered.
+func fixVersion(cmd *cli.Command, profile, required string) error {
+	path, _, err := manifest.Locate(profile)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(required) == "" {
+		printf(cmd, "%s declares no requires.tohru, leaving it unpinned\n", path)
+		return nil
+	}
+	if err := version.EnsureCompatible(required); err != nil {
+		return fmt.Errorf("not updating requires.tohru %q in %s: %w", required, path, err)
+	}
+
+	old, err := manifest.SetRequiredVersion(path, version.Version)
+	if err != nil {
+		return err
+	}
+	if old == version.Version {
+		printf(cmd, "%s already requires tohru %s\n", path, version.Version)
+		return nil
+	}
+	printf(cmd, "updated requires.tohru in %s from %s to %s\n", path, old, version.Version)
+	return nil
+}
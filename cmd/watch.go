@@ -0,0 +1,193 @@
+package cmd
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/olimci/tohru/pkg/store"
+	"github.com/urfave/cli/v3"
+)
+
+// watchState is what reload --watch polls for: the source directory of the
+// loaded profile and the managed paths that drifted from what it applied.
+type watchState struct {
+	Source  string   // hash of the source tree's paths, sizes, modes and mtimes
+	Drifted []string // drifted or missing paths of the main profile
+}
+
+func (w watchState) equal(other watchState) bool {
+	return w.Source == other.Source && slices.Equal(w.Drifted, other.Drifted)
+}
+
+// watchReload reapplies the loaded profile whenever its source directory
+// changes or one of its managed paths drifts, until ctx is cancelled or the
+// source directory disappears. A change is acted on once it has been stable
+// for one interval, so saving several files at once triggers one reload.
+func watchReload(ctx context.Context, cmd *cli.Command, s store.Store, opts store.Options, interval time.Duration) error {
+	if interval <= 0 {
+		return usageError("--watch-interval must be positive")
+	}
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	st, err := s.LoadState()
+	if err != nil {
+		return err
+	}
+	if st.Profile.State != "loaded" {
+		return fmt.Errorf("no profile is loaded to watch")
+	}
+	if st.Profile.Archive != "" {
+		return fmt.Errorf("--watch needs a profile loaded from a directory, not an archive")
+	}
+	source := st.Profile.Path
+
+	last, err := pollWatch(s, source)
+	if err != nil {
+		return err
+	}
+	printf(cmd, "watching %s and %d managed path(s), press ctrl-c to stop\n", source, len(st.Files))
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	pending := last
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		current, err := pollWatch(s, source)
+		if err != nil {
+			return err
+		}
+		if !current.equal(pending) {
+			// Still changing: wait for it to settle.
+			pending = current
+			continue
+		}
+		if current.equal(last) {
+			continue
+		}
+
+		trigger := describeTrigger(last, current)
+		res, err := s.Reload(opts)
+		if err != nil {
+			// Don't retry until something changes again, rather than failing
+			// the same way every interval.
+			fmt.Fprintf(os.Stderr, "%s: reload failed: %v\n", trigger, err)
+		} else {
+			printWatchResult(cmd, trigger, res)
+		}
+		if last, err = pollWatch(s, source); err != nil {
+			return err
+		}
+		pending = last
+	}
+}
+
+// pollWatch reads the current watchState of the profile in source.
+func pollWatch(s store.Store, source string) (watchState, error) {
+	sum, err := hashTree(source)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return watchState{}, fmt.Errorf("profile source %s is gone, stopping watch (use `tohru reload --source` if it moved)", source)
+		}
+		return watchState{}, err
+	}
+
+	snapshot, err := s.Status()
+	if err != nil {
+		return watchState{}, err
+	}
+	var drifted []string
+	for _, item := range snapshot.Tracked {
+		if item.Profile == "" && (item.Drifted || item.Missing) {
+			drifted = append(drifted, item.Path)
+		}
+	}
+	slices.Sort(drifted)
+	return watchState{Source: sum, Drifted: drifted}, nil
+}
+
+// hashTree hashes the metadata of everything under root, which changes
+// whenever a file in it is written, added, removed or renamed.
+func hashTree(root string) (string, error) {
+	info, err := os.Stat(root)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("profile source %s is not a directory", root)
+	}
+
+	h := sha256.New()
+	err = filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(h, "%s\x00%d\x00%o\x00%d\n", rel, info.Size(), info.Mode(), info.ModTime().UnixNano())
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("scan profile source %s: %w", root, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// describeTrigger says what changed between two polls.
+func describeTrigger(before, after watchState) string {
+	var reasons []string
+	if before.Source != after.Source {
+		reasons = append(reasons, "source changed")
+	}
+	var drifted []string
+	for _, path := range after.Drifted {
+		if !slices.Contains(before.Drifted, path) {
+			drifted = append(drifted, path)
+		}
+	}
+	switch {
+	case len(drifted) == 1:
+		reasons = append(reasons, "drifted "+drifted[0])
+	case len(drifted) > 1:
+		reasons = append(reasons, fmt.Sprintf("%d path(s) drifted", len(drifted)))
+	}
+	if len(reasons) == 0 {
+		reasons = append(reasons, "drift resolved")
+	}
+	return time.Now().Format(time.TimeOnly) + " " + strings.Join(reasons, ", ")
+}
+
+func printWatchResult(cmd *cli.Command, trigger string, res store.LoadResult) {
+	if res.Skipped {
+		printf(cmd, "%s: up to date\n", trigger)
+		return
+	}
+	printf(cmd, "%s: reloaded %s, %d path(s) changed\n", trigger, res.ProfileName, len(res.ChangedPaths))
+	printStat(cmd, res)
+	printRenamed(cmd, res.Operations)
+	printStashed(cmd, res.StashedPaths)
+	printWarnings(cmd, res.Warnings)
+	printChanges(cmd, res.ChangedPaths)
+}
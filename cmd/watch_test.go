@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/olimci/tohru/pkg/store"
+)
+
+func TestHashTreeTracksSourceEdits(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "home", "dot_zshrc")
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatalf("MkdirAll() error = %v", err)
+	}
+	if err := os.WriteFile(path, []byte("a\n"), 0o644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	before, err := hashTree(dir)
+	if err != nil {
+		t.Fatalf("hashTree() error = %v", err)
+	}
+	if again, _ := hashTree(dir); again != before {
+		t.Fatalf("hashTree() changed without an edit")
+	}
+	if err := os.WriteFile(path, []byte("ab\n"), 0o644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if after, _ := hashTree(dir); after == before {
+		t.Fatalf("hashTree() unchanged after an edit")
+	}
+
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatalf("RemoveAll() error = %v", err)
+	}
+	if _, err := pollWatch(store.Store{}, dir); err == nil || !strings.Contains(err.Error(), "is gone") {
+		t.Fatalf("pollWatch() error = %v, want the missing source reported", err)
+	}
+}
+
+func TestDescribeTrigger(t *testing.T) {
+	for _, tt := range []struct {
+		before, after watchState
+		want          string
+	}{
+		{watchState{Source: "a"}, watchState{Source: "b"}, "source changed"},
+		{watchState{Source: "a"}, watchState{Source: "a", Drifted: []string{"/h/.zshrc"}}, "drifted /h/.zshrc"},
+		{watchState{Source: "a", Drifted: []string{"/x"}}, watchState{Source: "b", Drifted: []string{"/x", "/y", "/z"}}, "source changed, 2 path(s) drifted"},
+		{watchState{Source: "a", Drifted: []string{"/x"}}, watchState{Source: "a"}, "drift resolved"},
+	} {
+		if got := describeTrigger(tt.before, tt.after); !strings.HasSuffix(got, " "+tt.want) {
+			t.Fatalf("describeTrigger(%v, %v) = %q, want suffix %q", tt.before, tt.after, got, tt.want)
+		}
+	}
+}
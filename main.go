@@ -11,6 +11,6 @@ import (
 func main() {
 	if err := cmd.Execute(context.Background(), os.Args); err != nil {
 		fmt.Fprintln(os.Stderr, "error:", err)
-		os.Exit(1)
+		os.Exit(cmd.ExitCode(err))
 	}
 }
@@ -17,6 +17,11 @@ const (
 
 const AlgorithmSHA256 = "sha256"
 
+// sumLengths is the length of the hex sum of each known algorithm.
+var sumLengths = map[string]int{
+	AlgorithmSHA256: 64,
+}
+
 func New(kind Kind, algorithm, sum string) (Digest, error) {
 	if err := validateKind(kind); err != nil {
 		return Digest{}, err
@@ -35,11 +40,15 @@ func New(kind Kind, algorithm, sum string) (Digest, error) {
 	if strings.TrimSpace(sum) == "" {
 		return Digest{}, fmt.Errorf("digest sum is required")
 	}
+	algorithm, sum = strings.TrimSpace(algorithm), strings.TrimSpace(sum)
+	if err := validateSum(algorithm, sum); err != nil {
+		return Digest{}, err
+	}
 
 	return Digest{
 		Kind:      kind,
-		Algorithm: strings.TrimSpace(algorithm),
-		Sum:       strings.TrimSpace(sum),
+		Algorithm: algorithm,
+		Sum:       sum,
 	}, nil
 }
 
@@ -82,6 +91,36 @@ func Parse(raw string) (Digest, error) {
 	return New(Kind(parts[0]), parts[1], parts[2])
 }
 
+// ParseStrict is Parse that also rejects algorithms tohru doesn't know.
+// Parse accepts them so that rehash can read state written by older or
+// newer versions.
+func ParseStrict(raw string) (Digest, error) {
+	d, err := Parse(raw)
+	if err != nil {
+		return Digest{}, err
+	}
+	if d.Algorithm != "" {
+		if _, ok := sumLengths[d.Algorithm]; !ok {
+			return Digest{}, fmt.Errorf("invalid digest %q: unknown algorithm %q", raw, d.Algorithm)
+		}
+	}
+	return d, nil
+}
+
+// validateSum checks that sum is lowercase hex, of the expected length when
+// algorithm is known.
+func validateSum(algorithm, sum string) error {
+	for _, c := range sum {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return fmt.Errorf("digest sum %q is not lowercase hex", sum)
+		}
+	}
+	if want, ok := sumLengths[algorithm]; ok && len(sum) != want {
+		return fmt.Errorf("%s digest sum has %d characters, expected %d", algorithm, len(sum), want)
+	}
+	return nil
+}
+
 func validateKind(kind Kind) error {
 	switch kind {
 	case KindNull, KindFile, KindDir, KindSymlink:
@@ -0,0 +1,59 @@
+package digest
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	sum := strings.Repeat("0f", 32)
+
+	tests := []struct {
+		name       string
+		raw        string
+		want       Digest
+		wantErr    string
+		wantStrict string
+	}{
+		{name: "empty"},
+		{name: "null", raw: "null", want: Digest{Kind: KindNull}},
+		{name: "sha256", raw: "file:sha256:" + sum, want: Digest{Kind: KindFile, Algorithm: AlgorithmSHA256, Sum: sum}},
+		{name: "wrong length", raw: "file:sha256:0123abcd", wantErr: "has 8 characters, expected 64"},
+		{name: "non-hex", raw: "dir:sha256:" + strings.Repeat("xy", 32), wantErr: "not lowercase hex"},
+		{name: "uppercase", raw: "file:sha256:" + strings.ToUpper(sum), wantErr: "not lowercase hex"},
+		{name: "missing part", raw: "file:" + sum, wantErr: "expected kind:algorithm:sum"},
+		{
+			name:       "unknown algorithm",
+			raw:        "file:legacy:0123abcd",
+			want:       Digest{Kind: KindFile, Algorithm: "legacy", Sum: "0123abcd"},
+			wantStrict: `unknown algorithm "legacy"`,
+		},
+		{name: "unknown algorithm non-hex", raw: "file:legacy:zz", wantErr: "not lowercase hex"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.raw)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Parse(%q) error = %v, want %q", tt.raw, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse(%q) error = %v", tt.raw, err)
+			}
+			if got != tt.want {
+				t.Fatalf("Parse(%q) = %+v, want %+v", tt.raw, got, tt.want)
+			}
+
+			_, err = ParseStrict(tt.raw)
+			if tt.wantStrict == "" {
+				if err != nil {
+					t.Fatalf("ParseStrict(%q) error = %v", tt.raw, err)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), tt.wantStrict) {
+				t.Fatalf("ParseStrict(%q) error = %v, want %q", tt.raw, err, tt.wantStrict)
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package digest
 
 import (
+	"bytes"
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
@@ -11,17 +12,31 @@ import (
 	"sort"
 )
 
+// Options controls how ForPathWith digests a directory.
+type Options struct {
+	// Exclude lists paths inside the directory that are left out of its
+	// digest, along with everything under them, as if they did not exist.
+	// They are matched against the walked paths, so must be spelled from
+	// the digested path.
+	Exclude []string
+}
+
 // ForPath computes the digest of the object at path.
 func ForPath(path string) (Digest, error) {
+	return ForPathWith(path, Options{})
+}
+
+// ForPathWith is ForPath with options.
+func ForPathWith(path string, opts Options) (Digest, error) {
 	info, err := os.Lstat(path)
 	if err != nil {
 		return Digest{}, err
 	}
 
-	return digestWithInfo(path, info)
+	return digestWithInfo(path, info, opts)
 }
 
-func digestWithInfo(path string, info os.FileInfo) (Digest, error) {
+func digestWithInfo(path string, info os.FileInfo, opts Options) (Digest, error) {
 	mode := info.Mode()
 
 	switch {
@@ -30,8 +45,7 @@ func digestWithInfo(path string, info os.FileInfo) (Digest, error) {
 		if err != nil {
 			return Digest{}, fmt.Errorf("read symlink %s: %w", path, err)
 		}
-		sum := sha256.Sum256([]byte(target))
-		return New(KindSymlink, AlgorithmSHA256, hex.EncodeToString(sum[:]))
+		return ForBytes(KindSymlink, []byte(target))
 	case mode.IsRegular():
 		sum, err := hashFile(path)
 		if err != nil {
@@ -39,7 +53,7 @@ func digestWithInfo(path string, info os.FileInfo) (Digest, error) {
 		}
 		return New(KindFile, AlgorithmSHA256, sum)
 	case mode.IsDir():
-		sum, err := hashDir(path)
+		sum, err := hashDir(path, opts.Exclude)
 		if err != nil {
 			return Digest{}, err
 		}
@@ -49,6 +63,37 @@ func digestWithInfo(path string, info os.FileInfo) (Digest, error) {
 	}
 }
 
+// ForReader computes the digest that an object of the given kind with the content
+// read from r would have, without it existing on disk. For a symlink the
+// content is its target. Directories can't be read as a stream.
+func ForReader(kind Kind, r io.Reader) (Digest, error) {
+	switch kind {
+	case KindFile, KindSymlink:
+	default:
+		return Digest{}, fmt.Errorf("cannot digest a %s from a stream", kind)
+	}
+
+	sum, err := hashReader(r)
+	if err != nil {
+		return Digest{}, err
+	}
+	return New(kind, AlgorithmSHA256, sum)
+}
+
+// ForBytes is ForReader for content already in memory, e.g. inline or
+// rendered file content.
+func ForBytes(kind Kind, b []byte) (Digest, error) {
+	return ForReader(kind, bytes.NewReader(b))
+}
+
+func hashReader(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
 func hashFile(path string) (string, error) {
 	f, err := os.Open(path)
 	if err != nil {
@@ -56,12 +101,11 @@ func hashFile(path string) (string, error) {
 	}
 	defer f.Close()
 
-	h := sha256.New()
-	if _, err := io.Copy(h, f); err != nil {
+	sum, err := hashReader(f)
+	if err != nil {
 		return "", fmt.Errorf("hash file %s: %w", path, err)
 	}
-
-	return hex.EncodeToString(h.Sum(nil)), nil
+	return sum, nil
 }
 
 type dirRecord struct {
@@ -70,13 +114,29 @@ type dirRecord struct {
 	Payload string
 }
 
-func hashDir(root string) (string, error) {
+// hashDir hashes one record per entry under root, sorted by path. Every
+// directory below root has a record of its own, so adding or removing an
+// empty subdirectory changes the digest, and an empty directory never hashes
+// like an empty file of the same name. Excluded paths leave no record, as if
+// they didn't exist: a directory holding only excluded entries hashes as
+// empty. The root itself has no record, so all empty directories hash alike.
+func hashDir(root string, exclude []string) (string, error) {
 	records := make([]dirRecord, 0, 32)
+	excluded := make(map[string]struct{}, len(exclude))
+	for _, path := range exclude {
+		excluded[filepath.Clean(path)] = struct{}{}
+	}
 
 	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
+		if _, ok := excluded[path]; ok && path != root {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
 
 		rel, err := filepath.Rel(root, path)
 		if err != nil {
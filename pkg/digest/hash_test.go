@@ -0,0 +1,135 @@
+package digest
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestForBytesMatchesForPath(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, []byte("content\n"), 0o644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	link := filepath.Join(dir, "link")
+	if err := os.Symlink("file", link); err != nil {
+		t.Fatalf("Symlink() error = %v", err)
+	}
+
+	tests := []struct {
+		path    string
+		kind    Kind
+		content string
+	}{
+		{path: file, kind: KindFile, content: "content\n"},
+		{path: link, kind: KindSymlink, content: "file"},
+	}
+	for _, tt := range tests {
+		want, err := ForPath(tt.path)
+		if err != nil {
+			t.Fatalf("ForPath(%s) error = %v", tt.path, err)
+		}
+		got, err := ForBytes(tt.kind, []byte(tt.content))
+		if err != nil {
+			t.Fatalf("ForBytes(%s) error = %v", tt.kind, err)
+		}
+		if got != want {
+			t.Fatalf("ForBytes(%s) = %s, want %s", tt.kind, got, want)
+		}
+	}
+
+	if _, err := ForReader(KindDir, strings.NewReader("")); err == nil {
+		t.Fatalf("ForReader(dir) succeeded, want error")
+	}
+}
+
+func TestForPathWithExclude(t *testing.T) {
+	write := func(path string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("MkdirAll() error = %v", err)
+		}
+		if err := os.WriteFile(path, []byte("content\n"), 0o644); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+	}
+	plain := t.TempDir()
+	write(filepath.Join(plain, "config"))
+	nested := t.TempDir()
+	write(filepath.Join(nested, "config"))
+	write(filepath.Join(nested, "store", "backups", "object"))
+	write(filepath.Join(nested, "lock"))
+
+	want, err := ForPath(plain)
+	if err != nil {
+		t.Fatalf("ForPath() error = %v", err)
+	}
+	if got, err := ForPath(nested); err != nil || got == want {
+		t.Fatalf("ForPath() = %s, %v, want the extra entries to change the digest", got, err)
+	}
+	got, err := ForPathWith(nested, Options{Exclude: []string{
+		filepath.Join(nested, "store"),
+		filepath.Join(nested, "lock") + string(filepath.Separator),
+	}})
+	if err != nil {
+		t.Fatalf("ForPathWith() error = %v", err)
+	}
+	if got != want {
+		t.Fatalf("ForPathWith() = %s, want %s as if the excluded paths did not exist", got, want)
+	}
+}
+
+func TestForPathEmptyDirectories(t *testing.T) {
+	digestOf := func(build func(root string)) Digest {
+		t.Helper()
+		root := t.TempDir()
+		if err := os.WriteFile(filepath.Join(root, "config"), []byte("content\n"), 0o644); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+		build(root)
+		d, err := ForPath(root)
+		if err != nil {
+			t.Fatalf("ForPath() error = %v", err)
+		}
+		return d
+	}
+	mkdir := func(rel string) func(string) {
+		return func(root string) {
+			if err := os.MkdirAll(filepath.Join(root, filepath.FromSlash(rel)), 0o755); err != nil {
+				t.Fatalf("MkdirAll() error = %v", err)
+			}
+		}
+	}
+
+	digests := map[string]Digest{
+		"without": digestOf(func(string) {}),
+		"empty":   digestOf(mkdir("cache")),
+		"nested":  digestOf(mkdir("cache/tmp")),
+		"renamed": digestOf(mkdir("cache2")),
+		"empty file": digestOf(func(root string) {
+			if err := os.WriteFile(filepath.Join(root, "cache"), nil, 0o644); err != nil {
+				t.Fatalf("WriteFile() error = %v", err)
+			}
+		}),
+	}
+	seen := make(map[Digest]string, len(digests))
+	for name, d := range digests {
+		if other, ok := seen[d]; ok {
+			t.Fatalf("%s and %s hash the same (%s), want every layout distinct", name, other, d)
+		}
+		seen[d] = name
+	}
+
+	if again := digestOf(mkdir("cache")); again != digests["empty"] {
+		t.Fatalf("ForPath() = %s, want an empty subdirectory to hash stably as %s", again, digests["empty"])
+	}
+	first, err := ForPath(t.TempDir())
+	if err != nil {
+		t.Fatalf("ForPath() error = %v", err)
+	}
+	if second, err := ForPath(t.TempDir()); err != nil || second != first {
+		t.Fatalf("ForPath() = %s, %v, want empty directories to hash alike as %s", second, err, first)
+	}
+}
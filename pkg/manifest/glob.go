@@ -0,0 +1,72 @@
+package manifest
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+
+	"github.com/olimci/tohru/pkg/utils/fileutils"
+)
+
+// GlobMatch is a file a Glob matched and where it goes.
+type GlobMatch struct {
+	Source string
+	Dest   string
+}
+
+// Expand lists the files under source, the directory g.Source resolves to,
+// whose path in destination names matches g.Pattern, in the lexical order of
+// their source paths, with where each goes under dest, g.Dest resolved.
+// Symlinks count as files and are not followed, so the walk never leaves
+// source. Entries starting with "." aren't destination names in a source
+// tree, e.g. .git, so they and everything under them are skipped, as is a
+// manifest directly in source. Matching nothing is an error, since it almost
+// always means a typo.
+func (g Glob) Expand(source, dest string) ([]GlobMatch, error) {
+	var matches []GlobMatch
+	err := filepath.WalkDir(source, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == source {
+			return nil
+		}
+		if strings.HasPrefix(entry.Name(), ".") {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if entry.IsDir() || filepath.Dir(path) == source && entry.Name() == Name {
+			return nil
+		}
+
+		rel, err := filepath.Rel(source, path)
+		if err != nil {
+			return err
+		}
+		parts := fileutils.SplitPathParts(rel)
+		for i, part := range parts {
+			parts[i] = DecodeSourcePart(part)
+		}
+		ok, err := fileutils.MatchGlob(g.Pattern, strings.Join(parts, "/"))
+		if err != nil {
+			return err
+		}
+		if ok {
+			matches = append(matches, GlobMatch{
+				Source: path,
+				Dest:   filepath.Join(append([]string{dest}, parts...)...),
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("expand glob %q in %s: %w", g.Pattern, source, err)
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("glob %q matches no files in %s", g.Pattern, source)
+	}
+	return matches, nil
+}
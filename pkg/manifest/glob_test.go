@@ -0,0 +1,117 @@
+package manifest
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResolveGlob(t *testing.T) {
+	m := Manifest{
+		Schema:  1,
+		Profile: Profile{Slug: "test", Name: "test"},
+		Roots: []Root{
+			{
+				Source:   "home",
+				Dest:     "~",
+				Defaults: &Defaults{Type: "copy", Mode: "0600"},
+				Glob: map[string][]string{
+					".config/**/*.conf": nil,
+					"bin/*":             {"link", "relative"},
+					"skip/*":            {"os:plan9-not-real"},
+				},
+			},
+		},
+	}
+	if err := m.Resolve(); err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+
+	want := []Glob{
+		{Pattern: ".config/**/*.conf", Source: "home", Dest: "~", Mode: "0600"},
+		{Pattern: "bin/*", Source: "home", Dest: "~", Link: true, Relative: true},
+	}
+	if len(m.Plan.Globs) != len(want) || m.Plan.Len() != len(want) {
+		t.Fatalf("Globs = %+v, want %+v", m.Plan.Globs, want)
+	}
+	for i, g := range m.Plan.Globs {
+		if g != want[i] {
+			t.Fatalf("Globs[%d] = %+v, want %+v", i, g, want[i])
+		}
+	}
+
+	for pattern, wantErr := range map[string]string{
+		"../outside/*": "stay inside it",
+		"/abs/*":       "stay inside it",
+		"a/{b,c":       "unmatched",
+		"a/[":          "syntax error",
+	} {
+		bad := Manifest{
+			Schema:  1,
+			Profile: Profile{Slug: "test"},
+			Roots:   []Root{{Source: "home", Dest: "~", Glob: map[string][]string{pattern: {"copy"}}}},
+		}
+		if err := bad.Resolve(); err == nil || !strings.Contains(err.Error(), wantErr) {
+			t.Errorf("Resolve() with glob %q error = %v, want %q", pattern, err, wantErr)
+		}
+	}
+}
+
+func TestGlobExpand(t *testing.T) {
+	source := t.TempDir()
+	for _, rel := range []string{
+		"dot_config/app/app.conf",
+		"dot_config/app/nested/deep.conf",
+		"dot_config/app/app.toml",
+		"dot_config/other.conf",
+		"dot_config/readme.md",
+		"dot__literal.conf",
+		".git/config.conf",
+		Name,
+	} {
+		path := filepath.Join(source, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("MkdirAll() error = %v", err)
+		}
+		if err := os.WriteFile(path, []byte(rel), 0o644); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+	}
+	dest := filepath.Join(t.TempDir(), "home")
+
+	tests := []struct {
+		pattern string
+		want    []string // destinations relative to dest, in source order
+	}{
+		{".config/**/*.conf", []string{".config/app/app.conf", ".config/app/nested/deep.conf", ".config/other.conf"}},
+		{".config/app/*.{conf,toml}", []string{".config/app/app.conf", ".config/app/app.toml"}},
+		{"{.config/*.md,dot_literal.conf}", []string{"dot_literal.conf", ".config/readme.md"}},
+		{"**", []string{"dot_literal.conf", ".config/app/app.conf", ".config/app/app.toml", ".config/app/nested/deep.conf", ".config/other.conf", ".config/readme.md"}},
+	}
+	for _, tt := range tests {
+		matches, err := Glob{Pattern: tt.pattern}.Expand(source, dest)
+		if err != nil {
+			t.Fatalf("Expand(%q) error = %v", tt.pattern, err)
+		}
+		var got []string
+		for _, match := range matches {
+			rel, err := filepath.Rel(dest, match.Dest)
+			if err != nil {
+				t.Fatalf("Rel() error = %v", err)
+			}
+			got = append(got, filepath.ToSlash(rel))
+			// The source is the file the destination mirrors.
+			if raw, err := os.ReadFile(match.Source); err != nil || !strings.HasSuffix(filepath.ToSlash(match.Source), string(raw)) {
+				t.Fatalf("Expand(%q) source %s holds %q, %v", tt.pattern, match.Source, raw, err)
+			}
+		}
+		if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+			t.Errorf("Expand(%q) = %v, want %v", tt.pattern, got, tt.want)
+		}
+	}
+
+	if _, err := (Glob{Pattern: "**/*.missing"}).Expand(source, dest); err == nil || !strings.Contains(err.Error(), "matches no files") {
+		t.Fatalf("Expand() of a pattern matching nothing error = %v, want it refused", err)
+	}
+}
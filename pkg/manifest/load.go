@@ -11,40 +11,61 @@ import (
 	"github.com/olimci/tohru/pkg/utils/fileutils"
 )
 
-const Name = "tohru.json"
+const (
+	Name       = "tohru.json"
+	HiddenName = ".tohru.json" // alternative name for manifests kept inside other repos
+)
 
 // Load resolves a source path and decodes its manifest.
 // returns an absolute path to the manifest directory
 func Load(source string) (Manifest, string, error) {
-	absSource, err := fileutils.AbsPath(source)
+	manifest, sourceDir, err := Read(source)
 	if err != nil {
 		return Manifest{}, "", err
 	}
-
-	info, err := os.Stat(absSource)
-	if err != nil {
-		return Manifest{}, "", fmt.Errorf("stat source %q: %w", source, err)
+	if err := manifest.Resolve(); err != nil {
+		return Manifest{}, "", err
 	}
 
-	manifestPath := absSource
-	sourceDir := filepath.Dir(absSource)
-	if info.IsDir() {
-		manifestPath, err = findManifestFile(absSource)
-		if err != nil {
-			return Manifest{}, "", err
-		}
-		sourceDir = absSource
+	return manifest, sourceDir, nil
+}
+
+// Read is Load without Resolve: it decodes the manifest for a source path but
+// leaves validating it to the caller.
+func Read(source string) (Manifest, string, error) {
+	manifestPath, sourceDir, err := Locate(source)
+	if err != nil {
+		return Manifest{}, "", err
 	}
 
 	manifest, err := decodeManifest(manifestPath)
 	if err != nil {
 		return Manifest{}, "", err
 	}
-	if err := manifest.Resolve(); err != nil {
-		return Manifest{}, "", err
+	return manifest, sourceDir, nil
+}
+
+// Locate resolves a source path to its manifest file and the absolute
+// directory holding it, without decoding the manifest.
+func Locate(source string) (string, string, error) {
+	absSource, err := fileutils.AbsPath(source)
+	if err != nil {
+		return "", "", err
 	}
 
-	return manifest, sourceDir, nil
+	info, err := os.Stat(absSource)
+	if err != nil {
+		return "", "", fmt.Errorf("stat source %q: %w", source, err)
+	}
+
+	if !info.IsDir() {
+		return absSource, filepath.Dir(absSource), nil
+	}
+	manifestPath, err := findManifestFile(absSource)
+	if err != nil {
+		return "", "", err
+	}
+	return manifestPath, absSource, nil
 }
 
 func decodeManifest(path string) (Manifest, error) {
@@ -72,7 +93,11 @@ func Write(path string, m Manifest) error {
 		return fmt.Errorf("encode %s: %w", path, err)
 	}
 	payload = append(payload, '\n')
+	return writeFile(path, payload)
+}
 
+// writeFile atomically replaces path with payload.
+func writeFile(path string, payload []byte) error {
 	dir := filepath.Dir(path)
 	if err := os.MkdirAll(dir, 0o755); err != nil {
 		return fmt.Errorf("create directory for %s: %w", path, err)
@@ -106,18 +131,77 @@ func Write(path string, m Manifest) error {
 }
 
 func findManifestFile(sourceDir string) (string, error) {
-	candidate := filepath.Join(sourceDir, Name)
+	var found []string
+	for _, name := range []string{Name, HiddenName} {
+		candidate := filepath.Join(sourceDir, name)
+		info, err := os.Stat(candidate)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return "", fmt.Errorf("stat manifest candidate %s: %w", candidate, err)
+		}
+		if info.IsDir() {
+			return "", fmt.Errorf("manifest path is a directory: %s", candidate)
+		}
+		found = append(found, candidate)
+	}
+
+	switch len(found) {
+	case 0:
+		return "", fmt.Errorf("no manifest found in %s (expected %s or %s)", sourceDir, Name, HiddenName)
+	case 1:
+		return found[0], nil
+	default:
+		return "", fmt.Errorf("both %s and %s exist in %s, remove one", Name, HiddenName, sourceDir)
+	}
+}
+
+// Discover walks up from startDir to the nearest directory holding a
+// manifest, the way git finds .git, and returns the manifest path. The walk
+// stops at the user's home directory.
+func Discover(startDir string) (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		home = ""
+	}
+	return DiscoverUntil(startDir, home)
+}
 
-	info, err := os.Stat(candidate)
+// DiscoverUntil is Discover with an explicit ceiling. The ceiling itself is
+// searched but nothing above it is; when startDir is not inside the ceiling,
+// or the ceiling is empty, the walk continues to the filesystem root.
+func DiscoverUntil(startDir, ceiling string) (string, error) {
+	dir, err := fileutils.AbsPath(startDir)
 	if err != nil {
-		if os.IsNotExist(err) {
-			return "", fmt.Errorf("no manifest found in %s (expected %s)", sourceDir, Name)
+		return "", err
+	}
+	if ceiling != "" {
+		if ceiling, err = fileutils.AbsPath(ceiling); err != nil {
+			return "", err
+		}
+		if rel, err := filepath.Rel(ceiling, dir); err != nil || fileutils.Escapes(rel) {
+			ceiling = ""
 		}
-		return "", fmt.Errorf("stat manifest candidate %s: %w", candidate, err)
 	}
-	if info.IsDir() {
-		return "", fmt.Errorf("manifest path is a directory: %s", candidate)
+
+	for {
+		for _, name := range []string{Name, HiddenName} {
+			if _, err := os.Lstat(filepath.Join(dir, name)); err == nil {
+				return findManifestFile(dir)
+			}
+		}
+
+		parent := filepath.Dir(dir)
+		if dir == ceiling || parent == dir {
+			break
+		}
+		dir = parent
 	}
 
-	return candidate, nil
+	stop := ceiling
+	if stop == "" {
+		stop = dir
+	}
+	return "", fmt.Errorf("no %s or %s found in %s or any parent up to %s", Name, HiddenName, startDir, stop)
 }
@@ -3,10 +3,16 @@ package manifest
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"maps"
 	"path/filepath"
+	"runtime"
 	"slices"
+	"strconv"
 	"strings"
+
+	"github.com/olimci/tohru/pkg/utils/fileutils"
 )
 
 const SchemaVersion = 1
@@ -16,21 +22,46 @@ const (
 	flagLink      = "link"
 	flagTracked   = "tracked"
 	flagUntracked = "untracked"
+	flagRelative  = "relative"  // link with a target relative to the link's directory
+	flagBackup    = "backup"    // back up what's at the destination, whatever the store's config says
+	flagNoBackup  = "no-backup" // never back up what's at the destination
+	flagPerFile   = "per-file"  // copy a directory as one tracked entry per file
+
+	// constraint flags are written as "os:<goos>" or "arch:<goarch>"; an
+	// entry is only compiled when every constrained key matches one value
+	prefixOS   = "os:"
+	prefixArch = "arch:"
+
+	// "tracked:<goos>" tracks the entry on the listed platforms only and
+	// leaves it untracked everywhere else
+	prefixTracked = "tracked:"
+
+	// "mode:<octal>" sets the permissions of a copied file
+	prefixMode = "mode:"
 )
 
+// goos and goarch are the platform constraint flags are matched against.
+var goos, goarch = runtime.GOOS, runtime.GOARCH
+
 var flagOrder = map[string]int{
 	flagCopy:      0,
 	flagLink:      1,
 	flagTracked:   2,
 	flagUntracked: 3,
+	flagRelative:  4,
+	flagBackup:    5,
+	flagNoBackup:  6,
+	flagPerFile:   7,
 }
 
 // Manifest represents a configuration file for a Tohru dotfiles source.
 type Manifest struct {
-	Schema   int      `json:"schema"`
-	Requires Requires `json:"requires,omitempty"`
-	Profile  Profile  `json:"profile"`
-	Roots    []Root   `json:"roots,omitempty"`
+	Schema   int       `json:"schema"`
+	Requires Requires  `json:"requires,omitempty"`
+	Profile  Profile   `json:"profile"`
+	Defaults *Defaults `json:"defaults,omitempty"` // inherited by every root; a root's own defaults take precedence
+	Options  *Options  `json:"options,omitempty"`
+	Roots    []Root    `json:"roots,omitempty"`
 
 	Plan Plan `json:"-"`
 }
@@ -39,6 +70,18 @@ type Requires struct {
 	Tohru string `json:"tohru,omitempty"`
 }
 
+// Options override the store's config options whenever the profile is
+// loaded. Unset fields keep the store's value.
+type Options struct {
+	Backups       *BackupOptions `json:"backups,omitempty"`
+	CacheProfiles *bool          `json:"cache_profiles,omitempty"`
+}
+
+type BackupOptions struct {
+	Enabled *bool  `json:"enabled,omitempty"`
+	Prune   string `json:"prune,omitempty"` // auto|manual
+}
+
 type Profile struct {
 	Slug        string `json:"slug"`
 	Name        string `json:"name"`
@@ -46,15 +89,21 @@ type Profile struct {
 }
 
 type Root struct {
-	Source   string    `json:"source"`
-	Dest     string    `json:"dest"`
-	Defaults *Defaults `json:"defaults,omitempty"`
-	Tree     Tree      `json:"tree,omitempty"`
+	Source   string              `json:"source"`
+	Dest     string              `json:"dest"`
+	Defaults *Defaults           `json:"defaults,omitempty"`
+	Tree     Tree                `json:"tree,omitempty"`
+	Glob     map[string][]string `json:"glob,omitempty"`   // dest-relative glob pattern -> file flags, see Glob
+	Inline   map[string]string   `json:"inline,omitempty"` // dest-relative path -> literal file content
 }
 
+// Defaults apply to the entries of a root unless an entry's flags say
+// otherwise. Base is only valid in the manifest's defaults.
 type Defaults struct {
 	Type  string `json:"type,omitempty"`
 	Track *bool  `json:"track,omitempty"`
+	Mode  string `json:"mode,omitempty"` // octal permissions of copied files, e.g. "0600"
+	Base  string `json:"base,omitempty"` // directory relative root dests resolve against
 }
 
 type Tree map[string]Node
@@ -69,29 +118,77 @@ type DirNode struct {
 	Tree  Tree
 }
 
+// Plan is the compiled, filesystem-independent form of a manifest.
+// Entries keep a stable order: roots are compiled in declaration order, and
+// within a root the tree is walked depth-first with keys sorted lexically.
 type Plan struct {
-	Links []Link
-	Files []File
-	Dirs  []Dir
+	Links  []Link
+	Files  []File
+	Dirs   []Dir
+	Copies []Copy
+	Globs  []Glob
+}
+
+// Len is the number of entries in the plan. A glob counts as one, however
+// many files it matches.
+func (p Plan) Len() int {
+	return len(p.Links) + len(p.Files) + len(p.Dirs) + len(p.Copies) + len(p.Globs)
 }
 
 type Link struct {
 	// Link is a symbolic link from somewhere else to something here
-	To   string `json:"to"`
-	From string `json:"from"`
+	To       string `json:"to"`
+	From     string `json:"from"`
+	Dir      bool   `json:"dir,omitempty"`      // To is a directory, declared as one with "." metadata
+	Relative bool   `json:"relative,omitempty"` // the link's target is To relative to the directory of From
+	Backup   *bool  `json:"backup,omitempty"`   // overrides whether an existing destination is backed up
+	Root     int    `json:"-"`                  // index of the declaring root in Manifest.Roots
 }
 
 type File struct {
-	// File is a copy of a file from somewhere here to somewhere else
+	// File is a copy of a file from somewhere here to somewhere else, or,
+	// when Source is empty, a file written with the literal Content
 	Source  string `json:"source"`
+	Content string `json:"content,omitempty"`
 	Dest    string `json:"dest"`
 	Tracked *bool  `json:"tracked,omitempty"` // nil defaults to true
+	Mode    string `json:"mode,omitempty"`    // octal permissions, empty to keep the source's
+	Backup  *bool  `json:"backup,omitempty"`  // overrides whether an existing destination is backed up
+	Root    int    `json:"-"`
 }
 
 type Dir struct {
 	// Dirs don't need a source
 	Path    string `json:"path"`
 	Tracked *bool  `json:"tracked,omitempty"` // nil defaults to true
+	Backup  *bool  `json:"backup,omitempty"`  // overrides whether an existing destination is backed up
+	Root    int    `json:"-"`
+}
+
+type Copy struct {
+	// Copy is a recursive copy of a whole source directory, tracked as one
+	// object, or as one per file when PerFile is set
+	Source  string `json:"source"`
+	Dest    string `json:"dest"`
+	Tracked *bool  `json:"tracked,omitempty"`  // nil defaults to true
+	Backup  *bool  `json:"backup,omitempty"`   // overrides whether an existing destination is backed up
+	PerFile bool   `json:"per_file,omitempty"` // expanded into the files it holds when loaded
+	Root    int    `json:"-"`
+}
+
+type Glob struct {
+	// Glob links or copies every file under Source whose path, in
+	// destination names (dot_ decoded), matches Pattern, to the same path
+	// under Dest. It is expanded when loaded, see Expand.
+	Pattern  string `json:"pattern"` // slash-separated, "**" and "{a,b}" allowed
+	Source   string `json:"source"`
+	Dest     string `json:"dest"`
+	Link     bool   `json:"link,omitempty"`     // link matching files rather than copy them
+	Relative bool   `json:"relative,omitempty"` // link targets are relative, see Link.Relative
+	Tracked  *bool  `json:"tracked,omitempty"`  // nil defaults to true
+	Mode     string `json:"mode,omitempty"`     // octal permissions of copied files
+	Backup   *bool  `json:"backup,omitempty"`   // overrides whether an existing destination is backed up
+	Root     int    `json:"-"`
 }
 
 func FileNode(flags ...string) Node {
@@ -117,7 +214,13 @@ func (n Node) IsFile() bool {
 
 func (n Node) MarshalJSON() ([]byte, error) {
 	if n.Dir == nil {
-		return json.Marshal(normalizeFlags(n.File))
+		// A file without flags is written as [] rather than null, the
+		// form every version of UnmarshalJSON reads back.
+		flags := normalizeFlags(n.File)
+		if flags == nil {
+			flags = []string{}
+		}
+		return json.Marshal(flags)
 	}
 
 	payload := map[string]any{}
@@ -143,6 +246,14 @@ func (n *Node) UnmarshalJSON(data []byte) error {
 		return fmt.Errorf("node: value is required")
 	}
 
+	// Write used to turn a file entry without flags into null, e.g. when
+	// profile tidy rewrote a manifest, so null still reads as one.
+	if bytes.Equal(data, []byte("null")) {
+		n.File = nil
+		n.Dir = nil
+		return nil
+	}
+
 	switch data[0] {
 	case '[':
 		var flags []string
@@ -189,64 +300,195 @@ func (n *Node) UnmarshalJSON(data []byte) error {
 	}
 }
 
+// Resolve validates the manifest and compiles its roots into m.Plan. When the
+// manifest is invalid, the error joins every problem Validate reports.
 func (m *Manifest) Resolve() error {
+	plan, errs := m.compile()
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	m.Plan = plan
+	return nil
+}
+
+// Validate reports every structural problem in the manifest, rather than
+// only the first, without touching the filesystem.
+func (m Manifest) Validate() []error {
+	_, errs := m.compile()
+	return errs
+}
+
+func (m Manifest) compile() (Plan, []error) {
+	var errs []error
 	if m.Schema != SchemaVersion {
-		return fmt.Errorf("schema: unsupported value %d (expected %d)", m.Schema, SchemaVersion)
+		errs = append(errs, fmt.Errorf("schema: unsupported value %d (expected %d)", m.Schema, SchemaVersion))
 	}
 
-	links := make([]Link, 0, 16)
-	files := make([]File, 0, 16)
-	dirs := make([]Dir, 0, 8)
+	plan := Plan{
+		Links:  make([]Link, 0, 16),
+		Files:  make([]File, 0, 16),
+		Dirs:   make([]Dir, 0, 8),
+		Copies: make([]Copy, 0),
+	}
+
+	if m.Options != nil && m.Options.Backups != nil {
+		switch strings.ToLower(strings.TrimSpace(m.Options.Backups.Prune)) {
+		case "", "auto", "manual":
+		default:
+			errs = append(errs, fmt.Errorf("options.backups.prune: unsupported value %q (expected auto or manual)", m.Options.Backups.Prune))
+		}
+	}
+
+	defaults := mergeDefaults(Defaults{}, m.Defaults)
+	if err := validateDefaults(defaults); err != nil {
+		errs = append(errs, fmt.Errorf("defaults.%w", err))
+	}
+	if base := strings.TrimSpace(defaults.Base); base != "" && !destIsAbsolute(base) {
+		errs = append(errs, fmt.Errorf("defaults.base: %q must be absolute or start with ~", base))
+		defaults.Base = ""
+	}
 
 	for i, root := range m.Roots {
-		rootLinks, rootFiles, rootDirs, err := root.compile()
-		if err != nil {
-			return fmt.Errorf("roots[%d]: %w", i, err)
+		for _, err := range root.compile(&plan, i, defaults) {
+			errs = append(errs, fmt.Errorf("roots[%d]: %w", i, err))
 		}
-		links = append(links, rootLinks...)
-		files = append(files, rootFiles...)
-		dirs = append(dirs, rootDirs...)
 	}
 
-	m.Plan = Plan{
-		Links: links,
-		Files: files,
-		Dirs:  dirs,
+	return plan, errs
+}
+
+// validateDefaults checks the values of defaults, returning an error that
+// names the offending field.
+func validateDefaults(defaults Defaults) error {
+	if defaults.Mode != "" {
+		if err := validateMode(defaults.Mode); err != nil {
+			return fmt.Errorf("mode: %w", err)
+		}
 	}
 	return nil
 }
 
-func (r Root) compile() ([]Link, []File, []Dir, error) {
+// validateMode checks that mode is octal permissions between 0001 and 0777.
+func validateMode(mode string) error {
+	value, err := strconv.ParseUint(strings.TrimSpace(mode), 8, 32)
+	if err != nil || value == 0 || value > 0o777 {
+		return fmt.Errorf("%q is not an octal mode between 0001 and 0777", mode)
+	}
+	return nil
+}
+
+// compile adds the entries of r to plan. inherited are the manifest's
+// defaults, which r's own defaults override.
+func (r Root) compile(plan *Plan, index int, inherited Defaults) []error {
+	var errs []error
+
 	source := strings.TrimSpace(r.Source)
 	if source == "" {
-		return nil, nil, nil, fmt.Errorf("source: value is required")
+		errs = append(errs, fmt.Errorf("source: value is required"))
 	}
 
+	base := strings.TrimSpace(inherited.Base)
 	dest := strings.TrimSpace(r.Dest)
-	if dest == "" {
-		return nil, nil, nil, fmt.Errorf("dest: value is required")
+	switch {
+	case dest == "" && base != "":
+		dest = base
+	case dest == "":
+		errs = append(errs, fmt.Errorf("dest: value is required"))
+	case !destIsAbsolute(dest) && base != "":
+		dest = filepath.Join(base, dest)
+	case !destIsAbsolute(dest):
+		errs = append(errs, fmt.Errorf("dest: %q is relative and would resolve against the working directory, use %q or an absolute path", dest, filepath.ToSlash(filepath.Join("~", dest))))
 	}
 
-	var (
-		links = make([]Link, 0)
-		files = make([]File, 0)
-		dirs  = make([]Dir, 0)
-	)
-
-	defaults := mergeDefaults(Defaults{}, r.Defaults)
+	if r.Defaults != nil && strings.TrimSpace(r.Defaults.Base) != "" {
+		errs = append(errs, fmt.Errorf("defaults.base: only valid in the manifest's defaults"))
+	}
+	if r.Defaults != nil {
+		if err := validateDefaults(*r.Defaults); err != nil {
+			errs = append(errs, fmt.Errorf("defaults.%w", err))
+		}
+	}
+	defaults := mergeDefaults(inherited, r.Defaults)
 	if _, exists := r.Tree["."]; exists {
-		return nil, nil, nil, fmt.Errorf("tree.\".\": reserved key is not allowed at the root level")
+		errs = append(errs, fmt.Errorf("tree.\".\": reserved key is not allowed at the root level"))
+	}
+
+	for _, key := range slices.Sorted(maps.Keys(r.Inline)) {
+		parts := fileutils.SplitPathParts(key)
+		if len(parts) == 0 || filepath.IsAbs(key) || slices.Contains(parts, "..") {
+			errs = append(errs, fmt.Errorf("inline.%q: path must be relative to dest and stay inside it", key))
+			continue
+		}
+		if treeDeclares(r.Tree, parts) {
+			errs = append(errs, fmt.Errorf("inline.%q: path is also declared in tree (use either a source file or inline content)", key))
+		}
+	}
+	for _, pattern := range slices.Sorted(maps.Keys(r.Glob)) {
+		parts := fileutils.SplitPathParts(pattern)
+		if len(parts) == 0 || strings.HasPrefix(filepath.ToSlash(pattern), "/") || slices.Contains(parts, "..") {
+			errs = append(errs, fmt.Errorf("glob.%q: pattern must be relative to dest and stay inside it", pattern))
+			continue
+		}
+		if err := fileutils.ValidateGlob(pattern); err != nil {
+			errs = append(errs, fmt.Errorf("glob.%q: %w", pattern, err))
+		}
+	}
+
+	// The tree is only compiled once source and dest are known good, so its
+	// errors aren't knock-on effects of the ones above.
+	if len(errs) > 0 {
+		return errs
 	}
 	if len(r.Tree) > 0 {
-		if err := compileTree(&links, &files, &dirs, source, dest, nil, defaults, r.Tree); err != nil {
-			return nil, nil, nil, err
+		if err := compileTree(plan, index, source, dest, nil, defaults, r.Tree); err != nil {
+			return []error{err}
+		}
+	}
+	for _, pattern := range slices.Sorted(maps.Keys(r.Glob)) {
+		if err := compileGlob(plan, index, source, dest, pattern, defaults, r.Glob[pattern]); err != nil {
+			return []error{err}
 		}
 	}
 
-	return links, files, dirs, nil
+	for _, key := range slices.Sorted(maps.Keys(r.Inline)) {
+		parts := fileutils.SplitPathParts(key)
+		plan.Files = append(plan.Files, File{
+			Content: r.Inline[key],
+			Dest:    filepath.Join(append([]string{dest}, parts...)...),
+			Tracked: cloneBool(defaults.Track),
+			Mode:    defaults.Mode,
+			Root:    index,
+		})
+	}
+
+	return nil
+}
+
+// destIsAbsolute reports whether dest names a fixed location: an absolute
+// path, or one under the home directory.
+func destIsAbsolute(dest string) bool {
+	return dest == "~" || strings.HasPrefix(dest, "~/") || filepath.IsAbs(dest)
+}
+
+// treeDeclares reports whether tree has a node at parts.
+func treeDeclares(tree Tree, parts []string) bool {
+	for i, part := range parts {
+		node, ok := tree[part]
+		if !ok {
+			return false
+		}
+		if i == len(parts)-1 {
+			return true
+		}
+		if !node.IsDir() {
+			return false
+		}
+		tree = node.Dir.Tree
+	}
+	return false
 }
 
-func compileTree(links *[]Link, files *[]File, dirs *[]Dir, sourceRoot, destRoot string, parts []string, defaults Defaults, tree Tree) error {
+func compileTree(plan *Plan, root int, sourceRoot, destRoot string, parts []string, defaults Defaults, tree Tree) error {
 	keys := make([]string, 0, len(tree))
 	for key := range tree {
 		keys = append(keys, key)
@@ -260,31 +502,82 @@ func compileTree(links *[]Link, files *[]File, dirs *[]Dir, sourceRoot, destRoot
 
 		if node.IsDir() {
 			flags := node.Dir.Flags
-			typeFlag, trackOverride, err := flagsForNode(flags, true, pathLabel)
+			typeFlag, trackOverride, applies, err := flagsForNode(flags, true, "tree."+pathLabel)
 			if err != nil {
 				return err
 			}
-			if typeFlag != "" {
-				return fmt.Errorf("tree.%s.\".\": type flags are not supported for directory metadata", pathLabel)
+			if !applies {
+				continue
+			}
+			dst := filepath.Join(append([]string{destRoot}, entryPath...)...)
+			relative := hasFlag(flags, flagRelative)
+			if relative && typeFlag != flagLink {
+				return fmt.Errorf("tree.%s: flag %q is only valid on link entries", pathLabel, flagRelative)
+			}
+			if _, ok := modeFlag(flags); ok {
+				return fmt.Errorf("tree.%s: flag %q is only valid on copied files", pathLabel, prefixMode)
+			}
+			perFile := hasFlag(flags, flagPerFile)
+			if perFile && typeFlag != flagCopy {
+				return fmt.Errorf("tree.%s: flag %q is only valid on copied directories", pathLabel, flagPerFile)
+			}
+			backup := backupFlag(flags)
+
+			if typeFlag == flagLink {
+				if len(node.Dir.Tree) > 0 {
+					return fmt.Errorf("tree.%s: linked directories may not declare children", pathLabel)
+				}
+				if trackOverride != nil && !*trackOverride {
+					return fmt.Errorf("tree.%s: untracked is not supported for link entries", pathLabel)
+				}
+				plan.Links = append(plan.Links, Link{
+					To:       SourcePath(sourceRoot, entryPath),
+					From:     dst,
+					Dir:      true,
+					Relative: relative,
+					Backup:   backup,
+					Root:     root,
+				})
+				continue
+			}
+
+			if typeFlag == flagCopy {
+				if len(node.Dir.Tree) > 0 {
+					return fmt.Errorf("tree.%s: copied directories may not declare children", pathLabel)
+				}
+				plan.Copies = append(plan.Copies, Copy{
+					Source:  SourcePath(sourceRoot, entryPath),
+					Dest:    dst,
+					Tracked: pickTrack(defaults.Track, trackOverride),
+					Backup:  backup,
+					PerFile: perFile,
+					Root:    root,
+				})
+				continue
 			}
 
 			if len(node.Dir.Tree) == 0 || trackOverride != nil {
-				*dirs = append(*dirs, Dir{
-					Path:    filepath.Join(append([]string{destRoot}, entryPath...)...),
+				plan.Dirs = append(plan.Dirs, Dir{
+					Path:    dst,
 					Tracked: pickTrack(defaults.Track, trackOverride),
+					Backup:  backup,
+					Root:    root,
 				})
 			}
 
-			if err := compileTree(links, files, dirs, sourceRoot, destRoot, entryPath, defaults, node.Dir.Tree); err != nil {
+			if err := compileTree(plan, root, sourceRoot, destRoot, entryPath, defaults, node.Dir.Tree); err != nil {
 				return err
 			}
 			continue
 		}
 
-		typeFlag, trackOverride, err := flagsForNode(node.File, false, pathLabel)
+		typeFlag, trackOverride, applies, err := flagsForNode(node.File, false, "tree."+pathLabel)
 		if err != nil {
 			return err
 		}
+		if !applies {
+			continue
+		}
 
 		effectiveType := typeFlag
 		if effectiveType == "" {
@@ -296,21 +589,42 @@ func compileTree(links *[]Link, files *[]File, dirs *[]Dir, sourceRoot, destRoot
 
 		tracked := pickTrack(defaults.Track, trackOverride)
 		dst := filepath.Join(append([]string{destRoot}, entryPath...)...)
+		relative := hasFlag(node.File, flagRelative)
+		if relative && effectiveType != flagLink {
+			return fmt.Errorf("tree.%s: flag %q is only valid on link entries", pathLabel, flagRelative)
+		}
+		if hasFlag(node.File, flagPerFile) {
+			return fmt.Errorf("tree.%s: flag %q is only valid on copied directories", pathLabel, flagPerFile)
+		}
+		mode, explicitMode := modeFlag(node.File)
+		if !explicitMode {
+			mode = defaults.Mode
+		}
+		backup := backupFlag(node.File)
 
 		switch effectiveType {
 		case flagCopy:
-			*files = append(*files, File{
+			plan.Files = append(plan.Files, File{
 				Source:  SourcePath(sourceRoot, entryPath),
 				Dest:    dst,
 				Tracked: tracked,
+				Mode:    mode,
+				Backup:  backup,
+				Root:    root,
 			})
 		case flagLink:
+			if explicitMode {
+				return fmt.Errorf("tree.%s: flag %q is only valid on copied files", pathLabel, prefixMode)
+			}
 			if tracked != nil && !*tracked {
 				return fmt.Errorf("tree.%s: untracked is not supported for link entries", pathLabel)
 			}
-			*links = append(*links, Link{
-				To:   SourcePath(sourceRoot, entryPath),
-				From: dst,
+			plan.Links = append(plan.Links, Link{
+				To:       SourcePath(sourceRoot, entryPath),
+				From:     dst,
+				Relative: relative,
+				Backup:   backup,
+				Root:     root,
 			})
 		default:
 			return fmt.Errorf("tree.%s: unsupported file type %q (expected %q or %q)", pathLabel, effectiveType, flagCopy, flagLink)
@@ -320,50 +634,183 @@ func compileTree(links *[]Link, files *[]File, dirs *[]Dir, sourceRoot, destRoot
 	return nil
 }
 
-func flagsForNode(flags []string, isDir bool, pathLabel string) (string, *bool, error) {
+// compileGlob adds the glob entry pattern of a root to plan. Its flags are
+// those of a file in the tree; per-file has no meaning for it.
+func compileGlob(plan *Plan, root int, sourceRoot, destRoot, pattern string, defaults Defaults, flags []string) error {
+	label := fmt.Sprintf("glob.%q", pattern)
+	typeFlag, trackOverride, applies, err := flagsForNode(flags, false, label)
+	if err != nil {
+		return err
+	}
+	if !applies {
+		return nil
+	}
+
+	effectiveType := typeFlag
+	if effectiveType == "" {
+		effectiveType = strings.ToLower(strings.TrimSpace(defaults.Type))
+	}
+	tracked := pickTrack(defaults.Track, trackOverride)
+	relative := hasFlag(flags, flagRelative)
+	mode, explicitMode := modeFlag(flags)
+	if !explicitMode {
+		mode = defaults.Mode
+	}
+	switch {
+	case effectiveType == "":
+		return fmt.Errorf("%s: file type is required", label)
+	case effectiveType != flagCopy && effectiveType != flagLink:
+		return fmt.Errorf("%s: unsupported file type %q (expected %q or %q)", label, effectiveType, flagCopy, flagLink)
+	case hasFlag(flags, flagPerFile):
+		return fmt.Errorf("%s: flag %q is only valid on copied directories", label, flagPerFile)
+	case relative && effectiveType != flagLink:
+		return fmt.Errorf("%s: flag %q is only valid on link entries", label, flagRelative)
+	case explicitMode && effectiveType == flagLink:
+		return fmt.Errorf("%s: flag %q is only valid on copied files", label, prefixMode)
+	case tracked != nil && !*tracked && effectiveType == flagLink:
+		return fmt.Errorf("%s: untracked is not supported for link entries", label)
+	}
+
+	entry := Glob{
+		Pattern:  filepath.ToSlash(filepath.Clean(pattern)),
+		Source:   sourceRoot,
+		Dest:     destRoot,
+		Link:     effectiveType == flagLink,
+		Relative: relative,
+		Tracked:  tracked,
+		Backup:   backupFlag(flags),
+		Root:     root,
+	}
+	if !entry.Link {
+		entry.Mode = mode
+	}
+	plan.Globs = append(plan.Globs, entry)
+	return nil
+}
+
+// flagsForNode parses a node's flags into its type and tracking override,
+// and reports whether its os/arch constraints match the current platform.
+// label names the node in errors, e.g. "tree.a.b".
+func flagsForNode(flags []string, isDir bool, label string) (string, *bool, bool, error) {
 	var (
 		typeFlag      string
 		trackOverride *bool
+		oses, arches  []string
+		trackedOSes   []string
 		seen          = map[string]struct{}{}
 	)
 
 	for _, raw := range flags {
 		flag := strings.ToLower(strings.TrimSpace(raw))
 		if flag == "" {
-			return "", nil, fmt.Errorf("tree.%s: flags may not be empty", pathLabel)
+			return "", nil, false, fmt.Errorf("%s: flags may not be empty", label)
 		}
 		if _, exists := seen[flag]; exists {
-			return "", nil, fmt.Errorf("tree.%s: duplicate flag %q", pathLabel, flag)
+			return "", nil, false, fmt.Errorf("%s: duplicate flag %q", label, flag)
 		}
 		seen[flag] = struct{}{}
 
 		switch flag {
 		case flagCopy, flagLink:
-			if isDir {
-				return "", nil, fmt.Errorf("tree.%s: flag %q is only valid on files", pathLabel, flag)
-			}
 			if typeFlag != "" {
-				return "", nil, fmt.Errorf("tree.%s: conflicting type flags %q and %q", pathLabel, typeFlag, flag)
+				return "", nil, false, fmt.Errorf("%s: conflicting type flags %q and %q", label, typeFlag, flag)
 			}
 			typeFlag = flag
 		case flagTracked:
 			if trackOverride != nil && !*trackOverride {
-				return "", nil, fmt.Errorf("tree.%s: conflicting tracking flags %q and %q", pathLabel, flagTracked, flagUntracked)
+				return "", nil, false, fmt.Errorf("%s: conflicting tracking flags %q and %q", label, flagTracked, flagUntracked)
 			}
 			v := true
 			trackOverride = &v
 		case flagUntracked:
 			if trackOverride != nil && *trackOverride {
-				return "", nil, fmt.Errorf("tree.%s: conflicting tracking flags %q and %q", pathLabel, flagTracked, flagUntracked)
+				return "", nil, false, fmt.Errorf("%s: conflicting tracking flags %q and %q", label, flagTracked, flagUntracked)
 			}
 			v := false
 			trackOverride = &v
+		case flagRelative, flagPerFile:
+			// checked against the entry's type by the caller
+		case flagBackup, flagNoBackup:
+			if hasFlag(flags, flagBackup) && hasFlag(flags, flagNoBackup) {
+				return "", nil, false, fmt.Errorf("%s: conflicting backup flags %q and %q", label, flagBackup, flagNoBackup)
+			}
 		default:
-			return "", nil, fmt.Errorf("tree.%s: unsupported flag %q", pathLabel, flag)
+			if value, ok := strings.CutPrefix(flag, prefixMode); ok {
+				if err := validateMode(value); err != nil {
+					return "", nil, false, fmt.Errorf("%s: flag %q: %w", label, flag, err)
+				}
+				continue
+			}
+			if value, ok := strings.CutPrefix(flag, prefixTracked); ok {
+				if strings.TrimSpace(value) == "" {
+					return "", nil, false, fmt.Errorf("%s: flag %q requires a value", label, flag)
+				}
+				trackedOSes = append(trackedOSes, value)
+				continue
+			}
+			value, isOS := strings.CutPrefix(flag, prefixOS)
+			if !isOS {
+				var isArch bool
+				if value, isArch = strings.CutPrefix(flag, prefixArch); !isArch {
+					return "", nil, false, fmt.Errorf("%s: unsupported flag %q", label, flag)
+				}
+			}
+			if strings.TrimSpace(value) == "" {
+				return "", nil, false, fmt.Errorf("%s: flag %q requires a value", label, flag)
+			}
+			if isOS {
+				oses = append(oses, value)
+			} else {
+				arches = append(arches, value)
+			}
 		}
 	}
 
-	return typeFlag, trackOverride, nil
+	if len(trackedOSes) > 0 {
+		if trackOverride != nil {
+			return "", nil, false, fmt.Errorf("%s: %q can't be combined with %q or %q", label, prefixTracked+trackedOSes[0], flagTracked, flagUntracked)
+		}
+		v := slices.Contains(trackedOSes, goos)
+		trackOverride = &v
+	}
+
+	applies := (len(oses) == 0 || slices.Contains(oses, goos)) &&
+		(len(arches) == 0 || slices.Contains(arches, goarch))
+
+	return typeFlag, trackOverride, applies, nil
+}
+
+// modeFlag returns the value of a "mode:" flag in flags, which flagsForNode
+// has already validated.
+func modeFlag(flags []string) (string, bool) {
+	for _, raw := range flags {
+		if value, ok := strings.CutPrefix(strings.ToLower(strings.TrimSpace(raw)), prefixMode); ok {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// backupFlag returns the backup override set by a "backup" or "no-backup"
+// flag in flags, or nil to leave it to the store's options.
+func backupFlag(flags []string) *bool {
+	switch {
+	case hasFlag(flags, flagBackup):
+		v := true
+		return &v
+	case hasFlag(flags, flagNoBackup):
+		v := false
+		return &v
+	default:
+		return nil
+	}
+}
+
+// hasFlag reports whether flags include flag, ignoring case and whitespace.
+func hasFlag(flags []string, flag string) bool {
+	return slices.ContainsFunc(flags, func(raw string) bool {
+		return strings.ToLower(strings.TrimSpace(raw)) == flag
+	})
 }
 
 func normalizeFlags(flags []string) []string {
@@ -430,10 +877,14 @@ func formatTreePath(parts []string) string {
 	return strings.Join(out, ".")
 }
 
+// mergeDefaults returns base with the fields set in override replacing its
+// own, so the nearer defaults win field by field.
 func mergeDefaults(base Defaults, override *Defaults) Defaults {
 	out := Defaults{
 		Type:  base.Type,
 		Track: cloneBool(base.Track),
+		Mode:  base.Mode,
+		Base:  base.Base,
 	}
 	if override == nil {
 		return out
@@ -444,6 +895,12 @@ func mergeDefaults(base Defaults, override *Defaults) Defaults {
 	if override.Track != nil {
 		out.Track = cloneBool(override.Track)
 	}
+	if strings.TrimSpace(override.Mode) != "" {
+		out.Mode = strings.TrimSpace(override.Mode)
+	}
+	if strings.TrimSpace(override.Base) != "" {
+		out.Base = strings.TrimSpace(override.Base)
+	}
 	return out
 }
 
@@ -3,6 +3,8 @@ package manifest
 import (
 	"os"
 	"path/filepath"
+	"reflect"
+	"slices"
 	"strings"
 	"testing"
 )
@@ -79,6 +81,81 @@ func TestResolveStructuralTree(t *testing.T) {
 	}
 }
 
+func TestResolveCopiedDirectory(t *testing.T) {
+	m := Manifest{
+		Schema: 1,
+		Profile: Profile{
+			Slug: "test",
+			Name: "test",
+		},
+		Roots: []Root{
+			{
+				Source: "home",
+				Dest:   "~",
+				Tree: Tree{
+					".config": DirectoryNode(nil, Tree{
+						"nvim": DirectoryNode([]string{"copy"}, nil),
+					}),
+				},
+			},
+		},
+	}
+
+	if err := m.Resolve(); err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+	if len(m.Plan.Copies) != 1 || len(m.Plan.Dirs) != 0 {
+		t.Fatalf("len(Copies) = %d, len(Dirs) = %d, want 1 and 0", len(m.Plan.Copies), len(m.Plan.Dirs))
+	}
+	got := m.Plan.Copies[0]
+	if got.Source != filepath.Join("home", "dot_config", "nvim") || got.Dest != filepath.Join("~", ".config", "nvim") || got.PerFile {
+		t.Fatalf("unexpected copy entry: %#v", got)
+	}
+
+	m.Roots[0].Tree[".config"].Dir.Tree["nvim"] = DirectoryNode([]string{"per-file", "copy"}, nil)
+	if err := m.Resolve(); err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+	if len(m.Plan.Copies) != 1 || !m.Plan.Copies[0].PerFile {
+		t.Fatalf("Copies = %#v, want one per-file copy", m.Plan.Copies)
+	}
+}
+
+func TestResolveLinkedDirectoryAndRelativeLinks(t *testing.T) {
+	m := Manifest{
+		Schema:  1,
+		Profile: Profile{Slug: "test", Name: "test"},
+		Roots: []Root{
+			{
+				Source:   "home",
+				Dest:     "~",
+				Defaults: &Defaults{Type: "link"},
+				Tree: Tree{
+					".zshrc": FileNode("relative"),
+					"bin":    DirectoryNode([]string{"link", "relative"}, nil),
+					"notes":  DirectoryNode(nil, nil),
+				},
+			},
+		},
+	}
+
+	if err := m.Resolve(); err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+	want := []Link{
+		{To: filepath.Join("home", "dot_zshrc"), From: filepath.Join("~", ".zshrc"), Relative: true},
+		{To: filepath.Join("home", "bin"), From: filepath.Join("~", "bin"), Dir: true, Relative: true},
+	}
+	if !slices.Equal(m.Plan.Links, want) {
+		t.Fatalf("Links = %#v, want %#v", m.Plan.Links, want)
+	}
+	// A directory only becomes a link when its own metadata says so, not
+	// through the root's default type.
+	if len(m.Plan.Dirs) != 1 || m.Plan.Dirs[0].Path != filepath.Join("~", "notes") {
+		t.Fatalf("Dirs = %#v, want notes created as a directory", m.Plan.Dirs)
+	}
+}
+
 func TestResolveTrackedOverridesDefaultFalse(t *testing.T) {
 	m := Manifest{
 		Schema: 1,
@@ -112,6 +189,57 @@ func TestResolveTrackedOverridesDefaultFalse(t *testing.T) {
 	}
 }
 
+func TestResolvePlanOrdering(t *testing.T) {
+	m := Manifest{
+		Schema: 1,
+		Profile: Profile{
+			Slug: "test",
+			Name: "test",
+		},
+		Roots: []Root{
+			{
+				Source:   "second",
+				Dest:     "/b",
+				Defaults: &Defaults{Type: "copy"},
+				Tree: Tree{
+					"z": FileNode(),
+					"a": DirectoryNode(nil, Tree{
+						"y": FileNode(),
+						"b": FileNode(),
+					}),
+				},
+			},
+			{
+				Source:   "first",
+				Dest:     "/a",
+				Defaults: &Defaults{Type: "copy"},
+				Tree: Tree{
+					"only": FileNode(),
+				},
+			},
+		},
+	}
+
+	if err := m.Resolve(); err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+
+	want := []string{
+		filepath.Join("/b", "a", "b"),
+		filepath.Join("/b", "a", "y"),
+		filepath.Join("/b", "z"),
+		filepath.Join("/a", "only"),
+	}
+	if len(m.Plan.Files) != len(want) {
+		t.Fatalf("len(Files) = %d, want %d", len(m.Plan.Files), len(want))
+	}
+	for i, file := range m.Plan.Files {
+		if file.Dest != want[i] {
+			t.Fatalf("Files[%d].Dest = %q, want %q", i, file.Dest, want[i])
+		}
+	}
+}
+
 func TestDecodeManifestRejectsOldEntriesFormat(t *testing.T) {
 	dir := t.TempDir()
 	path := filepath.Join(dir, Name)
@@ -166,15 +294,53 @@ func TestResolveRejectsInvalidTreeFlags(t *testing.T) {
 			wantErr: `duplicate flag "copy"`,
 		},
 		{
-			name: "directory type flag",
+			name: "linked directory with children",
 			root: Root{
 				Source: "home",
 				Dest:   "~",
 				Tree: Tree{
-					"dir": DirectoryNode([]string{"copy"}, nil),
+					"dir": DirectoryNode([]string{"link"}, Tree{"file": FileNode("copy")}),
 				},
 			},
-			wantErr: `flag "copy" is only valid on files`,
+			wantErr: "linked directories may not declare children",
+		},
+		{
+			name: "relative copy",
+			root: Root{
+				Source: "home",
+				Dest:   "~",
+				Tree:   Tree{"file": FileNode("copy", "relative")},
+			},
+			wantErr: `flag "relative" is only valid on link entries`,
+		},
+		{
+			name: "copied directory with children",
+			root: Root{
+				Source: "home",
+				Dest:   "~",
+				Tree: Tree{
+					"dir": DirectoryNode([]string{"copy"}, Tree{"file": FileNode("copy")}),
+				},
+			},
+			wantErr: "copied directories may not declare children",
+		},
+		{
+			name: "per-file file",
+			root: Root{
+				Source: "home",
+				Dest:   "~",
+				Tree:   Tree{"file": FileNode("copy", "per-file")},
+			},
+			wantErr: `flag "per-file" is only valid on copied directories`,
+		},
+		{
+			name: "per-file linked directory",
+			root: Root{
+				Source: "home",
+				Dest:   "~",
+				Tree:   Tree{"dir": DirectoryNode([]string{"link", "per-file"}, nil)},
+			},
+			wantErr: `flag "per-file" is only valid on copied directories`,
 		},
 		{
 			name: "reserved root dot",
@@ -185,6 +351,24 @@ func TestResolveRejectsInvalidTreeFlags(t *testing.T) {
 			},
 			wantErr: "reserved key is not allowed at the root level",
 		},
+		{
+			name: "relative dest",
+			root: Root{
+				Source: "home",
+				Dest:   ".config",
+				Tree:   Tree{"file": FileNode()},
+			},
+			wantErr: `dest: ".config" is relative and would resolve against the working directory, use "~/.config" or an absolute path`,
+		},
+		{
+			name: "empty constraint",
+			root: Root{
+				Source: "home",
+				Dest:   "~",
+				Tree:   Tree{"file": FileNode("copy", "os:")},
+			},
+			wantErr: `flag "os:" requires a value`,
+		},
 	}
 
 	for _, tt := range tests {
@@ -301,6 +485,353 @@ func TestWriteUsesStructuralTreeEncoding(t *testing.T) {
 	}
 }
 
+// TestWriteRoundTripsFlaglessFiles covers file entries without flags, which
+// were written as null and then failed to decode: a written manifest must
+// load back.
+func TestWriteRoundTripsFlaglessFiles(t *testing.T) {
+	dir := t.TempDir()
+	m := Manifest{
+		Schema:  SchemaVersion,
+		Profile: Profile{Slug: "test"},
+		Roots: []Root{{
+			Source:   "home",
+			Dest:     "~",
+			Defaults: &Defaults{Type: "copy"},
+			Tree:     Tree{".zshrc": FileNode()},
+		}},
+	}
+	if err := Write(filepath.Join(dir, Name), m); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	raw, err := os.ReadFile(filepath.Join(dir, Name))
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if !strings.Contains(string(raw), `".zshrc": []`) {
+		t.Fatalf("Write() output = %s, want .zshrc written as []", raw)
+	}
+
+	loaded, _, err := Load(dir)
+	if err != nil {
+		t.Fatalf("Load() of the written manifest error = %v", err)
+	}
+	if node, ok := loaded.Roots[0].Tree[".zshrc"]; !ok || !node.IsFile() || len(node.File) != 0 {
+		t.Fatalf("Load() .zshrc = %+v, %v, want a file entry without flags", node, ok)
+	}
+}
+
+func TestLoadReadsNullFileEntries(t *testing.T) {
+	dir := t.TempDir()
+	raw := `{"schema": 1, "profile": {"slug": "test"}, "roots": [{"source": "home", "dest": "~", "defaults": {"type": "copy"}, "tree": {".zshrc": null}}]}`
+	if err := os.WriteFile(filepath.Join(dir, Name), []byte(raw), 0o644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	loaded, _, err := Load(dir)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if node, ok := loaded.Roots[0].Tree[".zshrc"]; !ok || !node.IsFile() || len(node.File) != 0 {
+		t.Fatalf("Load() .zshrc = %+v, %v, want a file entry without flags", node, ok)
+	}
+}
+
 func boolPtr(v bool) *bool {
 	return &v
 }
+
+func TestResolveInlineContent(t *testing.T) {
+	m := Manifest{
+		Schema:  1,
+		Profile: Profile{Slug: "test", Name: "test"},
+		Roots: []Root{
+			{
+				Source: "home",
+				Dest:   "~",
+				Tree:   Tree{".zshrc": FileNode("copy")},
+				Inline: map[string]string{".config/app/marker": "", ".gitignore": "*.log\n"},
+			},
+		},
+	}
+
+	if err := m.Resolve(); err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+	if len(m.Plan.Files) != 3 {
+		t.Fatalf("len(Files) = %d, want 3", len(m.Plan.Files))
+	}
+	got := m.Plan.Files[2]
+	if got.Source != "" || got.Content != "*.log\n" || got.Dest != filepath.Join("~", ".gitignore") {
+		t.Fatalf("unexpected inline entry: %#v", got)
+	}
+
+	m.Roots[0].Inline = map[string]string{".zshrc": "conflict"}
+	if err := m.Resolve(); err == nil || !strings.Contains(err.Error(), "also declared in tree") {
+		t.Fatalf("Resolve() error = %v, want tree conflict", err)
+	}
+
+	m.Roots[0].Inline = map[string]string{"../escape": "x"}
+	if err := m.Resolve(); err == nil || !strings.Contains(err.Error(), "stay inside it") {
+		t.Fatalf("Resolve() error = %v, want escape error", err)
+	}
+}
+
+func TestResolvePlatformConstraints(t *testing.T) {
+	oldOS, oldArch := goos, goarch
+	goos, goarch = "linux", "arm64"
+	t.Cleanup(func() { goos, goarch = oldOS, oldArch })
+
+	m := Manifest{
+		Schema:  1,
+		Profile: Profile{Slug: "test", Name: "test"},
+		Roots: []Root{
+			{
+				Source:   "home",
+				Dest:     "~",
+				Defaults: &Defaults{Type: "link"},
+				Tree: Tree{
+					".bashrc":  FileNode("os:darwin", "os:linux"),
+					".xinitrc": FileNode("os:linux", "arch:amd64"),
+					"Library": DirectoryNode([]string{"os:darwin"}, Tree{
+						"prefs": FileNode("copy"),
+					}),
+					".config": DirectoryNode(nil, Tree{
+						"app": FileNode("copy", "arch:arm64"),
+					}),
+				},
+			},
+		},
+	}
+
+	if err := m.Resolve(); err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+	if len(m.Plan.Links) != 1 || m.Plan.Links[0].From != filepath.Join("~", ".bashrc") {
+		t.Fatalf("Links = %#v, want only .bashrc", m.Plan.Links)
+	}
+	if len(m.Plan.Files) != 1 || m.Plan.Files[0].Dest != filepath.Join("~", ".config", "app") {
+		t.Fatalf("Files = %#v, want only .config/app", m.Plan.Files)
+	}
+}
+
+func TestResolvePlatformTracking(t *testing.T) {
+	oldOS := goos
+	goos = "darwin"
+	t.Cleanup(func() { goos = oldOS })
+
+	m := Manifest{
+		Schema:  1,
+		Profile: Profile{Slug: "test", Name: "test"},
+		Roots: []Root{
+			{
+				Source:   "home",
+				Dest:     "~",
+				Defaults: &Defaults{Type: "copy"},
+				Tree: Tree{
+					".linuxrc": FileNode("tracked:linux"),
+					".macrc":   FileNode("tracked:linux", "tracked:darwin"),
+				},
+			},
+		},
+	}
+
+	if err := m.Resolve(); err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+	tracked := map[string]bool{}
+	for _, file := range m.Plan.Files {
+		tracked[filepath.Base(file.Dest)] = file.Tracked == nil || *file.Tracked
+	}
+	if tracked[".linuxrc"] || !tracked[".macrc"] {
+		t.Fatalf("tracked = %v, want only .macrc tracked on darwin", tracked)
+	}
+
+	m.Roots[0].Tree[".linuxrc"] = FileNode("untracked", "tracked:linux")
+	if err := m.Resolve(); err == nil || !strings.Contains(err.Error(), "can't be combined") {
+		t.Fatalf("Resolve() error = %v, want conflict with untracked", err)
+	}
+}
+
+func TestDiscoverWalksUpToCeiling(t *testing.T) {
+	root := t.TempDir()
+	profile := filepath.Join(root, "dotfiles")
+	nested := filepath.Join(profile, "home", "dot_config")
+	if err := os.MkdirAll(nested, 0o755); err != nil {
+		t.Fatalf("MkdirAll() error = %v", err)
+	}
+	manifestPath := filepath.Join(profile, HiddenName)
+	if err := os.WriteFile(manifestPath, []byte("{}"), 0o644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	got, err := DiscoverUntil(nested, root)
+	if err != nil {
+		t.Fatalf("DiscoverUntil() error = %v", err)
+	}
+	if got != manifestPath {
+		t.Fatalf("DiscoverUntil() = %q, want %q", got, manifestPath)
+	}
+
+	if _, err := DiscoverUntil(nested, filepath.Join(profile, "home")); err == nil {
+		t.Fatalf("DiscoverUntil() climbed above the ceiling")
+	}
+
+	if err := os.WriteFile(filepath.Join(profile, Name), []byte("{}"), 0o644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if _, err := DiscoverUntil(nested, root); err == nil || !strings.Contains(err.Error(), "remove one") {
+		t.Fatalf("DiscoverUntil() error = %v, want ambiguity error", err)
+	}
+}
+
+func TestValidateReportsEveryProblem(t *testing.T) {
+	m := Manifest{
+		Schema: 2,
+		Roots: []Root{
+			{Source: "", Dest: ""},
+			{Source: "home", Dest: "~", Inline: map[string]string{"../escape": ""}},
+			{Source: "etc", Dest: "/etc", Tree: Tree{"hosts": FileNode("bogus")}},
+		},
+	}
+
+	errs := m.Validate()
+	want := []string{
+		"schema: unsupported value 2",
+		"roots[0]: source: value is required",
+		"roots[0]: dest: value is required",
+		`roots[1]: inline."../escape"`,
+		"roots[2]: ",
+	}
+	if len(errs) != len(want) {
+		t.Fatalf("Validate() returned %d error(s), want %d: %v", len(errs), len(want), errs)
+	}
+	for i, err := range errs {
+		if !strings.Contains(err.Error(), want[i]) {
+			t.Fatalf("Validate()[%d] = %v, want it to contain %q", i, err, want[i])
+		}
+	}
+
+	if err := m.Resolve(); err == nil || !strings.Contains(err.Error(), "roots[1]") {
+		t.Fatalf("Resolve() error = %v, want every problem joined", err)
+	}
+}
+
+func TestResolveDefaultsPrecedence(t *testing.T) {
+	m := Manifest{
+		Schema:   1,
+		Profile:  Profile{Slug: "test", Name: "test"},
+		Defaults: &Defaults{Type: "copy", Track: boolPtr(false), Mode: "0600", Base: "~"},
+		Roots: []Root{
+			{
+				Source: "home",
+				Tree: Tree{
+					".zshrc":   FileNode(),
+					".profile": FileNode("mode:0644", "tracked"),
+				},
+			},
+			{
+				Source:   "config",
+				Dest:     ".config",
+				Defaults: &Defaults{Track: boolPtr(true), Mode: "0640"},
+				Tree: Tree{
+					"app.conf": FileNode(),
+					"link":     FileNode("link"),
+				},
+			},
+		},
+	}
+
+	if err := m.Resolve(); err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+	type resolved struct {
+		Mode    string
+		Tracked bool
+	}
+	got := map[string]resolved{}
+	for _, file := range m.Plan.Files {
+		got[filepath.ToSlash(file.Dest)] = resolved{Mode: file.Mode, Tracked: file.Tracked == nil || *file.Tracked}
+	}
+	want := map[string]resolved{
+		"~/.zshrc":           {Mode: "0600", Tracked: false}, // manifest defaults
+		"~/.profile":         {Mode: "0644", Tracked: true},  // entry flags
+		"~/.config/app.conf": {Mode: "0640", Tracked: true},  // root defaults
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("files = %v, want %v", got, want)
+	}
+	if len(m.Plan.Links) != 1 || filepath.ToSlash(m.Plan.Links[0].From) != "~/.config/link" {
+		t.Fatalf("links = %#v, want ~/.config/link without a mode", m.Plan.Links)
+	}
+
+	for _, tt := range []struct {
+		name string
+		edit func(m *Manifest)
+		want string
+	}{
+		{name: "bad mode", edit: func(m *Manifest) { m.Defaults.Mode = "0999" }, want: "defaults.mode"},
+		{name: "relative base", edit: func(m *Manifest) { m.Defaults.Base = "dotfiles" }, want: "defaults.base"},
+		{name: "root base", edit: func(m *Manifest) { m.Roots[1].Defaults.Base = "~" }, want: "only valid in the manifest's defaults"},
+		{name: "link mode", edit: func(m *Manifest) { m.Roots[1].Tree["link"] = FileNode("link", "mode:0600") }, want: "only valid on copied files"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			m := Manifest{
+				Schema:   1,
+				Profile:  Profile{Slug: "test", Name: "test"},
+				Defaults: &Defaults{Type: "copy", Base: "~"},
+				Roots: []Root{
+					{Source: "home", Tree: Tree{".zshrc": FileNode()}},
+					{Source: "config", Dest: ".config", Defaults: &Defaults{}, Tree: Tree{"link": FileNode("link")}},
+				},
+			}
+			tt.edit(&m)
+			if err := m.Resolve(); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("Resolve() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetRequiredVersionEditsOnlyTheValue(t *testing.T) {
+	const before = `{
+  "schema": 1,
+  "roots": [{"source": "home", "tree": {"requires": ["copy"]}}],
+  "requires":   { "other": {"tohru": "x"}, "tohru" :  "0.1.0" },
+  "profile": { "slug": "test" }
+}
+`
+	tests := []struct {
+		name    string
+		raw     string
+		wantOld string
+		want    string
+	}{
+		{"declared", before, "0.1.0", strings.Replace(before, `"0.1.0"`, `"0.2.0"`, 1)},
+		{"current", `{"requires": {"tohru": "0.2.0"}}`, "0.2.0", `{"requires": {"tohru": "0.2.0"}}`},
+		{"undeclared", `{"requires": {}, "profile": {"slug": "test"}}`, "", `{"requires": {}, "profile": {"slug": "test"}}`},
+		{"no requires", `{"profile": {"slug": "test"}}`, "", `{"profile": {"slug": "test"}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), Name)
+			if err := os.WriteFile(path, []byte(tt.raw), 0o644); err != nil {
+				t.Fatalf("WriteFile() error = %v", err)
+			}
+
+			old, err := SetRequiredVersion(path, "0.2.0")
+			if err != nil {
+				t.Fatalf("SetRequiredVersion() error = %v", err)
+			}
+			if old != tt.wantOld {
+				t.Fatalf("SetRequiredVersion() = %q, want %q", old, tt.wantOld)
+			}
+			got, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("ReadFile() error = %v", err)
+			}
+			if string(got) != tt.want {
+				t.Fatalf("manifest after SetRequiredVersion() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
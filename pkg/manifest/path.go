@@ -20,6 +20,20 @@ func EncodeSourcePart(part string) string {
 	}
 }
 
+// DecodeSourcePart is the inverse of EncodeSourcePart, mapping a source tree
+// segment back to the destination segment it stands for.
+func DecodeSourcePart(part string) string {
+	rest, ok := strings.CutPrefix(part, sourceDotEscapePrefix)
+	switch {
+	case !ok:
+		return part
+	case strings.HasPrefix(rest, "_"):
+		return sourceDotEscapePrefix + rest[1:]
+	default:
+		return "." + rest
+	}
+}
+
 func SourcePath(sourceRoot string, parts []string) string {
 	pathParts := make([]string, 0, len(parts)+1)
 	pathParts = append(pathParts, sourceRoot)
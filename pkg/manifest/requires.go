@@ -0,0 +1,134 @@
+package manifest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// SetRequiredVersion rewrites the requires.tohru field of the manifest file at
+// path to v and returns the version it replaced. Only the field's value is
+// edited, so the rest of the file keeps its layout and key order. A manifest
+// that declares no requires.tohru is left as it is and "" is returned.
+func SetRequiredVersion(path, v string) (string, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("read manifest %s: %w", path, err)
+	}
+
+	start, end, err := findRequiredVersion(raw)
+	if err != nil {
+		return "", fmt.Errorf("find requires.tohru in %s: %w", path, err)
+	}
+	if start < 0 {
+		return "", nil
+	}
+	var old string
+	if err := json.Unmarshal(raw[start:end], &old); err != nil {
+		return "", fmt.Errorf("decode requires.tohru in %s: %w", path, err)
+	}
+	if old == v {
+		return old, nil
+	}
+
+	value, err := json.Marshal(v)
+	if err != nil {
+		return "", fmt.Errorf("encode version %q: %w", v, err)
+	}
+	payload := make([]byte, 0, len(raw)-(end-start)+len(value))
+	payload = append(payload, raw[:start]...)
+	payload = append(payload, value...)
+	payload = append(payload, raw[end:]...)
+	if err := writeFile(path, payload); err != nil {
+		return "", err
+	}
+	return old, nil
+}
+
+// findRequiredVersion returns the byte range of the requires.tohru string in
+// the manifest raw, or -1 if it has none.
+func findRequiredVersion(raw []byte) (int, int, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	if err := expectDelim(dec, '{'); err != nil {
+		return 0, 0, err
+	}
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return 0, 0, err
+		}
+		if key != "requires" {
+			if err := skipValue(dec); err != nil {
+				return 0, 0, err
+			}
+			continue
+		}
+
+		if err := expectDelim(dec, '{'); err != nil {
+			return 0, 0, fmt.Errorf("requires: %w", err)
+		}
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return 0, 0, err
+			}
+			if key != "tohru" {
+				if err := skipValue(dec); err != nil {
+					return 0, 0, err
+				}
+				continue
+			}
+
+			// The offset is just past the key; the value starts at the next
+			// quote after the colon.
+			after := int(dec.InputOffset())
+			value, err := dec.Token()
+			if err != nil {
+				return 0, 0, err
+			}
+			if _, ok := value.(string); !ok {
+				return 0, 0, fmt.Errorf("requires.tohru is not a string")
+			}
+			end := int(dec.InputOffset())
+			start := bytes.IndexByte(raw[after:end], '"')
+			if start < 0 {
+				return 0, 0, fmt.Errorf("requires.tohru is not a string")
+			}
+			return after + start, end, nil
+		}
+		return -1, -1, nil
+	}
+	return -1, -1, nil
+}
+
+func expectDelim(dec *json.Decoder, want json.Delim) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok != want {
+		return fmt.Errorf("expected %q, found %v", want, tok)
+	}
+	return nil
+}
+
+// skipValue consumes the next value from dec, however deeply nested.
+func skipValue(dec *json.Decoder) error {
+	depth := 0
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch tok {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+		if depth == 0 {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package manifest
+
+import "encoding/json"
+
+// Schema returns a JSON Schema (draft 2020-12) describing the manifest file
+// format, for editor completion and external validation.
+func Schema() map[string]any {
+	str := func() map[string]any { return map[string]any{"type": "string"} }
+	ref := func(name string) map[string]any { return map[string]any{"$ref": "#/$defs/" + name} }
+
+	return map[string]any{
+		"$schema":              "https://json-schema.org/draft/2020-12/schema",
+		"title":                "tohru manifest",
+		"type":                 "object",
+		"required":             []string{"schema", "profile"},
+		"additionalProperties": false,
+		"properties": map[string]any{
+			"schema": map[string]any{"const": SchemaVersion},
+			"requires": map[string]any{
+				"type":                 "object",
+				"additionalProperties": false,
+				"properties": map[string]any{
+					"tohru": map[string]any{
+						"type":        "string",
+						"description": "minimum tohru version, e.g. \"0.2.0\"",
+					},
+				},
+			},
+			"profile": map[string]any{
+				"type":                 "object",
+				"additionalProperties": false,
+				"properties": map[string]any{
+					"slug":        str(),
+					"name":        str(),
+					"description": str(),
+				},
+			},
+			"defaults": ref("defaults"),
+			"options": map[string]any{
+				"type":                 "object",
+				"description":          "store config options overridden while this profile is loaded",
+				"additionalProperties": false,
+				"properties": map[string]any{
+					"backups": map[string]any{
+						"type":                 "object",
+						"additionalProperties": false,
+						"properties": map[string]any{
+							"enabled": map[string]any{"type": "boolean"},
+							"prune": map[string]any{
+								"enum":        []string{"auto", "manual"},
+								"description": "auto is only allowed when the store's config already prunes automatically",
+							},
+						},
+					},
+					"cache_profiles": map[string]any{"type": "boolean"},
+				},
+			},
+			"roots": map[string]any{
+				"type":  "array",
+				"items": ref("root"),
+			},
+		},
+		"$defs": map[string]any{
+			"root": map[string]any{
+				"type":                 "object",
+				"required":             []string{"source"},
+				"additionalProperties": false,
+				"properties": map[string]any{
+					"source":   map[string]any{"type": "string", "description": "directory in the profile, relative to the manifest"},
+					"dest":     map[string]any{"type": "string", "description": "absolute destination directory, ~ expands to $HOME; may be relative to, or omitted in favour of, the manifest's defaults.base"},
+					"defaults": ref("defaults"),
+					"tree":     ref("tree"),
+					"glob": map[string]any{
+						"type":                 "object",
+						"description":          "files linked or copied by pattern, keyed by a path relative to dest that may use *, ?, [...], ** (any depth) and {a,b}; each file under source that matches goes to the same path under dest",
+						"additionalProperties": ref("flags"),
+					},
+					"inline": map[string]any{
+						"type":                 "object",
+						"description":          "files written with literal content, keyed by path relative to dest",
+						"additionalProperties": map[string]any{"type": "string"},
+					},
+				},
+			},
+			"defaults": map[string]any{
+				"type":                 "object",
+				"additionalProperties": false,
+				"properties": map[string]any{
+					"type":  map[string]any{"enum": []string{flagCopy, flagLink}},
+					"track": map[string]any{"type": "boolean", "default": true},
+					"mode": map[string]any{
+						"type":        "string",
+						"description": "octal permissions of copied files, e.g. \"0600\"",
+						"pattern":     "^0?[0-7]{3}$",
+					},
+					"base": map[string]any{
+						"type":        "string",
+						"description": "manifest-level only: directory relative root dests resolve against, ~ expands to $HOME",
+					},
+				},
+			},
+			"flags": map[string]any{
+				"type":        "array",
+				"uniqueItems": true,
+				"items": map[string]any{
+					"anyOf": []any{
+						map[string]any{"enum": []string{flagCopy, flagLink, flagTracked, flagUntracked, flagRelative, flagBackup, flagNoBackup, flagPerFile}},
+						map[string]any{
+							"description": "restrict the entry to matching platforms, e.g. os:linux or arch:arm64, or track it only on some, e.g. tracked:linux, or set a copied file's permissions, e.g. mode:0600",
+							"pattern":     "^(os|arch|tracked|mode):.+$",
+						},
+					},
+				},
+			},
+			"tree": map[string]any{
+				"type":                 "object",
+				"additionalProperties": ref("node"),
+			},
+			"node": map[string]any{
+				"description": "an array of flags for a file, or an object for a directory",
+				"oneOf": []any{
+					ref("flags"),
+					map[string]any{
+						"type":                 "object",
+						"properties":           map[string]any{".": ref("flags")},
+						"additionalProperties": ref("node"),
+					},
+				},
+			},
+		},
+	}
+}
+
+// SchemaJSON returns Schema encoded as indented JSON.
+func SchemaJSON() ([]byte, error) {
+	raw, err := json.MarshalIndent(Schema(), "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(raw, '\n'), nil
+}
@@ -0,0 +1,64 @@
+package manifest
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSchemaCoversManifestFields(t *testing.T) {
+	schema := Schema()
+	defs := schema["$defs"].(map[string]any)
+
+	var check func(typ reflect.Type, node map[string]any, path string)
+	check = func(typ reflect.Type, node map[string]any, path string) {
+		if ref, ok := node["$ref"].(string); ok {
+			node = defs[strings.TrimPrefix(ref, "#/$defs/")].(map[string]any)
+		}
+		props, _ := node["properties"].(map[string]any)
+
+		for i := range typ.NumField() {
+			field := typ.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			prop, ok := props[name].(map[string]any)
+			if !ok {
+				t.Errorf("schema is missing %s.%s", path, name)
+				continue
+			}
+
+			ft := field.Type
+			for ft.Kind() == reflect.Pointer || ft.Kind() == reflect.Slice {
+				if ft.Kind() == reflect.Slice {
+					if items, ok := prop["items"].(map[string]any); ok {
+						prop = items
+					}
+				}
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				check(ft, prop, path+"."+name)
+			}
+		}
+	}
+
+	check(reflect.TypeOf(Manifest{}), schema, "manifest")
+}
+
+func TestSchemaJSON(t *testing.T) {
+	raw, err := SchemaJSON()
+	if err != nil {
+		t.Fatalf("SchemaJSON() error = %v", err)
+	}
+	if !strings.Contains(string(raw), `"$schema"`) {
+		t.Fatalf("SchemaJSON() output missing $schema:\n%s", raw)
+	}
+}
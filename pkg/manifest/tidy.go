@@ -2,6 +2,7 @@ package manifest
 
 import (
 	"fmt"
+	"maps"
 	"path/filepath"
 	"reflect"
 	"slices"
@@ -121,6 +122,27 @@ func mergeRoot(parent Root, child Root, relParts []string) (Root, error) {
 		return Root{}, err
 	}
 	out.Tree = mergedTree
+
+	for key, content := range child.Inline {
+		merged := filepath.ToSlash(filepath.Join(append(slices.Clone(relParts), fileutils.SplitPathParts(key)...)...))
+		if existing, ok := out.Inline[merged]; ok && existing != content {
+			return Root{}, fmt.Errorf("cannot merge inline path %q due to conflicting content", merged)
+		}
+		if out.Inline == nil {
+			out.Inline = map[string]string{}
+		}
+		out.Inline[merged] = content
+	}
+	for pattern, flags := range child.Glob {
+		merged := filepath.ToSlash(filepath.Join(append(slices.Clone(relParts), pattern)...))
+		if existing, ok := out.Glob[merged]; ok && !slices.Equal(normalizeFlags(existing), normalizeFlags(flags)) {
+			return Root{}, fmt.Errorf("cannot merge glob %q due to conflicting flags", merged)
+		}
+		if out.Glob == nil {
+			out.Glob = map[string][]string{}
+		}
+		out.Glob[merged] = slices.Clone(flags)
+	}
 	return out, nil
 }
 
@@ -212,6 +234,8 @@ func cloneRoot(root Root) Root {
 		Dest:     root.Dest,
 		Defaults: cloneDefaults(root.Defaults),
 		Tree:     cloneTree(root.Tree),
+		Glob:     cloneGlobs(root.Glob),
+		Inline:   maps.Clone(root.Inline),
 	}
 }
 
@@ -222,5 +246,18 @@ func cloneDefaults(defaults *Defaults) *Defaults {
 	return &Defaults{
 		Type:  defaults.Type,
 		Track: cloneBool(defaults.Track),
+		Mode:  defaults.Mode,
+		Base:  defaults.Base,
+	}
+}
+
+func cloneGlobs(globs map[string][]string) map[string][]string {
+	if globs == nil {
+		return nil
+	}
+	out := make(map[string][]string, len(globs))
+	for pattern, flags := range globs {
+		out[pattern] = slices.Clone(flags)
 	}
+	return out
 }
@@ -0,0 +1,85 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/olimci/tohru/pkg/digest"
+	"github.com/olimci/tohru/pkg/manifest"
+	"github.com/olimci/tohru/pkg/utils/archiveutils"
+	"github.com/olimci/tohru/pkg/utils/fileutils"
+)
+
+// extractArchive unpacks a profile archive under the store and returns the
+// directory holding its manifest, subdir of the archive's root when set.
+// Extractions are keyed by the archive's digest, so an unchanged archive is
+// reused and an updated one re-extracted.
+func (s Store) extractArchive(archive, subdir string) (string, error) {
+	dir, err := s.extractedDir(archive)
+	if err != nil {
+		return "", err
+	}
+	return archiveRoot(dir, subdir)
+}
+
+// extractedDir returns where archive is extracted under the store,
+// extracting it first if needed.
+func (s Store) extractedDir(archive string) (string, error) {
+	d, err := digest.ForPath(archive)
+	if err != nil {
+		return "", fmt.Errorf("digest archive %s: %w", archive, err)
+	}
+	dir := filepath.Join(s.ExtractedPath(), d.Sum)
+
+	if _, err := os.Stat(dir); errors.Is(err, os.ErrNotExist) {
+		if err := os.MkdirAll(s.ExtractedPath(), 0o755); err != nil {
+			return "", fmt.Errorf("create %s: %w", s.ExtractedPath(), err)
+		}
+		tmp, err := os.MkdirTemp(s.ExtractedPath(), d.Sum+tempMarker)
+		if err != nil {
+			return "", fmt.Errorf("create extraction directory: %w", err)
+		}
+		if err := archiveutils.Extract(archive, tmp); err != nil {
+			_ = fileutils.RemovePath(tmp)
+			return "", err
+		}
+		if err := os.Rename(tmp, dir); err != nil {
+			_ = fileutils.RemovePath(tmp)
+			return "", fmt.Errorf("move extracted archive into %s: %w", dir, err)
+		}
+	} else if err != nil {
+		return "", fmt.Errorf("stat %s: %w", dir, err)
+	}
+
+	return dir, nil
+}
+
+// archiveRoot finds the manifest directory of an extracted archive, allowing
+// for the single top-level directory most release tarballs wrap files in.
+// subdir, when set, is looked up under whichever of the two is the root.
+func archiveRoot(dir, subdir string) (string, error) {
+	roots := []string{dir}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", fmt.Errorf("read extracted archive %s: %w", dir, err)
+	}
+	if len(entries) == 1 && entries[0].IsDir() {
+		roots = append(roots, filepath.Join(dir, entries[0].Name()))
+	}
+
+	for _, root := range roots {
+		target, err := sourceSubdir(root, subdir)
+		if err != nil {
+			continue
+		}
+		if _, _, err := manifest.Locate(target); err == nil {
+			return target, nil
+		}
+	}
+	if subdir != "" {
+		return "", fmt.Errorf("no %s found in %s of the archive", manifest.Name, subdir)
+	}
+	return "", fmt.Errorf("no %s found at the top of the archive", manifest.Name)
+}
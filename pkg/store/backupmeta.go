@@ -0,0 +1,104 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+const backupMetaFile = "meta.json"
+
+// BackupMeta records where a backup object was taken from, so a restore can
+// put back the ownership, permissions and timestamp the copy into the store
+// doesn't keep. Backups share a CID, so Path is wherever the content was first
+// backed up from. Backups taken before metadata was recorded have none.
+//
+// The backup object keeps ModTime as its own modification time, so the age of
+// a backup is Taken, not the object's mtime; anything expiring backups by age
+// should go by Taken. It is zero for backups recorded before it was.
+type BackupMeta struct {
+	Path    string      `json:"path"`
+	Mode    os.FileMode `json:"mode"`
+	UID     int         `json:"uid"`
+	GID     int         `json:"gid"`
+	ModTime time.Time   `json:"mtime"`
+	Taken   time.Time   `json:"taken,omitzero"`
+}
+
+// backupMetaPath returns where the metadata of the backup object at
+// objectPath is kept.
+func backupMetaPath(objectPath string) string {
+	return filepath.Join(filepath.Dir(objectPath), backupMetaFile)
+}
+
+// writeBackupMeta records the metadata of source, the path being backed up,
+// next to the backup object at objectPath.
+func writeBackupMeta(objectPath, source string) error {
+	info, err := os.Lstat(source)
+	if err != nil {
+		return fmt.Errorf("stat backup source %s: %w", source, err)
+	}
+
+	meta := BackupMeta{
+		Path:    source,
+		Mode:    info.Mode(),
+		UID:     -1,
+		GID:     -1,
+		ModTime: info.ModTime(),
+		Taken:   time.Now(),
+	}
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		meta.UID, meta.GID = int(st.Uid), int(st.Gid)
+	}
+
+	path := backupMetaPath(objectPath)
+	if err := encodeJSON(path, meta); err != nil {
+		return fmt.Errorf("write backup metadata %s: %w", path, err)
+	}
+	return nil
+}
+
+// readBackupMeta reads the metadata stored next to the backup object at
+// objectPath, returning nil for backups that have none.
+func readBackupMeta(objectPath string) (*BackupMeta, error) {
+	path := backupMetaPath(objectPath)
+	var meta BackupMeta
+	if err := decodeJSON(path, &meta); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read backup metadata %s: %w", path, err)
+	}
+	return &meta, nil
+}
+
+// applyBackupMeta restores the recorded owner, mode and modification time of
+// the backup at objectPath onto destination. Ownership is only restored when
+// running as root, since nobody else can give files away.
+func applyBackupMeta(objectPath, destination string) error {
+	meta, err := readBackupMeta(objectPath)
+	if err != nil || meta == nil {
+		return err
+	}
+
+	if os.Geteuid() == 0 && meta.UID >= 0 && meta.GID >= 0 {
+		if err := os.Lchown(destination, meta.UID, meta.GID); err != nil {
+			return fmt.Errorf("restore owner of %s: %w", destination, err)
+		}
+	}
+	if meta.Mode&os.ModeSymlink != 0 {
+		return nil
+	}
+
+	mode := meta.Mode & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky)
+	if err := os.Chmod(destination, mode); err != nil {
+		return fmt.Errorf("restore mode of %s: %w", destination, err)
+	}
+	if err := os.Chtimes(destination, meta.ModTime, meta.ModTime); err != nil {
+		return fmt.Errorf("restore modification time of %s: %w", destination, err)
+	}
+	return nil
+}
@@ -0,0 +1,312 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/olimci/tohru/pkg/digest"
+	"github.com/olimci/tohru/pkg/store/config"
+	"github.com/olimci/tohru/pkg/utils/fileutils"
+)
+
+// backupStore keeps backup objects by CID. storeBackup, restoreBackup and
+// the backup cleanup and scanning go through it rather than the layout on
+// disk.
+type backupStore interface {
+	// Persist backs up the object at source under cid, unless a backup with
+	// that CID is already kept where the layout puts it, and returns where
+	// the object is kept.
+	Persist(cid, source string, recordPath func(string)) (string, error)
+	// Restore copies the object kept at path to destination and puts back
+	// the metadata recorded with it. destination must not exist.
+	Restore(path, destination string, recordPath func(string)) error
+	// Dirs lists the directories holding each CID's object and metadata.
+	// A CID backed up from several paths in the mirror layout has several.
+	// Finding them walks every backup, so an operation lists them once and
+	// hands each CID's to Verify and Remove.
+	Dirs() (map[string][]string, error)
+	// Scan lists the CIDs whose object is present, those whose isn't, and
+	// the directories it wasn't allowed to look in, along with Dirs.
+	Scan() (backupScan, error)
+	// Verify reports whether the object kept in dirs, the directories of
+	// cid, still has that digest. The object must be present.
+	Verify(cid string, dirs []string) (bool, error)
+	// Remove deletes the backup kept in dirs, object and metadata alike.
+	Remove(dirs []string, recordPath func(string)) error
+}
+
+// backupScan is what backupStore.Scan found.
+type backupScan struct {
+	Dirs         map[string][]string // directories of every CID, as Dirs lists them
+	Available    map[string]struct{} // CIDs whose object is present
+	Broken       []string            // CIDs whose object is gone, sorted
+	Inaccessible []string            // directories that couldn't be read, sorted
+}
+
+// backupObjectFile names the object in a backup directory, next to its
+// metadata.
+const backupObjectFile = "object"
+
+// mirrorDir holds the backups of config.LayoutMirror under root, apart from
+// the CID directories at the top.
+const mirrorDir = "mirror"
+
+// dirBackups keeps each backup in a directory named by its CID, holding the
+// object and its metadata. With config.LayoutCID the directories sit at the
+// top of root; with config.LayoutMirror they sit under the original path of
+// what was backed up in mirrorDir, e.g. root/mirror/home/me/.zshrc/<cid>, so
+// the backups of a path can be found by hand, at the cost of keeping content
+// backed up from several paths once per path. The layout only picks where
+// new backups go: backups are found in either. At the top of root every
+// directory whose name contains a ':' is a CID directory; under mirrorDir,
+// where the names are those of the original path, only a directory named by
+// a well-formed CID that holds nothing but its object and metadata is.
+type dirBackups struct {
+	root   string
+	layout string
+	retry  fileutils.RetryPolicy
+}
+
+func (s Store) backups() backupStore {
+	return dirBackups{root: s.BackupsPath(), layout: s.backupLayout, retry: s.retry}
+}
+
+// withBackupLayout returns s writing new backups in layout, see
+// config.Backups.Layout.
+func (s Store) withBackupLayout(layout string) Store {
+	s.backupLayout = layout
+	return s
+}
+
+// objectPath returns where the object kept under cid is, or where the cid
+// layout would keep it when there is no backup with that CID. source, when
+// known, is a path the backup was taken from: its mirrored copy is looked
+// for directly, and every backup is only walked when neither layout has it
+// where expected.
+func (b dirBackups) objectPath(cid, source string) string {
+	path := filepath.Join(b.root, cid, backupObjectFile)
+	if _, err := os.Lstat(filepath.Dir(path)); err == nil {
+		return path
+	}
+	if source != "" {
+		mirrored := b.mirrorPath(cid, source)
+		if _, err := os.Lstat(filepath.Dir(mirrored)); err == nil {
+			return mirrored
+		}
+	}
+	if dirs, err := b.Dirs(); err == nil && len(dirs[cid]) > 0 {
+		return filepath.Join(dirs[cid][0], backupObjectFile)
+	}
+	return path
+}
+
+// persistPath returns where a new backup of source under cid is written.
+func (b dirBackups) persistPath(cid, source string) string {
+	if b.layout != config.LayoutMirror {
+		return filepath.Join(b.root, cid, backupObjectFile)
+	}
+	return b.mirrorPath(cid, source)
+}
+
+// mirrorPath returns where config.LayoutMirror keeps the object of a backup
+// of source under cid.
+func (b dirBackups) mirrorPath(cid, source string) string {
+	mirrored := strings.TrimLeft(strings.TrimPrefix(filepath.Clean(source), filepath.VolumeName(source)), string(filepath.Separator))
+	return filepath.Join(b.root, mirrorDir, mirrored, cid, backupObjectFile)
+}
+
+func (b dirBackups) Persist(cid, source string, recordPath func(string)) (string, error) {
+	objectPath := b.persistPath(cid, source)
+
+	existingBackup, exists, err := maybeSnapshot(objectPath)
+	if err != nil {
+		return "", fmt.Errorf("check backup object at %s: %w", objectPath, err)
+	}
+	if exists {
+		if existingBackup.Digest != cid {
+			return "", fmt.Errorf("backup collision for CID %s at %s", cid, objectPath)
+		}
+		return objectPath, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(objectPath), 0o755); err != nil {
+		return "", fmt.Errorf("create backup directory for %s: %w", objectPath, err)
+	}
+	recordPath(objectPath)
+	// Backups keep the original modification time, see BackupMeta.Taken.
+	err = b.retry.Do(func() error {
+		return fileutils.CopyPathWith(source, objectPath, fileutils.CopyOptions{PreserveTimes: true})
+	})
+	if err != nil {
+		return "", fmt.Errorf("backup %s into %s: %w", source, objectPath, err)
+	}
+
+	written, err := snapshot(objectPath)
+	if err != nil {
+		return "", fmt.Errorf("snapshot backup object %s: %w", objectPath, err)
+	}
+	if written.Digest != cid {
+		_ = fileutils.RemovePath(objectPath)
+		return "", fmt.Errorf("backup digest mismatch for %s", objectPath)
+	}
+
+	recordPath(backupMetaPath(objectPath))
+	if err := writeBackupMeta(objectPath, source); err != nil {
+		return "", err
+	}
+
+	return objectPath, nil
+}
+
+func (b dirBackups) Restore(path, destination string, recordPath func(string)) error {
+	recordPath(destination)
+	if err := b.retry.Do(func() error { return copyPathFunc(path, destination, fileutils.CopyOptions{}) }); err != nil {
+		return fmt.Errorf("restore backup %s to %s: %w", path, destination, err)
+	}
+	return applyBackupMeta(path, destination)
+}
+
+func (b dirBackups) Dirs() (map[string][]string, error) {
+	dirs, _, err := b.walkDirs()
+	return dirs, err
+}
+
+// walkDirs is Dirs, also listing the directories below root it wasn't
+// allowed to read, which are skipped rather than failing the walk.
+func (b dirBackups) walkDirs() (map[string][]string, []string, error) {
+	dirs := make(map[string][]string)
+	var inaccessible []string
+	err := filepath.WalkDir(b.root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == b.root && errors.Is(err, fs.ErrNotExist) {
+				return fs.SkipAll
+			}
+			if path != b.root && errors.Is(err, fs.ErrPermission) {
+				inaccessible = append(inaccessible, path)
+				return fs.SkipDir
+			}
+			return err
+		}
+		if !d.IsDir() || path == b.root {
+			return nil
+		}
+		name := d.Name()
+		switch parent := filepath.Dir(path); {
+		case parent == b.root && strings.Contains(name, ":"):
+		case parent == b.root && name != mirrorDir:
+			// Not a backup; the walk doesn't look inside.
+			return fs.SkipDir
+		case parent == b.root:
+			return nil
+		default:
+			ok, err := isMirroredBackup(path, name)
+			if errors.Is(err, fs.ErrPermission) {
+				inaccessible = append(inaccessible, path)
+				return fs.SkipDir
+			} else if err != nil || !ok {
+				return err
+			}
+		}
+		dirs[name] = append(dirs[name], path)
+		return fs.SkipDir
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("read backups directory %s: %w", b.root, err)
+	}
+	return dirs, inaccessible, nil
+}
+
+// isMirroredBackup reports whether the directory at path, named name, under
+// mirrorDir is a backup rather than part of an original path: its name is a
+// well-formed CID and the only directory in it, if any, is the object. The
+// algorithm may be one tohru doesn't know, so rehash finds backups labelled
+// with another.
+func isMirroredBackup(path, name string) (bool, error) {
+	if d, err := digest.Parse(name); err != nil || d.IsZero() || d.Kind == digest.KindNull {
+		return false, nil
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return false, err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() && entry.Name() != backupObjectFile {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// Scan counts a CID whose directories can't be read as neither present nor
+// broken, so tidying never removes a backup it couldn't look at.
+func (b dirBackups) Scan() (backupScan, error) {
+	dirs, inaccessible, err := b.walkDirs()
+	if err != nil {
+		return backupScan{}, err
+	}
+
+	scan := backupScan{
+		Dirs:         dirs,
+		Available:    make(map[string]struct{}, len(dirs)),
+		Broken:       make([]string, 0, len(dirs)),
+		Inaccessible: inaccessible,
+	}
+	for cid, paths := range dirs {
+		unreadable := false
+		for _, dir := range paths {
+			path := filepath.Join(dir, backupObjectFile)
+			if _, statErr := os.Lstat(path); statErr == nil {
+				scan.Available[cid] = struct{}{}
+				break
+			} else if errors.Is(statErr, os.ErrPermission) {
+				scan.Inaccessible = append(scan.Inaccessible, dir)
+				unreadable = true
+			} else if !errors.Is(statErr, os.ErrNotExist) {
+				return backupScan{}, fmt.Errorf("stat backup object %s: %w", path, statErr)
+			}
+		}
+		if _, ok := scan.Available[cid]; !ok && !unreadable {
+			scan.Broken = append(scan.Broken, cid)
+		}
+	}
+	slices.Sort(scan.Broken)
+	slices.Sort(scan.Inaccessible)
+
+	return scan, nil
+}
+
+func (b dirBackups) Verify(cid string, dirs []string) (bool, error) {
+	for _, dir := range dirs {
+		path := filepath.Join(dir, backupObjectFile)
+		if _, err := os.Lstat(path); errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		obj, err := snapshot(path)
+		if err != nil {
+			return false, fmt.Errorf("digest backup object %s: %w", path, err)
+		}
+		if obj.Digest != cid {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func (b dirBackups) Remove(dirs []string, recordPath func(string)) error {
+	for _, path := range dirs {
+		recordPath(path)
+		if err := b.retry.Do(func() error { return fileutils.RemovePath(path) }); err != nil {
+			return err
+		}
+		// Mirrored backups leave the directories of their original path.
+		for dir := filepath.Dir(path); dir != b.root && os.Remove(dir) == nil; dir = filepath.Dir(dir) {
+			recordPath(dir)
+		}
+	}
+	return nil
+}
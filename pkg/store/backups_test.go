@@ -0,0 +1,262 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/olimci/tohru/pkg/manifest"
+	"github.com/olimci/tohru/pkg/store/config"
+)
+
+func TestDirBackups(t *testing.T) {
+	dir := t.TempDir()
+	var b backupStore = dirBackups{root: filepath.Join(dir, "backups")}
+	var recorded []string
+	record := func(path string) { recorded = append(recorded, path) }
+
+	source := filepath.Join(dir, "source")
+	writeTestFile(t, source, "original\n")
+	if err := os.Chmod(source, 0o600); err != nil {
+		t.Fatalf("Chmod() error = %v", err)
+	}
+	obj, err := snapshot(source)
+	if err != nil {
+		t.Fatalf("snapshot() error = %v", err)
+	}
+
+	path, err := b.Persist(obj.Digest, source, record)
+	if err != nil {
+		t.Fatalf("Persist() error = %v", err)
+	}
+	if len(recorded) != 2 {
+		t.Fatalf("Persist() recorded %v, want the object and its metadata", recorded)
+	}
+	recorded = nil
+	if again, err := b.Persist(obj.Digest, source, record); err != nil || again != path || len(recorded) != 0 {
+		t.Fatalf("Persist() again = %q, %v, recorded %v, want the existing backup reused", again, err, recorded)
+	}
+	if _, err := b.Persist("file:sha256:00", source, record); err == nil {
+		t.Fatalf("Persist() under the wrong CID succeeded, want digest mismatch")
+	}
+
+	restored := filepath.Join(dir, "restored")
+	if err := b.Restore(path, restored, record); err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+	if raw, _ := os.ReadFile(restored); string(raw) != "original\n" {
+		t.Fatalf("restored content = %q, want original", raw)
+	}
+	if info, err := os.Stat(restored); err != nil || info.Mode().Perm() != 0o600 {
+		t.Fatalf("restored mode = %v, %v, want 0600 from the metadata", info.Mode(), err)
+	}
+
+	broken := "file:sha256:ff"
+	if err := os.MkdirAll(filepath.Join(dir, "backups", broken), 0o755); err != nil {
+		t.Fatalf("MkdirAll() error = %v", err)
+	}
+	scan, err := b.Scan()
+	if err != nil {
+		t.Fatalf("Scan() error = %v", err)
+	}
+	// The failed Persist above leaves its directory behind without an object.
+	wantBroken := []string{"file:sha256:00", broken}
+	if _, ok := scan.Available[obj.Digest]; !ok || len(scan.Available) != 1 || !slices.Equal(scan.Broken, wantBroken) {
+		t.Fatalf("Scan() = %v, %v, want %s available and %v broken", scan.Available, scan.Broken, obj.Digest, wantBroken)
+	}
+
+	for _, cid := range append([]string{obj.Digest}, wantBroken...) {
+		if err := b.Remove(scan.Dirs[cid], record); err != nil {
+			t.Fatalf("Remove(%s) error = %v", cid, err)
+		}
+	}
+	if scan, err := b.Scan(); err != nil || len(scan.Available) != 0 || len(scan.Broken) != 0 {
+		t.Fatalf("Scan() after Remove = %+v, %v, want empty", scan, err)
+	}
+}
+
+func TestDirBackupsMirror(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "backups")
+	b := dirBackups{root: root, layout: config.LayoutMirror}
+	record := func(string) {}
+
+	source := filepath.Join(dir, "home", ".zshrc")
+	writeTestFile(t, source, "original\n")
+	obj, err := snapshot(source)
+	if err != nil {
+		t.Fatalf("snapshot() error = %v", err)
+	}
+
+	path, err := b.Persist(obj.Digest, source, record)
+	if err != nil {
+		t.Fatalf("Persist() error = %v", err)
+	}
+	want := filepath.Join(root, mirrorDir, strings.TrimPrefix(source, string(filepath.Separator)), obj.Digest, backupObjectFile)
+	if path != want {
+		t.Fatalf("Persist() = %s, want %s", path, want)
+	}
+
+	// The same content from another path is kept again, under that path.
+	other := filepath.Join(dir, "home", ".zshrc.old")
+	writeTestFile(t, other, "original\n")
+	second, err := b.Persist(obj.Digest, other, record)
+	if err != nil || second == path {
+		t.Fatalf("Persist() from another path = %s, %v, want a second copy", second, err)
+	}
+
+	// Either layout finds mirrored backups by CID.
+	cidLayout := dirBackups{root: root, layout: config.LayoutCID}
+	if got := cidLayout.objectPath(obj.Digest, ""); got != path && got != second {
+		t.Fatalf("objectPath() = %s, want one of the mirrored copies", got)
+	}
+	if got := cidLayout.objectPath(obj.Digest, other); got != second {
+		t.Fatalf("objectPath() from %s = %s, want its own copy %s", other, got, second)
+	}
+	scan, err := cidLayout.Scan()
+	if _, ok := scan.Available[obj.Digest]; err != nil || !ok || len(scan.Available) != 1 || len(scan.Broken) != 0 {
+		t.Fatalf("Scan() = %+v, %v, want %s available", scan, err, obj.Digest)
+	}
+	if intact, err := cidLayout.Verify(obj.Digest, scan.Dirs[obj.Digest]); err != nil || !intact {
+		t.Fatalf("Verify() = %v, %v, want intact", intact, err)
+	}
+
+	restored := filepath.Join(dir, "restored")
+	if err := b.Restore(path, restored, record); err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+	if raw, _ := os.ReadFile(restored); string(raw) != "original\n" {
+		t.Fatalf("restored content = %q, want original", raw)
+	}
+
+	if err := cidLayout.Remove(scan.Dirs[obj.Digest], record); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	entries, err := os.ReadDir(root)
+	if err != nil || len(entries) != 0 {
+		t.Fatalf("backups after Remove = %v, %v, want both copies and their mirrored directories gone", entries, err)
+	}
+}
+
+func TestDirBackupsMirrorColonPaths(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "backups")
+	b := dirBackups{root: root, layout: config.LayoutMirror}
+
+	// Path components with a ':' in them, even ones shaped like a CID, are
+	// part of the mirrored path rather than taken for a backup.
+	lookalike := "file:sha256:" + strings.Repeat("ab", 32)
+	var cids []string
+	for _, source := range []string{
+		filepath.Join(dir, "home", "a:b", "f.txt"),
+		filepath.Join(dir, "home", lookalike, "g.txt"),
+	} {
+		writeTestFile(t, source, source)
+		obj, err := snapshot(source)
+		if err != nil {
+			t.Fatalf("snapshot() error = %v", err)
+		}
+		if _, err := b.Persist(obj.Digest, source, func(string) {}); err != nil {
+			t.Fatalf("Persist() error = %v", err)
+		}
+		cids = append(cids, obj.Digest)
+	}
+
+	scan, err := b.Scan()
+	if err != nil {
+		t.Fatalf("Scan() error = %v", err)
+	}
+	for _, cid := range cids {
+		if _, ok := scan.Available[cid]; !ok {
+			t.Fatalf("Scan() = %+v, want %s available", scan, cid)
+		}
+	}
+	if len(scan.Available) != len(cids) || len(scan.Broken) != 0 {
+		t.Fatalf("Scan() = %+v, want only %v, all available", scan, cids)
+	}
+}
+
+func TestLoadUnloadBackupLayouts(t *testing.T) {
+	for _, layout := range []string{config.LayoutCID, config.LayoutMirror} {
+		t.Run(layout, func(t *testing.T) {
+			s, home := newTestStore(t)
+			profile := writeProfile(t, manifest.Root{
+				Source: "home",
+				Dest:   home,
+				Tree:   manifest.Tree{".zshrc": manifest.FileNode("copy")},
+			})
+			writeTestFile(t, filepath.Join(profile, "home", "dot_zshrc"), "managed\n")
+			zshrc := filepath.Join(home, ".zshrc")
+			writeTestFile(t, zshrc, "mine\n")
+			if _, err := s.Install(); err != nil {
+				t.Fatalf("Install() error = %v", err)
+			}
+			cfg := DefaultConfig()
+			cfg.Options.Backups.Layout = layout
+			if err := encodeJSON(s.ConfigPath(), cfg); err != nil {
+				t.Fatalf("encodeJSON() error = %v", err)
+			}
+
+			if _, err := s.Load(profile, Options{}); err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+			lck, err := s.LoadState()
+			if err != nil {
+				t.Fatalf("LoadState() error = %v", err)
+			}
+			cid := lck.Files[0].Previous.Digest
+			want := filepath.Join(s.BackupsPath(), cid, backupObjectFile)
+			if layout == config.LayoutMirror {
+				want = filepath.Join(s.BackupsPath(), mirrorDir, strings.TrimPrefix(zshrc, string(filepath.Separator)), cid, backupObjectFile)
+			}
+			if got := s.BackupPath(cid); got != want {
+				t.Fatalf("BackupPath() = %s, want %s", got, want)
+			}
+
+			if _, err := s.Unload(Options{}); err != nil {
+				t.Fatalf("Unload() error = %v", err)
+			}
+			if raw, err := os.ReadFile(zshrc); err != nil || string(raw) != "mine\n" {
+				t.Fatalf("restored .zshrc = %q, %v, want the backed up content", raw, err)
+			}
+			// Backups are pruned automatically once nothing refers to them.
+			if entries, err := os.ReadDir(s.BackupsPath()); err != nil || len(entries) != 0 {
+				t.Fatalf("backups after unload = %v, %v, want none left", entries, err)
+			}
+		})
+	}
+}
+
+func TestDirBackupsScanInaccessible(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("permissions are not enforced for root")
+	}
+	root := filepath.Join(t.TempDir(), "backups")
+	b := dirBackups{root: root}
+
+	// A CID directory that can't be searched, and a mirrored path that can't
+	// be listed, are reported rather than failing the scan or counting as
+	// broken.
+	locked := filepath.Join(root, "file:sha256:aa")
+	writeTestFile(t, filepath.Join(locked, backupObjectFile), "kept\n")
+	mirrored := filepath.Join(root, mirrorDir, "home")
+	writeTestFile(t, filepath.Join(mirrored, "file:sha256:bb", backupObjectFile), "kept\n")
+	for _, dir := range []string{locked, mirrored} {
+		if err := os.Chmod(dir, 0o000); err != nil {
+			t.Fatalf("Chmod() error = %v", err)
+		}
+		t.Cleanup(func() { _ = os.Chmod(dir, 0o755) })
+	}
+
+	scan, err := b.Scan()
+	if err != nil {
+		t.Fatalf("Scan() error = %v", err)
+	}
+	want := []string{locked, mirrored}
+	slices.Sort(want)
+	if len(scan.Available) != 0 || len(scan.Broken) != 0 || !slices.Equal(scan.Inaccessible, want) {
+		t.Fatalf("Scan() = %+v, want %v inaccessible and nothing else", scan, want)
+	}
+}
@@ -0,0 +1,466 @@
+package store
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/olimci/tohru/pkg/digest"
+	"github.com/olimci/tohru/pkg/store/config"
+	"github.com/olimci/tohru/pkg/store/state"
+	"github.com/olimci/tohru/pkg/utils/fileutils"
+	"github.com/olimci/tohru/pkg/version"
+)
+
+// A bundle is a tar.gz holding everything needed to load a profile on a
+// machine without network access: its header first, then the store's config
+// and state, then the profile's source tree under bundleSource.
+const (
+	bundleFormat = 1
+
+	bundleHeaderName = "tohru-bundle.json"
+	bundleConfigName = "config.json"
+	bundleStateName  = "state.json"
+	bundleSource     = "source"
+)
+
+// bundleHeader describes a bundle. Files lists every file and symlink in it
+// but the header, so an import can check it holds exactly what was exported.
+type bundleHeader struct {
+	Format  int          `json:"format"`
+	Tohru   string       `json:"tohru"`            // version of tohru that wrote the bundle
+	Profile string       `json:"profile"`          // slug of the bundled profile
+	Subdir  string       `json:"subdir,omitempty"` // manifest directory within the source, slash-separated
+	Files   []bundleFile `json:"files"`
+}
+
+type bundleFile struct {
+	Path   string `json:"path"` // slash-separated, relative to the bundle root
+	Digest string `json:"digest"`
+}
+
+type bundleEntry struct {
+	name   string // path in the bundle
+	path   string // path on disk
+	info   fs.FileInfo
+	target string // symlinks only
+}
+
+// ExportBundle writes the loaded profile's source, with the store's config
+// and state, to a .tar.gz at dest that ImportBundle loads from. Profiles
+// loaded with Options.Add are not bundled.
+func (s Store) ExportBundle(dest string) (ExportResult, error) {
+	guard, err := s.Lock()
+	if err != nil {
+		return ExportResult{}, err
+	}
+	defer guard.Unlock()
+
+	if !s.IsInstalled() {
+		return ExportResult{}, ErrNotInstalled
+	}
+	if !isBundle(dest) {
+		return ExportResult{}, fmt.Errorf("bundle %s must be a .tar.gz or .tgz file", dest)
+	}
+	dest, err = fileutils.AbsPath(dest)
+	if err != nil {
+		return ExportResult{}, err
+	}
+
+	lck, err := s.LoadState()
+	if err != nil {
+		return ExportResult{}, err
+	}
+	if strings.ToLower(lck.Profile.State) != "loaded" {
+		return ExportResult{}, fmt.Errorf("no profile is loaded to bundle")
+	}
+
+	header := bundleHeader{
+		Format:  bundleFormat,
+		Tohru:   version.Version,
+		Profile: lck.Profile.Slug,
+		Subdir:  lck.Profile.Subdir,
+	}
+	entries := []bundleEntry{}
+	for _, file := range []struct{ name, path string }{
+		{bundleConfigName, s.ConfigPath()},
+		{bundleStateName, s.StatePath()},
+	} {
+		info, err := os.Stat(file.path)
+		if err != nil {
+			return ExportResult{}, fmt.Errorf("stat %s: %w", file.path, err)
+		}
+		entries = append(entries, bundleEntry{name: file.name, path: file.path, info: info})
+	}
+	source, err := sourceEntries(subdirRoot(lck.Profile), dest)
+	if err != nil {
+		return ExportResult{}, err
+	}
+	entries = append(entries, source...)
+
+	for _, entry := range entries {
+		if entry.info.IsDir() {
+			continue
+		}
+		d, err := digest.ForPath(entry.path)
+		if err != nil {
+			return ExportResult{}, fmt.Errorf("digest %s: %w", entry.path, err)
+		}
+		header.Files = append(header.Files, bundleFile{Path: entry.name, Digest: d.String()})
+	}
+
+	if err := writeBundle(dest, header, entries); err != nil {
+		return ExportResult{}, err
+	}
+
+	result := ExportResult{
+		Path:        dest,
+		ProfileName: lck.Profile.Slug,
+		FileCount:   len(header.Files),
+	}
+	if len(lck.Added) > 0 {
+		slugs := make([]string, 0, len(lck.Added))
+		for _, layer := range lck.Added {
+			slugs = append(slugs, layer.Profile.Slug)
+		}
+		result.Warnings = append(result.Warnings, fmt.Sprintf("added profiles are not bundled: %s", strings.Join(slugs, ", ")))
+	}
+	return result, nil
+}
+
+// sourceEntries lists the source tree at root, skipping skip so a bundle
+// written into its own source doesn't include an older copy of itself.
+func sourceEntries(root, skip string) ([]bundleEntry, error) {
+	var entries []bundleEntry
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == skip {
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		entry := bundleEntry{name: path.Join(bundleSource, filepath.ToSlash(rel)), path: p, info: info}
+		switch mode := info.Mode(); {
+		case mode.IsDir(), mode.IsRegular():
+		case mode&fs.ModeSymlink != 0:
+			target, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			// Import refuses links leading out of the bundle, so refuse
+			// to write them in the first place.
+			if filepath.IsAbs(target) || fileutils.Escapes(filepath.Join(filepath.Dir(rel), target)) {
+				return fmt.Errorf("symlink %s points outside the source and can't be bundled", p)
+			}
+			entry.target = target
+		default:
+			return fmt.Errorf("unsupported file type at %s (%s)", p, mode)
+		}
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("read source %s: %w", root, err)
+	}
+	return entries, nil
+}
+
+// writeBundle writes header and entries to dest through a temporary file.
+func writeBundle(dest string, header bundleHeader, entries []bundleEntry) error {
+	payload, err := json.MarshalIndent(header, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode bundle header: %w", err)
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(dest), filepath.Base(dest)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("create temporary file for %s: %w", dest, err)
+	}
+	tmp := f.Name()
+	defer f.Close()
+
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	err = tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     bundleHeaderName,
+		Mode:     0o644,
+		Size:     int64(len(payload)),
+	})
+	if err == nil {
+		_, err = tw.Write(payload)
+	}
+	for _, entry := range entries {
+		if err != nil {
+			break
+		}
+		err = writeBundleEntry(tw, entry)
+	}
+	if err == nil {
+		err = tw.Close()
+	}
+	if err == nil {
+		err = gz.Close()
+	}
+	if err == nil {
+		err = f.Chmod(0o644)
+	}
+	if err == nil {
+		err = f.Close()
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("write bundle %s: %w", dest, err)
+	}
+
+	if err := os.Rename(tmp, dest); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("replace %s: %w", dest, err)
+	}
+	return nil
+}
+
+func writeBundleEntry(tw *tar.Writer, entry bundleEntry) error {
+	hdr, err := tar.FileInfoHeader(entry.info, entry.target)
+	if err != nil {
+		return err
+	}
+	hdr.Name = entry.name
+	if entry.info.IsDir() {
+		hdr.Name += "/"
+	}
+	hdr.Uid, hdr.Gid, hdr.Uname, hdr.Gname = 0, 0, "", ""
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if !entry.info.Mode().IsRegular() {
+		return nil
+	}
+
+	f, err := os.Open(entry.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// ImportBundle checks the bundle against its header, replaces the
+// store's config with the bundled one and loads the bundled profile from
+// it, as an archive source, installing the store first if needed.
+func (s Store) ImportBundle(bundle string, opts Options) (LoadResult, error) {
+	guard, err := s.Lock()
+	if err != nil {
+		return LoadResult{}, err
+	}
+	defer guard.Unlock()
+
+	if !isBundle(bundle) {
+		return LoadResult{}, fmt.Errorf("bundle %s must be a .tar.gz or .tgz file", bundle)
+	}
+	bundle, err = fileutils.AbsPath(bundle)
+	if err != nil {
+		return LoadResult{}, err
+	}
+	header, err := readBundleHeader(bundle)
+	if err != nil {
+		return LoadResult{}, err
+	}
+	var warnings []string
+	if err := version.EnsureCompatible(header.Tohru); errors.Is(err, version.ErrMajorVersion) {
+		return LoadResult{}, fmt.Errorf("bundle was written by tohru %s: %w", header.Tohru, err)
+	} else if err != nil {
+		warnings = append(warnings, fmt.Sprintf("bundle was written by tohru %s, newer than this %s", header.Tohru, version.Version))
+	}
+
+	dir, err := s.extractedDir(bundle)
+	if err != nil {
+		return LoadResult{}, err
+	}
+	if err := verifyBundle(dir, header); err != nil {
+		return LoadResult{}, fmt.Errorf("bundle %s: %w", bundle, err)
+	}
+	var cfg config.Config
+	if err := decodeJSON(filepath.Join(dir, bundleConfigName), &cfg); err != nil {
+		return LoadResult{}, fmt.Errorf("decode bundled config: %w", err)
+	}
+	if cfg.Schema != config.SchemaVersion {
+		return LoadResult{}, fmt.Errorf("unsupported bundled config schema %d", cfg.Schema)
+	}
+	var bundled state.State
+	if err := decodeJSON(filepath.Join(dir, bundleStateName), &bundled); err != nil {
+		return LoadResult{}, fmt.Errorf("decode bundled state: %w", err)
+	}
+
+	if _, err := s.installMissing(); err != nil {
+		return LoadResult{}, err
+	}
+	previous, err := os.ReadFile(s.ConfigPath())
+	if err != nil {
+		return LoadResult{}, fmt.Errorf("read %s: %w", s.ConfigPath(), err)
+	}
+	if err := encodeJSON(s.ConfigPath(), cfg); err != nil {
+		return LoadResult{}, err
+	}
+
+	opts.ManifestDir = path.Join(bundleSource, header.Subdir)
+	if strings.TrimSpace(opts.ExpectName) == "" {
+		opts.ExpectName = header.Profile
+	}
+	result, err := s.loadUnlocked(bundle, opts)
+	if err != nil {
+		if restoreErr := writeAtomic(s.ConfigPath(), previous); restoreErr != nil {
+			err = errors.Join(err, fmt.Errorf("restore config: %w", restoreErr))
+		}
+		return result, err
+	}
+
+	if lck, err := s.LoadState(); err != nil {
+		warnings = append(warnings, fmt.Sprintf("compare with bundled state: %v", err))
+	} else if differ := differingFiles(bundled.Files, lck.Files); len(differ) > 0 {
+		warnings = append(warnings, fmt.Sprintf("loaded differently than when bundled: %s", strings.Join(differ, ", ")))
+	}
+	result.Warnings = append(warnings, result.Warnings...)
+	if !result.Skipped {
+		result.Warnings = historyWarning(result.Warnings, s.logHistory("import", result.ProfileName, result.TrackedCount, result.ChangedPaths))
+	}
+	return result, nil
+}
+
+func isBundle(path string) bool {
+	name := strings.ToLower(path)
+	return strings.HasSuffix(name, ".tar.gz") || strings.HasSuffix(name, ".tgz")
+}
+
+// readBundleHeader reads the header that starts the bundle at path and
+// checks its format.
+func readBundleHeader(path string) (bundleHeader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return bundleHeader{}, fmt.Errorf("open bundle %s: %w", path, err)
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return bundleHeader{}, fmt.Errorf("read gzip stream %s: %w", path, err)
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	hdr, err := tr.Next()
+	if err != nil || hdr.Name != bundleHeaderName {
+		return bundleHeader{}, fmt.Errorf("%s is not a tohru bundle: it doesn't start with %s", path, bundleHeaderName)
+	}
+	var header bundleHeader
+	if err := json.NewDecoder(tr).Decode(&header); err != nil {
+		return bundleHeader{}, fmt.Errorf("decode header of bundle %s: %w", path, err)
+	}
+	if header.Format != bundleFormat {
+		return bundleHeader{}, fmt.Errorf("bundle %s has unsupported format %d", path, header.Format)
+	}
+	if strings.TrimSpace(header.Tohru) == "" {
+		return bundleHeader{}, fmt.Errorf("bundle %s doesn't record the tohru version that wrote it", path)
+	}
+	return header, nil
+}
+
+// verifyBundle checks that the bundle extracted to dir holds exactly the
+// files its header lists, with the listed content.
+func verifyBundle(dir string, header bundleHeader) error {
+	want := make(map[string]string, len(header.Files))
+	for _, file := range header.Files {
+		want[file.Path] = file.Digest
+	}
+	for _, name := range []string{bundleConfigName, bundleStateName} {
+		if _, ok := want[name]; !ok {
+			return fmt.Errorf("header doesn't list %s", name)
+		}
+	}
+
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if name == bundleHeaderName {
+			return nil
+		}
+		expected, ok := want[name]
+		if !ok {
+			return fmt.Errorf("holds %s, which its header doesn't list", name)
+		}
+		delete(want, name)
+		actual, err := digest.ForPath(p)
+		if err != nil {
+			return fmt.Errorf("digest %s: %w", p, err)
+		}
+		if actual.String() != expected {
+			return fmt.Errorf("%s doesn't match the digest in its header", name)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(want) > 0 {
+		missing := make([]string, 0, len(want))
+		for name := range want {
+			missing = append(missing, name)
+		}
+		slices.Sort(missing)
+		return fmt.Errorf("is missing %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// differingFiles returns the paths tracked in both bundled and loaded whose
+// content differs. Links are compared by what they resolve to, since the
+// bundle is loaded from a new source directory, and left out when either
+// state predates recording that. Paths tracked in only one are left out too,
+// since destinations can differ between machines.
+func differingFiles(bundled, loaded []state.File) []string {
+	current := make(map[string]state.File, len(loaded))
+	for _, f := range loaded {
+		current[f.Path] = f
+	}
+	var differ []string
+	for _, f := range bundled {
+		l, ok := current[f.Path]
+		switch {
+		case !ok:
+		case f.Target != nil && l.Target != nil:
+			if f.Target.Digest != l.Target.Digest {
+				differ = append(differ, f.Path)
+			}
+		case f.Target == nil && l.Target == nil:
+			if f.Current.Digest != l.Current.Digest {
+				differ = append(differ, f.Path)
+			}
+		}
+	}
+	return differ
+}
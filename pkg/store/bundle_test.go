@@ -0,0 +1,173 @@
+package store
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/olimci/tohru/pkg/manifest"
+)
+
+func TestBundleRoundTrip(t *testing.T) {
+	src, home := newTestStore(t)
+	profile := writeProfile(t, manifest.Root{
+		Source: "home",
+		Dest:   home,
+		Tree: manifest.Tree{
+			".zshrc":   manifest.FileNode("link"),
+			".vimrc":   manifest.FileNode("copy"),
+			".aliases": manifest.FileNode("link"),
+		},
+	})
+	writeTestFile(t, filepath.Join(profile, "home", "dot_zshrc"), "zsh\n")
+	writeTestFile(t, filepath.Join(profile, "home", "dot_vimrc"), "vim\n")
+	if err := os.Symlink("dot_zshrc", filepath.Join(profile, "home", "dot_aliases")); err != nil {
+		t.Fatalf("Symlink() error = %v", err)
+	}
+	if _, err := src.Load(profile, Options{}); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	cfg := DefaultConfig()
+	cfg.Options.CacheProfiles = false
+	if err := encodeJSON(src.ConfigPath(), cfg); err != nil {
+		t.Fatalf("encodeJSON() error = %v", err)
+	}
+
+	bundle := filepath.Join(t.TempDir(), "profile.tar.gz")
+	exported, err := src.ExportBundle(bundle)
+	if err != nil {
+		t.Fatalf("ExportBundle() error = %v", err)
+	}
+	// config, state, the manifest and three source files
+	if exported.FileCount != 6 {
+		t.Fatalf("ExportBundle() FileCount = %d, want 6", exported.FileCount)
+	}
+
+	// Take the original machine away: nothing loaded, no source left.
+	if _, err := src.Unload(Options{}); err != nil {
+		t.Fatalf("Unload() error = %v", err)
+	}
+	if err := os.RemoveAll(profile); err != nil {
+		t.Fatalf("RemoveAll() error = %v", err)
+	}
+
+	dst, _ := newTestStore(t)
+	res, err := dst.ImportBundle(bundle, Options{})
+	if err != nil {
+		t.Fatalf("ImportBundle() error = %v", err)
+	}
+	if res.TrackedCount != 3 {
+		t.Fatalf("ImportBundle() TrackedCount = %d, want 3", res.TrackedCount)
+	}
+	if len(res.Warnings) > 0 {
+		t.Fatalf("ImportBundle() Warnings = %v, want none", res.Warnings)
+	}
+	for name, want := range map[string]string{".zshrc": "zsh\n", ".vimrc": "vim\n", ".aliases": "zsh\n"} {
+		got, err := os.ReadFile(filepath.Join(home, name))
+		if err != nil || string(got) != want {
+			t.Fatalf("ReadFile(%s) = %q, %v, want %q", name, got, err, want)
+		}
+	}
+	target, err := filepath.EvalSymlinks(filepath.Join(home, ".zshrc"))
+	if err != nil || !strings.HasPrefix(target, dst.ExtractedPath()) {
+		t.Fatalf(".zshrc resolves to %q (%v), want it inside %s", target, err, dst.ExtractedPath())
+	}
+
+	got, err := dst.LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if got.Options.CacheProfiles {
+		t.Fatalf("imported config = %+v, want the bundled one", got.Options)
+	}
+}
+
+func TestImportBundleRejects(t *testing.T) {
+	header := func(h bundleHeader) []byte {
+		b, err := json.Marshal(h)
+		if err != nil {
+			t.Fatalf("Marshal() error = %v", err)
+		}
+		return b
+	}
+	valid := bundleHeader{Format: bundleFormat, Tohru: "0.1.0"}
+
+	tests := []struct {
+		name    string
+		entries []tar.Header
+		header  []byte
+		wantErr string
+	}{
+		{
+			name:    "unknown format",
+			header:  header(bundleHeader{Format: bundleFormat + 1, Tohru: "0.1.0"}),
+			wantErr: "unsupported format",
+		},
+		{
+			name:    "newer major version",
+			header:  header(bundleHeader{Format: bundleFormat, Tohru: "9.0.0"}),
+			wantErr: "unsupported major version",
+		},
+		{
+			name:    "path traversal",
+			header:  header(valid),
+			entries: []tar.Header{{Name: "../escaped", Typeflag: tar.TypeReg, Mode: 0o644}},
+			wantErr: "escapes the extraction directory",
+		},
+		{
+			name:    "unlisted file",
+			header:  header(valid),
+			entries: []tar.Header{{Name: bundleConfigName, Typeflag: tar.TypeReg, Mode: 0o644}},
+			wantErr: "doesn't list config.json",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			bundle := filepath.Join(dir, "bundle.tgz")
+			writeTestBundle(t, bundle, tt.header, tt.entries)
+
+			s, _ := newTestStore(t)
+			_, err := s.ImportBundle(bundle, Options{})
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("ImportBundle() error = %v, want one containing %q", err, tt.wantErr)
+			}
+			if _, err := os.Lstat(filepath.Join(s.ExtractedPath(), "escaped")); !os.IsNotExist(err) {
+				t.Fatalf("entry was written outside the extraction directory: %v", err)
+			}
+		})
+	}
+}
+
+func writeTestBundle(t *testing.T, path string, header []byte, entries []tar.Header) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	entries = append([]tar.Header{{Name: bundleHeaderName, Typeflag: tar.TypeReg, Mode: 0o644, Size: int64(len(header))}}, entries...)
+	for i, hdr := range entries {
+		if err := tw.WriteHeader(&hdr); err != nil {
+			t.Fatalf("WriteHeader() error = %v", err)
+		}
+		if i == 0 {
+			if _, err := tw.Write(header); err != nil {
+				t.Fatalf("Write() error = %v", err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+}
@@ -4,6 +4,8 @@ const (
 	SchemaVersion = 1
 	PruneAuto     = "auto"
 	PruneManual   = "manual"
+	LayoutCID     = "cid"    // backups/<cid>/object
+	LayoutMirror  = "mirror" // backups/<original path>/<cid>/object
 )
 
 type Config struct {
@@ -14,9 +16,11 @@ type Config struct {
 type Options struct {
 	Backups       Backups `json:"backups"`
 	CacheProfiles bool    `json:"cache_profiles"`
+	DefaultSource string  `json:"default_source,omitempty"` // profile loaded when none is given and none is loaded
 }
 
 type Backups struct {
 	Enabled bool   `json:"enabled"`
 	Prune   string `json:"prune"`
+	Layout  string `json:"layout,omitempty"` // where new backups are written; both layouts are always read
 }
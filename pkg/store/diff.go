@@ -0,0 +1,203 @@
+package store
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/olimci/tohru/pkg/digest"
+	"github.com/olimci/tohru/pkg/store/state"
+	"github.com/olimci/tohru/pkg/utils/archiveutils"
+	"github.com/olimci/tohru/pkg/utils/diffutils"
+	"github.com/olimci/tohru/pkg/utils/fileutils"
+	"github.com/olimci/tohru/pkg/utils/profileutils"
+)
+
+// How a destination would change, see DiffPath.
+const (
+	DiffNew       = "new"       // nothing is there yet
+	DiffOverwrite = "overwrite" // something else is there and would be replaced
+	DiffAbandon   = "abandon"   // tracked now but not declared by the source, so it would be unloaded
+)
+
+// SourceDiff is how loading a source would differ from what is loaded,
+// worked out without changing anything.
+type SourceDiff struct {
+	ProfileName    string     // the source's profile
+	Replaces       string     // the loaded profile it would replace, "" when none is loaded
+	Paths          []DiffPath // destinations that would change, by path
+	UnchangedCount int        // destinations already as the source declares them
+}
+
+// DiffPath is how loading a source would change one destination.
+type DiffPath struct {
+	Path    string
+	Kind    string // link, file, dir or copy; for DiffAbandon, the kind of the tracked object
+	Change  string // DiffNew, DiffOverwrite or DiffAbandon
+	Tracked bool   // the loaded profile tracks the path, so an overwrite updates a managed path
+	Diff    string // unified diff of the content an overwrite replaces, for files
+}
+
+// DiffSource works out how loading source, a profile path, cached slug or
+// archive, would differ from the loaded profile it replaces: destinations it
+// would create or overwrite, with content diffs for files, and tracked paths
+// it no longer declares. Remote sources are refused, since diffing them would
+// mean downloading them.
+func (s Store) DiffSource(source string) (SourceDiff, error) {
+	if !s.IsInstalled() {
+		return SourceDiff{}, ErrNotInstalled
+	}
+	if isRemote(source) {
+		return SourceDiff{}, fmt.Errorf("can't diff remote source %s, download it and diff the archive", source)
+	}
+
+	lck, err := s.LoadState()
+	if err != nil {
+		return SourceDiff{}, err
+	}
+	profiles, err := s.LoadProfiles()
+	if err != nil {
+		return SourceDiff{}, err
+	}
+	target, err := resolveProfile(source, profiles)
+	if err != nil {
+		return SourceDiff{}, err
+	}
+	if archiveutils.IsArchive(target) {
+		archive, err := fileutils.AbsPath(target)
+		if err != nil {
+			return SourceDiff{}, err
+		}
+		guard, err := s.Lock()
+		if err != nil {
+			return SourceDiff{}, err
+		}
+		target, err = s.extractArchive(archive, "")
+		guard.Unlock()
+		if err != nil {
+			return SourceDiff{}, err
+		}
+	}
+
+	src, err := s.loadSource(target)
+	if err != nil {
+		return SourceDiff{}, err
+	}
+	slug, err := profileutils.ValidateSlug(src.Manifest.Profile.Slug, "profile.slug", true)
+	if err != nil {
+		return SourceDiff{}, err
+	}
+	ops, err := plan(src.Manifest, src.Dir)
+	if err != nil {
+		return SourceDiff{}, err
+	}
+	ops = s.excludeStore(ops)
+	replaced, _, err := loadSlot(lck, slug, "", false)
+	if err != nil {
+		return SourceDiff{}, err
+	}
+
+	result := SourceDiff{ProfileName: profileutils.DisplayName(slug, src.Manifest.Profile.Name, src.Dir)}
+	if strings.ToLower(replaced.Profile.State) == "loaded" {
+		result.Replaces = profileutils.DisplayName(replaced.Profile.Slug, replaced.Profile.Name, replaced.Profile.Path)
+	}
+	tracked := make(map[string]state.File, len(replaced.Files))
+	for _, f := range replaced.Files {
+		tracked[f.Path] = f
+	}
+
+	for _, op := range ops {
+		path, err := diffOp(op)
+		if err != nil {
+			return SourceDiff{}, err
+		}
+		if _, ok := tracked[op.Dest]; ok {
+			path.Tracked = true
+			delete(tracked, op.Dest)
+		}
+		if path.Change == "" {
+			result.UnchangedCount++
+			continue
+		}
+		result.Paths = append(result.Paths, path)
+	}
+	for _, f := range tracked {
+		result.Paths = append(result.Paths, DiffPath{
+			Path:    f.Path,
+			Kind:    objectKind(f.Current.Digest),
+			Change:  DiffAbandon,
+			Tracked: true,
+		})
+	}
+	slices.SortFunc(result.Paths, func(a, b DiffPath) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+	return result, nil
+}
+
+// diffOp compares what op would put at its destination with what is there,
+// leaving Change empty when they already match.
+func diffOp(op op) (DiffPath, error) {
+	path := DiffPath{Path: op.Dest, Kind: string(op.Kind)}
+	info, err := os.Lstat(op.Dest)
+	if os.IsNotExist(err) {
+		path.Change = DiffNew
+		return path, nil
+	} else if err != nil {
+		return path, fmt.Errorf("stat %s: %w", op.Dest, err)
+	}
+
+	if op.Kind == opDir {
+		if !info.IsDir() {
+			path.Change = DiffOverwrite
+		}
+		return path, nil
+	}
+
+	want, err := opDigest(op)
+	if err != nil {
+		return path, err
+	}
+	have, err := digest.ForPath(op.Dest)
+	if err != nil {
+		return path, fmt.Errorf("digest %s: %w", op.Dest, err)
+	}
+	if want.String() == have.String() {
+		return path, nil
+	}
+	path.Change = DiffOverwrite
+
+	if op.Kind == opFile && info.Mode().IsRegular() {
+		current, err := os.ReadFile(op.Dest)
+		if err != nil {
+			return path, fmt.Errorf("read %s: %w", op.Dest, err)
+		}
+		declared := []byte(op.Content)
+		name := "(inline content)"
+		if op.Source != "" {
+			if declared, err = os.ReadFile(op.Source); err != nil {
+				return path, fmt.Errorf("read %s: %w", op.Source, err)
+			}
+			name = op.Source
+		}
+		path.Diff = diffutils.Unified(op.Dest, name, current, declared)
+	}
+	return path, nil
+}
+
+// opDigest is the digest op's destination would have once applied.
+func opDigest(op op) (digest.Digest, error) {
+	switch {
+	case op.Kind == opLink:
+		return digest.ForBytes(digest.KindSymlink, []byte(op.Target))
+	case op.Kind == opFile && op.Source == "":
+		return digest.ForBytes(digest.KindFile, []byte(op.Content))
+	}
+
+	d, err := digest.ForPathWith(op.Source, digest.Options{Exclude: op.Exclude})
+	if err != nil {
+		return digest.Digest{}, fmt.Errorf("digest %s: %w", op.Source, err)
+	}
+	return d, nil
+}
@@ -0,0 +1,82 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/olimci/tohru/pkg/manifest"
+)
+
+func TestDiffSource(t *testing.T) {
+	s, home := newTestStore(t)
+	loaded := writeProfile(t, manifest.Root{
+		Source: "home",
+		Dest:   home,
+		Tree: manifest.Tree{
+			".zshrc":     manifest.FileNode("copy"),
+			".gitconfig": manifest.FileNode("copy"),
+			".old":       manifest.FileNode("link"),
+		},
+	})
+	writeTestFile(t, filepath.Join(loaded, "home", "dot_zshrc"), "export A=1\n")
+	writeTestFile(t, filepath.Join(loaded, "home", "dot_gitconfig"), "[user]\n")
+	writeTestFile(t, filepath.Join(loaded, "home", "dot_old"), "old\n")
+	if _, err := s.Load(loaded, Options{}); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	next := writeProfile(t, manifest.Root{
+		Source: "home",
+		Dest:   home,
+		Tree: manifest.Tree{
+			".zshrc":     manifest.FileNode("copy"),
+			".gitconfig": manifest.FileNode("copy"),
+			".new":       manifest.FileNode("link"),
+		},
+	})
+	writeTestFile(t, filepath.Join(next, "home", "dot_zshrc"), "export A=2\n")
+	writeTestFile(t, filepath.Join(next, "home", "dot_gitconfig"), "[user]\n")
+	writeTestFile(t, filepath.Join(next, "home", "dot_new"), "new\n")
+	before, err := os.ReadFile(s.StatePath())
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+
+	diff, err := s.DiffSource(next)
+	if err != nil {
+		t.Fatalf("DiffSource() error = %v", err)
+	}
+	if diff.UnchangedCount != 1 {
+		t.Fatalf("DiffSource() UnchangedCount = %d, want 1 (.gitconfig)", diff.UnchangedCount)
+	}
+	want := []DiffPath{
+		{Path: filepath.Join(home, ".new"), Kind: "link", Change: DiffNew},
+		{Path: filepath.Join(home, ".old"), Kind: "link", Change: DiffAbandon, Tracked: true},
+		{Path: filepath.Join(home, ".zshrc"), Kind: "file", Change: DiffOverwrite, Tracked: true},
+	}
+	if len(diff.Paths) != len(want) {
+		t.Fatalf("DiffSource() Paths = %+v, want %+v", diff.Paths, want)
+	}
+	for i, got := range diff.Paths {
+		got.Diff = ""
+		if got != want[i] {
+			t.Errorf("DiffSource() Paths[%d] = %+v, want %+v", i, got, want[i])
+		}
+	}
+	if d := diff.Paths[2].Diff; !strings.Contains(d, "-export A=1\n+export A=2\n") {
+		t.Errorf("DiffSource() .zshrc diff =\n%s\nwant the changed line", d)
+	}
+
+	after, err := os.ReadFile(s.StatePath())
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if string(after) != string(before) {
+		t.Fatalf("DiffSource() changed the state")
+	}
+	if _, err := os.Lstat(filepath.Join(home, ".new")); !os.IsNotExist(err) {
+		t.Fatalf("DiffSource() created .new: %v", err)
+	}
+}
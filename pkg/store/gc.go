@@ -0,0 +1,242 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/olimci/tohru/pkg/manifest"
+	"github.com/olimci/tohru/pkg/store/state"
+	"github.com/olimci/tohru/pkg/utils/fileutils"
+)
+
+// GCOptions controls Store.GC.
+type GCOptions struct {
+	DryRun bool // report what would be removed without removing anything
+}
+
+// GC removes everything in the store that nothing can use any more: broken
+// and unreferenced backups, temporary files and rollback snapshots left by
+// interrupted writes, and source cache entries for directories that are gone.
+func (s Store) GC(opts GCOptions) (GCResult, error) {
+	var result GCResult
+	guard, err := s.Lock()
+	if err != nil {
+		return result, err
+	}
+	defer guard.Unlock()
+
+	result, err = s.gcUnlocked(opts)
+	if err == nil && !opts.DryRun {
+		result.Warnings = historyWarning(result.Warnings, s.logHistory("gc", "", 0, result.ChangedPaths))
+	}
+	return result, err
+}
+
+func (s Store) gcUnlocked(opts GCOptions) (GCResult, error) {
+	if !s.IsInstalled() {
+		return GCResult{}, ErrNotInstalled
+	}
+
+	var result GCResult
+	changes := newPathRecorder()
+
+	// A pending journal still needs its rollback snapshot. A real run
+	// recovers it first; a dry run must not, so it leaves snapshots alone.
+	pending := false
+	if opts.DryRun {
+		if _, err := os.Stat(s.JournalPath()); err == nil {
+			pending = true
+			result.Warnings = append(result.Warnings, "an interrupted transaction is pending, rollback snapshots are kept until it is recovered")
+		}
+	} else {
+		recovered, err := s.recoverUnlocked()
+		if err != nil {
+			return GCResult{}, err
+		}
+		if recovered {
+			result.Warnings = append(result.Warnings, "recovered from an interrupted transaction")
+		}
+	}
+
+	lck, err := s.LoadState()
+	if err != nil {
+		return GCResult{}, err
+	}
+
+	remove := func(path string) error {
+		result.RemovedPaths = append(result.RemovedPaths, path)
+		if opts.DryRun {
+			return nil
+		}
+		if err := fileutils.RemovePath(path); err != nil {
+			return fmt.Errorf("remove %s: %w", path, err)
+		}
+		changes.Add(path)
+		return nil
+	}
+
+	scan, err := s.backups().Scan()
+	if err != nil {
+		return GCResult{}, err
+	}
+	dirs, broken := scan.Dirs, scan.Broken
+	for _, cid := range broken {
+		for _, dir := range dirs[cid] {
+			if err := remove(dir); err != nil {
+				return GCResult{}, err
+			}
+		}
+		result.BrokenBackupCount++
+	}
+
+	// pruneBackups only asks match about unreferenced backups, so a dry run
+	// can list them there and decline every removal.
+	gone := slices.Clone(broken)
+	match := func(cid string) (bool, error) {
+		if slices.Contains(broken, cid) {
+			return false, nil
+		}
+		if opts.DryRun {
+			result.RemovedPaths = append(result.RemovedPaths, dirs[cid]...)
+			result.UnreferencedBackupCount++
+			gone = append(gone, cid)
+			return false, nil
+		}
+		return true, nil
+	}
+	removed, err := pruneBackupsFunc(s, lck, match, func(path string) {
+		result.RemovedPaths = append(result.RemovedPaths, path)
+		changes.Add(path)
+	})
+	if err != nil {
+		return GCResult{}, err
+	}
+	if !opts.DryRun {
+		result.UnreferencedBackupCount = removed
+	}
+
+	temps, err := s.leftoverTemps(pending, gone)
+	if err != nil {
+		return GCResult{}, err
+	}
+	for _, path := range temps {
+		if err := remove(path); err != nil {
+			return GCResult{}, err
+		}
+		result.TempCount++
+	}
+
+	extracted, err := s.staleExtractions(lck)
+	if err != nil {
+		return GCResult{}, err
+	}
+	downloads, err := s.staleDownloads(loadedArchives(lck))
+	if err != nil {
+		return GCResult{}, err
+	}
+	for _, path := range append(extracted, downloads...) {
+		if err := remove(path); err != nil {
+			return GCResult{}, err
+		}
+		result.ExtractedCount++
+	}
+
+	cache := s.loadSourceCache()
+	for dir := range cache {
+		if _, _, err := manifest.Locate(dir); err == nil {
+			continue
+		}
+		delete(cache, dir)
+		result.SourceCacheCount++
+	}
+	if result.SourceCacheCount > 0 && !opts.DryRun {
+		if err := encodeJSON(s.SourceCachePath(), cache); err != nil {
+			return GCResult{}, err
+		}
+		changes.Add(s.SourceCachePath())
+	}
+
+	result.ChangedPaths = changes.Paths()
+	return result, nil
+}
+
+// staleExtractions lists extracted archives no loaded profile lives in,
+// including partial extractions.
+func (s Store) staleExtractions(lck state.State) ([]string, error) {
+	entries, err := os.ReadDir(s.ExtractedPath())
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read extracted archives %s: %w", s.ExtractedPath(), err)
+	}
+
+	loaded := []state.Profile{lck.Profile}
+	for _, layer := range lck.Added {
+		loaded = append(loaded, layer.Profile)
+	}
+
+	var stale []string
+	for _, entry := range entries {
+		path := filepath.Join(s.ExtractedPath(), entry.Name())
+		inUse := slices.ContainsFunc(loaded, func(p state.Profile) bool {
+			if p.Kind != archiveKind && p.Kind != remoteKind {
+				return false
+			}
+			rel, err := filepath.Rel(path, p.Path)
+			return err == nil && !fileutils.Escapes(rel)
+		})
+		if !inUse {
+			stale = append(stale, path)
+		}
+	}
+	return stale, nil
+}
+
+// leftoverTemps lists temporary files left by interrupted atomic writes in
+// the store root and backup directories, and rollback snapshots and journal
+// logs no journal refers to. Those are skipped while a transaction is pending,
+// as are the backups in skip, which are removed whole.
+func (s Store) leftoverTemps(pending bool, skip []string) ([]string, error) {
+	var temps []string
+
+	entries, err := os.ReadDir(s.Root)
+	if err != nil {
+		return nil, fmt.Errorf("read store directory %s: %w", s.Root, err)
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		orphaned := strings.HasPrefix(name, rollbackDirPrefix) || name == journalLogFile
+		if strings.Contains(name, tempMarker) || (!pending && orphaned) {
+			temps = append(temps, filepath.Join(s.Root, name))
+		}
+	}
+
+	dirs, err := s.backups().Dirs()
+	if err != nil {
+		return nil, err
+	}
+	for _, cid := range slices.Sorted(maps.Keys(dirs)) {
+		if slices.Contains(skip, cid) {
+			continue
+		}
+		for _, dir := range dirs[cid] {
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				return nil, fmt.Errorf("read backup directory %s: %w", dir, err)
+			}
+			for _, entry := range entries {
+				if strings.Contains(entry.Name(), tempMarker) {
+					temps = append(temps, filepath.Join(dir, entry.Name()))
+				}
+			}
+		}
+	}
+
+	return temps, nil
+}
@@ -0,0 +1,70 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestGCRemovesLeftovers(t *testing.T) {
+	s, _ := newTestStore(t)
+	if _, err := s.Install(); err != nil {
+		t.Fatalf("Install() error = %v", err)
+	}
+
+	broken := filepath.Join(s.BackupsPath(), "file:sha256:broken")
+	if err := os.MkdirAll(broken, 0o755); err != nil {
+		t.Fatalf("MkdirAll() error = %v", err)
+	}
+	unreferenced := filepath.Join(s.BackupsPath(), "file:sha256:unreferenced")
+	writeTestFile(t, filepath.Join(unreferenced, "object"), "old\n")
+	writeTestFile(t, filepath.Join(unreferenced, "object.tmp-123"), "partial\n")
+	tempState := filepath.Join(s.Root, "state.json.tmp-456")
+	writeTestFile(t, tempState, "{")
+	rollbackDir := filepath.Join(s.Root, rollbackDirPrefix+"789")
+	if err := os.MkdirAll(rollbackDir, 0o755); err != nil {
+		t.Fatalf("MkdirAll() error = %v", err)
+	}
+	gone := filepath.Join(t.TempDir(), "gone")
+	if err := encodeJSON(s.SourceCachePath(), sourceCache{gone: {Digest: "dir:sha256:x"}}); err != nil {
+		t.Fatalf("encodeJSON() error = %v", err)
+	}
+
+	want := []string{broken, unreferenced, tempState, rollbackDir}
+
+	res, err := s.GC(GCOptions{DryRun: true})
+	if err != nil {
+		t.Fatalf("GC(dry run) error = %v", err)
+	}
+	if res.BrokenBackupCount != 1 || res.UnreferencedBackupCount != 1 || res.TempCount != 2 || res.SourceCacheCount != 1 {
+		t.Fatalf("GC(dry run) counts = %+v, want 1/1/2/1", res)
+	}
+	if !slices.Equal(slices.Sorted(slices.Values(res.RemovedPaths)), slices.Sorted(slices.Values(want))) {
+		t.Fatalf("GC(dry run) RemovedPaths = %v, want %v", res.RemovedPaths, want)
+	}
+	for _, path := range want {
+		if _, err := os.Lstat(path); err != nil {
+			t.Fatalf("dry run removed %s: %v", path, err)
+		}
+	}
+
+	res, err = s.GC(GCOptions{})
+	if err != nil {
+		t.Fatalf("GC() error = %v", err)
+	}
+	if res.BrokenBackupCount != 1 || res.UnreferencedBackupCount != 1 || res.TempCount != 2 || res.SourceCacheCount != 1 {
+		t.Fatalf("GC() counts = %+v, want 1/1/2/1", res)
+	}
+	for _, path := range want {
+		if _, err := os.Lstat(path); !os.IsNotExist(err) {
+			t.Fatalf("%s still exists after GC (err = %v)", path, err)
+		}
+	}
+	if cache := s.loadSourceCache(); len(cache) != 0 {
+		t.Fatalf("source cache = %v, want empty", cache)
+	}
+	if _, err := os.Stat(s.StatePath()); err != nil {
+		t.Fatalf("GC() removed state: %v", err)
+	}
+}
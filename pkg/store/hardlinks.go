@@ -0,0 +1,50 @@
+package store
+
+import (
+	"os"
+	"slices"
+	"strings"
+	"syscall"
+)
+
+// fileID identifies the inode behind a path.
+type fileID struct {
+	dev, ino uint64
+}
+
+// hardlinkID returns the inode of a regular file that has more than one link.
+// ok is false for anything else, and on platforms that report no inodes.
+func hardlinkID(info os.FileInfo) (fileID, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || !info.Mode().IsRegular() || st.Nlink < 2 {
+		return fileID{}, false
+	}
+	return fileID{dev: uint64(st.Dev), ino: uint64(st.Ino)}, true
+}
+
+// hardlinkGroups groups the paths that are hard links of the same file. Paths
+// that are missing or not linked to any of the others are left out.
+func hardlinkGroups(paths []string) [][]string {
+	byID := make(map[fileID][]string)
+	for _, path := range paths {
+		info, err := os.Lstat(path)
+		if err != nil {
+			continue
+		}
+		if id, ok := hardlinkID(info); ok {
+			byID[id] = append(byID[id], path)
+		}
+	}
+
+	var groups [][]string
+	for _, group := range byID {
+		if len(group) > 1 {
+			slices.Sort(group)
+			groups = append(groups, group)
+		}
+	}
+	slices.SortFunc(groups, func(a, b []string) int {
+		return strings.Compare(a[0], b[0])
+	})
+	return groups
+}
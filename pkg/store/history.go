@@ -0,0 +1,88 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// historyLimit caps history.jsonl; appending drops the oldest entries past it.
+const historyLimit = 1000
+
+// HistoryEntry is one line of the activity log tohru keeps of the operations
+// that changed the store or the filesystem.
+type HistoryEntry struct {
+	Time    time.Time `json:"time"`
+	Command string    `json:"command"`
+	Profile string    `json:"profile,omitempty"`
+	Tracked int       `json:"tracked"` // objects managed once the operation finished, or that unload released
+	Changed int       `json:"changed"` // filesystem paths the operation changed
+}
+
+// History returns the logged operations, oldest first. Lines that can't be
+// decoded are skipped rather than failing the whole log.
+func (s Store) History() ([]HistoryEntry, error) {
+	f, err := os.Open(s.HistoryPath())
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("open history %s: %w", s.HistoryPath(), err)
+	}
+	defer f.Close()
+
+	var entries []HistoryEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry HistoryEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read history %s: %w", s.HistoryPath(), err)
+	}
+	return entries, nil
+}
+
+// logHistory appends an entry to the activity log, keeping the newest
+// historyLimit entries. Callers treat failures as warnings: the log is a
+// convenience and must never fail the operation it records.
+func (s Store) logHistory(command, profile string, tracked int, changed []string) error {
+	entries, err := s.History()
+	if err != nil {
+		return err
+	}
+	entries = append(entries, HistoryEntry{
+		Time:    time.Now().UTC().Truncate(time.Second),
+		Command: command,
+		Profile: profile,
+		Tracked: tracked,
+		Changed: len(changed),
+	})
+	if len(entries) > historyLimit {
+		entries = entries[len(entries)-historyLimit:]
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, entry := range entries {
+		if err := enc.Encode(entry); err != nil {
+			return fmt.Errorf("encode history entry: %w", err)
+		}
+	}
+	return writeAtomic(s.HistoryPath(), buf.Bytes())
+}
+
+// historyWarning turns a logHistory failure into a result warning.
+func historyWarning(warnings []string, err error) []string {
+	if err == nil {
+		return warnings
+	}
+	return append(warnings, fmt.Sprintf("history update failed: %v", err))
+}
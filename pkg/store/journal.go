@@ -0,0 +1,205 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/olimci/tohru/pkg/store/state"
+	"github.com/olimci/tohru/pkg/utils/fileutils"
+)
+
+const (
+	phasePrepared  = "prepared"
+	phaseCommitted = "committed"
+)
+
+// transactionHook is called at each phase boundary of a transaction. Tests
+// use it to simulate a crash between phases.
+var transactionHook = func(phase string) {}
+
+// transaction journals a switch or unload so an interrupted run can be
+// recovered. While prepared, recovery rolls back to Previous using the
+// rollback snapshot and the paths journaled so far. Once committed, Next is
+// authoritative and recovery only finishes saving it.
+//
+// A path is journaled before the change that creates or replaces it, so a
+// run interrupted between the two leaves nothing recovery doesn't know
+// about; rolling back a path that was never changed only removes what isn't
+// there. Paths are appended to a log next to the journal, and each append is
+// synced before the change goes ahead. The journal itself is written
+// atomically and synced, so the commit point is the rename that flips Phase
+// to committed: either the old or the new state is always recoverable.
+type transaction struct {
+	store Store
+	log   *os.File
+
+	Phase    string          `json:"phase"`
+	Previous state.State     `json:"prev"`
+	Next     *state.State    `json:"next,omitempty"`
+	Snapshot string          `json:"snapshot"`
+	Entries  []snapshotEntry `json:"entries"`
+
+	// Paths are the paths journaled in the log, see readJournalLog.
+	Paths []string `json:"-"`
+}
+
+func beginTransaction(store Store, previous state.State, snapshot rollbackSnapshot) (*transaction, error) {
+	txn := &transaction{
+		store:    store,
+		Phase:    phasePrepared,
+		Previous: previous,
+		Snapshot: snapshot.root,
+		Entries:  snapshot.entries,
+	}
+	// The log goes first, so a journal never refers to the paths of an
+	// earlier one left behind.
+	log, err := os.OpenFile(store.JournalLogPath(), os.O_WRONLY|os.O_CREATE|os.O_TRUNC|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("create %s: %w", store.JournalLogPath(), err)
+	}
+	txn.log = log
+	if err := txn.write(); err != nil {
+		_ = log.Close()
+		return nil, err
+	}
+	transactionHook(phasePrepared)
+	return txn, nil
+}
+
+// record journals a path about to be changed so recovery can undo it.
+func (t *transaction) record(path string) error {
+	line, err := json.Marshal(path)
+	if err != nil {
+		return err
+	}
+	if _, err := t.log.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("append to %s: %w", t.log.Name(), err)
+	}
+	if err := t.log.Sync(); err != nil {
+		return fmt.Errorf("sync %s: %w", t.log.Name(), err)
+	}
+	t.Paths = append(t.Paths, path)
+	return nil
+}
+
+// commit makes next the authoritative state. From here on the caller must not
+// roll back: recovery saves next instead.
+func (t *transaction) commit(next state.State) error {
+	transactionHook("applied")
+	t.Phase = phaseCommitted
+	t.Next = &next
+	if err := t.write(); err != nil {
+		return err
+	}
+	transactionHook(phaseCommitted)
+	return nil
+}
+
+// finish removes the journal, its log and the rollback snapshot it owns.
+func (t *transaction) finish() error {
+	if t.log != nil {
+		_ = t.log.Close()
+		t.log = nil
+	}
+	if err := fileutils.RemovePath(t.Snapshot); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("remove rollback snapshot %s: %w", t.Snapshot, err)
+	}
+	for _, path := range []string{t.store.JournalPath(), t.store.JournalLogPath()} {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("remove %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+func (t *transaction) snapshot() rollbackSnapshot {
+	return rollbackSnapshot{root: t.Snapshot, entries: t.Entries}
+}
+
+func (t *transaction) write() error {
+	return t.store.retry.Do(func() error {
+		return encodeJSON(t.store.JournalPath(), t)
+	})
+}
+
+// readJournalLog returns the paths journaled in the log at path. A line cut
+// short by an interruption ends the log: the change it was journaling never
+// went ahead.
+func readJournalLog(path string) ([]string, error) {
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("read %s: %w", path, err)
+	}
+	var paths []string
+	for line := range bytes.Lines(raw) {
+		var path string
+		if !bytes.HasSuffix(line, []byte("\n")) || json.Unmarshal(line, &path) != nil {
+			break
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+// checkNoTransaction fails while a journal is left behind by an interrupted
+// load, reload or unload. Removing backups must wait until it is recovered:
+// recovering can need backups state.json doesn't refer to, such as those of
+// a committed state that wasn't saved yet.
+func (s Store) checkNoTransaction() error {
+	if _, err := os.Stat(s.JournalPath()); err == nil {
+		return fmt.Errorf("an interrupted transaction is pending, run `tohru gc` to recover it first")
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("stat %s: %w", s.JournalPath(), err)
+	}
+	return nil
+}
+
+// Recover completes or rolls back a transaction left behind by an interrupted
+// load, reload or unload. It reports whether there was anything to recover.
+func (s Store) Recover() (bool, error) {
+	guard, err := s.Lock()
+	if err != nil {
+		return false, err
+	}
+	defer guard.Unlock()
+
+	return s.recoverUnlocked()
+}
+
+func (s Store) recoverUnlocked() (bool, error) {
+	txn := &transaction{store: s}
+	if err := decodeJSON(s.JournalPath(), txn); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+		return false, fmt.Errorf("decode %s: %w", s.JournalPath(), err)
+	}
+	paths, err := readJournalLog(s.JournalLogPath())
+	if err != nil {
+		return false, err
+	}
+	txn.Paths = paths
+
+	switch txn.Phase {
+	case phasePrepared:
+		if _, err := rollback(s, txn.Previous, txn.snapshot(), txn.Paths, false); err != nil {
+			return false, fmt.Errorf("recover interrupted transaction: %w", err)
+		}
+	case phaseCommitted:
+		if txn.Next == nil {
+			return false, fmt.Errorf("recover interrupted transaction: committed journal has no state")
+		}
+		if err := s.SaveState(*txn.Next); err != nil {
+			return false, fmt.Errorf("recover interrupted transaction: %w", err)
+		}
+	default:
+		return false, fmt.Errorf("recover interrupted transaction: unknown phase %q", txn.Phase)
+	}
+
+	return true, txn.finish()
+}
@@ -0,0 +1,389 @@
+package store
+
+import (
+	"cmp"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/olimci/tohru/pkg/manifest"
+	"github.com/olimci/tohru/pkg/utils/fileutils"
+)
+
+var errSimulatedCrash = errors.New("simulated crash")
+
+// crashAt makes the next transaction abort abruptly at phase, leaving its
+// journal and rollback snapshot behind as a killed process would.
+func crashAt(t *testing.T, phase string) {
+	t.Helper()
+	transactionHook = func(p string) {
+		if p == phase {
+			panic(errSimulatedCrash)
+		}
+	}
+	t.Cleanup(func() { transactionHook = func(string) {} })
+}
+
+func loadCrashing(t *testing.T, s Store, profile string) {
+	t.Helper()
+	defer func() {
+		if r := recover(); r != errSimulatedCrash {
+			t.Fatalf("Load() recovered %v, want simulated crash", r)
+		}
+		transactionHook = func(string) {}
+	}()
+	_, _ = s.Load(profile, Options{})
+}
+
+func TestRecoverInterruptedSwitch(t *testing.T) {
+	tests := []struct {
+		phase    string
+		wantNext bool
+	}{
+		{phase: phasePrepared, wantNext: false},
+		{phase: "applied", wantNext: false},
+		{phase: phaseCommitted, wantNext: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.phase, func(t *testing.T) {
+			s, home := newTestStore(t)
+			root := manifest.Root{
+				Source:   "home",
+				Dest:     home,
+				Defaults: &manifest.Defaults{Type: "copy"},
+				Tree: manifest.Tree{
+					".zshrc": manifest.FileNode(),
+				},
+			}
+			first := writeProfile(t, root)
+			writeTestFile(t, filepath.Join(first, "home", "dot_zshrc"), "first\n")
+			root.Tree = manifest.Tree{
+				".zshrc":     manifest.FileNode(),
+				".gitconfig": manifest.FileNode(),
+			}
+			second := writeProfile(t, root)
+			writeTestFile(t, filepath.Join(second, "home", "dot_zshrc"), "second\n")
+			writeTestFile(t, filepath.Join(second, "home", "dot_gitconfig"), "git\n")
+
+			if _, err := s.Load(first, Options{}); err != nil {
+				t.Fatalf("Load(first) error = %v", err)
+			}
+
+			crashAt(t, tt.phase)
+			loadCrashing(t, s, second)
+			if _, err := os.Stat(s.JournalPath()); err != nil {
+				t.Fatalf("journal missing after crash: %v", err)
+			}
+
+			recovered, err := s.Recover()
+			if err != nil {
+				t.Fatalf("Recover() error = %v", err)
+			}
+			if !recovered {
+				t.Fatalf("Recover() = false, want true")
+			}
+			if _, err := os.Stat(s.JournalPath()); !os.IsNotExist(err) {
+				t.Fatalf("journal still present after recovery: %v", err)
+			}
+
+			lck, err := s.LoadState()
+			if err != nil {
+				t.Fatalf("LoadState() error = %v", err)
+			}
+			wantProfile, wantZshrc := first, "first\n"
+			if tt.wantNext {
+				wantProfile, wantZshrc = second, "second\n"
+			}
+			if lck.Profile.Path != wantProfile {
+				t.Fatalf("state profile = %s, want %s", lck.Profile.Path, wantProfile)
+			}
+			raw, err := os.ReadFile(filepath.Join(home, ".zshrc"))
+			if err != nil || string(raw) != wantZshrc {
+				t.Fatalf(".zshrc = %q, %v, want %q", raw, err, wantZshrc)
+			}
+			_, err = os.Lstat(filepath.Join(home, ".gitconfig"))
+			if tt.wantNext != (err == nil) {
+				t.Fatalf(".gitconfig exists = %v, want %v", err == nil, tt.wantNext)
+			}
+
+			if recovered, err := s.Recover(); err != nil || recovered {
+				t.Fatalf("second Recover() = %v, %v, want false, nil", recovered, err)
+			}
+		})
+	}
+}
+
+// TestRecoverPathCreatedBeforeCrash crashes right after a path is created,
+// before the switch goes any further. The path was journaled before it was
+// created, so recovery still removes it.
+func TestRecoverPathCreatedBeforeCrash(t *testing.T) {
+	s, home := newTestStore(t)
+	root := manifest.Root{
+		Source:   "home",
+		Dest:     home,
+		Defaults: &manifest.Defaults{Type: "copy"},
+		Tree:     manifest.Tree{".zshrc": manifest.FileNode()},
+	}
+	first := writeProfile(t, root)
+	writeTestFile(t, filepath.Join(first, "home", "dot_zshrc"), "first\n")
+	root.Tree = manifest.Tree{".gitconfig": manifest.FileNode()}
+	second := writeProfile(t, root)
+	writeTestFile(t, filepath.Join(second, "home", "dot_gitconfig"), "git\n")
+	if _, err := s.Load(first, Options{}); err != nil {
+		t.Fatalf("Load(first) error = %v", err)
+	}
+
+	gitconfig := filepath.Join(home, ".gitconfig")
+	copyPathFunc = func(src, dest string, opts fileutils.CopyOptions) error {
+		if err := fileutils.CopyPathWith(src, dest, opts); err != nil {
+			return err
+		}
+		if dest == gitconfig {
+			panic(errSimulatedCrash)
+		}
+		return nil
+	}
+	t.Cleanup(func() { copyPathFunc = fileutils.CopyPathWith })
+	loadCrashing(t, s, second)
+	copyPathFunc = fileutils.CopyPathWith
+
+	paths, err := readJournalLog(s.JournalLogPath())
+	if err != nil || !slices.Contains(paths, gitconfig) {
+		t.Fatalf("journaled paths = %v, %v, want %s", paths, err, gitconfig)
+	}
+	if recovered, err := s.Recover(); err != nil || !recovered {
+		t.Fatalf("Recover() = %v, %v, want true, nil", recovered, err)
+	}
+	if _, err := os.Lstat(gitconfig); !os.IsNotExist(err) {
+		t.Fatalf(".gitconfig after recovery: %v, want it removed", err)
+	}
+	raw, err := os.ReadFile(filepath.Join(home, ".zshrc"))
+	if err != nil || string(raw) != "first\n" {
+		t.Fatalf(".zshrc after recovery = %q, %v, want %q", raw, err, "first\n")
+	}
+	if _, err := os.Stat(s.JournalLogPath()); !os.IsNotExist(err) {
+		t.Fatalf("journal log still present after recovery: %v", err)
+	}
+}
+
+func TestReadJournalLogStopsAtTornLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), journalLogFile)
+	writeTestFile(t, path, "\"/home/me/.zshrc\"\n\"/home/me/.git")
+	paths, err := readJournalLog(path)
+	if err != nil || !slices.Equal(paths, []string{"/home/me/.zshrc"}) {
+		t.Fatalf("readJournalLog() = %v, %v, want [/home/me/.zshrc]", paths, err)
+	}
+}
+
+func TestLoadRollbackPolicy(t *testing.T) {
+	tests := []struct {
+		policy         RollbackPolicy
+		dropSnapshot   bool
+		wantRolledBack bool
+		wantUnrestored int
+		wantJournal    bool
+	}{
+		{policy: "", wantRolledBack: true},
+		{policy: RollbackStrict, dropSnapshot: true, wantUnrestored: 1, wantJournal: true},
+		{policy: RollbackBestEffort, dropSnapshot: true, wantUnrestored: 2, wantJournal: true},
+		{policy: RollbackLeave, wantJournal: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(cmp.Or(string(tt.policy), "default"), func(t *testing.T) {
+			s, home := newTestStore(t)
+			first := writeProfile(t, manifest.Root{
+				Source:   "home",
+				Dest:     home,
+				Defaults: &manifest.Defaults{Type: "copy"},
+				Tree: manifest.Tree{
+					".zshrc":     manifest.FileNode(),
+					".gitconfig": manifest.FileNode(),
+				},
+			})
+			writeTestFile(t, filepath.Join(first, "home", "dot_zshrc"), "first\n")
+			writeTestFile(t, filepath.Join(first, "home", "dot_gitconfig"), "git\n")
+			second := writeProfile(t, manifest.Root{
+				Source:   "home",
+				Dest:     home,
+				Defaults: &manifest.Defaults{Type: "copy"},
+				Tree:     manifest.Tree{".vimrc": manifest.FileNode()},
+			})
+			writeTestFile(t, filepath.Join(second, "home", "dot_vimrc"), "vim\n")
+			if _, err := s.Load(first, Options{}); err != nil {
+				t.Fatalf("Load(first) error = %v", err)
+			}
+
+			errCopy := errors.New("copy failed")
+			copyPathFunc = func(src, dest string, _ fileutils.CopyOptions) error {
+				if tt.dropSnapshot {
+					snapshots, _ := filepath.Glob(filepath.Join(s.Root, rollbackDirPrefix+"*", "*"))
+					for _, path := range snapshots {
+						_ = os.RemoveAll(path)
+					}
+				}
+				return errCopy
+			}
+			t.Cleanup(func() { copyPathFunc = fileutils.CopyPathWith })
+
+			_, err := s.Load(second, Options{Rollback: tt.policy})
+			var rbErr *RollbackError
+			if !errors.As(err, &rbErr) || !errors.Is(err, errCopy) {
+				t.Fatalf("Load(second) error = %v, want a RollbackError wrapping the copy failure", err)
+			}
+			if rbErr.RolledBack != tt.wantRolledBack || len(rbErr.Unrestored) != tt.wantUnrestored {
+				t.Fatalf("RolledBack = %v, Unrestored = %v, want %v and %d path(s)", rbErr.RolledBack, rbErr.Unrestored, tt.wantRolledBack, tt.wantUnrestored)
+			}
+			if tt.policy == RollbackLeave && len(rbErr.Changed) == 0 {
+				t.Fatalf("Changed is empty, want the paths unloaded before the failure")
+			}
+			if _, err := os.Stat(s.JournalPath()); (err == nil) != tt.wantJournal {
+				t.Fatalf("journal exists = %v, want %v", err == nil, tt.wantJournal)
+			}
+
+			if tt.wantRolledBack || tt.dropSnapshot {
+				return
+			}
+			copyPathFunc = fileutils.CopyPathWith
+			if recovered, err := s.Recover(); err != nil || !recovered {
+				t.Fatalf("Recover() = %v, %v, want true, nil", recovered, err)
+			}
+			raw, err := os.ReadFile(filepath.Join(home, ".zshrc"))
+			if err != nil || string(raw) != "first\n" {
+				t.Fatalf(".zshrc after recovery = %q, %v", raw, err)
+			}
+		})
+	}
+}
+
+// TestFailedLoadKeepsBackups injects a failure at each step of a switch that
+// would otherwise prune a backup, and checks the backup survives until the
+// switch has committed, saved its state and removed its journal.
+func TestFailedLoadKeepsBackups(t *testing.T) {
+	tests := []struct {
+		name   string
+		inject func(t *testing.T, s Store, home string)
+		crash  bool
+	}{
+		{name: "success"},
+		{name: "apply", inject: func(t *testing.T, _ Store, home string) {
+			gitconfig := filepath.Join(home, ".gitconfig")
+			copyPathFunc = func(src, dest string, opts fileutils.CopyOptions) error {
+				if dest == gitconfig {
+					return errors.New("injected copy failure")
+				}
+				return fileutils.CopyPathWith(src, dest, opts)
+			}
+			t.Cleanup(func() { copyPathFunc = fileutils.CopyPathWith })
+		}},
+		{name: phasePrepared, crash: true, inject: func(t *testing.T, _ Store, _ string) { crashAt(t, phasePrepared) }},
+		{name: "applied", crash: true, inject: func(t *testing.T, _ Store, _ string) { crashAt(t, "applied") }},
+		{name: phaseCommitted, crash: true, inject: func(t *testing.T, _ Store, _ string) { crashAt(t, phaseCommitted) }},
+		{name: "save state", inject: func(t *testing.T, s Store, _ string) {
+			onPhase(t, phaseCommitted, func() {
+				if err := os.Remove(s.StatePath()); err != nil {
+					t.Errorf("Remove() error = %v", err)
+				}
+				if err := os.Mkdir(s.StatePath(), 0o755); err != nil {
+					t.Errorf("Mkdir() error = %v", err)
+				}
+			})
+		}},
+		{name: "finish", inject: func(t *testing.T, s Store, _ string) {
+			onPhase(t, phaseCommitted, func() {
+				// A non-empty directory in place of the journal can't be
+				// removed by finish, though the state is saved.
+				if err := os.Remove(s.JournalPath()); err != nil {
+					t.Errorf("Remove() error = %v", err)
+				}
+				writeTestFile(t, filepath.Join(s.JournalPath(), "pinned"), "")
+			})
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, home := newTestStore(t)
+			writeTestFile(t, filepath.Join(home, ".zshrc"), "user\n")
+			root := manifest.Root{
+				Source:   "home",
+				Dest:     home,
+				Defaults: &manifest.Defaults{Type: "copy"},
+				Tree:     manifest.Tree{".zshrc": manifest.FileNode()},
+			}
+			first := writeProfile(t, root)
+			writeTestFile(t, filepath.Join(first, "home", "dot_zshrc"), "first\n")
+			root.Tree = manifest.Tree{".gitconfig": manifest.FileNode()}
+			second := writeProfile(t, root)
+			writeTestFile(t, filepath.Join(second, "home", "dot_gitconfig"), "git\n")
+
+			if _, err := s.Load(first, Options{}); err != nil {
+				t.Fatalf("Load(first) error = %v", err)
+			}
+			lck, err := s.LoadState()
+			if err != nil {
+				t.Fatalf("LoadState() error = %v", err)
+			}
+			backup := lck.Files[0].Previous.Digest
+			kept := func() bool {
+				t.Helper()
+				scan, err := s.backups().Scan()
+				if err != nil {
+					t.Fatalf("Scan() error = %v", err)
+				}
+				_, ok := scan.Available[backup]
+				return ok
+			}
+
+			// Switching releases the backup of .zshrc, so a switch that
+			// completes prunes it.
+			if tt.inject == nil {
+				res, err := s.Load(second, Options{})
+				if err != nil {
+					t.Fatalf("Load(second) error = %v", err)
+				}
+				if res.RemovedBackupCount != 1 || kept() {
+					t.Fatalf("RemovedBackupCount = %d, backup kept = %v, want it pruned", res.RemovedBackupCount, kept())
+				}
+				return
+			}
+
+			tt.inject(t, s, home)
+			if tt.crash {
+				loadCrashing(t, s, second)
+			} else {
+				res, err := s.Load(second, Options{})
+				if err == nil && res.RemovedBackupCount != 0 {
+					t.Fatalf("RemovedBackupCount = %d, want nothing pruned", res.RemovedBackupCount)
+				}
+			}
+			if !kept() {
+				t.Fatalf("backup %s removed by a load that failed at %s", backup, tt.name)
+			}
+
+			if _, err := os.Stat(s.JournalPath()); err == nil {
+				if _, err := s.Tidy(TidyOptions{}); err == nil || !strings.Contains(err.Error(), "interrupted transaction") {
+					t.Fatalf("Tidy() error = %v, want it refused while a journal is pending", err)
+				}
+				if !kept() {
+					t.Fatalf("backup %s removed by tidy while a journal is pending", backup)
+				}
+			}
+		})
+	}
+}
+
+// onPhase runs fn when the next transaction reaches phase.
+func onPhase(t *testing.T, phase string, fn func()) {
+	t.Helper()
+	transactionHook = func(p string) {
+		if p == phase {
+			fn()
+		}
+	}
+	t.Cleanup(func() { transactionHook = func(string) {} })
+}
@@ -1,6 +1,7 @@
 package store
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -12,28 +13,45 @@ const lockPath = ".lock"
 
 var processLock sync.Mutex
 
+// ErrLocked is returned by TryLock while another operation holds the store.
+var ErrLocked = errors.New("another tohru operation is in progress")
+
 type Lock struct {
 	file *os.File
 }
 
 // Lock serializes store mutations across goroutines and processes.
 func (s Store) Lock() (*Lock, error) {
-	lock, err := acquireLock(s.Root)
+	lock, err := acquireLock(s.Root, true)
 	if err != nil {
 		return nil, err
 	}
 	return lock, nil
 }
 
-func acquireLock(root string) (*Lock, error) {
+// TryLock is Lock, but fails with ErrLocked instead of waiting when another
+// goroutine or process holds the store.
+func (s Store) TryLock() (*Lock, error) {
+	return acquireLock(s.Root, false)
+}
+
+func acquireLock(root string, wait bool) (*Lock, error) {
 	cleanRoot := filepath.Clean(root)
 
-	processLock.Lock()
+	if wait {
+		processLock.Lock()
+	} else if !processLock.TryLock() {
+		return nil, ErrLocked
+	}
 
 	if err := os.MkdirAll(cleanRoot, 0o755); err != nil {
 		processLock.Unlock()
 		return nil, fmt.Errorf("create store root %s for lock: %w", cleanRoot, err)
 	}
+	if err := checkWritable(cleanRoot); err != nil {
+		processLock.Unlock()
+		return nil, err
+	}
 
 	file, err := os.OpenFile(filepath.Join(cleanRoot, lockPath), os.O_CREATE|os.O_RDWR, 0o644)
 	if err != nil {
@@ -41,9 +59,16 @@ func acquireLock(root string) (*Lock, error) {
 		return nil, fmt.Errorf("open lock in %s: %w", cleanRoot, err)
 	}
 
-	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX); err != nil {
+	how := syscall.LOCK_EX
+	if !wait {
+		how |= syscall.LOCK_NB
+	}
+	if err := syscall.Flock(int(file.Fd()), how); err != nil {
 		processLock.Unlock()
 		_ = file.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, ErrLocked
+		}
 		return nil, fmt.Errorf("lock %s: %w", cleanRoot, err)
 	}
 
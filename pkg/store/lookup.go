@@ -0,0 +1,78 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/olimci/tohru/pkg/manifest"
+	"github.com/olimci/tohru/pkg/store/state"
+	"github.com/olimci/tohru/pkg/utils/fileutils"
+)
+
+// ErrNotDeclared is returned by Lookup when the loaded manifest does not
+// declare the requested destination.
+var ErrNotDeclared = errors.New("path is not declared by the loaded profile")
+
+// Declared describes the manifest entry that produces a destination.
+type Declared struct {
+	Kind    string // link, file, dir or copy
+	Dest    string
+	Source  string // empty for dirs and inline files
+	Content string // literal content of inline files
+	Tracked bool
+}
+
+// Lookup resolves dest to the entry of the manifest of the loaded profile,
+// or of a profile loaded alongside it, that declares it.
+func (s Store) Lookup(dest string) (Declared, error) {
+	if !s.IsInstalled() {
+		return Declared{}, ErrNotInstalled
+	}
+
+	lck, err := s.LoadState()
+	if err != nil {
+		return Declared{}, err
+	}
+	var loaded []state.Profile
+	if strings.ToLower(lck.Profile.State) == "loaded" && strings.TrimSpace(lck.Profile.Path) != "" {
+		loaded = append(loaded, lck.Profile)
+	}
+	for _, layer := range lck.Added {
+		loaded = append(loaded, layer.Profile)
+	}
+	if len(loaded) == 0 {
+		return Declared{}, fmt.Errorf("no profile is loaded")
+	}
+
+	target, err := fileutils.AbsPath(dest)
+	if err != nil {
+		return Declared{}, err
+	}
+
+	for _, p := range loaded {
+		m, profileDir, err := manifest.Load(p.Path)
+		if err != nil {
+			return Declared{}, err
+		}
+		ops, err := plan(m, profileDir)
+		if err != nil {
+			return Declared{}, err
+		}
+
+		for _, op := range ops {
+			if op.Dest != target {
+				continue
+			}
+			return Declared{
+				Kind:    string(op.Kind),
+				Dest:    op.Dest,
+				Source:  op.Source,
+				Content: op.Content,
+				Tracked: op.Track,
+			}, nil
+		}
+	}
+
+	return Declared{}, fmt.Errorf("%s: %w", target, ErrNotDeclared)
+}
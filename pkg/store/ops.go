@@ -1,27 +1,134 @@
 package store
 
 import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"io/fs"
 	"maps"
 	"os"
 	"path/filepath"
 	"slices"
+	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/olimci/tohru/pkg/digest"
 	"github.com/olimci/tohru/pkg/manifest"
 	"github.com/olimci/tohru/pkg/store/config"
 	"github.com/olimci/tohru/pkg/store/state"
+	"github.com/olimci/tohru/pkg/utils/archiveutils"
 	"github.com/olimci/tohru/pkg/utils/fileutils"
 	"github.com/olimci/tohru/pkg/utils/profileutils"
 	"github.com/olimci/tohru/pkg/version"
 )
 
 type Options struct {
-	Force          bool
-	DiscardChanges bool
+	Force          bool   // clobber unmanaged paths and replace or remove drifted managed ones
+	DiscardChanges bool   // replace or remove drifted managed paths, but not unmanaged ones
+	SortByDest     bool   // apply operations in destination order instead of manifest order
+	IgnoreVersion  bool   // downgrade minor/patch version requirements to warnings
+	Umask          string // octal mask applied to created files and dirs, e.g. "077"
+	KeepFiles      bool   // unload stops tracking managed paths but leaves them in place
+	BackupDrifted  bool   // back up drifted managed paths before overwriting or removing them
+	NoParents      bool   // fail instead of creating missing parents the manifest doesn't declare
+	Repair         bool   // reapply every operation even when the profile is unchanged, to fix modes and other metadata
+	Retries        int    // retry filesystem changes failing with transient errors (EAGAIN, ESTALE) this many times
+	ExpectName     string // refuse to load a profile whose slug or name doesn't match, ignoring case
+	Add            bool   // load alongside the loaded profiles instead of replacing the main one
+	AllowEmpty     bool   // load a profile that declares nothing, removing whatever was loaded
+	Insecure       bool   // allow loading archives from plain http URLs
+	NoBackup       bool   // don't back up existing destinations, unless a manifest entry asks to
+	ManifestDir    string // directory of the source holding the manifest, e.g. "tools/dotfiles"
+
+	// KeepStateBackup copies the state file to state.json.bak before each
+	// save that changes it, so RestoreStateBackup can put it back.
+	KeepStateBackup bool
+
+	// RenameConflicts moves existing destinations aside (ResolveRename)
+	// instead of backing them up, clobbering them or refusing the load. It
+	// applies wherever Resolve leaves the decision to the default rules.
+	RenameConflicts bool
+
+	// Rollback is how a load or unload that fails part-way is undone; empty
+	// means RollbackStrict.
+	Rollback RollbackPolicy
+
+	// Resolve is asked what to do with each destination that already exists
+	// and that the load would replace. Nil, or ResolveDefault from it, applies
+	// Force and the backup settings.
+	Resolve ConflictResolver
+}
+
+// Conflict describes an existing destination a load is about to replace.
+type Conflict struct {
+	Path     string
+	Kind     string // kind of operation: link, file, dir or copy
+	Existing string // kind of object at Path: link, file or dir
+	Tracked  bool   // the operation tracks Path, so its backup is restored on unload
+}
+
+// Resolution is what to do with a conflicting destination.
+type Resolution int
+
+const (
+	ResolveDefault   Resolution = iota // apply the non-interactive rules
+	ResolveOverwrite                   // remove the existing object without backing it up
+	ResolveBackup                      // back the existing object up, then remove it
+	ResolveSkip                        // leave the existing object in place and don't apply the operation
+	ResolveAbort                       // stop the load and roll back
+	ResolveRename                      // move the existing object to a .tohru-bak-<time> path beside it
+)
+
+// asideInfix separates a destination's path from the time it was moved aside
+// by ResolveRename.
+const asideInfix = ".tohru-bak-"
+
+// ConflictResolver decides what happens to a conflicting destination.
+type ConflictResolver func(Conflict) (Resolution, error)
+
+// ErrAborted is returned when a ConflictResolver aborts a load.
+var ErrAborted = errors.New("load aborted")
+
+// ErrNeedsForce matches the errors of loads, reloads and unloads that
+// refuse to replace or remove something unless Options.Force is set, or
+// Options.DiscardChanges for drifted managed paths.
+var ErrNeedsForce = errors.New("refused without --force")
+
+// needsForce is an error that matches ErrNeedsForce.
+type needsForce string
+
+func (e needsForce) Error() string { return string(e) }
+
+func (e needsForce) Is(target error) bool { return target == ErrNeedsForce }
+
+// errNeedsForce formats an error that matches ErrNeedsForce.
+func errNeedsForce(format string, args ...any) error {
+	return needsForce(fmt.Sprintf(format, args...))
+}
+
+// ErrEmptyProfile is returned when loading a profile that declares nothing,
+// which would unload everything, without Options.AllowEmpty.
+var ErrEmptyProfile = errors.New("profile declares nothing to load")
+
+// retryBackoff is the delay before the first retry of a transient filesystem
+// error, doubled for each retry after it.
+const retryBackoff = 50 * time.Millisecond
+
+// withRetries returns s with its filesystem changes retried n times.
+func (s Store) withRetries(n int) Store {
+	s.retry = fileutils.RetryPolicy{Retries: n, Backoff: retryBackoff}
+	return s
+}
+
+// withStateBackup returns s keeping a backup of the state file when keep is
+// set, see Options.KeepStateBackup.
+func (s Store) withStateBackup(keep bool) Store {
+	s.keepStateBackup = keep
+	return s
 }
 
 type opKind string
@@ -30,13 +137,22 @@ const (
 	opLink opKind = "link"
 	opFile opKind = "file"
 	opDir  opKind = "dir"
+	opCopy opKind = "copy"
 )
 
 type op struct {
-	Kind   opKind
-	Source string
-	Dest   string
-	Track  bool
+	Kind     opKind
+	Source   string
+	Content  string // literal content for opFile when Source is empty
+	Target   string // what an opLink points at: Source, or Source relative to Dest's directory
+	LinkDir  bool   // an opLink declared as linking a directory
+	Dest     string
+	Track    bool
+	Mode     os.FileMode // permissions of an opFile, or 0 to keep the source's
+	Backup   *bool       // the manifest entry's backup override, nil to follow the options
+	Exclude  []string    // paths under an opCopy's Source left out of the copy, see excludeStore
+	CopyRoot string      // destination of the per-file copy an opFile was expanded from
+	Root     int         // index of the manifest root that declared the operation
 }
 
 type rollbackSnapshot struct {
@@ -45,9 +161,9 @@ type rollbackSnapshot struct {
 }
 
 type snapshotEntry struct {
-	Path      string
-	Backup    string
-	HadObject bool
+	Path      string `json:"path"`
+	Backup    string `json:"backup,omitempty"`
+	HadObject bool   `json:"had_object,omitempty"`
 }
 
 var (
@@ -55,6 +171,7 @@ var (
 		return s.SaveProfiles(profiles)
 	}
 	pruneBackupsFunc = pruneBackups
+	copyPathFunc     = fileutils.CopyPathWith
 )
 
 func (s Store) Load(profile string, opts Options) (LoadResult, error) {
@@ -66,6 +183,9 @@ func (s Store) Load(profile string, opts Options) (LoadResult, error) {
 	defer guard.Unlock()
 
 	result, err = s.loadUnlocked(profile, opts)
+	if err == nil && !result.Skipped {
+		result.Warnings = historyWarning(result.Warnings, s.logHistory("load", result.ProfileName, result.TrackedCount, result.ChangedPaths))
+	}
 	return result, err
 }
 
@@ -77,7 +197,28 @@ func (s Store) Reload(opts Options) (LoadResult, error) {
 	}
 	defer guard.Unlock()
 
-	result, err = s.reloadUnlocked(opts)
+	result, err = s.reloadUnlocked("", opts)
+	if err == nil && !result.Skipped {
+		result.Warnings = historyWarning(result.Warnings, s.logHistory("reload", result.ProfileName, result.TrackedCount, result.ChangedPaths))
+	}
+	return result, err
+}
+
+// ReloadFrom reloads the loaded profile from source, for when its directory
+// or archive has moved. Unless opts.Force is set, source must declare the
+// same profile as the one loaded.
+func (s Store) ReloadFrom(source string, opts Options) (LoadResult, error) {
+	var result LoadResult
+	guard, err := s.Lock()
+	if err != nil {
+		return result, err
+	}
+	defer guard.Unlock()
+
+	result, err = s.reloadUnlocked(source, opts)
+	if err == nil && !result.Skipped {
+		result.Warnings = historyWarning(result.Warnings, s.logHistory("reload", result.ProfileName, result.TrackedCount, result.ChangedPaths))
+	}
 	return result, err
 }
 
@@ -89,25 +230,87 @@ func (s Store) Unload(opts Options) (UnloadResult, error) {
 	}
 	defer guard.Unlock()
 
-	result, err = s.unloadUnlocked(opts)
+	result, err = s.unloadUnlocked("", opts)
+	if err == nil {
+		result.Warnings = historyWarning(result.Warnings, s.logHistory("unload", result.ProfileName, result.RemovedCount+result.UntrackedCount, result.ChangedPaths))
+	}
 	return result, err
 }
 
-func (s Store) Uninstall() error {
+// UnloadProfile unloads only the loaded profile whose slug or name is name,
+// leaving any others loaded. It is how a profile loaded with Options.Add is
+// unloaded on its own.
+func (s Store) UnloadProfile(name string, opts Options) (UnloadResult, error) {
+	var result UnloadResult
 	guard, err := s.Lock()
 	if err != nil {
-		return err
+		return result, err
+	}
+	defer guard.Unlock()
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return result, fmt.Errorf("profile name is empty")
+	}
+	result, err = s.unloadUnlocked(name, opts)
+	if err == nil {
+		result.Warnings = historyWarning(result.Warnings, s.logHistory("unload", result.ProfileName, result.RemovedCount+result.UntrackedCount, result.ChangedPaths))
+	}
+	return result, err
+}
+
+func (s Store) Uninstall() (UninstallResult, error) {
+	guard, err := s.Lock()
+	if err != nil {
+		return UninstallResult{}, err
 	}
 	defer guard.Unlock()
 
 	if !s.IsInstalled() {
-		return ErrNotInstalled
+		return UninstallResult{}, ErrNotInstalled
 	}
 
-	return fileutils.RemovePath(s.Root)
+	return s.removeRoot()
 }
 
-func (s Store) Tidy() (TidyResult, error) {
+// removeRoot removes the store root and reports what it held.
+func (s Store) removeRoot() (UninstallResult, error) {
+	var result UninstallResult
+	entries, err := os.ReadDir(s.Root)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return result, fmt.Errorf("read store %s: %w", s.Root, err)
+	}
+	for _, entry := range entries {
+		result.RemovedPaths = append(result.RemovedPaths, filepath.Join(s.Root, entry.Name()))
+	}
+	if err := fileutils.RemovePath(s.Root); err != nil {
+		return UninstallResult{}, err
+	}
+	result.RemovedPaths = append(result.RemovedPaths, s.Root)
+	return result, nil
+}
+
+// TidyOptions scopes tidy to backups whose CIDs match the given glob patterns.
+type TidyOptions struct {
+	// Include and Exclude are globs selecting backups by the path they were
+	// taken from; "~" expands as in tracked paths.
+	Include []string
+	Exclude []string
+	Stashed bool // also drop stashed backups of drifted content
+
+	// Orphans, Broken and Corrupted pick what tidy removes: backups nothing
+	// refers to, backup directories with no object in them, and backups whose
+	// object no longer matches its CID. With none set, tidy removes broken
+	// and unreferenced backups.
+	Orphans   bool
+	Broken    bool
+	Corrupted bool
+}
+
+// Tidy removes unreferenced and broken backups, or the categories opts
+// selects. A backup that can't be removed doesn't stop the rest being tried:
+// the result counts what was removed, and the error joins every failure.
+func (s Store) Tidy(opts TidyOptions) (TidyResult, error) {
 	var result TidyResult
 	guard, err := s.Lock()
 	if err != nil {
@@ -115,56 +318,66 @@ func (s Store) Tidy() (TidyResult, error) {
 	}
 	defer guard.Unlock()
 
-	result, err = s.tidyUnlocked()
+	result, err = s.tidyUnlocked(opts)
+	if len(result.ChangedPaths) > 0 {
+		// TidyResult has no warnings to report a failure in; the log is best-effort.
+		_ = s.logHistory("tidy", "", 0, result.ChangedPaths)
+	}
 	return result, err
 }
 
-func (s Store) InstallAndLoad(profile string, opts Options) (LoadResult, error) {
+// InstallAndLoad installs the store and, if profile is set, loads it.
+func (s Store) InstallAndLoad(profile string, opts Options) (InstallResult, LoadResult, error) {
 	var result LoadResult
 	guard, err := s.Lock()
 	if err != nil {
-		return result, err
+		return InstallResult{}, result, err
 	}
 	defer guard.Unlock()
 
 	if s.IsInstalled() {
-		return result, ErrAlreadyInstalled
+		return InstallResult{}, result, ErrAlreadyInstalled
 	}
 
-	if _, err := s.installMissing(); err != nil {
-		return result, err
+	installed, err := s.installMissing()
+	if err != nil {
+		return installed, result, err
 	}
 	if strings.TrimSpace(profile) == "" {
-		return result, nil
+		return installed, result, nil
 	}
 
 	cfg, err := s.LoadConfig()
 	if err != nil {
-		return result, err
+		return installed, result, err
 	}
 
 	result, err = s.switchProfile(cfg, profile, opts)
-	return result, err
+	if err == nil {
+		result.Warnings = historyWarning(result.Warnings, s.logHistory("install", result.ProfileName, result.TrackedCount, result.ChangedPaths))
+	}
+	return installed, result, err
 }
 
-func (s Store) UnloadAndUninstall(opts Options) (UnloadResult, error) {
+func (s Store) UnloadAndUninstall(opts Options) (UnloadResult, UninstallResult, error) {
 	var result UnloadResult
 	guard, err := s.Lock()
 	if err != nil {
-		return result, err
+		return result, UninstallResult{}, err
 	}
 	defer guard.Unlock()
 
-	result, err = s.unloadUnlocked(opts)
+	result, err = s.unloadUnlocked("", opts)
 	if err != nil {
-		return result, err
+		return result, UninstallResult{}, err
 	}
-	err = fileutils.RemovePath(s.Root)
-	return result, err
+	removed, err := s.removeRoot()
+	return result, removed, err
 }
 
 func (s Store) loadUnlocked(profile string, opts Options) (LoadResult, error) {
-	if _, err := s.installMissing(); err != nil {
+	installed, err := s.installMissing()
+	if err != nil {
 		return LoadResult{}, err
 	}
 
@@ -173,10 +386,14 @@ func (s Store) loadUnlocked(profile string, opts Options) (LoadResult, error) {
 		return LoadResult{}, err
 	}
 
-	return s.switchProfile(cfg, profile, opts)
+	result, err := s.switchProfile(cfg, profile, opts)
+	if err == nil {
+		result.ChangedPaths = append(installed.CreatedPaths, result.ChangedPaths...)
+	}
+	return result, err
 }
 
-func (s Store) reloadUnlocked(opts Options) (LoadResult, error) {
+func (s Store) reloadUnlocked(source string, opts Options) (LoadResult, error) {
 	if !s.IsInstalled() {
 		return LoadResult{}, ErrNotInstalled
 	}
@@ -192,104 +409,360 @@ func (s Store) reloadUnlocked(opts Options) (LoadResult, error) {
 	}
 
 	if strings.ToLower(lck.Profile.State) != "loaded" {
+		// With nothing loaded, reload loads the default source, if set.
+		def, err := defaultSource(cfg)
+		if err != nil {
+			return LoadResult{}, err
+		}
+		if source == "" && def != "" {
+			return s.switchProfile(cfg, def, opts)
+		}
 		return LoadResult{}, fmt.Errorf("no loaded profile to reload")
 	}
-	if lck.Profile.Kind != "local" {
+	location := lck.Profile.Path
+	switch lck.Profile.Kind {
+	case defaultKind:
+		location = subdirRoot(lck.Profile)
+	case archiveKind:
+		// re-extract so edits to the archive are picked up
+		location = lck.Profile.Archive
+	case remoteKind:
+		// download again, verifying the pin if the URL has one
+		location = lck.Profile.URL
+	default:
 		return LoadResult{}, fmt.Errorf("unsupported profile kind %q", lck.Profile.Kind)
 	}
-	if lck.Profile.Path == "" {
+	if source == "" && opts.ManifestDir == "" {
+		// load the same directory of the source again
+		opts.ManifestDir = lck.Profile.Subdir
+	}
+	if source != "" {
+		if !opts.Force {
+			if err := s.checkSameProfile(lck.Profile, source, opts); err != nil {
+				return LoadResult{}, err
+			}
+		}
+		location = source
+	}
+	if location == "" {
 		return LoadResult{}, fmt.Errorf("loaded profile location is empty")
 	}
 
-	return s.switchProfile(cfg, lck.Profile.Path, opts)
+	return s.switchProfile(cfg, location, opts)
 }
 
-func (s Store) unloadUnlocked(opts Options) (UnloadResult, error) {
+// unloadUnlocked unloads the profile whose slug or name is name, or every
+// loaded profile when name is empty.
+func (s Store) unloadUnlocked(name string, opts Options) (UnloadResult, error) {
 	if !s.IsInstalled() {
 		return UnloadResult{}, ErrNotInstalled
 	}
+	s = s.withRetries(opts.Retries).withStateBackup(opts.KeepStateBackup)
+	policy, err := parseRollbackPolicy(opts.Rollback)
+	if err != nil {
+		return UnloadResult{}, err
+	}
 
 	cfg, err := s.LoadConfig()
 	if err != nil {
 		return UnloadResult{}, err
 	}
+	s = s.withBackupLayout(cfg.Options.Backups.Layout)
+
+	recovered, err := s.recoverUnlocked()
+	if err != nil {
+		return UnloadResult{}, err
+	}
 
 	lck, err := s.LoadState()
 	if err != nil {
 		return UnloadResult{}, err
 	}
 
+	target, newLock, err := unloadTarget(lck, name)
+	if err != nil {
+		return UnloadResult{}, err
+	}
+	files, dirs, profileName := target.Files, target.Dirs, target.Name
+
 	changes := newPathRecorder()
-	snapshot, err := takeSnapshot(s, lck.Files)
+	snapshot, err := takeSnapshot(s, files)
+	if err != nil {
+		return UnloadResult{}, err
+	}
+	txn, err := beginTransaction(s, lck, snapshot)
 	if err != nil {
+		_ = snapshot.Cleanup()
 		return UnloadResult{}, err
 	}
-	defer snapshot.Cleanup()
+	changes.journal = txn
 
 	rollbackOnErr := func(err error) (UnloadResult, error) {
-		if rollbackErr := rollback(s, lck, snapshot, changes.Paths()); rollbackErr != nil {
-			return UnloadResult{}, fmt.Errorf("%w (rollback failed: %v)", err, rollbackErr)
-		}
-		return UnloadResult{}, fmt.Errorf("%w (rolled back to previous state)", err)
+		return UnloadResult{}, s.undo(txn, lck, snapshot, changes.Paths(), policy, err)
 	}
 
-	if len(lck.Files) > 0 {
-		if err := unloadTracked(s, lck.Files, nil, opts, changes.Add); err != nil {
+	var restored restoreStats
+	removed, untracked := len(files), 0
+	if opts.KeepFiles {
+		// Managed paths stay where they are, so neither they nor the parents
+		// created for them are removed, and backups are not restored over them.
+		removed, untracked = 0, len(files)
+	} else {
+		if len(files) > 0 {
+			restored, err = unloadTracked(s, files, nil, opts, changes.Add)
+			if err != nil {
+				return rollbackOnErr(err)
+			}
+		}
+		if err := pruneAutoDirs(dirs, changes.Add); err != nil {
 			return rollbackOnErr(err)
 		}
 	}
-	if err := pruneAutoDirs(lck.Dirs, changes.Add); err != nil {
+
+	newLock.Stashed = append(slices.Clone(lck.Stashed), restored.Stashed...)
+	if err := changes.Err(); err != nil {
+		return rollbackOnErr(err)
+	}
+	if err := txn.commit(newLock); err != nil {
 		return rollbackOnErr(err)
 	}
-
-	newLock := DefaultState()
+	changes.journal = nil
 	if err := s.SaveState(newLock); err != nil {
-		return rollbackOnErr(err)
+		return UnloadResult{}, fmt.Errorf("%w (state will be recovered on the next run)", err)
 	}
 	changes.Add(s.StatePath())
 
 	removedBackups := 0
-	warnings := make([]string, 0, 1)
+	warnings := make([]string, 0, 3+len(restored.Unverified))
+	if recovered {
+		warnings = append(warnings, "recovered from an interrupted transaction")
+	}
+	if w := newerWriterWarning(lck); w != "" {
+		warnings = append(warnings, w)
+	}
+	finishErr := txn.finish()
+	if finishErr != nil {
+		warnings = append(warnings, fmt.Sprintf("transaction cleanup failed: %v", finishErr))
+	}
+	for _, path := range restored.Unverified {
+		warnings = append(warnings, fmt.Sprintf("restored %s could not be verified against its backup digest", path))
+	}
 
 	if cfg.Options.Backups.Prune == config.PruneAuto {
-		removedBackups, err = pruneBackupsFunc(s, newLock.Files, changes.Add)
-		if err != nil {
-			warnings = append(warnings, fmt.Sprintf("backup cleanup failed: %v", err))
-		}
+		removedBackups, warnings = pruneCommitted(s, newLock, finishErr, changes.Add, warnings)
 	}
 
 	return UnloadResult{
-		ProfileName:        profileutils.DisplayName(lck.Profile.Slug, lck.Profile.Name, lck.Profile.Path),
-		RemovedCount:       len(lck.Files),
+		ProfileName:        profileName,
+		RemovedCount:       removed,
+		UntrackedCount:     untracked,
+		RestoredCount:      restored.Verified,
 		RemovedBackupCount: removedBackups,
+		StashedPaths:       stashedPaths(restored.Stashed),
+		Operations:         restored.Ops,
 		ChangedPaths:       changes.Paths(),
 		Warnings:           warnings,
 	}, nil
 }
 
-func (s Store) tidyUnlocked() (TidyResult, error) {
+func (s Store) tidyUnlocked(opts TidyOptions) (TidyResult, error) {
 	if !s.IsInstalled() {
 		return TidyResult{}, ErrNotInstalled
 	}
+	// Until a pending journal is recovered, state.json may not be the state
+	// that ends up authoritative, so what it refers to can't tell which
+	// backups are still needed.
+	if err := s.checkNoTransaction(); err != nil {
+		return TidyResult{}, err
+	}
 
 	lck, err := s.LoadState()
 	if err != nil {
 		return TidyResult{}, err
 	}
 
-	changes := newPathRecorder()
-	removed, err := pruneBackupsFunc(s, lck.Files, changes.Add)
+	backups := s.backups()
+	scan, err := backups.Scan()
 	if err != nil {
 		return TidyResult{}, err
 	}
 
-	return TidyResult{
-		RemovedCount: removed,
-		ChangedPaths: changes.Paths(),
-	}, nil
+	// Filters match the paths backups were taken from, worked out before
+	// stashes are dropped so a stash still counts as one.
+	selected := func(cid string) (bool, error) { return true, nil }
+	if len(opts.Include) > 0 || len(opts.Exclude) > 0 {
+		include, err := absPatterns(opts.Include)
+		if err != nil {
+			return TidyResult{}, err
+		}
+		exclude, err := absPatterns(opts.Exclude)
+		if err != nil {
+			return TidyResult{}, err
+		}
+		sources, err := backupSources(scan.Dirs, lck)
+		if err != nil {
+			return TidyResult{}, err
+		}
+		selected = func(cid string) (bool, error) {
+			return matchBackupSources(include, exclude, sources[cid])
+		}
+	}
+
+	changes := newPathRecorder()
+	if opts.Stashed && len(lck.Stashed) > 0 {
+		lck.Stashed = nil
+		if err := s.SaveState(lck); err != nil {
+			return TidyResult{}, err
+		}
+		changes.Add(s.StatePath())
+	}
+
+	everything := !opts.Orphans && !opts.Broken && !opts.Corrupted
+
+	available, broken := scan.Available, scan.Broken
+	var result TidyResult
+	var errs []error
+
+	// Broken and corrupted backups go whether or not state still refers to
+	// them; there is nothing left in them that could be restored.
+	if everything || opts.Broken {
+		for _, cid := range broken {
+			if ok, err := selected(cid); err != nil {
+				return TidyResult{}, fmt.Errorf("match backup %s: %w", cid, err)
+			} else if !ok {
+				continue
+			}
+			if err := backups.Remove(scan.Dirs[cid], changes.Add); err != nil {
+				errs = append(errs, fmt.Errorf("remove broken backup %s: %w", cid, err))
+				continue
+			}
+			result.RemovedBrokenCount++
+		}
+	}
+	if opts.Corrupted {
+		for _, cid := range slices.Sorted(maps.Keys(available)) {
+			if ok, err := selected(cid); err != nil {
+				return TidyResult{}, fmt.Errorf("match backup %s: %w", cid, err)
+			} else if !ok {
+				continue
+			}
+			intact, err := backups.Verify(cid, scan.Dirs[cid])
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			if intact {
+				continue
+			}
+			if err := backups.Remove(scan.Dirs[cid], changes.Add); err != nil {
+				errs = append(errs, fmt.Errorf("remove corrupted backup %s: %w", cid, err))
+				continue
+			}
+			result.RemovedCorruptedCount++
+		}
+	}
+
+	if everything || opts.Orphans {
+		match := selected
+		if !everything {
+			// orphans are backups with an object; broken ones are left alone
+			match = func(cid string) (bool, error) {
+				if _, ok := available[cid]; !ok {
+					return false, nil
+				}
+				return selected(cid)
+			}
+		}
+		result.RemovedCount, err = pruneBackupsFunc(s, lck, match, changes.Add)
+		errs = append(errs, err)
+	}
+
+	result.ChangedPaths = changes.Paths()
+	return result, errors.Join(errs...)
+}
+
+// absPatterns normalizes glob patterns like tracked paths, so "~/.config/**"
+// matches what is under it.
+func absPatterns(patterns []string) ([]string, error) {
+	out := make([]string, 0, len(patterns))
+	for _, pattern := range patterns {
+		abs, err := fileutils.AbsPath(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("filter %q: %w", pattern, err)
+		}
+		out = append(out, abs)
+	}
+	return out, nil
+}
+
+// backupSources maps each backup's CID to the paths it was taken from: those
+// recorded in the metadata of its copies, kept in dirs, or, for a backup
+// without any, the paths in st that refer to it.
+func backupSources(dirs map[string][]string, st state.State) (map[string][]string, error) {
+	sources := make(map[string][]string, len(dirs))
+	for cid, cidDirs := range dirs {
+		for _, dir := range cidDirs {
+			meta, err := readBackupMeta(filepath.Join(dir, backupObjectFile))
+			if err != nil {
+				return nil, err
+			}
+			if meta != nil && meta.Path != "" && !slices.Contains(sources[cid], meta.Path) {
+				sources[cid] = append(sources[cid], meta.Path)
+			}
+		}
+	}
+
+	referenced := make(map[string][]string)
+	for _, f := range st.AllFiles() {
+		if hasBackup(f.Previous) && f.Previous.Digest != "" {
+			referenced[f.Previous.Digest] = append(referenced[f.Previous.Digest], f.Path)
+		}
+	}
+	for _, stash := range st.Stashed {
+		referenced[stash.Backup.Digest] = append(referenced[stash.Backup.Digest], stash.Path)
+	}
+	for cid, paths := range referenced {
+		if len(sources[cid]) == 0 {
+			sources[cid] = paths
+		}
+	}
+	return sources, nil
+}
+
+// matchBackupSources reports whether a backup taken from paths is selected by
+// include and exclude: one of its paths must be included, and none excluded.
+// A backup whose paths aren't known is only selected when nothing is
+// included.
+func matchBackupSources(include, exclude, paths []string) (bool, error) {
+	included := len(include) == 0
+	for _, path := range paths {
+		if ok, err := fileutils.MatchFilters(nil, exclude, path); err != nil {
+			return false, err
+		} else if !ok {
+			return false, nil
+		}
+		if !included {
+			ok, err := fileutils.MatchFilters(include, nil, path)
+			if err != nil {
+				return false, err
+			}
+			included = ok
+		}
+	}
+	return included, nil
 }
 
 func (s Store) switchProfile(cfg config.Config, profile string, opts Options) (LoadResult, error) {
+	s = s.withRetries(opts.Retries).withStateBackup(opts.KeepStateBackup).withBackupLayout(cfg.Options.Backups.Layout)
+	policy, err := parseRollbackPolicy(opts.Rollback)
+	if err != nil {
+		return LoadResult{}, err
+	}
+	recovered, err := s.recoverUnlocked()
+	if err != nil {
+		return LoadResult{}, err
+	}
+
 	oldLock, err := s.LoadState()
 	if err != nil {
 		return LoadResult{}, err
@@ -300,33 +773,140 @@ func (s Store) switchProfile(cfg config.Config, profile string, opts Options) (L
 		return LoadResult{}, err
 	}
 
-	target, err := resolveProfile(profile, loadedProfiles)
+	subdir, err := cleanSubdir(opts.ManifestDir)
 	if err != nil {
 		return LoadResult{}, err
 	}
+	var target, archive, remoteURL, remoteSum string
+	if isRemote(profile) {
+		src, err := parseRemote(profile, opts.Insecure)
+		if err != nil {
+			return LoadResult{}, err
+		}
+		if src.Subdir != "" {
+			if subdir != "" && subdir != src.Subdir {
+				return LoadResult{}, fmt.Errorf("source URL names manifest directory %q but --manifest-dir is %q", src.Subdir, subdir)
+			}
+			subdir = src.Subdir
+		}
+		if target, remoteSum, err = s.download(src); err != nil {
+			return LoadResult{}, err
+		}
+		remoteURL = strings.TrimSpace(profile)
+	} else if target, err = resolveProfile(profile, loadedProfiles); err != nil {
+		return LoadResult{}, err
+	}
+	if archiveutils.IsArchive(target) {
+		if archive, err = fileutils.AbsPath(target); err != nil {
+			return LoadResult{}, err
+		}
+		if target, err = s.extractArchive(archive, subdir); err != nil {
+			return LoadResult{}, err
+		}
+	} else if target, err = sourceSubdir(target, subdir); err != nil {
+		return LoadResult{}, err
+	}
 
-	m, profileDir, err := manifest.Load(target)
+	source, err := s.loadSource(target)
 	if err != nil {
 		return LoadResult{}, err
 	}
+	m, profileDir := source.Manifest, source.Dir
+	if cfg, err = applySourceOptions(cfg, m.Options); err != nil {
+		return LoadResult{}, fmt.Errorf("%s: %w", profileDir, err)
+	}
+	warnings := make([]string, 0, 3)
+	if recovered {
+		warnings = append(warnings, "recovered from an interrupted transaction")
+	}
+	if w := newerWriterWarning(oldLock); w != "" {
+		warnings = append(warnings, w)
+	}
 	if err := version.EnsureCompatible(m.Requires.Tohru); err != nil {
-		return LoadResult{}, fmt.Errorf("unsupported profile version %q: %w", m.Requires.Tohru, err)
+		if !opts.IgnoreVersion || errors.Is(err, version.ErrMajorVersion) {
+			return LoadResult{}, fmt.Errorf("unsupported profile version %q: %w", m.Requires.Tohru, err)
+		}
+		warnings = append(warnings, fmt.Sprintf("ignoring profile version requirement %q: %v", m.Requires.Tohru, err))
 	}
 	slug, err := profileutils.ValidateSlug(m.Profile.Slug, "profile.slug", true)
 	if err != nil {
 		return LoadResult{}, err
 	}
 	m.Profile.Slug = slug
+	if want := strings.TrimSpace(opts.ExpectName); want != "" &&
+		!strings.EqualFold(want, slug) && !strings.EqualFold(want, strings.TrimSpace(m.Profile.Name)) {
+		return LoadResult{}, fmt.Errorf("profile in %s is %q, not the expected %q", profileDir, slug, want)
+	}
+
+	mask, err := parseUmask(opts.Umask)
+	if err != nil {
+		return LoadResult{}, err
+	}
 
 	ops, err := plan(m, profileDir)
 	if err != nil {
 		return LoadResult{}, err
 	}
+	if len(ops) == 0 && !opts.AllowEmpty {
+		return LoadResult{}, fmt.Errorf("%s: %w, use --allow-empty to load it anyway", profileDir, ErrEmptyProfile)
+	}
+	if err := s.checkDestinations(ops); err != nil {
+		return LoadResult{}, err
+	}
+	if err := checkSourceDestinations(ops, profileDir); err != nil {
+		return LoadResult{}, err
+	}
+	if err := checkSelfReferences(ops); err != nil {
+		return LoadResult{}, err
+	}
+	ops = s.excludeStore(ops)
+	old, index, err := loadSlot(oldLock, slug, cmp.Or(remoteURL, archive, profileDir), opts.Add)
+	if err != nil {
+		return LoadResult{}, err
+	}
+	if err := checkCollisions(ops, oldLock, index); err != nil {
+		return LoadResult{}, err
+	}
+	if opts.SortByDest {
+		sortOps(ops)
+	}
+
+	fp, err := source.fingerprint(ops, mask)
+	if err != nil {
+		return LoadResult{}, err
+	}
+	if err := s.cacheSource(source, fp, mask); err != nil {
+		warnings = append(warnings, fmt.Sprintf("source cache update failed: %v", err))
+	}
+	unchanged := old.Profile.Path == profileDir &&
+		old.Profile.Archive == archive &&
+		old.Profile.URL == remoteURL &&
+		old.Profile.Fingerprint == fp &&
+		old.Profile.Slug == m.Profile.Slug &&
+		old.Profile.Name == strings.TrimSpace(m.Profile.Name)
+	// --force always applies, so it can put back what the check below
+	// doesn't look at, such as the content of untracked paths.
+	if unchanged && !opts.Repair && !opts.Force {
+		drifted, err := hasDrifted(old.Files, ops)
+		if err != nil {
+			return LoadResult{}, err
+		}
+		if !drifted {
+			return LoadResult{
+				ProfileDir:   profileDir,
+				ProfileName:  profileutils.DisplayName(m.Profile.Slug, m.Profile.Name, profileDir),
+				TrackedCount: len(old.Files),
+				Warnings:     warnings,
+				Skipped:      true,
+			}, nil
+		}
+	}
+
 	changes := newPathRecorder()
 	profileCache := maps.Clone(loadedProfiles)
 
-	oldByPath := make(map[string]state.File, len(oldLock.Files))
-	for _, f := range oldLock.Files {
+	oldByPath := make(map[string]state.File, len(old.Files))
+	for _, f := range old.Files {
 		oldByPath[f.Path] = f
 	}
 
@@ -335,57 +915,92 @@ func (s Store) switchProfile(cfg config.Config, profile string, opts Options) (L
 		occupiedByNew[op.Dest] = struct{}{}
 	}
 
-	snapshot, err := takeSnapshot(s, oldLock.Files)
+	snapshot, err := takeSnapshot(s, old.Files)
+	if err != nil {
+		return LoadResult{}, err
+	}
+	txn, err := beginTransaction(s, oldLock, snapshot)
 	if err != nil {
+		_ = snapshot.Cleanup()
 		return LoadResult{}, err
 	}
-	defer snapshot.Cleanup()
+	changes.journal = txn
 
 	rollbackOnErr := func(err error) (LoadResult, error) {
-		if rollbackErr := rollback(s, oldLock, snapshot, changes.Paths()); rollbackErr != nil {
-			return LoadResult{}, fmt.Errorf("%w (rollback failed: %v)", err, rollbackErr)
-		}
-		return LoadResult{}, fmt.Errorf("%w (rolled back to previous state)", err)
+		return LoadResult{}, s.undo(txn, oldLock, snapshot, changes.Paths(), policy, err)
 	}
 
-	if err := unloadTracked(s, oldLock.Files, occupiedByNew, opts, changes.Add); err != nil {
+	unloaded, err := unloadTracked(s, old.Files, occupiedByNew, opts, changes.Add)
+	if err != nil {
 		return rollbackOnErr(err)
 	}
-	if err := pruneAutoDirs(oldLock.Dirs, changes.Add); err != nil {
+	stashed := append(slices.Clone(oldLock.Stashed), unloaded.Stashed...)
+	if err := pruneAutoDirs(old.Dirs, changes.Add); err != nil {
 		return rollbackOnErr(err)
 	}
 
 	// Persist unloaded state before loading the new profile so failures don't
 	// leave state metadata claiming the old profile is active.
-	unloaded := DefaultState()
-	if err := s.SaveState(unloaded); err != nil {
+	unloadedState := replaceSlot(oldLock, index, state.Layer{Profile: DefaultState().Profile})
+	unloadedState.Stashed = stashed
+	if err := s.SaveState(unloadedState); err != nil {
 		return rollbackOnErr(err)
 	}
 	changes.Add(s.StatePath())
 
-	tracked, autoDirs, err := apply(s, cfg, ops, oldByPath, opts.Force, changes.Add)
+	var kept []AppliedOp
+	if opts.Repair {
+		ops, kept = keepUntracked(ops)
+	}
+	var replaced []state.Stash
+	tracked, autoDirs, applied, err := apply(s, cfg, ops, oldByPath, opts, mask, changes.Add, func(stash state.Stash) {
+		replaced = append(replaced, stash)
+	})
 	if err != nil {
 		return rollbackOnErr(err)
 	}
+	applied = append(applied, kept...)
+	stashed = append(stashed, replaced...)
 
-	newLock := DefaultState()
-	newLock.Profile.State = "loaded"
-	newLock.Profile.Kind = "local"
-	newLock.Profile.Path = profileDir
-	newLock.Profile.Slug = m.Profile.Slug
-	newLock.Profile.Name = strings.TrimSpace(m.Profile.Name)
-	newLock.Files = tracked
-	newLock.Dirs = autoDirs
+	loaded := state.Profile{
+		State:       "loaded",
+		Kind:        defaultKind,
+		Path:        profileDir,
+		Slug:        m.Profile.Slug,
+		Name:        strings.TrimSpace(m.Profile.Name),
+		Subdir:      subdir,
+		Fingerprint: fp,
+	}
+	if archive != "" {
+		loaded.Kind = archiveKind
+		loaded.Archive = archive
+	}
+	if remoteURL != "" {
+		loaded.Kind = remoteKind
+		loaded.URL = remoteURL
+		loaded.SHA256 = remoteSum
+	}
+	newLock := replaceSlot(oldLock, index, state.Layer{Profile: loaded, Files: tracked, Dirs: autoDirs})
+	newLock.Stashed = stashed
 
-	if err := s.SaveState(newLock); err != nil {
+	if err := changes.Err(); err != nil {
 		return rollbackOnErr(err)
 	}
+	if err := txn.commit(newLock); err != nil {
+		return rollbackOnErr(err)
+	}
+	changes.journal = nil
+	if err := s.SaveState(newLock); err != nil {
+		return LoadResult{}, fmt.Errorf("%w (state will be recovered on the next run)", err)
+	}
 	changes.Add(s.StatePath())
-
-	warnings := make([]string, 0, 2)
+	finishErr := txn.finish()
+	if finishErr != nil {
+		warnings = append(warnings, fmt.Sprintf("transaction cleanup failed: %v", finishErr))
+	}
 
 	if cfg.Options.CacheProfiles {
-		cacheProfile(profileCache, m.Profile, profileDir)
+		cacheProfile(profileCache, m.Profile, cmp.Or(archive, profileDir))
 		if err := saveProfilesCache(s, profileCache); err != nil {
 			warnings = append(warnings, fmt.Sprintf("profile cache update failed: %v", err))
 		} else {
@@ -396,73 +1011,445 @@ func (s Store) switchProfile(cfg config.Config, profile string, opts Options) (L
 	removedBackups := 0
 
 	if cfg.Options.Backups.Prune == config.PruneAuto {
-		removedBackups, err = pruneBackupsFunc(s, newLock.Files, changes.Add)
-		if err != nil {
-			warnings = append(warnings, fmt.Sprintf("backup cleanup failed: %v", err))
-		}
+		removedBackups, warnings = pruneCommitted(s, newLock, finishErr, changes.Add, warnings)
 	}
 
+	operations := append(unloaded.Ops, applied...)
+	copied, backedUp := transferred(operations)
 	return LoadResult{
 		ProfileDir:           profileDir,
 		ProfileName:          profileutils.DisplayName(m.Profile.Slug, m.Profile.Name, profileDir),
 		TrackedCount:         len(tracked),
-		UnloadedProfileName:  profileutils.DisplayName(oldLock.Profile.Slug, oldLock.Profile.Name, oldLock.Profile.Path),
-		UnloadedTrackedCount: len(oldLock.Files),
+		UnloadedProfileName:  profileutils.DisplayName(old.Profile.Slug, old.Profile.Name, old.Profile.Path),
+		UnloadedTrackedCount: len(old.Files),
 		RemovedBackupCount:   removedBackups,
-		ChangedPaths:         changes.Paths(),
+		StashedPaths:         stashedPaths(append(unloaded.Stashed, replaced...)),
+		RewrittenCount:       rewritten(applied),
+		BytesCopied:          copied,
+		BytesBackedUp:        backedUp,
+		Operations:           operations,
+		ChangedPaths:         append(changes.Paths(), renamedPaths(applied)...),
 		Warnings:             warnings,
 	}, nil
 }
 
-func plan(m manifest.Manifest, sourceDir string) ([]op, error) {
-	compiled := m.Plan
-	ops := make([]op, 0, len(compiled.Links)+len(compiled.Files)+len(compiled.Dirs))
-	seenDest := make(map[string]struct{}, len(compiled.Links)+len(compiled.Files)+len(compiled.Dirs))
+// unloadedLayer is what unloading a profile, or every profile, takes away.
+type unloadedLayer struct {
+	Name  string
+	Files []state.File
+	Dirs  []state.Dir
+}
 
-	add := func(op op) error {
-		if _, ok := seenDest[op.Dest]; ok {
-			return fmt.Errorf("duplicate destination in manifest: %s", op.Dest)
-		}
-		seenDest[op.Dest] = struct{}{}
-		ops = append(ops, op)
-		return nil
+// unloadTarget picks what unloading name removes from st, or everything when
+// name is empty, and returns the state left behind.
+func unloadTarget(st state.State, name string) (unloadedLayer, state.State, error) {
+	if name == "" {
+		return unloadedLayer{Name: loadedNames(st), Files: st.AllFiles(), Dirs: st.AllDirs()}, DefaultState(), nil
 	}
 
-	for _, l := range compiled.Links {
-		src, err := resolvePath(sourceDir, l.To)
-		if err != nil {
-			return nil, fmt.Errorf("link.to %q: %w", l.To, err)
-		}
-		dest, err := fileutils.AbsPath(l.From)
+	index := slices.IndexFunc(st.Added, func(layer state.Layer) bool {
+		return matchesProfile(layer.Profile, name)
+	})
+	target := state.Layer{Profile: st.Profile, Files: st.Files, Dirs: st.Dirs}
+	switch {
+	case index >= 0:
+		target = st.Added[index]
+	case strings.ToLower(st.Profile.State) == "loaded" && matchesProfile(st.Profile, name):
+	default:
+		return unloadedLayer{}, state.State{}, fmt.Errorf("profile %s is not loaded", name)
+	}
+	return unloadedLayer{
+		Name:  profileutils.DisplayName(target.Profile.Slug, target.Profile.Name, target.Profile.Path),
+		Files: target.Files,
+		Dirs:  target.Dirs,
+	}, replaceSlot(st, index, state.Layer{Profile: DefaultState().Profile}), nil
+}
+
+// matchesProfile reports whether name is the slug or name of p, ignoring case.
+func matchesProfile(p state.Profile, name string) bool {
+	name = strings.TrimSpace(name)
+	return strings.EqualFold(name, p.Slug) || (p.Name != "" && strings.EqualFold(name, p.Name))
+}
+
+// loadedNames joins the display names of every profile loaded in st, the main
+// one first.
+func loadedNames(st state.State) string {
+	var names []string
+	if strings.ToLower(st.Profile.State) == "loaded" {
+		names = append(names, profileutils.DisplayName(st.Profile.Slug, st.Profile.Name, st.Profile.Path))
+	}
+	for _, layer := range st.Added {
+		names = append(names, profileutils.DisplayName(layer.Profile.Slug, layer.Profile.Name, layer.Profile.Path))
+	}
+	return strings.Join(names, ", ")
+}
+
+// loadSlot returns the profile a load of slug from location replaces and its
+// index in st.Added, -1 for the main profile. With add, that is the added
+// profile with the same slug, or an empty one indexed past the end of
+// st.Added. Added profiles are told apart by slug, so one loaded with add
+// needs a slug, and can only replace an added profile of the same slug that
+// was loaded from the same location.
+func loadSlot(st state.State, slug, location string, add bool) (state.Layer, int, error) {
+	index := slices.IndexFunc(st.Added, func(layer state.Layer) bool {
+		return layer.Profile.Slug == slug
+	})
+	if !add {
+		if index >= 0 {
+			return state.Layer{}, 0, fmt.Errorf("profile %s is loaded with --add, unload it before loading it as the main profile", slug)
+		}
+		return state.Layer{Profile: st.Profile, Files: st.Files, Dirs: st.Dirs}, -1, nil
+	}
+
+	if slug == "" {
+		return state.Layer{}, 0, fmt.Errorf("profile in %s has no profile.slug, which --add needs to tell loaded profiles apart", location)
+	}
+	if strings.ToLower(st.Profile.State) == "loaded" && st.Profile.Slug == slug {
+		return state.Layer{}, 0, fmt.Errorf("profile %s is already loaded as the main profile", slug)
+	}
+	if index < 0 {
+		return state.Layer{Profile: DefaultState().Profile}, len(st.Added), nil
+	}
+	if loaded := profileLocation(st.Added[index].Profile); loaded != location {
+		return state.Layer{}, 0, fmt.Errorf("a profile with slug %s is already loaded with --add from %s, unload it before loading the one in %s", slug, loaded, location)
+	}
+	return st.Added[index], index, nil
+}
+
+// profileLocation returns where p was loaded from: its URL, archive or
+// directory.
+func profileLocation(p state.Profile) string {
+	return cmp.Or(p.URL, p.Archive, p.Path)
+}
+
+// replaceSlot returns st with the profile at index, as returned by loadSlot,
+// replaced by layer. Added profiles that are no longer loaded are dropped.
+func replaceSlot(st state.State, index int, layer state.Layer) state.State {
+	st.Added = slices.Clone(st.Added)
+	switch {
+	case index < 0:
+		st.Profile, st.Files, st.Dirs = layer.Profile, layer.Files, layer.Dirs
+	case strings.ToLower(layer.Profile.State) != "loaded":
+		if index < len(st.Added) {
+			st.Added = slices.Delete(st.Added, index, index+1)
+		}
+	case index < len(st.Added):
+		st.Added[index] = layer
+	default:
+		st.Added = append(st.Added, layer)
+	}
+	return st
+}
+
+// checkCollisions rejects operations that overlap a path tracked by a loaded
+// profile other than the one at index: the same path, a path inside it, or,
+// for operations that replace their destination, a directory containing it.
+func checkCollisions(ops []op, st state.State, index int) error {
+	owners := make(map[string]string)
+	for i := -1; i < len(st.Added); i++ {
+		if i == index {
+			continue
+		}
+		layer := state.Layer{Profile: st.Profile, Files: st.Files}
+		if i >= 0 {
+			layer = st.Added[i]
+		}
+		name := profileutils.DisplayName(layer.Profile.Slug, layer.Profile.Name, layer.Profile.Path)
+		for _, f := range layer.Files {
+			owners[f.Path] = name
+		}
+	}
+	if len(owners) == 0 {
+		return nil
+	}
+
+	replacing := make(map[string]op, len(ops))
+	for _, op := range ops {
+		for dir := op.Dest; ; dir = filepath.Dir(dir) {
+			if owner, ok := owners[dir]; ok {
+				return fmt.Errorf("%s %s: destination overlaps %s, which profile %s tracks", op.Kind, op.Dest, dir, owner)
+			}
+			if filepath.Dir(dir) == dir {
+				break
+			}
+		}
+		if op.Kind != opDir || op.Track {
+			replacing[op.Dest] = op
+		}
+	}
+	for _, path := range slices.Sorted(maps.Keys(owners)) {
+		for dir := filepath.Dir(path); ; dir = filepath.Dir(dir) {
+			if op, ok := replacing[dir]; ok {
+				return fmt.Errorf("%s %s: destination contains %s, which profile %s tracks", op.Kind, op.Dest, path, owners[path])
+			}
+			if filepath.Dir(dir) == dir {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// keepUntracked drops untracked operations whose destination already exists.
+// A repair only rewrites what tohru manages; untracked paths belong to the
+// user once written, and rewriting them would need --force.
+func keepUntracked(ops []op) ([]op, []AppliedOp) {
+	var kept []AppliedOp
+	remaining := ops[:0:0]
+	for _, op := range ops {
+		if !op.Track {
+			if _, err := os.Lstat(op.Dest); err == nil {
+				kept = append(kept, AppliedOp{Path: op.Dest, Kind: string(op.Kind), Action: ActionKept})
+				continue
+			}
+		}
+		remaining = append(remaining, op)
+	}
+	return remaining, kept
+}
+
+// rewritten counts the applied operations that wrote their destination, as
+// opposed to keeping what was already there.
+func rewritten(applied []AppliedOp) int {
+	var n int
+	for _, op := range applied {
+		if op.Action != ActionKept && op.Action != ActionAdopted {
+			n++
+		}
+	}
+	return n
+}
+
+// transferred totals the bytes operations copied to destinations and backed
+// up.
+func transferred(ops []AppliedOp) (copied, backedUp int64) {
+	for _, op := range ops {
+		copied += op.Bytes
+		backedUp += op.BackupBytes
+	}
+	return copied, backedUp
+}
+
+// checkDestinations rejects operations that would write into the store, or
+// replace a directory containing it, since loading them would clobber the
+// state and backups the load depends on. Parents are resolved, so a symlinked
+// directory leading into the store is caught too.
+func (s Store) checkDestinations(ops []op) error {
+	root := resolveExisting(s.Root)
+	for _, op := range ops {
+		dest := filepath.Join(resolveExisting(filepath.Dir(op.Dest)), filepath.Base(op.Dest))
+		if rel, err := filepath.Rel(root, dest); err == nil && !fileutils.Escapes(rel) {
+			return fmt.Errorf("%s %s: destination is inside the tohru store %s", op.Kind, op.Dest, s.Root)
+		}
+		if op.Kind == opDir && !op.Track {
+			continue
+		}
+		if rel, err := filepath.Rel(dest, root); err == nil && !fileutils.Escapes(rel) {
+			return fmt.Errorf("%s %s: destination contains the tohru store %s and would replace it", op.Kind, op.Dest, s.Root)
+		}
+	}
+	return nil
+}
+
+// storeWithin returns the store root spelled from dir when dir contains the
+// store, or "" when it doesn't.
+func (s Store) storeWithin(dir string) string {
+	rel, err := filepath.Rel(resolveExisting(dir), resolveExisting(s.Root))
+	if err != nil || rel == "." || fileutils.Escapes(rel) {
+		return ""
+	}
+	return filepath.Join(dir, rel)
+}
+
+// excludeStore leaves the store out of directory copies whose source contains
+// it, which would otherwise copy the store's own backups into the destination
+// and grow with every load, and drops the files of per-file copies that are
+// inside it. checkDestinations keeps destinations clear of it.
+func (s Store) excludeStore(ops []op) []op {
+	for i := range ops {
+		if ops[i].Kind != opCopy {
+			continue
+		}
+		if path := s.storeWithin(ops[i].Source); path != "" {
+			ops[i].Exclude = []string{path}
+		}
+	}
+	return slices.DeleteFunc(ops, func(op op) bool {
+		return op.CopyRoot != "" && s.holds(op.Source)
+	})
+}
+
+// holds reports whether path is inside the store.
+func (s Store) holds(path string) bool {
+	rel, err := filepath.Rel(resolveExisting(s.Root), resolveExisting(path))
+	return err == nil && !fileutils.Escapes(rel)
+}
+
+// StoreOverlaps reports the entries of the manifest m in sourceDir that
+// involve the store: destinations load refuses because they would write into
+// or replace it, and copied directories holding it, which are copied without
+// it.
+func (s Store) StoreOverlaps(m manifest.Manifest, sourceDir string) ([]string, error) {
+	ops, err := plan(m, sourceDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var overlaps []string
+	perFile := make(map[string]struct{})
+	for i, op := range ops {
+		if err := s.checkDestinations(ops[i : i+1]); err != nil {
+			overlaps = append(overlaps, err.Error()+", load will refuse it")
+		}
+		if op.Kind == opCopy && s.storeWithin(op.Source) != "" {
+			overlaps = append(overlaps, fmt.Sprintf("%s %s: source contains the tohru store %s, which is left out of the copy", op.Kind, op.Source, s.Root))
+		}
+		if _, seen := perFile[op.CopyRoot]; op.CopyRoot != "" && !seen && s.holds(op.Source) {
+			perFile[op.CopyRoot] = struct{}{}
+			overlaps = append(overlaps, fmt.Sprintf("copy %s: source contains the tohru store %s, which is left out of the copy", op.CopyRoot, s.Root))
+		}
+	}
+	return overlaps, nil
+}
+
+// checkSourceDestinations rejects operations that would write into the
+// profile's own source directory, where the next load would pick up what this
+// one wrote as part of the profile. Parents are resolved as in
+// checkDestinations, so a symlink leading back into the source is caught too.
+func checkSourceDestinations(ops []op, sourceDir string) error {
+	root := resolveExisting(sourceDir)
+	for _, op := range ops {
+		dest := filepath.Join(resolveExisting(filepath.Dir(op.Dest)), filepath.Base(op.Dest))
+		if rel, err := filepath.Rel(root, dest); err == nil && !fileutils.Escapes(rel) {
+			return fmt.Errorf("%s %s: destination is inside the profile's source directory %s", op.Kind, op.Dest, sourceDir)
+		}
+	}
+	return nil
+}
+
+// maxLinkHops bounds how many symlinks reachesPath follows, as the kernel
+// bounds path resolution.
+const maxLinkHops = 40
+
+// checkSelfReferences rejects operations whose source is, or leads through
+// symlinks in the source to, their own destination: a copy would read the
+// file it is replacing, and a link would point at itself. Only the
+// destination's parents are resolved, so a destination that is already a
+// link to its source, as a previous load leaves it, is fine.
+func checkSelfReferences(ops []op) error {
+	for _, op := range ops {
+		if op.Source == "" || op.Kind == opDir {
+			continue
+		}
+		dest := filepath.Join(resolveExisting(filepath.Dir(op.Dest)), filepath.Base(op.Dest))
+		if reachesPath(op.Source, dest) {
+			if op.Kind == opLink {
+				return fmt.Errorf("%s %s: link would point at itself through %s", op.Kind, op.Dest, op.Source)
+			}
+			return fmt.Errorf("%s %s: source %s resolves to the destination itself", op.Kind, op.Dest, op.Source)
+		}
+	}
+	return nil
+}
+
+// reachesPath reports whether path is dest, or a chain of symlinks from it
+// reaches dest before ending or looping. dest must have its parents resolved.
+func reachesPath(path, dest string) bool {
+	for range maxLinkHops {
+		if filepath.Join(resolveExisting(filepath.Dir(path)), filepath.Base(path)) == dest {
+			return true
+		}
+		target, err := os.Readlink(path)
+		if err != nil {
+			return false
+		}
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(path), target)
+		}
+		path = target
+	}
+	return false
+}
+
+// plan turns a resolved manifest into filesystem operations.
+// Links come first, then files, then dirs, then directory copies, each in
+// manifest plan order.
+func plan(m manifest.Manifest, sourceDir string) ([]op, error) {
+	compiled := m.Plan
+	count := compiled.Len()
+	ops := make([]op, 0, count)
+	seenDest := make(map[string]struct{}, count)
+
+	add := func(op op) error {
+		if _, ok := seenDest[op.Dest]; ok {
+			return fmt.Errorf("duplicate destination in manifest: %s", op.Dest)
+		}
+		seenDest[op.Dest] = struct{}{}
+		ops = append(ops, op)
+		return nil
+	}
+
+	for _, l := range compiled.Links {
+		src, err := resolvePath(sourceDir, l.To)
+		if err != nil {
+			return nil, fmt.Errorf("link.to %q: %w", l.To, err)
+		}
+		dest, err := fileutils.AbsPath(l.From)
 		if err != nil {
 			return nil, fmt.Errorf("link.from %q: %w", l.From, err)
 		}
+		target := src
+		if l.Relative {
+			if target, err = filepath.Rel(filepath.Dir(dest), src); err != nil {
+				return nil, fmt.Errorf("link.to %q: %w", l.To, err)
+			}
+		}
 
 		if err := add(op{
-			Kind:   opLink,
-			Source: src,
-			Dest:   dest,
-			Track:  true,
+			Kind:    opLink,
+			Source:  src,
+			Target:  target,
+			LinkDir: l.Dir,
+			Dest:    dest,
+			Track:   true,
+			Backup:  l.Backup,
+			Root:    l.Root,
 		}); err != nil {
 			return nil, err
 		}
 	}
 
 	for _, f := range compiled.Files {
-		src, err := resolvePath(sourceDir, f.Source)
-		if err != nil {
-			return nil, fmt.Errorf("file.source %q: %w", f.Source, err)
+		var src string
+		if f.Source != "" {
+			resolved, err := resolvePath(sourceDir, f.Source)
+			if err != nil {
+				return nil, fmt.Errorf("file.source %q: %w", f.Source, err)
+			}
+			src = resolved
 		}
 		dest, err := fileutils.AbsPath(f.Dest)
 		if err != nil {
 			return nil, fmt.Errorf("file.dest %q: %w", f.Dest, err)
 		}
+		var mode os.FileMode
+		if f.Mode != "" {
+			parsed, err := strconv.ParseUint(f.Mode, 8, 32)
+			if err != nil {
+				return nil, fmt.Errorf("file.mode %q: %w", f.Mode, err)
+			}
+			mode = os.FileMode(parsed).Perm()
+		}
 
 		if err := add(op{
-			Kind:   opFile,
-			Source: src,
-			Dest:   dest,
-			Track:  f.Tracked == nil || *f.Tracked,
+			Kind:    opFile,
+			Source:  src,
+			Content: f.Content,
+			Dest:    dest,
+			Track:   f.Tracked == nil || *f.Tracked,
+			Mode:    mode,
+			Backup:  f.Backup,
+			Root:    f.Root,
 		}); err != nil {
 			return nil, err
 		}
@@ -475,66 +1462,452 @@ func plan(m manifest.Manifest, sourceDir string) ([]op, error) {
 		}
 
 		if err := add(op{
-			Kind:  opDir,
-			Dest:  dest,
-			Track: d.Tracked == nil || *d.Tracked,
+			Kind:   opDir,
+			Dest:   dest,
+			Track:  d.Tracked == nil || *d.Tracked,
+			Backup: d.Backup,
+			Root:   d.Root,
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, c := range compiled.Copies {
+		src, err := resolvePath(sourceDir, c.Source)
+		if err != nil {
+			return nil, fmt.Errorf("copy.source %q: %w", c.Source, err)
+		}
+		dest, err := fileutils.AbsPath(c.Dest)
+		if err != nil {
+			return nil, fmt.Errorf("copy.dest %q: %w", c.Dest, err)
+		}
+		if c.PerFile {
+			files, err := expandCopy(src, dest)
+			if err != nil {
+				return nil, fmt.Errorf("copy.source %q: %w", c.Source, err)
+			}
+			for _, f := range files {
+				if err := add(op{
+					Kind:     opFile,
+					Source:   f.Source,
+					Dest:     f.Dest,
+					Track:    c.Tracked == nil || *c.Tracked,
+					Backup:   c.Backup,
+					CopyRoot: dest,
+					Root:     c.Root,
+				}); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+
+		if err := add(op{
+			Kind:   opCopy,
+			Source: src,
+			Dest:   dest,
+			Track:  c.Tracked == nil || *c.Tracked,
+			Backup: c.Backup,
+			Root:   c.Root,
 		}); err != nil {
 			return nil, err
 		}
 	}
 
+	// A glob matching a path another entry declares is a duplicate
+	// destination like any other.
+	for _, g := range compiled.Globs {
+		src, err := resolvePath(sourceDir, g.Source)
+		if err != nil {
+			return nil, fmt.Errorf("glob.source %q: %w", g.Source, err)
+		}
+		dest, err := fileutils.AbsPath(g.Dest)
+		if err != nil {
+			return nil, fmt.Errorf("glob.dest %q: %w", g.Dest, err)
+		}
+		var mode os.FileMode
+		if g.Mode != "" {
+			parsed, err := strconv.ParseUint(g.Mode, 8, 32)
+			if err != nil {
+				return nil, fmt.Errorf("glob.mode %q: %w", g.Mode, err)
+			}
+			mode = os.FileMode(parsed).Perm()
+		}
+		matches, err := g.Expand(src, dest)
+		if err != nil {
+			return nil, err
+		}
+		for _, match := range matches {
+			next := op{
+				Kind:   opFile,
+				Source: match.Source,
+				Dest:   match.Dest,
+				Track:  g.Tracked == nil || *g.Tracked,
+				Mode:   mode,
+				Backup: g.Backup,
+				Root:   g.Root,
+			}
+			if g.Link {
+				next.Kind, next.Target, next.Track, next.Mode = opLink, match.Source, true, 0
+				if g.Relative {
+					if next.Target, err = filepath.Rel(filepath.Dir(match.Dest), match.Source); err != nil {
+						return nil, fmt.Errorf("glob %q: %w", g.Pattern, err)
+					}
+				}
+			}
+			if err := add(next); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := checkTrackedDirs(ops); err != nil {
+		return nil, err
+	}
+	if err := checkLinkSources(ops); err != nil {
+		return nil, err
+	}
 	return ops, nil
 }
 
-func apply(store Store, cfg config.Config, ops []op, oldByPath map[string]state.File, force bool, recordPath func(string)) ([]state.File, []state.Dir, error) {
+// checkLinkSources fails when a link's source is a directory and the link
+// wasn't declared as linking one, or the other way round, so a whole
+// directory is only ever linked on purpose.
+func checkLinkSources(ops []op) error {
+	for _, op := range ops {
+		if op.Kind != opLink {
+			continue
+		}
+		info, err := os.Stat(op.Source)
+		if err != nil || info.IsDir() == op.LinkDir {
+			continue
+		}
+		if op.LinkDir {
+			return fmt.Errorf("link source %s is not a directory", op.Source)
+		}
+		return fmt.Errorf("link source %s is a directory, declare it with \".\": [\"link\"] to link the whole directory", op.Source)
+	}
+	return nil
+}
+
+// checkTrackedDirs fails when an untracked entry is declared inside a tracked
+// directory. What is tracked inside one is left out of its digest, but an
+// untracked entry would count as the directory's content and show it drifted
+// as soon as it was loaded.
+func checkTrackedDirs(ops []op) error {
+	for _, dir := range ops {
+		if dir.Kind != opDir || !dir.Track {
+			continue
+		}
+		for _, op := range ops {
+			if !op.Track && isWithin(dir.Dest, op.Dest) {
+				return fmt.Errorf("%s %s is untracked but declared inside tracked directory %s", op.Kind, op.Dest, dir.Dest)
+			}
+		}
+	}
+	return nil
+}
+
+// expandCopy lists the files of a per-file copy of the directory src to
+// dest: every entry under src that isn't a directory, symlinks included, with
+// where it is copied to. Empty directories have nothing to track and are
+// left out.
+func expandCopy(src, dest string) ([]op, error) {
+	info, err := os.Stat(src)
+	if err != nil {
+		return nil, fmt.Errorf("stat copy source: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("copy source is not a directory: %s", src)
+	}
+
+	var files []op
+	err = filepath.WalkDir(src, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, op{Source: path, Dest: filepath.Join(dest, rel)})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list copy source %s: %w", src, err)
+	}
+	return files, nil
+}
+
+// fingerprint hashes everything that determines the result of applying ops:
+// each operation, the digest of its source and the umask. Operations are
+// hashed in destination order so --sort does not change the fingerprint.
+func fingerprint(ops []op, mask umask) (string, error) {
+	ordered := slices.Clone(ops)
+	sortOps(ordered)
+
+	h := sha256.New()
+	fmt.Fprintf(h, "umask:%t:%o\n", mask.set, mask.mask)
+	for _, op := range ordered {
+		source := op.Source
+		switch {
+		case op.Kind == opLink:
+			// The target, so switching a link between relative and
+			// absolute changes the fingerprint, as does declaring it a
+			// directory link or not.
+			source = op.Target
+			if op.LinkDir {
+				source += "\x00dir"
+			}
+		case op.Kind == opFile && op.Source == "":
+			sum := sha256.Sum256([]byte(op.Content))
+			source = "content:" + hex.EncodeToString(sum[:])
+		case op.Kind == opFile || op.Kind == opCopy:
+			d, err := digest.ForPathWith(op.Source, digest.Options{Exclude: op.Exclude})
+			if err != nil {
+				return "", fmt.Errorf("fingerprint manifest source %s: %w", op.Source, err)
+			}
+			source = d.String()
+		}
+		if op.Mode != 0 {
+			// Only when set, so fingerprints of manifests without modes
+			// are unchanged.
+			source += fmt.Sprintf("\x00mode:%o", op.Mode)
+		}
+		fmt.Fprintf(h, "%s\x00%s\x00%s\x00%t\n", op.Kind, op.Dest, source, op.Track)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// hasDrifted reports whether any tracked path no longer matches its recorded
+// digest, or the destination of any untracked operation in ops is gone.
+// Untracked paths aren't recorded, so only their presence is checked.
+func hasDrifted(files []state.File, ops []op) (bool, error) {
+	for _, f := range files {
+		current, exists, err := snapshotTracked(f, files)
+		if err != nil {
+			return false, fmt.Errorf("check tracked path %s: %w", f.Path, err)
+		}
+		if !exists || current.Digest != f.Current.Digest {
+			return true, nil
+		}
+	}
+	for _, op := range ops {
+		if op.Track {
+			continue
+		}
+		if _, err := os.Lstat(op.Dest); errors.Is(err, os.ErrNotExist) {
+			return true, nil
+		} else if err != nil {
+			return false, fmt.Errorf("check untracked path %s: %w", op.Dest, err)
+		}
+	}
+	return false, nil
+}
+
+// sortOps orders operations by destination, shallowest first, so apply logs
+// are reproducible regardless of how the manifest is laid out.
+func sortOps(ops []op) {
+	slices.SortStableFunc(ops, func(a, b op) int {
+		return fileutils.CompareDepth(a.Dest, b.Dest)
+	})
+}
+
+// isWithin reports whether path is strictly inside dir.
+func isWithin(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != "." && !fileutils.Escapes(rel)
+}
+
+// dirsFirst returns ops with the directory operations moved ahead of the
+// rest, shallowest first, and the others in their original order.
+func dirsFirst(ops []op) []op {
+	ordered := make([]op, 0, len(ops))
+	for _, op := range ops {
+		if op.Kind == opDir {
+			ordered = append(ordered, op)
+		}
+	}
+	sortOps(ordered)
+	for _, op := range ops {
+		if op.Kind != opDir {
+			ordered = append(ordered, op)
+		}
+	}
+	return ordered
+}
+
+// apply carries out ops. Existing objects a ConflictResolver chose to back up
+// without a tracked path to restore them to are passed to stash.
+func apply(store Store, cfg config.Config, ops []op, oldByPath map[string]state.File, opts Options, mask umask, recordPath func(string), stash func(state.Stash)) ([]state.File, []state.Dir, []AppliedOp, error) {
 	tracked := make([]state.File, 0, len(ops))
+	applied := make([]AppliedOp, 0, len(ops))
 	autoDirSet := make(map[string]struct{}, 16)
 
+	// A tracked directory replaces whatever is at its destination, so it has
+	// to be in place before anything declared inside it is loaded.
+	ops = dirsFirst(ops)
+
+	// Directories the manifest declares will be created anyway, so they may
+	// be created early as parents even when creating parents is disabled.
+	declaredDirs := make(map[string]struct{})
+	for _, op := range ops {
+		if op.Kind == opDir {
+			declaredDirs[op.Dest] = struct{}{}
+		}
+		if op.CopyRoot != "" {
+			// a per-file copy declares its directories, as a copy would create them
+			for dir := filepath.Dir(op.Dest); ; dir = filepath.Dir(dir) {
+				declaredDirs[dir] = struct{}{}
+				if dir == op.CopyRoot || dir == filepath.Dir(dir) {
+					break
+				}
+			}
+		}
+	}
+
 	for _, op := range ops {
 		var prev *state.Object
 		if old, ok := oldByPath[op.Dest]; ok {
 			prev = old.Previous
 		}
 
-		prevAfterPrepare, err := prepare(store, cfg, op, prev, force, recordPath)
+		existing, statErr := os.Lstat(op.Dest)
+		var stashedBytes int64
+		var renamedTo string
+		prevAfterPrepare, outcome, err := prepare(store, cfg, op, prev, opts, recordPath, func(s state.Stash) {
+			if n, err := fileutils.Size(backupPath(store, s.Backup.Digest, s.Path)); err == nil {
+				stashedBytes += n
+			}
+			stash(s)
+		}, func(aside string) {
+			renamedTo = aside
+		})
 		if err != nil {
-			return nil, nil, fmt.Errorf("%s %s: %w", op.Kind, op.Dest, err)
+			return nil, nil, nil, fmt.Errorf("%s %s: %w", op.Kind, op.Dest, err)
+		}
+		if outcome == prepareSkipped {
+			applied = append(applied, AppliedOp{Path: op.Dest, Kind: string(op.Kind), Action: ActionSkipped})
+			continue
+		}
+		satisfied := outcome == prepareSatisfied
+
+		result := AppliedOp{Path: op.Dest, Kind: string(op.Kind), Action: ActionReplaced, BackupBytes: stashedBytes, RenamedTo: renamedTo}
+		switch {
+		case satisfied:
+			result.Action = ActionAdopted
+		case statErr != nil:
+			result.Action = ActionCreated
+		case op.Kind == opDir && !op.Track && existing.IsDir():
+			result.Action = ActionKept
+		}
+		if hasBackup(prevAfterPrepare) && prevAfterPrepare != prev {
+			result.Backup = prevAfterPrepare.Digest
+			if n, err := fileutils.Size(backupPath(store, prevAfterPrepare.Digest, op.Dest)); err == nil {
+				result.BackupBytes += n
+			}
+		}
+		applied = append(applied, result)
+
+		if opts.NoParents {
+			missing, err := missingParent(op.Dest, declaredDirs)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			if missing != "" {
+				return nil, nil, nil, fmt.Errorf("%s %s: parent directory %s does not exist (creating parents is disabled)", op.Kind, op.Dest, missing)
+			}
 		}
 
-		createdParents, err := makeParents(op.Dest)
+		createdParents, err := makeParents(op.Dest, mask.dirMode(), recordPath)
 		if err != nil {
-			return nil, nil, err
+			return nil, nil, nil, err
 		}
 		for _, dir := range createdParents {
 			autoDirSet[dir] = struct{}{}
-			recordPath(dir)
+			if err := mask.apply(dir); err != nil {
+				return nil, nil, nil, err
+			}
 		}
 
 		switch op.Kind {
 		case opLink:
-			if err := os.Symlink(op.Source, op.Dest); err != nil {
-				return nil, nil, fmt.Errorf("create symlink %s -> %s: %w", op.Dest, op.Source, err)
+			if satisfied {
+				break
 			}
 			recordPath(op.Dest)
+			if err := store.retry.Do(func() error { return os.Symlink(op.Target, op.Dest) }); err != nil {
+				return nil, nil, nil, fmt.Errorf("create symlink %s -> %s: %w", op.Dest, op.Target, err)
+			}
 		case opFile:
+			if op.Source == "" {
+				recordPath(op.Dest)
+				if err := store.retry.Do(func() error { return fileutils.WriteFile(op.Dest, []byte(op.Content), 0o644) }); err != nil {
+					return nil, nil, nil, err
+				}
+				if err := mask.apply(op.Dest); err != nil {
+					return nil, nil, nil, err
+				}
+				if err := chmodFile(op.Dest, op.Mode); err != nil {
+					return nil, nil, nil, err
+				}
+				break
+			}
 			info, err := os.Lstat(op.Source)
 			if err != nil {
-				return nil, nil, fmt.Errorf("stat manifest source %s: %w", op.Source, err)
+				return nil, nil, nil, fmt.Errorf("stat manifest source %s: %w", op.Source, err)
 			}
 			if info.IsDir() && info.Mode()&os.ModeSymlink == 0 {
-				return nil, nil, fmt.Errorf("manifest file source is a directory: %s", op.Source)
+				return nil, nil, nil, fmt.Errorf("manifest file source is a directory: %s", op.Source)
+			}
+			recordPath(op.Dest)
+			if err := store.retry.Do(func() error { return copyPathFunc(op.Source, op.Dest, fileutils.CopyOptions{}) }); err != nil {
+				return nil, nil, nil, err
+			}
+			if err := mask.apply(op.Dest); err != nil {
+				return nil, nil, nil, err
 			}
-			if err := fileutils.CopyPath(op.Source, op.Dest); err != nil {
-				return nil, nil, err
+			if err := chmodFile(op.Dest, op.Mode); err != nil {
+				return nil, nil, nil, err
 			}
+		case opCopy:
+			info, err := os.Lstat(op.Source)
+			if err != nil {
+				return nil, nil, nil, fmt.Errorf("stat manifest source %s: %w", op.Source, err)
+			}
+			if !info.IsDir() {
+				return nil, nil, nil, fmt.Errorf("manifest copy source is not a directory: %s", op.Source)
+			}
+			copyOpts := fileutils.CopyOptions{Exclude: op.Exclude}
 			recordPath(op.Dest)
+			if err := store.retry.Do(func() error { return copyPathFunc(op.Source, op.Dest, copyOpts) }); err != nil {
+				return nil, nil, nil, err
+			}
+			if err := mask.applyTree(op.Dest); err != nil {
+				return nil, nil, nil, err
+			}
 		case opDir:
-			if err := os.MkdirAll(op.Dest, 0o755); err != nil {
-				return nil, nil, fmt.Errorf("create directory %s: %w", op.Dest, err)
+			// A directory kept in place is the user's: rolling back must
+			// not remove it, nor the umask change its mode.
+			if _, err := os.Lstat(op.Dest); err == nil {
+				break
 			}
 			recordPath(op.Dest)
+			if err := os.MkdirAll(op.Dest, mask.dirMode()); err != nil {
+				return nil, nil, nil, fmt.Errorf("create directory %s: %w", op.Dest, err)
+			}
+			if err := mask.apply(op.Dest); err != nil {
+				return nil, nil, nil, err
+			}
 		default:
-			return nil, nil, fmt.Errorf("unsupported operation kind %q", op.Kind)
+			return nil, nil, nil, fmt.Errorf("unsupported operation kind %q", op.Kind)
+		}
+		if op.Kind == opFile || op.Kind == opCopy {
+			n, err := fileutils.Size(op.Dest)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			applied[len(applied)-1].Bytes = n
 		}
 
 		if !op.Track {
@@ -543,13 +1916,21 @@ func apply(store Store, cfg config.Config, ops []op, oldByPath map[string]state.
 
 		curr, err := snapshot(op.Dest)
 		if err != nil {
-			return nil, nil, fmt.Errorf("snapshot tracked path %s: %w", op.Dest, err)
+			return nil, nil, nil, fmt.Errorf("snapshot tracked path %s: %w", op.Dest, err)
+		}
+
+		var target *state.Object
+		if op.Kind == opLink {
+			if target, err = snapshotTarget(op.Dest); err != nil {
+				return nil, nil, nil, fmt.Errorf("snapshot target of %s: %w", op.Dest, err)
+			}
 		}
 
 		tracked = append(tracked, state.File{
 			Path:     op.Dest,
 			Current:  curr,
 			Previous: prevAfterPrepare,
+			Target:   target,
 		})
 	}
 
@@ -561,131 +1942,338 @@ func apply(store Store, cfg config.Config, ops []op, oldByPath map[string]state.
 		return strings.Compare(a.Path, b.Path)
 	})
 
-	return tracked, autoDirs, nil
+	return tracked, autoDirs, applied, nil
 }
 
-func prepare(store Store, cfg config.Config, op op, prev *state.Object, force bool, recordPath func(string)) (*state.Object, error) {
+type prepareOutcome int
+
+const (
+	prepareCleared   prepareOutcome = iota // nothing is in the way of the operation
+	prepareSatisfied                       // the destination is already what the operation would create
+	prepareSkipped                         // a ConflictResolver chose to leave the destination alone
+)
+
+// prepare clears the way for op, backing up, moving aside or removing
+// whatever is at its destination, or asking opts.Resolve what to do with it.
+// It reports prepareSatisfied when the destination is already a symlink to the
+// intended target, in which case it is left in place.
+//
+// A destination moved aside is passed to renamed rather than recordPath, so a
+// rollback leaves it where the user was told it is.
+func prepare(store Store, cfg config.Config, op op, prev *state.Object, opts Options, recordPath func(string), stash func(state.Stash), renamed func(string)) (*state.Object, prepareOutcome, error) {
 	current, exists, err := maybeSnapshot(op.Dest)
 	if err != nil {
-		return nil, err
+		return nil, prepareCleared, err
 	}
 	if !exists {
-		return prev, nil
+		if prev == nil && op.Track {
+			// Record that nothing was here, so unload knows removing the
+			// path is all there is to restore.
+			return nullObject(), prepareCleared, nil
+		}
+		return prev, prepareCleared, nil
+	}
+
+	if op.Kind == opLink {
+		if target, err := os.Readlink(op.Dest); err == nil && target == op.Target {
+			// Keep a copy of the link as the previous object so unload puts
+			// it back rather than leaving nothing behind.
+			if backup, _ := backupsEnabled(cfg, op, opts); !hasBackup(prev) && backup {
+				if prev, err = storeBackup(store, current, recordPath); err != nil {
+					return nil, prepareCleared, err
+				}
+			}
+			return prev, prepareSatisfied, nil
+		}
 	}
 
-	if op.Kind == opDir {
+	if op.Kind == opDir && !op.Track {
 		currentDigest, parseErr := digest.Parse(current.Digest)
 		if parseErr != nil {
-			return nil, fmt.Errorf("parse digest for %s: %w", op.Dest, parseErr)
+			return nil, prepareCleared, fmt.Errorf("parse digest for %s: %w", op.Dest, parseErr)
+		}
+		if currentDigest.Kind == digest.KindDir {
+			return prev, prepareCleared, nil
+		}
+	}
+
+	remove := func() error {
+		recordPath(op.Dest)
+		return store.retry.Do(func() error { return fileutils.RemovePath(op.Dest) })
+	}
+
+	resolution := ResolveDefault
+	if opts.Resolve != nil {
+		resolution, err = opts.Resolve(Conflict{
+			Path:     op.Dest,
+			Kind:     string(op.Kind),
+			Existing: objectKind(current.Digest),
+			Tracked:  op.Track,
+		})
+		if err != nil {
+			return nil, prepareCleared, err
+		}
+	}
+	if resolution == ResolveDefault && opts.RenameConflicts {
+		resolution = ResolveRename
+	}
+	switch resolution {
+	case ResolveAbort:
+		return nil, prepareCleared, ErrAborted
+	case ResolveSkip:
+		return prev, prepareSkipped, nil
+	case ResolveOverwrite:
+		return prev, prepareCleared, remove()
+	case ResolveBackup:
+		backup, err := storeBackup(store, current, recordPath)
+		if err != nil {
+			return nil, prepareCleared, err
 		}
-		if currentDigest.Kind == digest.KindDir {
-			if !op.Track {
-				return prev, nil
-			}
-			return nil, fmt.Errorf("tracked dir destination already exists: %s", op.Dest)
+		if err := remove(); err != nil {
+			return nil, prepareCleared, err
 		}
-		if op.Track {
-			return nil, fmt.Errorf("tracked dir destination exists and is not a directory: %s", op.Dest)
+		// Only a tracked path with no earlier backup can restore this one on
+		// unload; anything else is kept as a stash.
+		if op.Track && !hasBackup(prev) {
+			return backup, prepareCleared, nil
+		}
+		stash(state.Stash{Path: op.Dest, Backup: *backup})
+		return prev, prepareCleared, nil
+	case ResolveRename:
+		aside, err := moveAside(store, op.Dest)
+		if err != nil {
+			return nil, prepareCleared, err
+		}
+		renamed(aside)
+		// Unload leaves the moved object where it is, so there is nothing to
+		// restore unless an earlier backup is.
+		if op.Track && !hasBackup(prev) {
+			return nullObject(), prepareCleared, nil
 		}
+		return prev, prepareCleared, nil
 	}
 
 	if !op.Track {
-		if !force {
-			return nil, fmt.Errorf("destination exists (would clobber), use --force to overwrite")
-		}
-		if err := fileutils.RemovePath(op.Dest); err != nil {
-			return nil, err
+		if !opts.Force {
+			return nil, prepareCleared, errNeedsForce("destination exists (would clobber), use --force to overwrite")
 		}
-		recordPath(op.Dest)
-		return prev, nil
+		return prev, prepareCleared, remove()
 	}
 
-	if prev == nil && cfg.Options.Backups.Enabled {
+	backup, disabledBy := backupsEnabled(cfg, op, opts)
+	if !hasBackup(prev) && backup {
 		storedPrev, err := storeBackup(store, current, recordPath)
 		if err != nil {
-			return nil, err
+			return nil, prepareCleared, err
 		}
-		if err := fileutils.RemovePath(op.Dest); err != nil {
-			return nil, err
+		if err := remove(); err != nil {
+			return nil, prepareCleared, err
 		}
-		recordPath(op.Dest)
-		return storedPrev, nil
+		return storedPrev, prepareCleared, nil
 	}
 
-	if !force {
-		if prev == nil && !cfg.Options.Backups.Enabled {
-			return nil, fmt.Errorf("destination exists and options.backups.enabled=false, refusing to clobber without --force")
+	if !opts.Force {
+		if !hasBackup(prev) && !backup {
+			return nil, prepareCleared, errNeedsForce("destination exists and %s, refusing to clobber without --force", disabledBy)
 		}
-		return nil, fmt.Errorf("destination exists (would clobber), use --force to overwrite")
+		return nil, prepareCleared, errNeedsForce("destination exists (would clobber), use --force to overwrite")
 	}
 
-	if err := fileutils.RemovePath(op.Dest); err != nil {
-		return nil, err
+	return prev, prepareCleared, remove()
+}
+
+// moveAside renames the object at path to a free path beside it, marked with
+// the time it was moved, and returns that path.
+func moveAside(store Store, path string) (string, error) {
+	stamp := time.Now().Format("20060102-150405")
+	aside := path + asideInfix + stamp
+	for n := 2; ; n++ {
+		_, err := os.Lstat(aside)
+		if errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("check %s: %w", aside, err)
+		}
+		aside = fmt.Sprintf("%s%s%s-%d", path, asideInfix, stamp, n)
+	}
+	if err := store.retry.Do(func() error { return os.Rename(path, aside) }); err != nil {
+		return "", fmt.Errorf("move %s aside: %w", path, err)
+	}
+	return aside, nil
+}
+
+// renamedPaths lists where ops moved existing objects aside to.
+func renamedPaths(ops []AppliedOp) []string {
+	var paths []string
+	for _, op := range ops {
+		if op.RenamedTo != "" {
+			paths = append(paths, op.RenamedTo)
+		}
+	}
+	return paths
+}
+
+// backupsEnabled reports whether an existing destination of op is backed up
+// before being replaced, and if not, what disabled it. The manifest entry's
+// flag wins over --no-backup, which wins over the store's options.
+func backupsEnabled(cfg config.Config, op op, opts Options) (bool, string) {
+	switch {
+	case op.Backup != nil && *op.Backup:
+		return true, ""
+	case op.Backup != nil:
+		return false, "its manifest entry is flagged no-backup"
+	case opts.NoBackup:
+		return false, "--no-backup is set"
+	case !cfg.Options.Backups.Enabled:
+		return false, "options.backups.enabled=false"
+	default:
+		return true, ""
 	}
-	recordPath(op.Dest)
+}
+
+func stashedPaths(stashes []state.Stash) []string {
+	paths := make([]string, 0, len(stashes))
+	for _, stash := range stashes {
+		paths = append(paths, stash.Path)
+	}
+	return paths
+}
 
-	return prev, nil
+// restoreStats counts restored backups by whether the restored object matched
+// its recorded digest.
+type restoreStats struct {
+	Verified   int
+	Unverified []string
+	Stashed    []state.Stash // drifted content backed up before removal
+	Ops        []AppliedOp
 }
 
-func unloadTracked(store Store, files []state.File, occupiedByNew map[string]struct{}, opts Options, recordPath func(string)) error {
+func unloadTracked(store Store, files []state.File, occupiedByNew map[string]struct{}, opts Options, recordPath func(string)) (restoreStats, error) {
+	var stats restoreStats
 	managedFiles := slices.Clone(files)
 	slices.SortFunc(managedFiles, func(a, b state.File) int {
 		return -fileutils.CompareDepth(a.Path, b.Path)
 	})
 
 	for _, managed := range managedFiles {
-		if err := removeManaged(managed, opts, recordPath); err != nil {
-			return err
+		stash, err := removeManaged(store, managed, files, opts, recordPath)
+		if err != nil {
+			return stats, err
+		}
+		result := AppliedOp{Path: managed.Path, Kind: objectKind(managed.Current.Digest), Action: ActionRemoved}
+		if stash != nil {
+			stats.Stashed = append(stats.Stashed, *stash)
+			result.Backup = stash.Backup.Digest
+			if n, err := fileutils.Size(backupPath(store, stash.Backup.Digest, stash.Path)); err == nil {
+				result.BackupBytes = n
+			}
 		}
 
-		if managed.Previous != nil && managed.Previous.Digest != "" {
-			if _, stillOccupied := occupiedByNew[managed.Path]; stillOccupied {
-				continue
-			}
-			if err := restoreBackup(store, managed.Previous, managed.Path, opts.Force, recordPath); err != nil {
-				return err
+		switch {
+		case isNullObject(managed.Previous):
+			// Nothing was there before tohru, so removing the managed path
+			// is the whole restore.
+		case managed.Previous != nil && managed.Previous.Digest != "":
+			if _, stillOccupied := occupiedByNew[managed.Path]; !stillOccupied {
+				outcome, err := restoreBackup(store, managed.Previous, managed.Path, opts.Force, recordPath)
+				if err != nil {
+					return stats, err
+				}
+				switch outcome {
+				case restoreVerified:
+					stats.Verified++
+				case restoreUnverified:
+					stats.Unverified = append(stats.Unverified, managed.Path)
+				}
+				if outcome != restoreSkipped {
+					result.Action = ActionRestored
+					result.Backup = managed.Previous.Digest
+				}
 			}
 		}
+		stats.Ops = append(stats.Ops, result)
 	}
 
-	return nil
+	return stats, nil
 }
 
-func removeManaged(managed state.File, opts Options, recordPath func(string)) error {
+// removeManaged removes a managed path, refusing if it drifted from its
+// recorded digest unless forced. With opts.BackupDrifted, drifted content is
+// backed up first and returned as a stash.
+func removeManaged(store Store, managed state.File, files []state.File, opts Options, recordPath func(string)) (*state.Stash, error) {
 	path := strings.TrimSpace(managed.Path)
 	if path == "" {
-		return nil
+		return nil, nil
+	}
+
+	current, exists, drifted, err := inspectManaged(managed, files, opts)
+	if err != nil || !exists {
+		return nil, err
+	}
+
+	var stash *state.Stash
+	if drifted && opts.BackupDrifted {
+		// The backup is of everything at path, what the digest leaves out
+		// included.
+		whole, err := snapshot(path)
+		if err != nil {
+			return nil, fmt.Errorf("snapshot drifted path %s: %w", path, err)
+		}
+		backup, err := storeBackup(store, whole, recordPath)
+		if err != nil {
+			return nil, fmt.Errorf("back up drifted path %s: %w", path, err)
+		}
+		stash = &state.Stash{Path: path, Backup: *backup}
 	}
 
-	current, exists, err := maybeSnapshot(path)
+	// Unless forced, only remove the object that was just checked, in case
+	// the path was swapped since.
+	expected := current.Digest
+	if opts.Force {
+		expected = ""
+	}
+	recordPath(path)
+	exclude := digest.Options{Exclude: trackedExcludes(managed, files)}
+	if err := store.retry.Do(func() error { return fileutils.RemovePathExpectingWith(path, expected, exclude) }); err != nil {
+		return nil, fmt.Errorf("remove managed path %s: %w", path, err)
+	}
+
+	return stash, nil
+}
+
+// inspectManaged snapshots the managed path, one of files, and reports
+// whether it still exists and whether it drifted from its recorded digest.
+// It fails where removeManaged would refuse to go on under opts: a missing
+// path without Force, or a drifted one without Force, DiscardChanges or
+// BackupDrifted.
+func inspectManaged(managed state.File, files []state.File, opts Options) (state.Object, bool, bool, error) {
+	path := strings.TrimSpace(managed.Path)
+	current, exists, err := snapshotTracked(managed, files)
 	if err != nil {
-		return fmt.Errorf("check managed path %s: %w", path, err)
+		return state.Object{}, false, false, fmt.Errorf("check managed path %s: %w", path, err)
 	}
 	if !exists {
 		if opts.Force {
-			return nil
+			return state.Object{}, false, false, nil
 		}
-		return fmt.Errorf("managed path missing: %s", path)
+		return state.Object{}, false, false, fmt.Errorf("managed path missing: %s", path)
 	}
 
 	expected, err := digest.Parse(managed.Current.Digest)
 	if err != nil {
-		return fmt.Errorf("invalid digest for managed path %s: %w", path, err)
+		return current, true, false, fmt.Errorf("invalid digest for managed path %s: %w", path, err)
 	}
 	actual, err := digest.Parse(current.Digest)
 	if err != nil {
-		return fmt.Errorf("invalid current digest for managed path %s: %w", path, err)
-	}
-	if !(opts.Force || opts.DiscardChanges) && !expected.IsZero() && expected.String() != actual.String() {
-		return fmt.Errorf("managed path was modified: %s", path)
+		return current, true, false, fmt.Errorf("invalid current digest for managed path %s: %w", path, err)
 	}
 
-	if err := fileutils.RemovePath(path); err != nil {
-		return fmt.Errorf("remove managed path %s: %w", path, err)
+	drifted := !expected.IsZero() && expected.String() != actual.String()
+	if drifted && !(opts.Force || opts.DiscardChanges || opts.BackupDrifted) {
+		return current, true, true, errNeedsForce("managed path was modified: %s", path)
 	}
-	recordPath(path)
-
-	return nil
+	return current, true, drifted, nil
 }
 
 func storeBackup(store Store, object state.Object, recordPath func(string)) (*state.Object, error) {
@@ -697,144 +2285,274 @@ func storeBackup(store Store, object state.Object, recordPath func(string)) (*st
 		return nil, fmt.Errorf("cannot backup object %s with empty digest", object.Path)
 	}
 
-	cid := d.String()
-	objectPath := backupPath(store, cid)
+	if _, err := store.backups().Persist(d.String(), object.Path, recordPath); err != nil {
+		return nil, err
+	}
+	// Only the CID is recorded: the object's path follows from it and the
+	// store root, which may move.
+	return &state.Object{Digest: d.String()}, nil
+}
+
+type restoreOutcome int
+
+const (
+	restoreSkipped restoreOutcome = iota
+	restoreVerified
+	restoreUnverified
+)
+
+// restoreBackup copies a backup object back to destination and verifies the
+// restored object against the recorded digest. Mismatches are only tolerated
+// with force, in which case the restore is reported as unverified.
+func restoreBackup(store Store, prev *state.Object, destination string, force bool, recordPath func(string)) (restoreOutcome, error) {
+	path, backupKind, ok, err := locateBackup(store, prev, destination, force)
+	if err != nil || !ok {
+		return restoreSkipped, err
+	}
 
-	existingBackup, exists, err := maybeSnapshot(objectPath)
+	current, destinationExists, err := maybeSnapshot(destination)
 	if err != nil {
-		return nil, fmt.Errorf("check backup object at %s: %w", objectPath, err)
+		return restoreSkipped, fmt.Errorf("check restore destination %s: %w", destination, err)
 	}
-	if exists {
-		if existingBackup.Digest != d.String() {
-			return nil, fmt.Errorf("backup collision for CID %s at %s", cid, objectPath)
+	if destinationExists {
+		if !force {
+			currentKind, err := digestKind(current.Digest)
+			if err != nil {
+				return restoreSkipped, fmt.Errorf("parse digest for %s: %w", destination, err)
+			}
+			if currentKind != backupKind {
+				return restoreSkipped, errNeedsForce("restore destination %s is a %s but its backup is a %s, use --force to replace it", destination, currentKind, backupKind)
+			}
+			return restoreSkipped, fmt.Errorf("restore destination exists for %s", destination)
+		}
+		recordPath(destination)
+		if err := store.retry.Do(func() error { return fileutils.RemovePath(destination) }); err != nil {
+			return restoreSkipped, fmt.Errorf("remove restore destination %s: %w", destination, err)
 		}
-		return &state.Object{Path: objectPath, Digest: d.String()}, nil
 	}
 
-	if err := os.MkdirAll(filepath.Dir(objectPath), 0o755); err != nil {
-		return nil, fmt.Errorf("create backup directory for %s: %w", objectPath, err)
-	}
-	if err := fileutils.CopyPath(object.Path, objectPath); err != nil {
-		return nil, fmt.Errorf("backup %s into %s: %w", object.Path, objectPath, err)
+	if err := store.backups().Restore(path, destination, recordPath); err != nil {
+		return restoreSkipped, err
 	}
-	recordPath(objectPath)
 
-	written, err := snapshot(objectPath)
+	if prev.Digest == "" {
+		return restoreUnverified, nil
+	}
+	restored, err := snapshot(destination)
 	if err != nil {
-		return nil, fmt.Errorf("snapshot backup object %s: %w", objectPath, err)
+		return restoreSkipped, fmt.Errorf("snapshot restored object %s: %w", destination, err)
 	}
-	if written.Digest != d.String() {
-		_ = fileutils.RemovePath(objectPath)
-		return nil, fmt.Errorf("backup digest mismatch for %s", objectPath)
+	if restored.Digest != prev.Digest {
+		if !force {
+			return restoreSkipped, fmt.Errorf("restored digest mismatch for %s (expected %s, got %s)", destination, prev.Digest, restored.Digest)
+		}
+		return restoreUnverified, nil
 	}
 
-	return &state.Object{Path: objectPath, Digest: d.String()}, nil
+	return restoreVerified, nil
 }
 
-func restoreBackup(store Store, prev *state.Object, destination string, force bool, recordPath func(string)) error {
-	if prev == nil {
-		return nil
+// nullObject is the previous object of a path that had nothing at it before
+// tohru managed it.
+func nullObject() *state.Object {
+	return &state.Object{Digest: digest.Digest{Kind: digest.KindNull}.String()}
+}
+
+// isNullObject reports whether prev records that nothing was at the path.
+func isNullObject(prev *state.Object) bool {
+	return prev != nil && strings.TrimSpace(prev.Digest) == string(digest.KindNull)
+}
+
+// hasBackup reports whether prev refers to a backup to restore, as opposed to
+// nothing being recorded or nothing having been there.
+func hasBackup(prev *state.Object) bool {
+	return prev != nil && !isNullObject(prev)
+}
+
+// locateBackup finds the backup object prev refers to and checks it against
+// the recorded digest, returning its path and kind. ok is false when there is
+// nothing to restore, including a missing backup with force.
+func locateBackup(store Store, prev *state.Object, destination string, force bool) (string, digest.Kind, bool, error) {
+	if !hasBackup(prev) {
+		return "", "", false, nil
 	}
 
-	path := strings.TrimSpace(prev.Path)
-	if path == "" {
-		d, err := digest.Parse(prev.Digest)
-		if err != nil {
-			return fmt.Errorf("parse previous digest for %s: %w", destination, err)
-		}
-		if d.IsZero() {
-			return nil
-		}
-		path = backupPath(store, d.String())
+	// The path is always derived from the CID. Older versions recorded the
+	// absolute path too, which goes stale once the store moves.
+	d, err := digest.Parse(prev.Digest)
+	if err != nil {
+		return "", "", false, fmt.Errorf("parse previous digest for %s: %w", destination, err)
+	}
+	if d.IsZero() {
+		return "", "", false, nil
 	}
+	path := backupPath(store, d.String(), destination)
 
 	backup, exists, err := maybeSnapshot(path)
 	if err != nil {
-		return fmt.Errorf("check backup object %s: %w", path, err)
+		return "", "", false, fmt.Errorf("check backup object %s: %w", path, err)
 	}
 	if !exists {
 		if force {
-			return nil
+			return "", "", false, nil
 		}
-		return fmt.Errorf("missing backup object %s for %s", path, destination)
+		return "", "", false, fmt.Errorf("missing backup object %s for %s", path, destination)
 	}
 
+	backupKind, err := digestKind(backup.Digest)
+	if err != nil {
+		return "", "", false, fmt.Errorf("parse backup digest for %s: %w", path, err)
+	}
 	if prev.Digest != "" && backup.Digest != prev.Digest {
+		expectedKind, err := digestKind(prev.Digest)
+		if err != nil {
+			return "", "", false, fmt.Errorf("parse previous digest for %s: %w", destination, err)
+		}
 		if !force {
-			return fmt.Errorf("backup digest mismatch for %s", path)
+			if expectedKind != backupKind {
+				return "", "", false, errNeedsForce("backup %s is a %s but %s was recorded as a %s, use --force to restore it anyway", path, backupKind, destination, expectedKind)
+			}
+			return "", "", false, fmt.Errorf("backup digest mismatch for %s", path)
 		}
 	}
+	return path, backupKind, true, nil
+}
 
-	_, destinationExists, err := maybeSnapshot(destination)
+// objectKind names the kind of a recorded object the way AppliedOp does.
+func objectKind(raw string) string {
+	kind, err := digestKind(raw)
 	if err != nil {
-		return fmt.Errorf("check restore destination %s: %w", destination, err)
+		return ""
 	}
-	if destinationExists {
-		if !force {
-			return fmt.Errorf("restore destination exists for %s", destination)
-		}
-		if err := fileutils.RemovePath(destination); err != nil {
-			return fmt.Errorf("remove restore destination %s: %w", destination, err)
-		}
-		recordPath(destination)
+	switch kind {
+	case digest.KindSymlink:
+		return string(opLink)
+	case digest.KindDir:
+		return string(opDir)
+	default:
+		return string(kind)
 	}
+}
 
-	if err := fileutils.CopyPath(path, destination); err != nil {
-		return fmt.Errorf("restore backup %s to %s: %w", path, destination, err)
+func digestKind(raw string) (digest.Kind, error) {
+	d, err := digest.Parse(raw)
+	if err != nil {
+		return "", err
 	}
-	recordPath(destination)
+	return d.Kind, nil
+}
 
-	return nil
+// pruneCommitted prunes the backups st no longer references at the end of a
+// load or unload, and returns how many it removed and warnings with any
+// failure appended. It is only called once st is saved, and prunes nothing
+// unless finishErr shows the journal is gone too: a journal left behind is
+// recovered on the next run, and until then every backup is kept, since a
+// removed one can't be brought back.
+func pruneCommitted(s Store, st state.State, finishErr error, recordPath func(string), warnings []string) (int, []string) {
+	if finishErr != nil {
+		return 0, append(warnings, "backups are kept until the interrupted transaction is recovered")
+	}
+	if err := s.checkNoTransaction(); err != nil {
+		return 0, append(warnings, fmt.Sprintf("backup cleanup skipped: %v", err))
+	}
+	removed, err := pruneBackupsFunc(s, st, nil, recordPath)
+	if err != nil {
+		warnings = append(warnings, fmt.Sprintf("backup cleanup failed: %v", err))
+	}
+	return removed, warnings
 }
 
-func pruneBackups(store Store, tracked []state.File, recordPath func(string)) (int, error) {
-	referenced := make(map[string]struct{}, len(tracked))
-	for _, f := range tracked {
-		if f.Previous == nil || f.Previous.Digest == "" {
-			continue
-		}
-		d, err := digest.Parse(f.Previous.Digest)
-		if err != nil {
-			return 0, fmt.Errorf("parse previous digest for %s: %w", f.Path, err)
-		}
-		if d.IsZero() {
-			continue
+// pruneBackups removes backups that neither st nor the state backup kept by
+// Options.KeepStateBackup refer to. When match is
+// non-nil, only backups whose CID it selects are considered. A backup that
+// can't be removed is skipped; the count covers the rest, and the error joins
+// every failure.
+func pruneBackups(store Store, st state.State, match func(cid string) (bool, error), recordPath func(string)) (int, error) {
+	referenced := make(map[string]struct{}, len(st.Files)+len(st.Stashed))
+	if err := referenceBackups(st, referenced); err != nil {
+		return 0, err
+	}
+	// A state backup can be restored, so the backups it refers to are kept
+	// as well.
+	if previous, ok, err := store.loadStateBackup(); err != nil {
+		return 0, err
+	} else if ok {
+		if err := referenceBackups(previous, referenced); err != nil {
+			return 0, fmt.Errorf("%s: %w", store.StateBackupPath(), err)
 		}
-		referenced[d.String()] = struct{}{}
 	}
 
-	entries, err := os.ReadDir(store.BackupsPath())
+	backups := store.backups()
+	scan, err := backups.Scan()
 	if err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			return 0, nil
-		}
-		return 0, fmt.Errorf("read backups directory %s: %w", store.BackupsPath(), err)
+		return 0, err
 	}
 
+	cids := append(slices.Collect(maps.Keys(scan.Available)), scan.Broken...)
+	slices.Sort(cids)
+
 	var removed int
-	for _, entry := range entries {
-		cid := entry.Name()
+	var errs []error
+	for _, cid := range cids {
 		if _, keep := referenced[cid]; keep {
 			continue
 		}
+		if match != nil {
+			selected, err := match(cid)
+			if err != nil {
+				return 0, fmt.Errorf("match backup %s: %w", cid, err)
+			}
+			if !selected {
+				continue
+			}
+		}
 
-		path := filepath.Join(store.BackupsPath(), cid)
-		if err := fileutils.RemovePath(path); err != nil {
-			return 0, fmt.Errorf("remove unreferenced backup %s: %w", path, err)
+		if err := backups.Remove(scan.Dirs[cid], recordPath); err != nil {
+			errs = append(errs, fmt.Errorf("remove unreferenced backup %s: %w", cid, err))
+			continue
 		}
-		recordPath(path)
 		removed++
 	}
 
-	return removed, nil
+	return removed, errors.Join(errs...)
+}
+
+// referenceBackups adds the CIDs of the backups st refers to to referenced.
+func referenceBackups(st state.State, referenced map[string]struct{}) error {
+	reference := func(path, raw string) error {
+		d, err := digest.Parse(raw)
+		if err != nil {
+			return fmt.Errorf("parse backup digest for %s: %w", path, err)
+		}
+		if !d.IsZero() {
+			referenced[d.String()] = struct{}{}
+		}
+		return nil
+	}
+	for _, f := range st.AllFiles() {
+		if !hasBackup(f.Previous) || f.Previous.Digest == "" {
+			continue
+		}
+		if err := reference(f.Path, f.Previous.Digest); err != nil {
+			return err
+		}
+	}
+	for _, stash := range st.Stashed {
+		if err := reference(stash.Path, stash.Backup.Digest); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func backupPath(store Store, cid string) string {
-	return filepath.Join(store.BackupsPath(), cid, "object")
+// backupPath returns where the object of the backup under cid is kept, see
+// dirBackups.objectPath for source.
+func backupPath(store Store, cid, source string) string {
+	return dirBackups{root: store.BackupsPath()}.objectPath(cid, source)
 }
 
 func takeSnapshot(store Store, files []state.File) (rollbackSnapshot, error) {
-	root, err := os.MkdirTemp(store.Root, "switch-rollback-")
+	root, err := os.MkdirTemp(store.Root, rollbackDirPrefix)
 	if err != nil {
 		return rollbackSnapshot{}, fmt.Errorf("create rollback snapshot directory: %w", err)
 	}
@@ -891,13 +2609,26 @@ func (s rollbackSnapshot) Cleanup() error {
 	return fileutils.RemovePath(s.root)
 }
 
-func rollback(store Store, oldLock state.State, snapshot rollbackSnapshot, changedPaths []string) error {
+// rollback undoes changedPaths and puts back the managed paths in snapshot
+// and oldLock. It stops at the first failure unless bestEffort is set, in
+// which case it carries on and returns every path it couldn't restore.
+func rollback(store Store, oldLock state.State, snapshot rollbackSnapshot, changedPaths []string, bestEffort bool) ([]string, error) {
+	var failed []string
+	var errs []error
+	fail := func(path string, err error) bool {
+		failed = append(failed, path)
+		errs = append(errs, err)
+		return !bestEffort
+	}
+
 	for _, path := range fileutils.SortByDepth(changedPaths, true) {
 		if path == store.StatePath() {
 			continue
 		}
 		if err := fileutils.RemovePath(path); err != nil {
-			return fmt.Errorf("rollback remove changed path %s: %w", path, err)
+			if fail(path, fmt.Errorf("rollback remove changed path %s: %w", path, err)) {
+				return failed, errors.Join(errs...)
+			}
 		}
 	}
 
@@ -905,10 +2636,15 @@ func rollback(store Store, oldLock state.State, snapshot rollbackSnapshot, chang
 	for _, entry := range snapshot.entries {
 		removeTargets = append(removeTargets, entry.Path)
 	}
+	cleared := make(map[string]bool, len(removeTargets))
 	for _, path := range fileutils.SortByDepth(removeTargets, true) {
 		if err := fileutils.RemovePath(path); err != nil {
-			return fmt.Errorf("rollback clear managed path %s: %w", path, err)
+			if fail(path, fmt.Errorf("rollback clear managed path %s: %w", path, err)) {
+				return failed, errors.Join(errs...)
+			}
+			continue
 		}
+		cleared[path] = true
 	}
 
 	restoreEntries := slices.Clone(snapshot.entries)
@@ -917,24 +2653,34 @@ func rollback(store Store, oldLock state.State, snapshot rollbackSnapshot, chang
 	})
 
 	for _, entry := range restoreEntries {
-		if !entry.HadObject {
+		if !entry.HadObject || !cleared[entry.Path] {
 			continue
 		}
 		if err := fileutils.CopyPath(entry.Backup, entry.Path); err != nil {
-			return fmt.Errorf("rollback restore managed path %s: %w", entry.Path, err)
+			if fail(entry.Path, fmt.Errorf("rollback restore managed path %s: %w", entry.Path, err)) {
+				return failed, errors.Join(errs...)
+			}
 		}
 	}
 
 	if err := store.SaveState(oldLock); err != nil {
-		return fmt.Errorf("rollback restore lock: %w", err)
+		fail(store.StatePath(), fmt.Errorf("rollback restore lock: %w", err))
 	}
 
-	return nil
+	return failed, errors.Join(errs...)
 }
 
+// pathRecorder collects the paths a load or unload changes. Paths are added
+// before the change that creates or replaces them, so the journal knows about
+// them even if the run is interrupted part-way through the change.
 type pathRecorder struct {
 	seen  map[string]struct{}
 	paths []string
+
+	// journal, when set, receives every recorded path; the first journal
+	// write error is kept in err.
+	journal *transaction
+	err     error
 }
 
 func newPathRecorder() *pathRecorder {
@@ -953,6 +2699,13 @@ func (r *pathRecorder) Add(path string) {
 	}
 	r.seen[trimmed] = struct{}{}
 	r.paths = append(r.paths, trimmed)
+	if r.journal != nil && r.err == nil {
+		r.err = r.journal.record(trimmed)
+	}
+}
+
+func (r *pathRecorder) Err() error {
+	return r.err
 }
 
 func (r *pathRecorder) Paths() []string {
@@ -982,6 +2735,48 @@ func resolveProfile(input string, cache map[string]state.CachedProfile) (string,
 	return "", fmt.Errorf("profile %q not found as a path and not found in cached profiles", ref)
 }
 
+// checkSameProfile verifies that the manifest at source declares the same
+// profile as loaded, comparing slugs, or names when neither has a slug.
+func (s Store) checkSameProfile(loaded state.Profile, source string, opts Options) error {
+	subdir, err := cleanSubdir(opts.ManifestDir)
+	if err != nil {
+		return err
+	}
+	target := fileutils.ExpandHome(strings.TrimSpace(source))
+	if isRemote(target) {
+		src, err := parseRemote(target, opts.Insecure)
+		if err != nil {
+			return err
+		}
+		if src.Subdir != "" {
+			subdir = src.Subdir
+		}
+		if target, _, err = s.download(src); err != nil {
+			return err
+		}
+	}
+	if archiveutils.IsArchive(target) {
+		if target, err = s.extractArchive(target, subdir); err != nil {
+			return err
+		}
+	} else if target, err = sourceSubdir(target, subdir); err != nil {
+		return err
+	}
+	m, _, err := manifest.Load(target)
+	if err != nil {
+		return err
+	}
+
+	got, want := profileutils.NormalizeSlug(m.Profile.Slug), loaded.Slug
+	if got == "" && want == "" {
+		got, want = strings.TrimSpace(m.Profile.Name), loaded.Name
+	}
+	if got != want {
+		return errNeedsForce("%s declares profile %q but %q is loaded, use --force to reload from it anyway", source, got, want)
+	}
+	return nil
+}
+
 func cacheProfile(cache map[string]state.CachedProfile, profile manifest.Profile, loc string) {
 	slug := profileutils.NormalizeSlug(profile.Slug)
 	if slug == "" {
@@ -1022,7 +2817,25 @@ func resolvePath(sourceDir, raw string) (string, error) {
 	return resolved, nil
 }
 
-func makeParents(path string) ([]string, error) {
+// missingParent returns the deepest missing ancestor of path that is not in
+// declared, or "" if every missing ancestor is declared.
+func missingParent(path string, declared map[string]struct{}) (string, error) {
+	for cur := filepath.Dir(filepath.Clean(path)); ; cur = filepath.Dir(cur) {
+		if _, err := os.Stat(cur); err == nil {
+			return "", nil
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return "", fmt.Errorf("stat parent directory %s: %w", cur, err)
+		}
+		if _, ok := declared[cur]; !ok {
+			return cur, nil
+		}
+		if next := filepath.Dir(cur); next == cur || next == "." {
+			return "", nil
+		}
+	}
+}
+
+func makeParents(path string, perm os.FileMode, recordPath func(string)) ([]string, error) {
 	parent := filepath.Clean(filepath.Dir(path))
 	if parent == "." || parent == string(filepath.Separator) {
 		return nil, nil
@@ -1053,7 +2866,8 @@ func makeParents(path string) ([]string, error) {
 	created := make([]string, 0, len(missing))
 	for i := len(missing) - 1; i >= 0; i-- {
 		dir := missing[i]
-		if err := os.Mkdir(dir, 0o755); err != nil {
+		recordPath(dir)
+		if err := os.Mkdir(dir, perm); err != nil {
 			if errors.Is(err, os.ErrExist) {
 				info, statErr := os.Stat(dir)
 				if statErr == nil && info.IsDir() {
@@ -1068,6 +2882,97 @@ func makeParents(path string) ([]string, error) {
 	return created, nil
 }
 
+// defaultDirMode is the mode used for directories tohru creates.
+const defaultDirMode os.FileMode = 0o755
+
+// umask holds the optional mask applied to files and directories created
+// during apply. Without a mask, copied files keep their source modes and
+// directories are created with defaultDirMode subject to the process umask.
+type umask struct {
+	mask os.FileMode
+	set  bool
+}
+
+// key identifies the umask in cached fingerprints.
+func (u umask) key() string {
+	return fmt.Sprintf("%t:%o", u.set, u.mask)
+}
+
+func parseUmask(raw string) (umask, error) {
+	value := strings.TrimSpace(raw)
+	if value == "" {
+		return umask{}, nil
+	}
+	mask, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || mask > 0o777 {
+		return umask{}, fmt.Errorf("invalid umask %q (expected octal such as 022)", raw)
+	}
+	return umask{mask: os.FileMode(mask), set: true}, nil
+}
+
+func (u umask) dirMode() os.FileMode {
+	return defaultDirMode &^ u.mask
+}
+
+// apply chmods a created file or directory so its permissions respect the
+// mask regardless of the process umask. Symlinks are left untouched.
+func (u umask) apply(path string) error {
+	if !u.set {
+		return nil
+	}
+	info, err := os.Lstat(path)
+	if err != nil {
+		return fmt.Errorf("stat %s: %w", path, err)
+	}
+
+	var mode os.FileMode
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		return nil
+	case info.IsDir():
+		mode = u.dirMode()
+	default:
+		mode = info.Mode().Perm() &^ u.mask
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		return fmt.Errorf("chmod %s: %w", path, err)
+	}
+	return nil
+}
+
+// chmodFile sets the permissions of a copied file to a mode declared in the
+// manifest. The mode is explicit, so the mask doesn't apply; a zero mode and
+// symlinks are left alone.
+func chmodFile(path string, mode os.FileMode) error {
+	if mode == 0 {
+		return nil
+	}
+	info, err := os.Lstat(path)
+	if err != nil {
+		return fmt.Errorf("stat %s: %w", path, err)
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		return nil
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		return fmt.Errorf("chmod %s: %w", path, err)
+	}
+	return nil
+}
+
+// applyTree applies the mask to path and everything beneath it.
+func (u umask) applyTree(path string) error {
+	if !u.set {
+		return nil
+	}
+	return filepath.WalkDir(path, func(p string, _ fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		return u.apply(p)
+	})
+}
+
 func pruneAutoDirs(dirs []state.Dir, recordPath func(string)) error {
 	ordered := slices.Clone(dirs)
 	slices.SortFunc(ordered, func(a, b state.Dir) int {
@@ -1125,6 +3030,57 @@ func maybeSnapshot(path string) (state.Object, bool, error) {
 	return obj, true, nil
 }
 
+// snapshotTracked snapshots the tracked path f.Path, leaving out what
+// trackedExcludes lists.
+func snapshotTracked(f state.File, files []state.File) (state.Object, bool, error) {
+	d, err := digest.ForPathWith(f.Path, digest.Options{Exclude: trackedExcludes(f, files)})
+	if errors.Is(err, os.ErrNotExist) {
+		return state.Object{}, false, nil
+	} else if err != nil {
+		return state.Object{}, false, err
+	}
+	return state.Object{Path: f.Path, Digest: d.String()}, true, nil
+}
+
+// trackedExcludes lists what the digest of the tracked path f leaves out: for
+// a tracked directory, the paths among files tracked inside it and the
+// directories loading them created in between. They are loaded after it and
+// removed before it, so its recorded digest never covers them.
+func trackedExcludes(f state.File, files []state.File) []string {
+	if d, err := digest.Parse(f.Current.Digest); err != nil || d.Kind != digest.KindDir {
+		return nil
+	}
+	var exclude []string
+	for _, other := range files {
+		for path := other.Path; isWithin(f.Path, path); path = filepath.Dir(path) {
+			exclude = append(exclude, path)
+		}
+	}
+	return exclude
+}
+
+// snapshotTarget snapshots what the symlink at path resolves to. A dangling
+// link gives the target it names, with no digest.
+func snapshotTarget(path string) (*state.Object, error) {
+	resolved, err := filepath.EvalSymlinks(path)
+	if errors.Is(err, os.ErrNotExist) {
+		target, err := os.Readlink(path)
+		if err != nil {
+			return nil, err
+		}
+		return &state.Object{Path: target}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	obj, err := snapshot(resolved)
+	if err != nil {
+		return nil, err
+	}
+	return &obj, nil
+}
+
 func snapshot(path string) (state.Object, error) {
 	d, err := digest.ForPath(path)
 	if err != nil {
@@ -0,0 +1,2830 @@
+package store
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/olimci/tohru/pkg/digest"
+	"github.com/olimci/tohru/pkg/manifest"
+	"github.com/olimci/tohru/pkg/store/config"
+	"github.com/olimci/tohru/pkg/store/state"
+	"github.com/olimci/tohru/pkg/utils/fileutils"
+)
+
+func newTestStore(t testing.TB) (Store, string) {
+	t.Helper()
+	dir := t.TempDir()
+	home := filepath.Join(dir, "home")
+	if err := os.MkdirAll(home, 0o755); err != nil {
+		t.Fatalf("MkdirAll() error = %v", err)
+	}
+	return Store{Root: filepath.Join(dir, "store")}, home
+}
+
+func writeProfile(t testing.TB, roots ...manifest.Root) string {
+	t.Helper()
+	dir := t.TempDir()
+	m := manifest.Manifest{
+		Schema: manifest.SchemaVersion,
+		Profile: manifest.Profile{
+			Slug: "test",
+			Name: "test",
+		},
+		Roots: roots,
+	}
+	if err := manifest.Write(filepath.Join(dir, manifest.Name), m); err != nil {
+		t.Fatalf("manifest.Write() error = %v", err)
+	}
+	return dir
+}
+
+func writeTestFile(t testing.TB, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatalf("MkdirAll() error = %v", err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+}
+
+func mustDigest(t *testing.T, path string) string {
+	t.Helper()
+	d, err := digest.ForPath(path)
+	if err != nil {
+		t.Fatalf("digest.ForPath(%s) error = %v", path, err)
+	}
+	return d.String()
+}
+
+func TestSortOpsByDest(t *testing.T) {
+	ops := []op{
+		{Kind: opLink, Dest: filepath.Join("/home", "a", "b", "link")},
+		{Kind: opFile, Dest: filepath.Join("/home", "z")},
+		{Kind: opDir, Dest: filepath.Join("/home", "a")},
+		{Kind: opFile, Dest: filepath.Join("/home", "b")},
+	}
+
+	sortOps(ops)
+
+	want := []string{
+		filepath.Join("/home", "a"),
+		filepath.Join("/home", "b"),
+		filepath.Join("/home", "z"),
+		filepath.Join("/home", "a", "b", "link"),
+	}
+	for i, op := range ops {
+		if op.Dest != want[i] {
+			t.Fatalf("ops[%d].Dest = %q, want %q", i, op.Dest, want[i])
+		}
+	}
+}
+
+func TestLoadTrackedDirBacksUpExistingTree(t *testing.T) {
+	s, home := newTestStore(t)
+	data := filepath.Join(home, "data")
+	writeTestFile(t, filepath.Join(data, "a.txt"), "alpha\n")
+	writeTestFile(t, filepath.Join(data, "sub", "b.txt"), "beta\n")
+	original := mustDigest(t, data)
+
+	profile := writeProfile(t, manifest.Root{
+		Source: "home",
+		Dest:   home,
+		Tree: manifest.Tree{
+			"data": manifest.DirectoryNode(nil, nil),
+		},
+	})
+
+	if _, err := s.Load(profile, Options{}); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	entries, err := os.ReadDir(data)
+	if err != nil {
+		t.Fatalf("ReadDir() error = %v", err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("managed dir has %d entries, want 0", len(entries))
+	}
+
+	if _, err := s.Unload(Options{}); err != nil {
+		t.Fatalf("Unload() error = %v", err)
+	}
+	if got := mustDigest(t, data); got != original {
+		t.Fatalf("restored digest = %s, want %s", got, original)
+	}
+	raw, err := os.ReadFile(filepath.Join(data, "sub", "b.txt"))
+	if err != nil || string(raw) != "beta\n" {
+		t.Fatalf("restored sub/b.txt = %q, %v", raw, err)
+	}
+}
+
+func TestLoadTrackedDirWithChildren(t *testing.T) {
+	s, home := newTestStore(t)
+	cfg := filepath.Join(home, "cfg")
+	writeTestFile(t, filepath.Join(cfg, "old.txt"), "original\n")
+	original := mustDigest(t, cfg)
+
+	profile := writeProfile(t, manifest.Root{
+		Source: "home",
+		Dest:   home,
+		Tree: manifest.Tree{
+			"cfg": manifest.DirectoryNode([]string{"tracked"}, manifest.Tree{
+				"a.txt": manifest.FileNode("copy"),
+			}),
+		},
+	})
+	writeTestFile(t, filepath.Join(profile, "home", "cfg", "a.txt"), "managed\n")
+
+	// The directory used to be replaced after its child was copied into it,
+	// losing the child and backing up tohru's own output as the original.
+	if _, err := s.Load(profile, Options{}); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if raw, err := os.ReadFile(filepath.Join(cfg, "a.txt")); err != nil || string(raw) != "managed\n" {
+		t.Fatalf("cfg/a.txt = %q, %v, want the declared child loaded", raw, err)
+	}
+	if _, err := os.Lstat(filepath.Join(cfg, "old.txt")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Lstat(old.txt) error = %v, want the replaced tree backed up and removed", err)
+	}
+	st, err := s.LoadState()
+	if err != nil {
+		t.Fatalf("LoadState() error = %v", err)
+	}
+	for _, f := range st.Files {
+		if f.Path == cfg && (f.Previous == nil || f.Previous.Digest != original) {
+			t.Fatalf("cfg previous = %+v, want the original tree %s", f.Previous, original)
+		}
+	}
+
+	snap, err := s.Status()
+	if err != nil {
+		t.Fatalf("Status() error = %v", err)
+	}
+	for _, item := range snap.Tracked {
+		if item.Drifted {
+			t.Fatalf("%s drifted right after load, want the child left out of the directory's digest", item.Path)
+		}
+	}
+	if res, err := s.Reload(Options{}); err != nil || !res.Skipped {
+		t.Fatalf("Reload() = %+v, %v, want it skipped as unchanged", res, err)
+	}
+
+	if _, err := s.Unload(Options{}); err != nil {
+		t.Fatalf("Unload() error = %v", err)
+	}
+	if got := mustDigest(t, cfg); got != original {
+		t.Fatalf("restored digest = %s, want %s", got, original)
+	}
+
+	untracked := writeProfile(t, manifest.Root{
+		Source: "home",
+		Dest:   home,
+		Tree: manifest.Tree{
+			"cfg": manifest.DirectoryNode([]string{"tracked"}, manifest.Tree{
+				"a.txt": manifest.FileNode("copy", "untracked"),
+			}),
+		},
+	})
+	writeTestFile(t, filepath.Join(untracked, "home", "cfg", "a.txt"), "managed\n")
+	if _, err := s.Load(untracked, Options{}); err == nil || !strings.Contains(err.Error(), "inside tracked directory") {
+		t.Fatalf("Load() error = %v, want an untracked child of a tracked directory refused", err)
+	}
+}
+
+// TestLoadTrackedDirWithNestedChildren declares a child a level below a
+// tracked directory, so loading it creates the directory in between.
+func TestLoadTrackedDirWithNestedChildren(t *testing.T) {
+	s, home := newTestStore(t)
+	cfg := filepath.Join(home, "cfg")
+	profile := writeProfile(t, manifest.Root{
+		Source: "home",
+		Dest:   home,
+		Tree: manifest.Tree{
+			"cfg": manifest.DirectoryNode([]string{"tracked"}, manifest.Tree{
+				"sub": manifest.DirectoryNode(nil, manifest.Tree{
+					"a.txt": manifest.FileNode("copy"),
+				}),
+			}),
+		},
+	})
+	writeTestFile(t, filepath.Join(profile, "home", "cfg", "sub", "a.txt"), "managed\n")
+
+	if _, err := s.Load(profile, Options{}); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if raw, err := os.ReadFile(filepath.Join(cfg, "sub", "a.txt")); err != nil || string(raw) != "managed\n" {
+		t.Fatalf("cfg/sub/a.txt = %q, %v, want the declared child loaded", raw, err)
+	}
+	snap, err := s.Status()
+	if err != nil {
+		t.Fatalf("Status() error = %v", err)
+	}
+	for _, item := range snap.Tracked {
+		if item.Drifted {
+			t.Fatalf("%s drifted right after load, want the directories created for its children left out", item.Path)
+		}
+	}
+	if res, err := s.Reload(Options{}); err != nil || !res.Skipped {
+		t.Fatalf("Reload() = %+v, %v, want it skipped as unchanged", res, err)
+	}
+
+	if _, err := s.Unload(Options{}); err != nil {
+		t.Fatalf("Unload() error = %v", err)
+	}
+	if _, err := os.Lstat(cfg); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Lstat(cfg) error = %v, want it removed by unload", err)
+	}
+}
+
+func TestUnloadDetectsTruncatedRestore(t *testing.T) {
+	s, home := newTestStore(t)
+	zshrc := filepath.Join(home, ".zshrc")
+	writeTestFile(t, zshrc, "original contents\n")
+
+	profile := writeProfile(t, manifest.Root{
+		Source:   "home",
+		Dest:     home,
+		Defaults: &manifest.Defaults{Type: "copy"},
+		Tree: manifest.Tree{
+			".zshrc": manifest.FileNode(),
+		},
+	})
+	writeTestFile(t, filepath.Join(profile, "home", "dot_zshrc"), "managed\n")
+
+	if _, err := s.Load(profile, Options{}); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	copyPathFunc = func(src, dest string, _ fileutils.CopyOptions) error {
+		return os.WriteFile(dest, []byte("orig"), 0o644)
+	}
+	t.Cleanup(func() { copyPathFunc = fileutils.CopyPathWith })
+
+	_, err := s.Unload(Options{})
+	if err == nil || !strings.Contains(err.Error(), "restored digest mismatch") {
+		t.Fatalf("Unload() error = %v, want restored digest mismatch", err)
+	}
+
+	copyPathFunc = fileutils.CopyPathWith
+	res, err := s.Unload(Options{})
+	if err != nil {
+		t.Fatalf("Unload() error = %v", err)
+	}
+	if res.RestoredCount != 1 {
+		t.Fatalf("RestoredCount = %d, want 1", res.RestoredCount)
+	}
+	raw, err := os.ReadFile(zshrc)
+	if err != nil || string(raw) != "original contents\n" {
+		t.Fatalf("restored .zshrc = %q, %v", raw, err)
+	}
+}
+
+func TestLoadAppliesUmask(t *testing.T) {
+	s, home := newTestStore(t)
+	profile := writeProfile(t, manifest.Root{
+		Source:   "home",
+		Dest:     home,
+		Defaults: &manifest.Defaults{Type: "copy"},
+		Tree: manifest.Tree{
+			".config": manifest.DirectoryNode(nil, manifest.Tree{
+				"app": manifest.DirectoryNode(nil, manifest.Tree{
+					"conf": manifest.FileNode(),
+				}),
+			}),
+		},
+	})
+	writeTestFile(t, filepath.Join(profile, "home", "dot_config", "app", "conf"), "x\n")
+
+	if _, err := s.Load(profile, Options{Umask: "077"}); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	for path, want := range map[string]os.FileMode{
+		filepath.Join(home, ".config"):                0o700,
+		filepath.Join(home, ".config", "app"):         0o700,
+		filepath.Join(home, ".config", "app", "conf"): 0o600,
+	} {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("Stat(%s) error = %v", path, err)
+		}
+		if got := info.Mode().Perm(); got != want {
+			t.Fatalf("mode of %s = %o, want %o", path, got, want)
+		}
+	}
+}
+
+func TestLoadUmaskLeavesExistingDirs(t *testing.T) {
+	s, home := newTestStore(t)
+	profile := writeProfile(t, manifest.Root{
+		Source: "home",
+		Dest:   home,
+		Tree: manifest.Tree{
+			".config": manifest.DirectoryNode([]string{"untracked"}, manifest.Tree{
+				"app": manifest.DirectoryNode([]string{"untracked"}, nil),
+			}),
+		},
+	})
+	config := filepath.Join(home, ".config")
+	if err := os.Mkdir(config, 0o755); err != nil {
+		t.Fatalf("Mkdir() error = %v", err)
+	}
+
+	res, err := s.Load(profile, Options{Umask: "077"})
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if i := slices.IndexFunc(res.Operations, func(op AppliedOp) bool { return op.Path == config }); i < 0 || res.Operations[i].Action != ActionKept {
+		t.Fatalf("Operations = %+v, want %s kept", res.Operations, config)
+	}
+
+	for path, want := range map[string]os.FileMode{
+		config:                       0o755,
+		filepath.Join(config, "app"): 0o700,
+	} {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("Stat(%s) error = %v", path, err)
+		}
+		if got := info.Mode().Perm(); got != want {
+			t.Fatalf("mode of %s = %o, want %o", path, got, want)
+		}
+	}
+}
+
+func TestLoadAppliesFileModes(t *testing.T) {
+	s, home := newTestStore(t)
+	profile := writeProfile(t, manifest.Root{
+		Source:   "home",
+		Dest:     home,
+		Defaults: &manifest.Defaults{Type: "copy", Mode: "0600"},
+		Tree: manifest.Tree{
+			".netrc": manifest.FileNode(),
+			".zshrc": manifest.FileNode("mode:0640"),
+		},
+		Inline: map[string]string{".token": "secret\n"},
+	})
+	writeTestFile(t, filepath.Join(profile, "home", "dot_netrc"), "x\n")
+	writeTestFile(t, filepath.Join(profile, "home", "dot_zshrc"), "x\n")
+
+	if _, err := s.Load(profile, Options{Umask: "077"}); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	for path, want := range map[string]os.FileMode{
+		filepath.Join(home, ".netrc"): 0o600,
+		filepath.Join(home, ".zshrc"): 0o640, // the entry's mode wins over both the defaults and the umask
+		filepath.Join(home, ".token"): 0o600,
+	} {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("Stat(%s) error = %v", path, err)
+		}
+		if got := info.Mode().Perm(); got != want {
+			t.Fatalf("mode of %s = %o, want %o", path, got, want)
+		}
+	}
+}
+
+func TestLoadCopiesDirectoryRecursively(t *testing.T) {
+	s, home := newTestStore(t)
+	profile := writeProfile(t, manifest.Root{
+		Source: "home",
+		Dest:   home,
+		Tree: manifest.Tree{
+			"themes": manifest.DirectoryNode([]string{"copy"}, nil),
+		},
+	})
+	src := filepath.Join(profile, "home", "themes")
+	writeTestFile(t, filepath.Join(src, "dark.conf"), "dark\n")
+	writeTestFile(t, filepath.Join(src, "extra", "light.conf"), "light\n")
+
+	res, err := s.Load(profile, Options{})
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if res.TrackedCount != 1 {
+		t.Fatalf("TrackedCount = %d, want 1", res.TrackedCount)
+	}
+	dest := filepath.Join(home, "themes")
+	if got, want := mustDigest(t, dest), mustDigest(t, src); got != want {
+		t.Fatalf("copied digest = %s, want %s", got, want)
+	}
+
+	if _, err := s.Unload(Options{}); err != nil {
+		t.Fatalf("Unload() error = %v", err)
+	}
+	if _, err := os.Lstat(dest); !os.IsNotExist(err) {
+		t.Fatalf("Lstat(%s) error = %v, want not exist", dest, err)
+	}
+}
+
+func TestLoadTwiceIsNoop(t *testing.T) {
+	s, home := newTestStore(t)
+	profile := writeProfile(t, manifest.Root{
+		Source:   "home",
+		Dest:     home,
+		Defaults: &manifest.Defaults{Type: "copy"},
+		Tree: manifest.Tree{
+			".zshrc": manifest.FileNode(),
+			".config": manifest.DirectoryNode(nil, manifest.Tree{
+				"app": manifest.FileNode("link"),
+			}),
+		},
+	})
+	writeTestFile(t, filepath.Join(profile, "home", "dot_zshrc"), "managed\n")
+	writeTestFile(t, filepath.Join(profile, "home", "dot_config", "app"), "app\n")
+
+	if _, err := s.Load(profile, Options{}); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	zshrc := filepath.Join(home, ".zshrc")
+	past := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(zshrc, past, past); err != nil {
+		t.Fatalf("Chtimes() error = %v", err)
+	}
+
+	res, err := s.Load(profile, Options{})
+	if err != nil {
+		t.Fatalf("second Load() error = %v", err)
+	}
+	if !res.Skipped {
+		t.Fatalf("second Load() Skipped = false, want true")
+	}
+	if len(res.ChangedPaths) != 0 {
+		t.Fatalf("second Load() changed paths %v, want none", res.ChangedPaths)
+	}
+	info, err := os.Stat(zshrc)
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if !info.ModTime().Equal(past) {
+		t.Fatalf("mtime of %s = %v, want %v", zshrc, info.ModTime(), past)
+	}
+
+	writeTestFile(t, filepath.Join(profile, "home", "dot_zshrc"), "updated\n")
+	res, err = s.Load(profile, Options{})
+	if err != nil {
+		t.Fatalf("third Load() error = %v", err)
+	}
+	if res.Skipped {
+		t.Fatalf("third Load() Skipped = true after source change, want false")
+	}
+}
+
+func TestReloadPutsBackUntrackedPaths(t *testing.T) {
+	s, home := newTestStore(t)
+	profile := writeProfile(t, manifest.Root{
+		Source:   "home",
+		Dest:     home,
+		Defaults: &manifest.Defaults{Type: "copy"},
+		Tree: manifest.Tree{
+			".zshrc":     manifest.FileNode(),
+			".hushlogin": manifest.FileNode("untracked"),
+			".cache":     manifest.DirectoryNode([]string{"untracked"}, nil),
+		},
+	})
+	writeTestFile(t, filepath.Join(profile, "home", "dot_zshrc"), "zsh\n")
+	writeTestFile(t, filepath.Join(profile, "home", "dot_hushlogin"), "quiet\n")
+	if _, err := s.Load(profile, Options{}); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	hushlogin := filepath.Join(home, ".hushlogin")
+	cache := filepath.Join(home, ".cache")
+	for _, path := range []string{hushlogin, cache} {
+		if err := os.Remove(path); err != nil {
+			t.Fatalf("Remove() error = %v", err)
+		}
+	}
+	if res, err := s.Reload(Options{}); err != nil || res.Skipped {
+		t.Fatalf("Reload() with untracked paths gone = %+v, %v, want them put back", res, err)
+	}
+	for _, path := range []string{hushlogin, cache} {
+		if _, err := os.Lstat(path); err != nil {
+			t.Fatalf("%s after Reload(): %v", path, err)
+		}
+	}
+
+	writeTestFile(t, hushlogin, "edited\n")
+	if res, err := s.Reload(Options{}); err != nil || !res.Skipped {
+		t.Fatalf("Reload() with an untracked path edited = %+v, %v, want it skipped", res, err)
+	}
+	if res, err := s.Reload(Options{Force: true}); err != nil || res.Skipped {
+		t.Fatalf("Reload(Force) = %+v, %v, want it applied", res, err)
+	}
+	raw, err := os.ReadFile(hushlogin)
+	if err != nil || string(raw) != "quiet\n" {
+		t.Fatalf(".hushlogin after Reload(Force) = %q, %v, want %q", raw, err, "quiet\n")
+	}
+}
+
+func TestStatusByRootGroupsByDeclaringRoot(t *testing.T) {
+	s, home := newTestStore(t)
+	etc := filepath.Join(filepath.Dir(home), "etc")
+	profile := writeProfile(t,
+		manifest.Root{
+			Source:   "home",
+			Dest:     home,
+			Defaults: &manifest.Defaults{Type: "copy"},
+			Tree:     manifest.Tree{".zshrc": manifest.FileNode()},
+		},
+		manifest.Root{
+			Source:   "etc",
+			Dest:     etc,
+			Defaults: &manifest.Defaults{Type: "copy"},
+			Tree:     manifest.Tree{"hosts": manifest.FileNode()},
+		},
+	)
+	writeTestFile(t, filepath.Join(profile, "home", "dot_zshrc"), "zsh\n")
+	writeTestFile(t, filepath.Join(profile, "etc", "hosts"), "hosts\n")
+
+	if _, err := s.Load(profile, Options{}); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	snapshot, err := s.Status()
+	if err != nil {
+		t.Fatalf("Status() error = %v", err)
+	}
+	roots, err := s.StatusByRoot(snapshot)
+	if err != nil {
+		t.Fatalf("StatusByRoot() error = %v", err)
+	}
+
+	if len(roots) != 2 {
+		t.Fatalf("len(roots) = %d, want 2", len(roots))
+	}
+	for i, want := range []string{filepath.Join(home, ".zshrc"), filepath.Join(etc, "hosts")} {
+		if len(roots[i].Tracked) != 1 || roots[i].Tracked[0].Path != want {
+			t.Fatalf("roots[%d].Tracked = %#v, want only %s", i, roots[i].Tracked, want)
+		}
+	}
+}
+
+func TestStatusByRootGroupsAddedProfiles(t *testing.T) {
+	s, home := newTestStore(t)
+	etc := filepath.Join(filepath.Dir(home), "etc")
+	dir := t.TempDir()
+	m := manifest.Manifest{
+		Schema:  manifest.SchemaVersion,
+		Profile: manifest.Profile{Slug: "tools", Name: "tools"},
+		Roots: []manifest.Root{
+			{
+				Source:   "home",
+				Dest:     home,
+				Defaults: &manifest.Defaults{Type: "copy"},
+				Tree:     manifest.Tree{".zshrc": manifest.FileNode()},
+			},
+			{
+				Source:   "etc",
+				Dest:     etc,
+				Defaults: &manifest.Defaults{Type: "copy"},
+				Tree:     manifest.Tree{"hosts": manifest.FileNode()},
+			},
+		},
+	}
+	if err := manifest.Write(filepath.Join(dir, manifest.Name), m); err != nil {
+		t.Fatalf("manifest.Write() error = %v", err)
+	}
+	writeTestFile(t, filepath.Join(dir, "home", "dot_zshrc"), "zsh\n")
+	writeTestFile(t, filepath.Join(dir, "etc", "hosts"), "hosts\n")
+
+	// Only an added profile is loaded, with no main profile beside it.
+	if _, err := s.Load(dir, Options{Add: true}); err != nil {
+		t.Fatalf("Load(Add) error = %v", err)
+	}
+	snapshot, err := s.Status()
+	if err != nil {
+		t.Fatalf("Status() error = %v", err)
+	}
+	roots, err := s.StatusByRoot(snapshot)
+	if err != nil {
+		t.Fatalf("StatusByRoot() error = %v", err)
+	}
+
+	if len(roots) != 2 {
+		t.Fatalf("len(roots) = %d, want 2", len(roots))
+	}
+	for i, want := range []string{filepath.Join(home, ".zshrc"), filepath.Join(etc, "hosts")} {
+		if roots[i].Profile != "tools" || roots[i].Index != i {
+			t.Fatalf("roots[%d] = %+v, want roots[%d] of tools", i, roots[i], i)
+		}
+		if len(roots[i].Tracked) != 1 || roots[i].Tracked[0].Path != want {
+			t.Fatalf("roots[%d].Tracked = %#v, want only %s", i, roots[i].Tracked, want)
+		}
+	}
+}
+
+func TestUnloadKeepFilesLeavesDestinations(t *testing.T) {
+	s, home := newTestStore(t)
+	zshrc := filepath.Join(home, ".zshrc")
+	writeTestFile(t, zshrc, "original\n")
+
+	profile := writeProfile(t, manifest.Root{
+		Source:   "home",
+		Dest:     home,
+		Defaults: &manifest.Defaults{Type: "copy"},
+		Tree: manifest.Tree{
+			".zshrc": manifest.FileNode(),
+		},
+	})
+	writeTestFile(t, filepath.Join(profile, "home", "dot_zshrc"), "managed\n")
+
+	if _, err := s.Load(profile, Options{}); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	res, err := s.Unload(Options{KeepFiles: true})
+	if err != nil {
+		t.Fatalf("Unload() error = %v", err)
+	}
+	if res.RemovedCount != 0 || res.UntrackedCount != 1 || res.RestoredCount != 0 {
+		t.Fatalf("Unload() counts = removed %d, untracked %d, restored %d, want 0, 1, 0", res.RemovedCount, res.UntrackedCount, res.RestoredCount)
+	}
+
+	raw, err := os.ReadFile(zshrc)
+	if err != nil || string(raw) != "managed\n" {
+		t.Fatalf(".zshrc = %q, %v, want managed contents left in place", raw, err)
+	}
+	lck, err := s.LoadState()
+	if err != nil {
+		t.Fatalf("LoadState() error = %v", err)
+	}
+	if lck.Profile.State != "unloaded" || len(lck.Files) != 0 {
+		t.Fatalf("state after unload = %#v, want unloaded with no files", lck)
+	}
+}
+
+func TestTidyFiltersByPath(t *testing.T) {
+	s, home := newTestStore(t)
+	t.Setenv("HOME", home)
+	if _, err := s.Install(); err != nil {
+		t.Fatalf("Install() error = %v", err)
+	}
+
+	// Two orphaned backups know where they were taken from, and a stashed one
+	// without metadata is matched by the path the stash records.
+	backup := func(path, content string) string {
+		writeTestFile(t, path, content)
+		cid := mustDigest(t, path)
+		if _, err := s.backups().Persist(cid, path, func(string) {}); err != nil {
+			t.Fatalf("Persist() error = %v", err)
+		}
+		return cid
+	}
+	nested := backup(filepath.Join(home, ".config", "a"), "a\n")
+	bashrc := backup(filepath.Join(home, ".bashrc"), "bashrc\n")
+	stashed := backup(filepath.Join(home, ".config", "c"), "c\n")
+	if err := os.Remove(backupMetaPath(backupPath(s, stashed, ""))); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	lck, err := s.LoadState()
+	if err != nil {
+		t.Fatalf("LoadState() error = %v", err)
+	}
+	lck.Stashed = []state.Stash{{Path: filepath.Join(home, ".config", "c"), Backup: state.Object{Digest: stashed}}}
+	if err := s.SaveState(lck); err != nil {
+		t.Fatalf("SaveState() error = %v", err)
+	}
+
+	res, err := s.Tidy(TidyOptions{Include: []string{"~/.config/**"}, Stashed: true})
+	if err != nil {
+		t.Fatalf("Tidy() error = %v", err)
+	}
+	if res.RemovedCount != 2 {
+		t.Fatalf("RemovedCount = %d, want 2", res.RemovedCount)
+	}
+	for _, cid := range []string{nested, stashed} {
+		if _, err := os.Stat(backupPath(s, cid, "")); !os.IsNotExist(err) {
+			t.Fatalf("backup of a path under ~/.config left behind: %v", err)
+		}
+	}
+	if _, err := os.Stat(backupPath(s, bashrc, "")); err != nil {
+		t.Fatalf("backup of ~/.bashrc was removed: %v", err)
+	}
+
+	if res, err := s.Tidy(TidyOptions{Exclude: []string{"~/.bashrc"}}); err != nil || res.RemovedCount != 0 {
+		t.Fatalf("Tidy() excluding ~/.bashrc = %+v, %v, want nothing removed", res, err)
+	}
+}
+
+func TestTidyRemovesBrokenBackups(t *testing.T) {
+	s, _ := newTestStore(t)
+	if _, err := s.Install(); err != nil {
+		t.Fatalf("Install() error = %v", err)
+	}
+	broken := filepath.Join(s.BackupsPath(), "file:sha256:cc")
+	if err := os.MkdirAll(broken, 0o755); err != nil {
+		t.Fatalf("MkdirAll() error = %v", err)
+	}
+	writeTestFile(t, backupPath(s, "file:sha256:aa", ""), "x")
+
+	res, err := s.Tidy(TidyOptions{})
+	if err != nil {
+		t.Fatalf("Tidy() error = %v", err)
+	}
+	if res.RemovedBrokenCount != 1 || res.RemovedCount != 1 {
+		t.Fatalf("Tidy() removed %d broken and %d unreferenced, want 1 and 1", res.RemovedBrokenCount, res.RemovedCount)
+	}
+	if _, err := os.Stat(broken); !os.IsNotExist(err) {
+		t.Fatalf("broken backup still present (err = %v)", err)
+	}
+}
+
+func TestTidyByCategory(t *testing.T) {
+	s, _ := newTestStore(t)
+	if _, err := s.Install(); err != nil {
+		t.Fatalf("Install() error = %v", err)
+	}
+	broken := filepath.Join(s.BackupsPath(), "file:sha256:cc")
+	if err := os.MkdirAll(broken, 0o755); err != nil {
+		t.Fatalf("MkdirAll() error = %v", err)
+	}
+	corrupted := "file:sha256:aa"
+	writeTestFile(t, backupPath(s, corrupted, ""), "x")
+	src := filepath.Join(t.TempDir(), "orphan")
+	writeTestFile(t, src, "orphan\n")
+	orphan := mustDigest(t, src)
+	writeTestFile(t, backupPath(s, orphan, ""), "orphan\n")
+
+	exists := func(path string) bool {
+		_, err := os.Stat(path)
+		return err == nil
+	}
+	for _, tt := range []struct {
+		opts TidyOptions
+		want TidyResult
+		left []string
+	}{
+		{
+			opts: TidyOptions{Broken: true},
+			want: TidyResult{RemovedBrokenCount: 1},
+			left: []string{backupPath(s, corrupted, ""), backupPath(s, orphan, "")},
+		},
+		{
+			opts: TidyOptions{Corrupted: true},
+			want: TidyResult{RemovedCorruptedCount: 1},
+			left: []string{backupPath(s, orphan, "")},
+		},
+		{
+			opts: TidyOptions{Orphans: true},
+			want: TidyResult{RemovedCount: 1},
+		},
+	} {
+		res, err := s.Tidy(tt.opts)
+		if err != nil {
+			t.Fatalf("Tidy(%+v) error = %v", tt.opts, err)
+		}
+		if res.RemovedCount != tt.want.RemovedCount || res.RemovedBrokenCount != tt.want.RemovedBrokenCount ||
+			res.RemovedCorruptedCount != tt.want.RemovedCorruptedCount {
+			t.Fatalf("Tidy(%+v) = %+v, want counts of %+v", tt.opts, res, tt.want)
+		}
+		for _, path := range tt.left {
+			if !exists(path) {
+				t.Fatalf("Tidy(%+v) removed %s", tt.opts, path)
+			}
+		}
+	}
+	if exists(broken) || exists(backupPath(s, corrupted, "")) || exists(backupPath(s, orphan, "")) {
+		t.Fatalf("backups left after tidying every category")
+	}
+}
+
+func TestLoadWritesInlineContent(t *testing.T) {
+	s, home := newTestStore(t)
+	profile := writeProfile(t, manifest.Root{
+		Source: "home",
+		Dest:   home,
+		Inline: map[string]string{".gitignore": "*.log\n"},
+	})
+
+	if _, err := s.Load(profile, Options{}); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	path := filepath.Join(home, ".gitignore")
+	raw, err := os.ReadFile(path)
+	if err != nil || string(raw) != "*.log\n" {
+		t.Fatalf(".gitignore = %q, %v", raw, err)
+	}
+
+	lck, err := s.LoadState()
+	if err != nil {
+		t.Fatalf("LoadState() error = %v", err)
+	}
+	if len(lck.Files) != 1 || lck.Files[0].Current.Digest != mustDigest(t, path) {
+		t.Fatalf("tracked files = %#v, want inline file tracked by its content digest", lck.Files)
+	}
+}
+
+func TestStatusReportsAutoDirs(t *testing.T) {
+	s, home := newTestStore(t)
+	profile := writeProfile(t, manifest.Root{
+		Source:   "home",
+		Dest:     home,
+		Defaults: &manifest.Defaults{Type: "copy"},
+		Tree: manifest.Tree{
+			".config": manifest.DirectoryNode(nil, manifest.Tree{
+				"app": manifest.DirectoryNode(nil, manifest.Tree{
+					"conf": manifest.FileNode(),
+				}),
+			}),
+		},
+	})
+	writeTestFile(t, filepath.Join(profile, "home", "dot_config", "app", "conf"), "x\n")
+	if _, err := s.Load(profile, Options{}); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	stray := filepath.Join(home, ".config", "stray")
+	writeTestFile(t, stray, "user file\n")
+
+	snapshot, err := s.Status()
+	if err != nil {
+		t.Fatalf("Status() error = %v", err)
+	}
+	if len(snapshot.AutoDirs) != 2 {
+		t.Fatalf("AutoDirs = %#v, want 2 entries", snapshot.AutoDirs)
+	}
+	config, app := snapshot.AutoDirs[0], snapshot.AutoDirs[1]
+	if config.OnlyManaged || len(config.Unmanaged) != 1 || config.Unmanaged[0] != stray {
+		t.Fatalf(".config status = %#v, want %s unmanaged", config, stray)
+	}
+	if !app.OnlyManaged {
+		t.Fatalf(".config/app status = %#v, want only managed entries", app)
+	}
+}
+
+func TestReloadBackupDriftedStashesEdits(t *testing.T) {
+	s, home := newTestStore(t)
+	profile := writeProfile(t, manifest.Root{
+		Source:   "home",
+		Dest:     home,
+		Defaults: &manifest.Defaults{Type: "copy"},
+		Tree: manifest.Tree{
+			".zshrc": manifest.FileNode(),
+		},
+	})
+	writeTestFile(t, filepath.Join(profile, "home", "dot_zshrc"), "managed\n")
+	if _, err := s.Load(profile, Options{}); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	zshrc := filepath.Join(home, ".zshrc")
+	writeTestFile(t, zshrc, "my edits\n")
+	edited := mustDigest(t, zshrc)
+
+	if _, err := s.Reload(Options{}); err == nil {
+		t.Fatalf("Reload() error = nil, want modified error")
+	}
+	res, err := s.Reload(Options{BackupDrifted: true})
+	if err != nil {
+		t.Fatalf("Reload(BackupDrifted) error = %v", err)
+	}
+	if len(res.StashedPaths) != 1 || res.StashedPaths[0] != zshrc {
+		t.Fatalf("StashedPaths = %v, want [%s]", res.StashedPaths, zshrc)
+	}
+	raw, err := os.ReadFile(zshrc)
+	if err != nil || string(raw) != "managed\n" {
+		t.Fatalf(".zshrc = %q, %v, want managed contents", raw, err)
+	}
+
+	// The stash must survive pruning and later loads.
+	if _, err := s.Unload(Options{}); err != nil {
+		t.Fatalf("Unload() error = %v", err)
+	}
+	raw, err = os.ReadFile(backupPath(s, edited, ""))
+	if err != nil || string(raw) != "my edits\n" {
+		t.Fatalf("stashed backup = %q, %v, want edited contents", raw, err)
+	}
+
+	if _, err := s.Tidy(TidyOptions{Stashed: true}); err != nil {
+		t.Fatalf("Tidy() error = %v", err)
+	}
+	if _, err := os.Stat(backupPath(s, edited, "")); !os.IsNotExist(err) {
+		t.Fatalf("stashed backup still present after tidy --stashed: %v", err)
+	}
+}
+
+func TestLookupResolvesDeclaredSource(t *testing.T) {
+	s, home := newTestStore(t)
+	profile := writeProfile(t, manifest.Root{
+		Source:   "home",
+		Dest:     home,
+		Defaults: &manifest.Defaults{Type: "link"},
+		Tree: manifest.Tree{
+			".zshrc": manifest.FileNode(),
+		},
+		Inline: map[string]string{".hushlogin": ""},
+	})
+	writeTestFile(t, filepath.Join(profile, "home", "dot_zshrc"), "zsh\n")
+	if _, err := s.Load(profile, Options{}); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	got, err := s.Lookup(filepath.Join(home, ".zshrc"))
+	if err != nil {
+		t.Fatalf("Lookup() error = %v", err)
+	}
+	if got.Kind != "link" || got.Source != filepath.Join(profile, "home", "dot_zshrc") {
+		t.Fatalf("Lookup(.zshrc) = %#v", got)
+	}
+
+	got, err = s.Lookup(filepath.Join(home, ".hushlogin"))
+	if err != nil || got.Kind != "file" || got.Source != "" {
+		t.Fatalf("Lookup(.hushlogin) = %#v, %v, want inline file", got, err)
+	}
+
+	if _, err := s.Lookup(filepath.Join(home, ".bashrc")); !errors.Is(err, ErrNotDeclared) {
+		t.Fatalf("Lookup(.bashrc) error = %v, want ErrNotDeclared", err)
+	}
+}
+
+func TestLoadKeepsSatisfiedLink(t *testing.T) {
+	s, home := newTestStore(t)
+	profile := writeProfile(t, manifest.Root{
+		Source: "home",
+		Dest:   home,
+		Tree: manifest.Tree{
+			".vimrc": manifest.FileNode("link"),
+			".zshrc": manifest.FileNode("link"),
+		},
+	})
+	writeTestFile(t, filepath.Join(profile, "home", "dot_vimrc"), "vim\n")
+	writeTestFile(t, filepath.Join(profile, "home", "dot_zshrc"), "zsh\n")
+
+	cfg := DefaultConfig()
+	cfg.Options.Backups.Enabled = false
+	if err := os.MkdirAll(s.Root, 0o755); err != nil {
+		t.Fatalf("MkdirAll() error = %v", err)
+	}
+	if err := encodeJSON(s.ConfigPath(), cfg); err != nil {
+		t.Fatalf("encodeJSON() error = %v", err)
+	}
+
+	vimrc := filepath.Join(home, ".vimrc")
+	zshrc := filepath.Join(home, ".zshrc")
+	if err := os.Symlink(filepath.Join(profile, "home", "dot_vimrc"), vimrc); err != nil {
+		t.Fatalf("Symlink() error = %v", err)
+	}
+	if err := os.Symlink(filepath.Join(profile, "home", "dot_zshrc"), zshrc); err != nil {
+		t.Fatalf("Symlink() error = %v", err)
+	}
+	before, err := os.Lstat(vimrc)
+	if err != nil {
+		t.Fatalf("Lstat() error = %v", err)
+	}
+
+	if _, err := s.Load(profile, Options{}); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	after, err := os.Lstat(vimrc)
+	if err != nil {
+		t.Fatalf("Lstat() error = %v", err)
+	}
+	if !os.SameFile(before, after) {
+		t.Fatalf("%s was re-created, want the existing link kept", vimrc)
+	}
+	st, err := s.LoadState()
+	if err != nil {
+		t.Fatalf("LoadState() error = %v", err)
+	}
+	if len(st.Files) != 2 {
+		t.Fatalf("tracked %d files, want 2", len(st.Files))
+	}
+
+	if _, err := s.Unload(Options{}); err != nil {
+		t.Fatalf("Unload() error = %v", err)
+	}
+
+	if err := os.Symlink(filepath.Join(home, "elsewhere"), vimrc); err != nil {
+		t.Fatalf("Symlink() error = %v", err)
+	}
+	if _, err := s.Load(profile, Options{}); err == nil {
+		t.Fatalf("Load() over a link to another target succeeded, want clobber error")
+	}
+	if _, err := s.Load(profile, Options{Force: true}); err != nil {
+		t.Fatalf("Load(force) error = %v", err)
+	}
+	target, err := os.Readlink(vimrc)
+	if err != nil {
+		t.Fatalf("Readlink() error = %v", err)
+	}
+	if want := filepath.Join(profile, "home", "dot_vimrc"); target != want {
+		t.Fatalf("%s -> %s, want %s", vimrc, target, want)
+	}
+}
+
+func TestLoadFromArchive(t *testing.T) {
+	s, home := newTestStore(t)
+	profile := writeProfile(t, manifest.Root{
+		Source:   "home",
+		Dest:     home,
+		Defaults: &manifest.Defaults{Type: "copy"},
+		Tree:     manifest.Tree{".zshrc": manifest.FileNode()},
+	})
+	writeTestFile(t, filepath.Join(profile, "home", "dot_zshrc"), "v1\n")
+
+	archive := filepath.Join(t.TempDir(), "dotfiles.tar")
+	writeTar := func() {
+		t.Helper()
+		f, err := os.Create(archive)
+		if err != nil {
+			t.Fatalf("Create() error = %v", err)
+		}
+		defer f.Close()
+		tw := tar.NewWriter(f)
+		if err := tw.AddFS(os.DirFS(profile)); err != nil {
+			t.Fatalf("AddFS() error = %v", err)
+		}
+		if err := tw.Close(); err != nil {
+			t.Fatalf("Close() error = %v", err)
+		}
+	}
+	writeTar()
+
+	if _, err := s.Load(archive, Options{}); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	st, err := s.LoadState()
+	if err != nil {
+		t.Fatalf("LoadState() error = %v", err)
+	}
+	if st.Profile.Kind != archiveKind || st.Profile.Archive != archive {
+		t.Fatalf("profile = %+v, want archive kind recording %s", st.Profile, archive)
+	}
+	first := st.Profile.Path
+
+	writeTestFile(t, filepath.Join(profile, "home", "dot_zshrc"), "v2\n")
+	writeTar()
+	if _, err := s.Reload(Options{}); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	got, err := os.ReadFile(filepath.Join(home, ".zshrc"))
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if string(got) != "v2\n" {
+		t.Fatalf(".zshrc = %q, want re-extracted content", got)
+	}
+
+	res, err := s.GC(GCOptions{})
+	if err != nil {
+		t.Fatalf("GC() error = %v", err)
+	}
+	if res.ExtractedCount != 1 {
+		t.Fatalf("GC() ExtractedCount = %d, want 1", res.ExtractedCount)
+	}
+	if _, err := os.Stat(first); !os.IsNotExist(err) {
+		t.Fatalf("previous extraction %s still exists (err = %v)", first, err)
+	}
+}
+
+func TestLoadManifestDir(t *testing.T) {
+	s, home := newTestStore(t)
+	repo := t.TempDir()
+	dotfiles := filepath.Join(repo, "tools", "dotfiles")
+	writeManifest := func(source string) {
+		t.Helper()
+		m := manifest.Manifest{
+			Schema:  manifest.SchemaVersion,
+			Profile: manifest.Profile{Slug: "test", Name: "test"},
+			Roots: []manifest.Root{{
+				Source:   source,
+				Dest:     home,
+				Defaults: &manifest.Defaults{Type: "copy"},
+				Tree:     manifest.Tree{".zshrc": manifest.FileNode()},
+			}},
+		}
+		if err := manifest.Write(filepath.Join(dotfiles, manifest.Name), m); err != nil {
+			t.Fatalf("manifest.Write() error = %v", err)
+		}
+	}
+	writeTestFile(t, filepath.Join(dotfiles, "home", "dot_zshrc"), "v1\n")
+	writeTestFile(t, filepath.Join(repo, "shared", "dot_zshrc"), "shared\n")
+
+	// sources are resolved against the manifest directory, not the source
+	writeManifest("../../shared")
+	if _, err := s.Load(repo, Options{ManifestDir: "tools/dotfiles"}); err == nil || !strings.Contains(err.Error(), "escapes source root") {
+		t.Fatalf("Load() error = %v, want the source outside the manifest directory rejected", err)
+	}
+	writeManifest("home")
+	for _, dir := range []string{"../repo", "/tools/dotfiles", "missing"} {
+		if _, err := s.Load(repo, Options{ManifestDir: dir}); err == nil {
+			t.Fatalf("Load(ManifestDir %q) error = nil, want rejected", dir)
+		}
+	}
+	outside := t.TempDir()
+	if err := os.Symlink(outside, filepath.Join(repo, "escape")); err != nil {
+		t.Fatalf("Symlink() error = %v", err)
+	}
+	if _, err := s.Load(repo, Options{ManifestDir: "escape"}); err == nil || !strings.Contains(err.Error(), "through a symlink") {
+		t.Fatalf("Load() error = %v, want the symlink out of the source rejected", err)
+	}
+	if err := os.Remove(filepath.Join(repo, "escape")); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+
+	if _, err := s.Load(repo, Options{ManifestDir: "tools/dotfiles/"}); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	st, err := s.LoadState()
+	if err != nil {
+		t.Fatalf("LoadState() error = %v", err)
+	}
+	if st.Profile.Subdir != "tools/dotfiles" || st.Profile.Path != dotfiles {
+		t.Fatalf("profile = %+v, want subdir tools/dotfiles at %s", st.Profile, dotfiles)
+	}
+
+	writeTestFile(t, filepath.Join(dotfiles, "home", "dot_zshrc"), "v2\n")
+	if _, err := s.Reload(Options{}); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	if got, err := os.ReadFile(filepath.Join(home, ".zshrc")); err != nil || string(got) != "v2\n" {
+		t.Fatalf(".zshrc = %q, %v, want reloaded from the manifest directory", got, err)
+	}
+
+	archive := filepath.Join(t.TempDir(), "monorepo.tar")
+	f, err := os.Create(archive)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	tw := tar.NewWriter(f)
+	if err := tw.AddFS(os.DirFS(repo)); err != nil {
+		t.Fatalf("AddFS() error = %v", err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	_ = f.Close()
+
+	if _, err := s.Load(archive, Options{}); err == nil {
+		t.Fatalf("Load() error = nil, want no manifest at the top of the archive")
+	}
+	if _, err := s.Load(archive, Options{ManifestDir: "tools/dotfiles", Force: true}); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if _, err := s.Reload(Options{Repair: true}); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	if st, err = s.LoadState(); err != nil {
+		t.Fatalf("LoadState() error = %v", err)
+	}
+	if st.Profile.Kind != archiveKind || st.Profile.Subdir != "tools/dotfiles" || filepath.Base(st.Profile.Path) != "dotfiles" {
+		t.Fatalf("profile = %+v, want the archive's dotfiles directory", st.Profile)
+	}
+}
+
+func TestRestoreBackupRefusesKindMismatch(t *testing.T) {
+	create := map[digest.Kind]func(t *testing.T, path string){
+		digest.KindFile: func(t *testing.T, path string) {
+			writeTestFile(t, path, "file\n")
+		},
+		digest.KindDir: func(t *testing.T, path string) {
+			writeTestFile(t, filepath.Join(path, "inner"), "inner\n")
+		},
+		digest.KindSymlink: func(t *testing.T, path string) {
+			if err := os.Symlink("target", path); err != nil {
+				t.Fatalf("Symlink() error = %v", err)
+			}
+		},
+	}
+	kinds := []digest.Kind{digest.KindFile, digest.KindDir, digest.KindSymlink}
+
+	for _, backupKind := range kinds {
+		for _, destKind := range kinds {
+			if backupKind == destKind {
+				continue
+			}
+			t.Run(fmt.Sprintf("%s over %s", backupKind, destKind), func(t *testing.T) {
+				s, home := newTestStore(t)
+				dest := filepath.Join(home, "entry")
+				create[backupKind](t, dest)
+				current, err := snapshot(dest)
+				if err != nil {
+					t.Fatalf("snapshot() error = %v", err)
+				}
+				prev, err := storeBackup(s, current, func(string) {})
+				if err != nil {
+					t.Fatalf("storeBackup() error = %v", err)
+				}
+				if err := os.RemoveAll(dest); err != nil {
+					t.Fatalf("RemoveAll() error = %v", err)
+				}
+				create[destKind](t, dest)
+
+				_, err = restoreBackup(s, prev, dest, false, func(string) {})
+				want := fmt.Sprintf("is a %s but its backup is a %s", destKind, backupKind)
+				if err == nil || !strings.Contains(err.Error(), want) {
+					t.Fatalf("restoreBackup() error = %v, want %q", err, want)
+				}
+
+				outcome, err := restoreBackup(s, prev, dest, true, func(string) {})
+				if err != nil {
+					t.Fatalf("restoreBackup(force) error = %v", err)
+				}
+				if outcome != restoreVerified {
+					t.Fatalf("restoreBackup(force) outcome = %v, want verified", outcome)
+				}
+			})
+		}
+	}
+}
+
+func TestRestoreBackupRefusesRecordedKindMismatch(t *testing.T) {
+	s, home := newTestStore(t)
+	dir := filepath.Join(home, "dir")
+	writeTestFile(t, filepath.Join(dir, "inner"), "inner\n")
+	current, err := snapshot(dir)
+	if err != nil {
+		t.Fatalf("snapshot() error = %v", err)
+	}
+	prev, err := storeBackup(s, current, func(string) {})
+	if err != nil {
+		t.Fatalf("storeBackup() error = %v", err)
+	}
+
+	// State records a file where the backups hold a directory.
+	corrupt := *prev
+	corrupt.Digest = "file:sha256:" + strings.Repeat("0", 64)
+	if err := os.Rename(filepath.Dir(backupPath(s, prev.Digest, "")), filepath.Dir(backupPath(s, corrupt.Digest, ""))); err != nil {
+		t.Fatalf("Rename() error = %v", err)
+	}
+	_, err = restoreBackup(s, &corrupt, filepath.Join(home, "restored"), false, func(string) {})
+	if err == nil || !strings.Contains(err.Error(), "is a dir but") {
+		t.Fatalf("restoreBackup() error = %v, want recorded kind mismatch", err)
+	}
+}
+
+func TestUnloadAfterMovingStore(t *testing.T) {
+	s, home := newTestStore(t)
+	profile := writeProfile(t, manifest.Root{
+		Source:   "home",
+		Dest:     home,
+		Defaults: &manifest.Defaults{Type: "copy"},
+		Tree:     manifest.Tree{".zshrc": manifest.FileNode()},
+	})
+	writeTestFile(t, filepath.Join(profile, "home", "dot_zshrc"), "managed\n")
+	zshrc := filepath.Join(home, ".zshrc")
+	writeTestFile(t, zshrc, "original\n")
+
+	if _, err := s.Load(profile, Options{}); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	raw, err := os.ReadFile(s.StatePath())
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if strings.Contains(string(raw), s.BackupsPath()) {
+		t.Fatalf("state records backup paths:\n%s", raw)
+	}
+
+	// States written by older versions recorded the absolute path, which
+	// moving the store leaves pointing at nothing.
+	st, err := s.LoadState()
+	if err != nil {
+		t.Fatalf("LoadState() error = %v", err)
+	}
+	st.Files[0].Previous.Path = s.BackupPath(st.Files[0].Previous.Digest)
+	if err := s.SaveState(st); err != nil {
+		t.Fatalf("SaveState() error = %v", err)
+	}
+
+	moved := Store{Root: filepath.Join(t.TempDir(), "store")}
+	if err := os.Rename(s.Root, moved.Root); err != nil {
+		t.Fatalf("Rename() error = %v", err)
+	}
+	res, err := moved.Unload(Options{})
+	if err != nil {
+		t.Fatalf("Unload() error = %v", err)
+	}
+	if res.RestoredCount != 1 {
+		t.Fatalf("RestoredCount = %d, want 1", res.RestoredCount)
+	}
+	if raw, _ := os.ReadFile(zshrc); string(raw) != "original\n" {
+		t.Fatalf(".zshrc = %q after unload, want the backup restored", raw)
+	}
+}
+
+func TestLoadNoParentsRefusesMissingParents(t *testing.T) {
+	s, home := newTestStore(t)
+	profile := writeProfile(t, manifest.Root{
+		Source:   "home",
+		Dest:     home,
+		Defaults: &manifest.Defaults{Type: "copy"},
+		Tree: manifest.Tree{
+			".zshrc": manifest.FileNode(),
+			".config": manifest.DirectoryNode(nil, manifest.Tree{
+				"app": manifest.FileNode(),
+			}),
+		},
+	})
+	writeTestFile(t, filepath.Join(profile, "home", "dot_zshrc"), "zsh\n")
+	writeTestFile(t, filepath.Join(profile, "home", "dot_config", "app"), "app\n")
+
+	_, err := s.Load(profile, Options{NoParents: true})
+	if err == nil || !strings.Contains(err.Error(), "parent directory "+filepath.Join(home, ".config")+" does not exist") {
+		t.Fatalf("Load(NoParents) error = %v, want missing parent error", err)
+	}
+	if _, err := os.Lstat(filepath.Join(home, ".zshrc")); !os.IsNotExist(err) {
+		t.Fatalf(".zshrc left behind after failed load (err = %v)", err)
+	}
+
+	if err := os.Mkdir(filepath.Join(home, ".config"), 0o755); err != nil {
+		t.Fatalf("Mkdir() error = %v", err)
+	}
+	if _, err := s.Load(profile, Options{NoParents: true}); err != nil {
+		t.Fatalf("Load(NoParents) with parents present error = %v", err)
+	}
+	st, err := s.LoadState()
+	if err != nil {
+		t.Fatalf("LoadState() error = %v", err)
+	}
+	if len(st.Dirs) != 0 {
+		t.Fatalf("auto-created dirs = %v, want none", st.Dirs)
+	}
+}
+
+func TestLoadNoParentsAllowsDeclaredDirs(t *testing.T) {
+	s, home := newTestStore(t)
+	profile := writeProfile(t, manifest.Root{
+		Source:   "home",
+		Dest:     home,
+		Defaults: &manifest.Defaults{Type: "copy"},
+		Tree: manifest.Tree{
+			"bin": manifest.DirectoryNode([]string{"untracked"}, manifest.Tree{
+				"tool": manifest.FileNode(),
+			}),
+		},
+	})
+	writeTestFile(t, filepath.Join(profile, "home", "bin", "tool"), "tool\n")
+
+	if _, err := s.Load(profile, Options{NoParents: true}); err != nil {
+		t.Fatalf("Load(NoParents) error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(home, "bin", "tool")); err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+}
+
+func TestReloadFromMovedSource(t *testing.T) {
+	s, home := newTestStore(t)
+	root := manifest.Root{
+		Source: "home",
+		Dest:   home,
+		Tree:   manifest.Tree{".zshrc": manifest.FileNode("link")},
+	}
+	profile := writeProfile(t, root)
+	writeTestFile(t, filepath.Join(profile, "home", "dot_zshrc"), "zsh\n")
+
+	if _, err := s.Load(profile, Options{}); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	moved := filepath.Join(t.TempDir(), "dotfiles")
+	if err := os.Rename(profile, moved); err != nil {
+		t.Fatalf("Rename() error = %v", err)
+	}
+	if _, err := s.Reload(Options{}); err == nil {
+		t.Fatalf("Reload() from the old location succeeded, want error")
+	}
+
+	other := t.TempDir()
+	if err := manifest.Write(filepath.Join(other, manifest.Name), manifest.Manifest{
+		Schema:  manifest.SchemaVersion,
+		Profile: manifest.Profile{Slug: "other", Name: "other"},
+		Roots:   []manifest.Root{root},
+	}); err != nil {
+		t.Fatalf("manifest.Write() error = %v", err)
+	}
+	if _, err := s.ReloadFrom(other, Options{}); err == nil || !strings.Contains(err.Error(), `declares profile "other"`) {
+		t.Fatalf("ReloadFrom(other) error = %v, want profile mismatch", err)
+	}
+
+	if _, err := s.ReloadFrom(moved, Options{}); err != nil {
+		t.Fatalf("ReloadFrom() error = %v", err)
+	}
+	st, err := s.LoadState()
+	if err != nil {
+		t.Fatalf("LoadState() error = %v", err)
+	}
+	if st.Profile.Path != moved {
+		t.Fatalf("profile path = %q, want %q", st.Profile.Path, moved)
+	}
+	target, err := os.Readlink(filepath.Join(home, ".zshrc"))
+	if err != nil {
+		t.Fatalf("Readlink() error = %v", err)
+	}
+	if want := filepath.Join(moved, "home", "dot_zshrc"); target != want {
+		t.Fatalf(".zshrc -> %s, want %s", target, want)
+	}
+}
+
+func TestLoadReportsOperations(t *testing.T) {
+	s, home := newTestStore(t)
+	profile := writeProfile(t, manifest.Root{
+		Source:   "home",
+		Dest:     home,
+		Defaults: &manifest.Defaults{Type: "copy"},
+		Tree: manifest.Tree{
+			".zshrc":     manifest.FileNode(),
+			".gitconfig": manifest.FileNode(),
+		},
+	})
+	writeTestFile(t, filepath.Join(profile, "home", "dot_zshrc"), "zsh\n")
+	writeTestFile(t, filepath.Join(profile, "home", "dot_gitconfig"), "git\n")
+	writeTestFile(t, filepath.Join(home, ".gitconfig"), "original\n")
+
+	res, err := s.Load(profile, Options{Force: true})
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	ops := map[string]AppliedOp{}
+	for _, op := range res.Operations {
+		ops[op.Path] = op
+	}
+	if op := ops[filepath.Join(home, ".zshrc")]; op.Action != ActionCreated || op.Kind != "file" || op.Backup != "" {
+		t.Fatalf(".zshrc operation = %+v, want created file without backup", op)
+	}
+	replaced := ops[filepath.Join(home, ".gitconfig")]
+	if replaced.Action != ActionReplaced || replaced.Backup == "" {
+		t.Fatalf(".gitconfig operation = %+v, want replaced with backup", replaced)
+	}
+
+	unloaded, err := s.Unload(Options{})
+	if err != nil {
+		t.Fatalf("Unload() error = %v", err)
+	}
+	ops = map[string]AppliedOp{}
+	for _, op := range unloaded.Operations {
+		ops[op.Path] = op
+	}
+	if op := ops[filepath.Join(home, ".zshrc")]; op.Action != ActionRemoved || op.Kind != "file" {
+		t.Fatalf(".zshrc unload operation = %+v, want removed file", op)
+	}
+	if op := ops[filepath.Join(home, ".gitconfig")]; op.Action != ActionRestored || op.Backup != replaced.Backup {
+		t.Fatalf(".gitconfig unload operation = %+v, want restored from %s", op, replaced.Backup)
+	}
+}
+
+func TestUnloadRestoresBackupMetadata(t *testing.T) {
+	s, home := newTestStore(t)
+	profile := writeProfile(t, manifest.Root{
+		Source:   "home",
+		Dest:     home,
+		Defaults: &manifest.Defaults{Type: "copy"},
+		Tree:     manifest.Tree{".netrc": manifest.FileNode()},
+	})
+	writeTestFile(t, filepath.Join(profile, "home", "dot_netrc"), "managed\n")
+
+	original := filepath.Join(home, ".netrc")
+	writeTestFile(t, original, "original\n")
+	if err := os.Chmod(original, 0o600); err != nil {
+		t.Fatalf("Chmod() error = %v", err)
+	}
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := os.Chtimes(original, mtime, mtime); err != nil {
+		t.Fatalf("Chtimes() error = %v", err)
+	}
+
+	if _, err := s.Load(profile, Options{Force: true}); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	st, err := s.LoadState()
+	if err != nil {
+		t.Fatalf("LoadState() error = %v", err)
+	}
+	meta, err := readBackupMeta(backupPath(s, st.Files[0].Previous.Digest, ""))
+	if err != nil || meta == nil {
+		t.Fatalf("readBackupMeta() = %v, %v, want metadata", meta, err)
+	}
+	if meta.Path != original || meta.Mode.Perm() != 0o600 {
+		t.Fatalf("backup metadata = %+v, want path %s and mode 0600", meta, original)
+	}
+
+	if _, err := s.Unload(Options{}); err != nil {
+		t.Fatalf("Unload() error = %v", err)
+	}
+	info, err := os.Stat(original)
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if info.Mode().Perm() != 0o600 || !info.ModTime().Equal(mtime) {
+		t.Fatalf("restored .netrc mode = %v, mtime = %v, want 0600 and %v", info.Mode().Perm(), info.ModTime(), mtime)
+	}
+}
+
+func TestHistoryLogsOperations(t *testing.T) {
+	s, home := newTestStore(t)
+	profile := writeProfile(t, manifest.Root{
+		Source:   "home",
+		Dest:     home,
+		Defaults: &manifest.Defaults{Type: "copy"},
+		Tree:     manifest.Tree{".zshrc": manifest.FileNode()},
+	})
+	writeTestFile(t, filepath.Join(profile, "home", "dot_zshrc"), "zsh\n")
+
+	if _, err := s.Load(profile, Options{}); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if _, err := s.Load(profile, Options{}); err != nil {
+		t.Fatalf("second Load() error = %v", err)
+	}
+	if _, err := s.Unload(Options{}); err != nil {
+		t.Fatalf("Unload() error = %v", err)
+	}
+
+	entries, err := s.History()
+	if err != nil {
+		t.Fatalf("History() error = %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("History() = %+v, want load and unload only", entries)
+	}
+	if entries[0].Command != "load" || entries[0].Tracked != 1 || entries[0].Changed == 0 {
+		t.Fatalf("load entry = %+v", entries[0])
+	}
+	if entries[1].Command != "unload" || entries[1].Tracked != 1 {
+		t.Fatalf("unload entry = %+v", entries[1])
+	}
+}
+
+func TestReloadRepairRewritesUnchangedProfile(t *testing.T) {
+	s, home := newTestStore(t)
+	profile := writeProfile(t, manifest.Root{
+		Source:   "home",
+		Dest:     home,
+		Defaults: &manifest.Defaults{Type: "copy"},
+		Tree: manifest.Tree{
+			".ssh-config": manifest.FileNode(),
+			".hushlogin":  manifest.FileNode("untracked"),
+		},
+	})
+	writeTestFile(t, filepath.Join(profile, "home", "dot_ssh-config"), "Host *\n")
+	writeTestFile(t, filepath.Join(profile, "home", "dot_hushlogin"), "")
+	if err := os.Chmod(filepath.Join(profile, "home", "dot_ssh-config"), 0o600); err != nil {
+		t.Fatalf("Chmod() error = %v", err)
+	}
+
+	if _, err := s.Load(profile, Options{}); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	dest := filepath.Join(home, ".ssh-config")
+	if err := os.Chmod(dest, 0o666); err != nil {
+		t.Fatalf("Chmod() error = %v", err)
+	}
+
+	res, err := s.Reload(Options{})
+	if err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	if !res.Skipped {
+		t.Fatalf("Reload() without repair Skipped = false, want true")
+	}
+
+	res, err = s.Reload(Options{Repair: true})
+	if err != nil {
+		t.Fatalf("Reload(Repair) error = %v", err)
+	}
+	if res.Skipped || res.RewrittenCount != 1 {
+		t.Fatalf("Reload(Repair) Skipped = %t, RewrittenCount = %d, want false and 1", res.Skipped, res.RewrittenCount)
+	}
+	info, err := os.Stat(dest)
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Fatalf("mode after repair = %v, want 0600", info.Mode().Perm())
+	}
+}
+
+func TestReloadMatchesEquivalentDestinations(t *testing.T) {
+	s, home := newTestStore(t)
+	root := manifest.Root{
+		Source:   "home",
+		Dest:     home,
+		Defaults: &manifest.Defaults{Type: "copy"},
+		Tree:     manifest.Tree{".zshrc": manifest.FileNode()},
+	}
+	profile := writeProfile(t, root)
+	writeTestFile(t, filepath.Join(profile, "home", "dot_zshrc"), "managed\n")
+	dest := filepath.Join(home, ".zshrc")
+	writeTestFile(t, dest, "original\n")
+
+	if _, err := s.Load(profile, Options{Force: true}); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	// Write the same destination differently, both in the manifest and in
+	// state, and make sure the reload still sees it as the tracked path.
+	root.Dest = home + "/./sub/../"
+	m := manifest.Manifest{
+		Schema:  manifest.SchemaVersion,
+		Profile: manifest.Profile{Slug: "test", Name: "test"},
+		Roots:   []manifest.Root{root},
+	}
+	if err := manifest.Write(filepath.Join(profile, manifest.Name), m); err != nil {
+		t.Fatalf("manifest.Write() error = %v", err)
+	}
+	writeTestFile(t, filepath.Join(profile, "home", "dot_zshrc"), "updated\n")
+	st, err := s.LoadState()
+	if err != nil {
+		t.Fatalf("LoadState() error = %v", err)
+	}
+	st.Files[0].Path = dest + "/"
+	if err := s.SaveState(st); err != nil {
+		t.Fatalf("SaveState() error = %v", err)
+	}
+
+	if _, err := s.Reload(Options{}); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	st, err = s.LoadState()
+	if err != nil {
+		t.Fatalf("LoadState() error = %v", err)
+	}
+	if len(st.Files) != 1 || st.Files[0].Path != dest || st.Files[0].Previous == nil {
+		t.Fatalf("state files = %+v, want %s tracked with its backup", st.Files, dest)
+	}
+
+	if _, err := s.Unload(Options{}); err != nil {
+		t.Fatalf("Unload() error = %v", err)
+	}
+	if got, err := os.ReadFile(dest); err != nil || string(got) != "original\n" {
+		t.Fatalf(".zshrc after unload = %q, %v, want original content restored", got, err)
+	}
+}
+
+func TestLoadRetriesTransientErrors(t *testing.T) {
+	s, home := newTestStore(t)
+	profile := writeProfile(t, manifest.Root{
+		Source:   "home",
+		Dest:     home,
+		Defaults: &manifest.Defaults{Type: "copy"},
+		Tree:     manifest.Tree{".zshrc": manifest.FileNode()},
+	})
+	writeTestFile(t, filepath.Join(profile, "home", "dot_zshrc"), "managed\n")
+
+	stale := 0
+	copyPathFunc = func(src, dest string, opts fileutils.CopyOptions) error {
+		if stale > 0 {
+			stale--
+			return &os.PathError{Op: "rename", Path: dest, Err: syscall.ESTALE}
+		}
+		return fileutils.CopyPathWith(src, dest, opts)
+	}
+	t.Cleanup(func() { copyPathFunc = fileutils.CopyPathWith })
+
+	stale = 1
+	if _, err := s.Load(profile, Options{}); !errors.Is(err, syscall.ESTALE) {
+		t.Fatalf("Load() without retries error = %v, want ESTALE", err)
+	}
+
+	stale = 1
+	if _, err := s.Load(profile, Options{Retries: 2}); err != nil {
+		t.Fatalf("Load() with retries error = %v", err)
+	}
+	if raw, err := os.ReadFile(filepath.Join(home, ".zshrc")); err != nil || string(raw) != "managed\n" {
+		t.Fatalf(".zshrc = %q, %v", raw, err)
+	}
+}
+
+func TestLoadChecksExpectedName(t *testing.T) {
+	s, home := newTestStore(t)
+	profile := writeProfile(t, manifest.Root{
+		Source:   "home",
+		Dest:     home,
+		Defaults: &manifest.Defaults{Type: "copy"},
+		Tree:     manifest.Tree{".zshrc": manifest.FileNode()},
+	})
+	writeTestFile(t, filepath.Join(profile, "home", "dot_zshrc"), "managed\n")
+
+	_, err := s.Load(profile, Options{ExpectName: "work"})
+	if err == nil || !strings.Contains(err.Error(), `not the expected "work"`) {
+		t.Fatalf("Load() error = %v, want expected name mismatch", err)
+	}
+	if _, err := os.Lstat(filepath.Join(home, ".zshrc")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Lstat(.zshrc) error = %v, want nothing applied", err)
+	}
+
+	if _, err := s.Load(profile, Options{ExpectName: "  TEST "}); err != nil {
+		t.Fatalf("Load() with matching name error = %v", err)
+	}
+}
+
+func TestCheckSourceDestinationsRejectsSelfReference(t *testing.T) {
+	_, home := newTestStore(t)
+	profile := writeProfile(t)
+	link := filepath.Join(home, "dotfiles")
+	if err := os.Symlink(profile, link); err != nil {
+		t.Fatalf("Symlink() error = %v", err)
+	}
+
+	tests := []struct {
+		name string
+		op   op
+		want bool
+	}{
+		{"source itself", op{Kind: opDir, Dest: profile}, true},
+		{"nested in source", op{Kind: opFile, Dest: filepath.Join(profile, "home", ".zshrc"), Track: true}, true},
+		{"through a symlink", op{Kind: opLink, Dest: filepath.Join(link, ".zshrc"), Track: true}, true},
+		{"symlink itself", op{Kind: opLink, Dest: link, Track: true}, false},
+		{"parent of source", op{Kind: opDir, Dest: filepath.Dir(profile)}, false},
+		{"unrelated", op{Kind: opFile, Dest: filepath.Join(home, ".zshrc"), Track: true}, false},
+	}
+
+	for _, tt := range tests {
+		err := checkSourceDestinations([]op{tt.op}, profile)
+		if got := err != nil && strings.Contains(err.Error(), "inside the profile's source directory"); got != tt.want {
+			t.Errorf("%s: checkSourceDestinations() error = %v, want rejected %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestLoadRejectsDestinationInSource(t *testing.T) {
+	s, _ := newTestStore(t)
+	profile := writeProfile(t)
+	m, _, err := manifest.Read(profile)
+	if err != nil {
+		t.Fatalf("manifest.Read() error = %v", err)
+	}
+	m.Roots = []manifest.Root{{
+		Source: "home",
+		Dest:   filepath.Join(profile, "out"),
+		Tree:   manifest.Tree{".zshrc": manifest.FileNode("copy")},
+	}}
+	if err := manifest.Write(filepath.Join(profile, manifest.Name), m); err != nil {
+		t.Fatalf("manifest.Write() error = %v", err)
+	}
+	writeTestFile(t, filepath.Join(profile, "home", "dot_zshrc"), "managed\n")
+
+	_, err = s.Load(profile, Options{})
+	if err == nil || !strings.Contains(err.Error(), "inside the profile's source directory") {
+		t.Fatalf("Load() error = %v, want destination inside source rejected", err)
+	}
+	if _, err := os.Lstat(filepath.Join(profile, "out", ".zshrc")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Lstat() error = %v, want nothing written into the source", err)
+	}
+}
+
+func TestCheckSelfReferences(t *testing.T) {
+	_, home := newTestStore(t)
+	profile := writeProfile(t)
+	src := filepath.Join(profile, "home")
+	zshrc := filepath.Join(home, ".zshrc")
+	writeTestFile(t, zshrc, "mine\n")
+	if err := os.MkdirAll(src, 0o755); err != nil {
+		t.Fatalf("MkdirAll() error = %v", err)
+	}
+	for name, target := range map[string]string{
+		"dot_zshrc": zshrc,
+		"dot_chain": filepath.Join(src, "dot_zshrc"),
+		"dot_other": filepath.Join(home, ".other"),
+	} {
+		if err := os.Symlink(target, filepath.Join(src, name)); err != nil {
+			t.Fatalf("Symlink() error = %v", err)
+		}
+	}
+	// A destination already linked to its source, as a previous load leaves it.
+	loaded := filepath.Join(home, ".loaded")
+	writeTestFile(t, filepath.Join(src, "dot_loaded"), "loaded\n")
+	if err := os.Symlink(filepath.Join(src, "dot_loaded"), loaded); err != nil {
+		t.Fatalf("Symlink() error = %v", err)
+	}
+
+	tests := []struct {
+		name string
+		op   op
+		want string
+	}{
+		{"file from its destination", op{Kind: opFile, Source: filepath.Join(src, "dot_zshrc"), Dest: zshrc}, "resolves to the destination itself"},
+		{"copy through a chain", op{Kind: opCopy, Source: filepath.Join(src, "dot_chain"), Dest: zshrc}, "resolves to the destination itself"},
+		{"link to itself", op{Kind: opLink, Source: filepath.Join(src, "dot_zshrc"), Dest: zshrc}, "point at itself"},
+		{"link elsewhere", op{Kind: opLink, Source: filepath.Join(src, "dot_other"), Dest: zshrc}, ""},
+		{"already loaded link", op{Kind: opLink, Source: filepath.Join(src, "dot_loaded"), Dest: loaded}, ""},
+		{"literal content", op{Kind: opFile, Content: "x", Dest: zshrc}, ""},
+	}
+
+	for _, tt := range tests {
+		err := checkSelfReferences([]op{tt.op})
+		if tt.want == "" {
+			if err != nil {
+				t.Errorf("%s: checkSelfReferences() error = %v", tt.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: checkSelfReferences() error = %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestLoadRejectsSourceResolvingToDestination(t *testing.T) {
+	s, home := newTestStore(t)
+	profile := writeProfile(t, manifest.Root{
+		Source: "home",
+		Dest:   home,
+		Tree:   manifest.Tree{".zshrc": manifest.FileNode("copy")},
+	})
+	zshrc := filepath.Join(home, ".zshrc")
+	writeTestFile(t, zshrc, "mine\n")
+	if err := os.MkdirAll(filepath.Join(profile, "home"), 0o755); err != nil {
+		t.Fatalf("MkdirAll() error = %v", err)
+	}
+	if err := os.Symlink(zshrc, filepath.Join(profile, "home", "dot_zshrc")); err != nil {
+		t.Fatalf("Symlink() error = %v", err)
+	}
+
+	_, err := s.Load(profile, Options{Force: true})
+	if err == nil || !strings.Contains(err.Error(), "resolves to the destination itself") {
+		t.Fatalf("Load() error = %v, want the self-referencing source rejected", err)
+	}
+	if got, err := os.ReadFile(zshrc); err != nil || string(got) != "mine\n" {
+		t.Fatalf("ReadFile() = %q, %v, want the destination untouched", got, err)
+	}
+}
+
+func TestLoadPerFileCopy(t *testing.T) {
+	s, home := newTestStore(t)
+	profile := writeProfile(t, manifest.Root{
+		Source: "home",
+		Dest:   home,
+		Tree: manifest.Tree{".config": manifest.DirectoryNode(nil, manifest.Tree{
+			"nvim": manifest.DirectoryNode([]string{"copy", "per-file"}, nil),
+		})},
+	})
+	src := filepath.Join(profile, "home", "dot_config", "nvim")
+	writeTestFile(t, filepath.Join(src, "init.lua"), "init\n")
+	writeTestFile(t, filepath.Join(src, "lua", "plugins.lua"), "plugins\n")
+	nvim := filepath.Join(home, ".config", "nvim")
+	initLua, plugins := filepath.Join(nvim, "init.lua"), filepath.Join(nvim, "lua", "plugins.lua")
+
+	// The copy's directories count as declared, so no parents are needed.
+	if _, err := s.Load(profile, Options{NoParents: true}); err == nil || !strings.Contains(err.Error(), ".config") {
+		t.Fatalf("Load(NoParents) error = %v, want the undeclared .config reported", err)
+	}
+	if err := os.MkdirAll(filepath.Join(home, ".config"), 0o755); err != nil {
+		t.Fatalf("MkdirAll() error = %v", err)
+	}
+	if _, err := s.Load(profile, Options{NoParents: true}); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	st, err := s.LoadState()
+	if err != nil {
+		t.Fatalf("LoadState() error = %v", err)
+	}
+	var tracked []string
+	for _, f := range st.Files {
+		tracked = append(tracked, f.Path)
+	}
+	if want := []string{initLua, plugins}; !slices.Equal(tracked, want) {
+		t.Fatalf("tracked = %v, want one entry per file %v", tracked, want)
+	}
+
+	writeTestFile(t, initLua, "edited\n")
+	snap, err := s.Status()
+	if err != nil {
+		t.Fatalf("Status() error = %v", err)
+	}
+	for _, item := range snap.Tracked {
+		if item.Drifted != (item.Path == initLua) {
+			t.Fatalf("%s drifted = %v, want only the edited file drifted", item.Path, item.Drifted)
+		}
+	}
+
+	if _, err := s.Unload(Options{DiscardChanges: true}); err != nil {
+		t.Fatalf("Unload() error = %v", err)
+	}
+	if _, err := os.Stat(nvim); !os.IsNotExist(err) {
+		t.Fatalf("%s still exists after unload (err = %v)", nvim, err)
+	}
+}
+
+func TestLoadCopyExcludesStore(t *testing.T) {
+	_, home := newTestStore(t)
+	profile := writeProfile(t, manifest.Root{
+		Source: "home",
+		Dest:   home,
+		Tree:   manifest.Tree{".config": manifest.DirectoryNode([]string{"copy"}, nil)},
+	})
+	source := filepath.Join(profile, "home", "dot_config")
+	writeTestFile(t, filepath.Join(source, "app.toml"), "managed\n")
+	s := Store{Root: filepath.Join(source, "tohru")}
+
+	for i := range 2 {
+		res, err := s.Load(profile, Options{})
+		if err != nil {
+			t.Fatalf("Load() #%d error = %v", i+1, err)
+		}
+		// The first load fills the store, so a copy that included it would
+		// differ by the second.
+		if i == 1 && !res.Skipped {
+			t.Fatalf("second Load() was not skipped, want the store left out of the fingerprint")
+		}
+	}
+	if raw, err := os.ReadFile(filepath.Join(home, ".config", "app.toml")); err != nil || string(raw) != "managed\n" {
+		t.Fatalf("app.toml = %q, %v, want copied content", raw, err)
+	}
+	if _, err := os.Lstat(filepath.Join(home, ".config", "tohru")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Lstat() error = %v, want the store left out of the copy", err)
+	}
+
+	m, dir, err := manifest.Load(profile)
+	if err != nil {
+		t.Fatalf("manifest.Load() error = %v", err)
+	}
+	overlaps, err := s.StoreOverlaps(m, dir)
+	if err != nil {
+		t.Fatalf("StoreOverlaps() error = %v", err)
+	}
+	if len(overlaps) != 1 || !strings.Contains(overlaps[0], "left out of the copy") {
+		t.Fatalf("StoreOverlaps() = %q, want the copy source reported", overlaps)
+	}
+}
+
+func TestCheckDestinationsRejectsStorePaths(t *testing.T) {
+	s, home := newTestStore(t)
+	if err := os.MkdirAll(s.BackupsPath(), 0o755); err != nil {
+		t.Fatalf("MkdirAll() error = %v", err)
+	}
+	link := filepath.Join(home, "tohru")
+	if err := os.Symlink(s.Root, link); err != nil {
+		t.Fatalf("Symlink() error = %v", err)
+	}
+	parent := filepath.Dir(s.Root)
+
+	tests := []struct {
+		name string
+		op   op
+		want string
+	}{
+		{"state file", op{Kind: opFile, Dest: s.StatePath(), Track: true}, "inside the tohru store"},
+		{"nested in backups", op{Kind: opFile, Dest: filepath.Join(s.BackupsPath(), "sha256", "x"), Track: true}, "inside the tohru store"},
+		{"through a symlink", op{Kind: opLink, Dest: filepath.Join(link, "config.json"), Track: true}, "inside the tohru store"},
+		{"root itself", op{Kind: opDir, Dest: s.Root}, "inside the tohru store"},
+		{"copy over a parent", op{Kind: opCopy, Dest: parent, Track: true}, "contains the tohru store"},
+		{"untracked parent dir", op{Kind: opDir, Dest: parent}, ""},
+		{"symlink itself", op{Kind: opLink, Dest: link, Track: true}, ""},
+		{"unrelated", op{Kind: opFile, Dest: filepath.Join(home, ".zshrc"), Track: true}, ""},
+	}
+
+	for _, tt := range tests {
+		err := s.checkDestinations([]op{tt.op})
+		if tt.want == "" {
+			if err != nil {
+				t.Errorf("%s: checkDestinations() error = %v", tt.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: checkDestinations() error = %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestReloadDriftedFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    Options
+		wantErr string
+	}{
+		{name: "no flag", wantErr: "managed path was modified"},
+		{name: "discard changes", opts: Options{DiscardChanges: true}},
+		{name: "force", opts: Options{Force: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, home := newTestStore(t)
+			profile := writeProfile(t, manifest.Root{
+				Source:   "home",
+				Dest:     home,
+				Defaults: &manifest.Defaults{Type: "copy"},
+				Tree:     manifest.Tree{".zshrc": manifest.FileNode()},
+			})
+			writeTestFile(t, filepath.Join(profile, "home", "dot_zshrc"), "managed\n")
+			zshrc := filepath.Join(home, ".zshrc")
+			writeTestFile(t, zshrc, "original\n")
+			if _, err := s.Load(profile, Options{}); err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+
+			writeTestFile(t, zshrc, "my edits\n")
+			res, err := s.Reload(tt.opts)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Reload() error = %v, want %q", err, tt.wantErr)
+				}
+				if raw, _ := os.ReadFile(zshrc); string(raw) != "my edits\n" {
+					t.Fatalf(".zshrc = %q after failed reload, want edits kept", raw)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Reload() error = %v", err)
+			}
+			if res.Skipped {
+				t.Fatalf("Reload() Skipped = true for a drifted file")
+			}
+			if raw, _ := os.ReadFile(zshrc); string(raw) != "managed\n" {
+				t.Fatalf(".zshrc = %q, want managed contents", raw)
+			}
+
+			// The backup of what was there before the first load is kept.
+			if _, err := s.Unload(Options{}); err != nil {
+				t.Fatalf("Unload() error = %v", err)
+			}
+			if raw, _ := os.ReadFile(zshrc); string(raw) != "original\n" {
+				t.Fatalf(".zshrc = %q after unload, want original contents", raw)
+			}
+		})
+	}
+}
+
+func TestLoadAddTellsProfilesApartBySlug(t *testing.T) {
+	s, home := newTestStore(t)
+	writeAdded := func(slug, file string) string {
+		dir := t.TempDir()
+		m := manifest.Manifest{
+			Schema:  manifest.SchemaVersion,
+			Profile: manifest.Profile{Slug: slug, Name: file},
+			Roots: []manifest.Root{{
+				Source:   "home",
+				Dest:     home,
+				Defaults: &manifest.Defaults{Type: "copy"},
+				Tree:     manifest.Tree{file: manifest.FileNode()},
+			}},
+		}
+		if err := manifest.Write(filepath.Join(dir, manifest.Name), m); err != nil {
+			t.Fatalf("manifest.Write() error = %v", err)
+		}
+		writeTestFile(t, filepath.Join(dir, "home", "dot_"+strings.TrimPrefix(file, ".")), file+"\n")
+		return dir
+	}
+
+	// Without a slug, a second added profile used to replace the first.
+	if _, err := s.Load(writeAdded("", ".a"), Options{Add: true}); err == nil || !strings.Contains(err.Error(), "profile.slug") {
+		t.Fatalf("Load(slugless, Add) error = %v, want a slug required", err)
+	}
+
+	first := writeAdded("tools", ".a")
+	if _, err := s.Load(first, Options{Add: true}); err != nil {
+		t.Fatalf("Load(first, Add) error = %v", err)
+	}
+	other := writeAdded("tools", ".b")
+	if _, err := s.Load(other, Options{Add: true}); err == nil || !strings.Contains(err.Error(), "already loaded with --add from "+first) {
+		t.Fatalf("Load(other, Add) error = %v, want another source reusing the slug refused", err)
+	}
+	if raw, err := os.ReadFile(filepath.Join(home, ".a")); err != nil || string(raw) != ".a\n" {
+		t.Fatalf(".a = %q, %v, want the first added profile still loaded", raw, err)
+	}
+	if _, err := os.Lstat(filepath.Join(home, ".b")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Lstat(.b) error = %v, want the refused profile not loaded", err)
+	}
+}
+
+func TestLoadAddKeepsProfilesSideBySide(t *testing.T) {
+	s, home := newTestStore(t)
+	shell := writeProfile(t, manifest.Root{
+		Source:   "home",
+		Dest:     home,
+		Defaults: &manifest.Defaults{Type: "copy"},
+		Tree:     manifest.Tree{".zshrc": manifest.FileNode()},
+	})
+	writeTestFile(t, filepath.Join(shell, "home", "dot_zshrc"), "shell\n")
+
+	writeNamedProfile := func(slug, file string) string {
+		dir := t.TempDir()
+		m := manifest.Manifest{
+			Schema:  manifest.SchemaVersion,
+			Profile: manifest.Profile{Slug: slug, Name: slug},
+			Roots: []manifest.Root{{
+				Source:   "home",
+				Dest:     home,
+				Defaults: &manifest.Defaults{Type: "copy"},
+				Tree:     manifest.Tree{file: manifest.FileNode()},
+			}},
+		}
+		if err := manifest.Write(filepath.Join(dir, manifest.Name), m); err != nil {
+			t.Fatalf("manifest.Write() error = %v", err)
+		}
+		writeTestFile(t, filepath.Join(dir, "home", "dot_"+strings.TrimPrefix(file, ".")), slug+"\n")
+		return dir
+	}
+	editor := writeNamedProfile("editor", ".vimrc")
+	clash := writeNamedProfile("clash", ".zshrc")
+
+	vimrc := filepath.Join(home, ".vimrc")
+	zshrc := filepath.Join(home, ".zshrc")
+	writeTestFile(t, vimrc, "original\n")
+
+	if _, err := s.Load(shell, Options{}); err != nil {
+		t.Fatalf("Load(shell) error = %v", err)
+	}
+	if _, err := s.Load(editor, Options{Add: true}); err != nil {
+		t.Fatalf("Load(editor, Add) error = %v", err)
+	}
+	for path, want := range map[string]string{zshrc: "shell\n", vimrc: "editor\n"} {
+		if raw, _ := os.ReadFile(path); string(raw) != want {
+			t.Fatalf("%s = %q, want %q", path, raw, want)
+		}
+	}
+
+	if _, err := s.Load(clash, Options{Add: true}); err == nil || !strings.Contains(err.Error(), "which profile test tracks") {
+		t.Fatalf("Load(clash, Add) error = %v, want collision with test", err)
+	}
+	if _, err := s.Load(editor, Options{}); err == nil || !strings.Contains(err.Error(), "loaded with --add") {
+		t.Fatalf("Load(editor) error = %v, want already added", err)
+	}
+	res, err := s.Load(editor, Options{Add: true})
+	if err != nil || !res.Skipped {
+		t.Fatalf("Load(editor, Add) again = %+v, %v, want skipped", res, err)
+	}
+
+	// The editor's backup of .vimrc must survive pruning on main profile loads.
+	if _, err := s.Reload(Options{Repair: true}); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	if _, err := s.UnloadProfile("EDITOR", Options{}); err != nil {
+		t.Fatalf("UnloadProfile() error = %v", err)
+	}
+	if raw, _ := os.ReadFile(vimrc); string(raw) != "original\n" {
+		t.Fatalf(".vimrc = %q after unloading editor, want original", raw)
+	}
+	if raw, _ := os.ReadFile(zshrc); string(raw) != "shell\n" {
+		t.Fatalf(".zshrc = %q after unloading editor, want shell still loaded", raw)
+	}
+	st, err := s.LoadState()
+	if err != nil {
+		t.Fatalf("LoadState() error = %v", err)
+	}
+	if st.Profile.Slug != "test" || len(st.Added) != 0 {
+		t.Fatalf("state = %s with %d added, want test alone", st.Profile.Slug, len(st.Added))
+	}
+
+	if _, err := s.Load(editor, Options{Add: true}); err != nil {
+		t.Fatalf("Load(editor, Add) error = %v", err)
+	}
+	unloaded, err := s.Unload(Options{})
+	if err != nil {
+		t.Fatalf("Unload() error = %v", err)
+	}
+	if unloaded.ProfileName != "test, editor" || unloaded.RemovedCount != 2 {
+		t.Fatalf("Unload() = %q, %d removed, want both profiles", unloaded.ProfileName, unloaded.RemovedCount)
+	}
+	if _, err := os.Lstat(zshrc); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf(".zshrc still present after unload: %v", err)
+	}
+	if raw, _ := os.ReadFile(vimrc); string(raw) != "original\n" {
+		t.Fatalf(".vimrc = %q after unload, want original", raw)
+	}
+}
+
+func TestLoadResolvesConflicts(t *testing.T) {
+	s, home := newTestStore(t)
+	profile := writeProfile(t, manifest.Root{
+		Source:   "home",
+		Dest:     home,
+		Defaults: &manifest.Defaults{Type: "copy"},
+		Tree: manifest.Tree{
+			".zshrc":     manifest.FileNode(),
+			".vimrc":     manifest.FileNode(),
+			".gitconfig": manifest.FileNode(),
+		},
+	})
+	answers := map[string]Resolution{}
+	for _, name := range []string{".zshrc", ".vimrc", ".gitconfig"} {
+		writeTestFile(t, filepath.Join(profile, "home", "dot_"+strings.TrimPrefix(name, ".")), "managed\n")
+		writeTestFile(t, filepath.Join(home, name), "original\n")
+	}
+	zshrc := filepath.Join(home, ".zshrc")
+	vimrc := filepath.Join(home, ".vimrc")
+	gitconfig := filepath.Join(home, ".gitconfig")
+	answers[zshrc] = ResolveSkip
+	answers[vimrc] = ResolveOverwrite
+	resolve := func(c Conflict) (Resolution, error) {
+		if c.Existing != "file" || !c.Tracked {
+			t.Errorf("conflict = %+v, want a tracked file", c)
+		}
+		return answers[c.Path], nil
+	}
+
+	// Destinations are resolved in manifest order, so .gitconfig comes first.
+	answers[gitconfig] = ResolveAbort
+	if _, err := s.Load(profile, Options{Resolve: resolve}); !errors.Is(err, ErrAborted) {
+		t.Fatalf("Load() error = %v, want ErrAborted", err)
+	}
+	for _, path := range []string{zshrc, vimrc, gitconfig} {
+		if raw, _ := os.ReadFile(path); string(raw) != "original\n" {
+			t.Fatalf("%s = %q after abort, want original", path, raw)
+		}
+	}
+
+	answers[gitconfig] = ResolveBackup
+	res, err := s.Load(profile, Options{Resolve: resolve})
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if res.TrackedCount != 2 {
+		t.Fatalf("TrackedCount = %d, want 2 with .zshrc skipped", res.TrackedCount)
+	}
+	want := map[string]string{zshrc: "original\n", vimrc: "managed\n", gitconfig: "managed\n"}
+	for path, content := range want {
+		if raw, _ := os.ReadFile(path); string(raw) != content {
+			t.Fatalf("%s = %q after load, want %q", path, raw, content)
+		}
+	}
+
+	if _, err := s.Unload(Options{}); err != nil {
+		t.Fatalf("Unload() error = %v", err)
+	}
+	if raw, _ := os.ReadFile(zshrc); string(raw) != "original\n" {
+		t.Fatalf(".zshrc = %q after unload, want it untouched", raw)
+	}
+	if _, err := os.Lstat(vimrc); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf(".vimrc was restored after overwriting without a backup: %v", err)
+	}
+	if raw, _ := os.ReadFile(gitconfig); string(raw) != "original\n" {
+		t.Fatalf(".gitconfig = %q after unload, want the backup restored", raw)
+	}
+}
+
+func TestLoadRenamesConflicts(t *testing.T) {
+	s, home := newTestStore(t)
+	profile := writeProfile(t, manifest.Root{
+		Source:   "home",
+		Dest:     home,
+		Defaults: &manifest.Defaults{Type: "copy"},
+		Tree: manifest.Tree{
+			".zshrc": manifest.FileNode(),
+			".vimrc": manifest.FileNode("untracked"),
+		},
+	})
+	zshrc := filepath.Join(home, ".zshrc")
+	vimrc := filepath.Join(home, ".vimrc")
+	for _, path := range []string{zshrc, vimrc} {
+		writeTestFile(t, filepath.Join(profile, "home", "dot_"+strings.TrimPrefix(filepath.Base(path), ".")), "managed\n")
+		writeTestFile(t, path, "original\n")
+	}
+	aside := func(path string) string {
+		t.Helper()
+		matches, err := filepath.Glob(path + asideInfix + "*")
+		if err != nil || len(matches) != 1 {
+			t.Fatalf("Glob(%s) = %q, %v, want one moved-aside copy", path, matches, err)
+		}
+		if raw, _ := os.ReadFile(matches[0]); string(raw) != "original\n" {
+			t.Fatalf("%s = %q, want the original content", matches[0], raw)
+		}
+		return matches[0]
+	}
+
+	// A failed load leaves what it moved aside where it is. Entries are
+	// applied in order, so both are moved before .zshrc fails.
+	errCopy := errors.New("copy failed")
+	copyPathFunc = func(src, dest string, opts fileutils.CopyOptions) error {
+		if dest == zshrc {
+			return errCopy
+		}
+		return fileutils.CopyPathWith(src, dest, opts)
+	}
+	t.Cleanup(func() { copyPathFunc = fileutils.CopyPathWith })
+	if _, err := s.Load(profile, Options{RenameConflicts: true}); !errors.Is(err, errCopy) {
+		t.Fatalf("Load() error = %v, want %v", err, errCopy)
+	}
+	for _, path := range []string{zshrc, vimrc} {
+		if err := os.Rename(aside(path), path); err != nil {
+			t.Fatalf("Rename() error = %v", err)
+		}
+	}
+	copyPathFunc = fileutils.CopyPathWith
+
+	// Without a resolver decision the conflicts are renamed rather than
+	// backed up, or refused for the untracked .vimrc.
+	res, err := s.Load(profile, Options{
+		RenameConflicts: true,
+		Resolve:         func(Conflict) (Resolution, error) { return ResolveDefault, nil },
+	})
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	for _, path := range []string{zshrc, vimrc} {
+		moved := aside(path)
+		if !slices.Contains(res.ChangedPaths, moved) {
+			t.Fatalf("ChangedPaths = %q, want %s", res.ChangedPaths, moved)
+		}
+		if raw, _ := os.ReadFile(path); string(raw) != "managed\n" {
+			t.Fatalf("%s = %q after load, want managed", path, raw)
+		}
+	}
+	for _, op := range res.Operations {
+		if op.Action != ActionReplaced || op.RenamedTo == "" || op.Backup != "" {
+			t.Fatalf("operation = %+v, want replaced by renaming without a backup", op)
+		}
+	}
+
+	if _, err := s.Unload(Options{}); err != nil {
+		t.Fatalf("Unload() error = %v", err)
+	}
+	if _, err := os.Lstat(zshrc); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf(".zshrc after unload: %v, want it removed and the original left aside", err)
+	}
+	aside(zshrc)
+}
+
+func TestUnloadPlanFlagsFailingPaths(t *testing.T) {
+	s, home := newTestStore(t)
+	profile := writeProfile(t, manifest.Root{
+		Source:   "home",
+		Dest:     home,
+		Defaults: &manifest.Defaults{Type: "copy"},
+		Tree: manifest.Tree{
+			".zshrc": manifest.FileNode(),
+			".vimrc": manifest.FileNode(),
+		},
+	})
+	writeTestFile(t, filepath.Join(profile, "home", "dot_zshrc"), "managed\n")
+	writeTestFile(t, filepath.Join(profile, "home", "dot_vimrc"), "managed\n")
+	zshrc := filepath.Join(home, ".zshrc")
+	vimrc := filepath.Join(home, ".vimrc")
+	writeTestFile(t, zshrc, "original\n")
+	if _, err := s.Load(profile, Options{}); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	plan, err := s.UnloadPlan("", Options{})
+	if err != nil {
+		t.Fatalf("UnloadPlan() error = %v", err)
+	}
+	if plan.Failing() || len(plan.Paths) != 2 {
+		t.Fatalf("UnloadPlan() = %+v, want two clean paths", plan)
+	}
+	for _, path := range plan.Paths {
+		if !path.Remove || (path.Path == zshrc) != (path.Restore != "") {
+			t.Fatalf("planned %+v, want removed and only .zshrc restored", path)
+		}
+	}
+
+	writeTestFile(t, vimrc, "my edits\n")
+	st, err := s.LoadState()
+	if err != nil {
+		t.Fatalf("LoadState() error = %v", err)
+	}
+	for _, f := range st.Files {
+		if f.Path == zshrc {
+			if err := os.RemoveAll(filepath.Dir(backupPath(s, f.Previous.Digest, ""))); err != nil {
+				t.Fatalf("RemoveAll() error = %v", err)
+			}
+		}
+	}
+
+	plan, err = s.UnloadPlan("", Options{})
+	if err != nil {
+		t.Fatalf("UnloadPlan() error = %v", err)
+	}
+	problems := map[string]string{}
+	for _, path := range plan.Paths {
+		problems[path.Path] = path.Problem
+	}
+	if !strings.Contains(problems[vimrc], "managed path was modified") {
+		t.Fatalf(".vimrc problem = %q, want modified", problems[vimrc])
+	}
+	if !strings.Contains(problems[zshrc], "missing backup object") {
+		t.Fatalf(".zshrc problem = %q, want missing backup", problems[zshrc])
+	}
+	if raw, _ := os.ReadFile(vimrc); string(raw) != "my edits\n" {
+		t.Fatalf(".vimrc = %q after planning, want it untouched", raw)
+	}
+
+	plan, err = s.UnloadPlan("", Options{Force: true})
+	if err != nil || plan.Failing() {
+		t.Fatalf("UnloadPlan(Force) = %+v, %v, want nothing failing", plan, err)
+	}
+}
+
+func TestReloadLoadsDefaultSource(t *testing.T) {
+	s, home := newTestStore(t)
+	profile := writeProfile(t, manifest.Root{
+		Source:   "home",
+		Dest:     home,
+		Defaults: &manifest.Defaults{Type: "copy"},
+		Tree:     manifest.Tree{".zshrc": manifest.FileNode()},
+	})
+	writeTestFile(t, filepath.Join(profile, "home", "dot_zshrc"), "managed\n")
+	if _, err := s.Install(); err != nil {
+		t.Fatalf("Install() error = %v", err)
+	}
+	if _, err := s.Reload(Options{}); err == nil || !strings.Contains(err.Error(), "no loaded profile") {
+		t.Fatalf("Reload() error = %v, want nothing to reload", err)
+	}
+
+	cfg := DefaultConfig()
+	cfg.Options.DefaultSource = profile
+	if err := encodeJSON(s.ConfigPath(), cfg); err != nil {
+		t.Fatalf("encodeJSON() error = %v", err)
+	}
+	res, err := s.Reload(Options{})
+	if err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	if res.ProfileDir != profile {
+		t.Fatalf("Reload() ProfileDir = %q, want default source %q", res.ProfileDir, profile)
+	}
+
+	t.Setenv("HOME", home)
+	t.Setenv("TOHRU_SOURCE", "~/dotfiles")
+	if got, err := s.DefaultSource(); err != nil || got != filepath.Join(home, "dotfiles") {
+		t.Fatalf("DefaultSource() = %q, %v, want TOHRU_SOURCE expanded", got, err)
+	}
+}
+
+func TestTidyContinuesPastUnremovableBackups(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root can remove files from read-only directories")
+	}
+	s, _ := newTestStore(t)
+	if _, err := s.Install(); err != nil {
+		t.Fatalf("Install() error = %v", err)
+	}
+	for _, cid := range []string{"file:sha256:aa", "file:sha256:bb", "file:sha256:cc"} {
+		writeTestFile(t, backupPath(s, cid, ""), "x")
+	}
+	stuck := filepath.Join(s.BackupsPath(), "file:sha256:bb")
+	if err := os.Chmod(stuck, 0o555); err != nil {
+		t.Fatalf("Chmod() error = %v", err)
+	}
+	t.Cleanup(func() { _ = os.Chmod(stuck, 0o755) })
+
+	res, err := s.Tidy(TidyOptions{})
+	if err == nil || !strings.Contains(err.Error(), stuck) {
+		t.Fatalf("Tidy() error = %v, want failure for %s", err, stuck)
+	}
+	if res.RemovedCount != 2 {
+		t.Fatalf("RemovedCount = %d, want the other 2 backups removed", res.RemovedCount)
+	}
+	for _, cid := range []string{"file:sha256:aa", "file:sha256:cc"} {
+		if _, err := os.Stat(filepath.Join(s.BackupsPath(), cid)); !os.IsNotExist(err) {
+			t.Fatalf("backup %s still present (err = %v)", cid, err)
+		}
+	}
+}
+
+func TestLoadCreatesRelativeAndDirectoryLinks(t *testing.T) {
+	s, home := newTestStore(t)
+	root := manifest.Root{
+		Source:   "home",
+		Dest:     home,
+		Defaults: &manifest.Defaults{Type: "link"},
+		Tree: manifest.Tree{
+			".zshrc": manifest.FileNode("relative"),
+			"bin":    manifest.DirectoryNode([]string{"link"}, nil),
+		},
+	}
+	profile := writeProfile(t, root)
+	writeTestFile(t, filepath.Join(profile, "home", "dot_zshrc"), "managed\n")
+	writeTestFile(t, filepath.Join(profile, "home", "bin", "tool"), "#!/bin/sh\n")
+
+	if _, err := s.Load(profile, Options{}); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	zshrc := filepath.Join(home, ".zshrc")
+	target, err := os.Readlink(zshrc)
+	if err != nil || filepath.IsAbs(target) {
+		t.Fatalf("Readlink(.zshrc) = %q, %v, want a relative target", target, err)
+	}
+	if raw, _ := os.ReadFile(zshrc); string(raw) != "managed\n" {
+		t.Fatalf(".zshrc = %q, want it to resolve to the source", raw)
+	}
+	if target, err := os.Readlink(filepath.Join(home, "bin")); err != nil || target != filepath.Join(profile, "home", "bin") {
+		t.Fatalf("Readlink(bin) = %q, %v, want the source directory", target, err)
+	}
+
+	// Dropping "relative" changes the link target, so reload relinks it.
+	root.Tree[".zshrc"] = manifest.FileNode()
+	m := manifest.Manifest{Schema: manifest.SchemaVersion, Profile: manifest.Profile{Slug: "test", Name: "test"}, Roots: []manifest.Root{root}}
+	if err := manifest.Write(filepath.Join(profile, manifest.Name), m); err != nil {
+		t.Fatalf("manifest.Write() error = %v", err)
+	}
+	res, err := s.Reload(Options{})
+	if err != nil || res.Skipped {
+		t.Fatalf("Reload() = %+v, %v, want the link rewritten", res, err)
+	}
+	if target, err := os.Readlink(zshrc); err != nil || target != filepath.Join(profile, "home", "dot_zshrc") {
+		t.Fatalf("Readlink(.zshrc) = %q, %v, want the absolute source", target, err)
+	}
+
+	// A directory linked from a file entry is refused when planning, so
+	// validate and diff report it and reload leaves the old link alone.
+	root.Tree["bin"] = manifest.FileNode()
+	m.Roots = []manifest.Root{root}
+	if err := manifest.Write(filepath.Join(profile, manifest.Name), m); err != nil {
+		t.Fatalf("manifest.Write() error = %v", err)
+	}
+	if err := m.Resolve(); err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+	if _, err := plan(m, profile); err == nil || !strings.Contains(err.Error(), "is a directory") {
+		t.Fatalf("plan() error = %v, want directory link refused", err)
+	}
+	if _, err := s.StoreOverlaps(m, profile); err == nil || !strings.Contains(err.Error(), "is a directory") {
+		t.Fatalf("StoreOverlaps() error = %v, want directory link refused", err)
+	}
+	if _, err := s.Reload(Options{}); err == nil || !strings.Contains(err.Error(), "is a directory") {
+		t.Fatalf("Reload() error = %v, want directory link refused", err)
+	}
+	if target, err := os.Readlink(filepath.Join(home, "bin")); err != nil || target != filepath.Join(profile, "home", "bin") {
+		t.Fatalf("Readlink(bin) after the refused reload = %q, %v, want the link left in place", target, err)
+	}
+}
+
+func TestLoadRefusesEmptyProfile(t *testing.T) {
+	s, home := newTestStore(t)
+	profile := writeProfile(t, manifest.Root{
+		Source:   "home",
+		Dest:     home,
+		Defaults: &manifest.Defaults{Type: "copy"},
+		Tree:     manifest.Tree{".zshrc": manifest.FileNode()},
+	})
+	writeTestFile(t, filepath.Join(profile, "home", "dot_zshrc"), "managed\n")
+	empty := writeProfile(t)
+	if _, err := s.Install(); err != nil {
+		t.Fatalf("Install() error = %v", err)
+	}
+	if _, err := s.Load(profile, Options{}); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	if _, err := s.Load(empty, Options{}); !errors.Is(err, ErrEmptyProfile) {
+		t.Fatalf("Load(empty) error = %v, want ErrEmptyProfile", err)
+	}
+	st, err := s.LoadState()
+	if err != nil {
+		t.Fatalf("LoadState() error = %v", err)
+	}
+	if st.Profile.Path != profile || len(st.Files) != 1 {
+		t.Fatalf("state after refused load = %+v, want the previous profile untouched", st.Profile)
+	}
+
+	if _, err := s.Load(empty, Options{AllowEmpty: true}); err != nil {
+		t.Fatalf("Load(empty, AllowEmpty) error = %v", err)
+	}
+	if _, err := os.Lstat(filepath.Join(home, ".zshrc")); !os.IsNotExist(err) {
+		t.Fatalf("Lstat(.zshrc) error = %v, want it removed by the empty load", err)
+	}
+}
+
+func TestNullPreviousRoundTrip(t *testing.T) {
+	s, home := newTestStore(t)
+	zshrc := filepath.Join(home, ".zshrc")
+	profile := writeProfile(t, manifest.Root{
+		Source:   "home",
+		Dest:     home,
+		Defaults: &manifest.Defaults{Type: "copy"},
+		Tree:     manifest.Tree{".zshrc": manifest.FileNode()},
+	})
+	writeTestFile(t, filepath.Join(profile, "home", "dot_zshrc"), "v1\n")
+
+	if _, err := s.Load(profile, Options{}); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	st, err := s.LoadState()
+	if err != nil {
+		t.Fatalf("LoadState() error = %v", err)
+	}
+	if len(st.Files) != 1 || !isNullObject(st.Files[0].Previous) {
+		t.Fatalf("files = %#v, want .zshrc recorded with a null previous object", st.Files)
+	}
+
+	// A reload carries the null previous object over rather than losing it.
+	writeTestFile(t, filepath.Join(profile, "home", "dot_zshrc"), "v2\n")
+	if _, err := s.Reload(Options{}); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	if st, err = s.LoadState(); err != nil {
+		t.Fatalf("LoadState() error = %v", err)
+	}
+	if len(st.Files) != 1 || !isNullObject(st.Files[0].Previous) {
+		t.Fatalf("files after reload = %#v, want the null previous object kept", st.Files)
+	}
+
+	snapshot, err := s.Status()
+	if err != nil {
+		t.Fatalf("Status() error = %v", err)
+	}
+	if summary := snapshot.Summary(); !summary.Clean || summary.Backups != 0 {
+		t.Fatalf("Summary() = %+v, want clean with no backup references", summary)
+	}
+
+	plan, err := s.UnloadPlan("", Options{})
+	if err != nil {
+		t.Fatalf("UnloadPlan() error = %v", err)
+	}
+	if len(plan.Paths) != 1 || !plan.Paths[0].Remove || plan.Paths[0].Restore != "" || plan.Paths[0].Problem != "" {
+		t.Fatalf("plan = %#v, want .zshrc removed with nothing to restore", plan.Paths)
+	}
+
+	res, err := s.Unload(Options{})
+	if err != nil {
+		t.Fatalf("Unload() error = %v", err)
+	}
+	if len(res.Operations) != 1 || res.Operations[0].Action != ActionRemoved || res.Operations[0].Backup != "" || res.RestoredCount != 0 {
+		t.Fatalf("Operations = %#v, want .zshrc removed without a restore", res.Operations)
+	}
+	if _, err := os.Lstat(zshrc); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Lstat(.zshrc) error = %v, want it gone", err)
+	}
+}
+
+func TestLoadAppliesSourceOptions(t *testing.T) {
+	s, home := newTestStore(t)
+	profile := writeProfile(t, manifest.Root{
+		Source:   "home",
+		Dest:     home,
+		Defaults: &manifest.Defaults{Type: "copy"},
+		Tree:     manifest.Tree{".zshrc": manifest.FileNode()},
+	})
+	writeTestFile(t, filepath.Join(profile, "home", "dot_zshrc"), "zsh\n")
+	writeTestFile(t, filepath.Join(home, ".zshrc"), "existing\n")
+
+	path := filepath.Join(profile, manifest.Name)
+	m, _, err := manifest.Read(profile)
+	if err != nil {
+		t.Fatalf("manifest.Read() error = %v", err)
+	}
+	disabled := false
+	m.Options = &manifest.Options{Backups: &manifest.BackupOptions{Enabled: &disabled}}
+	if err := manifest.Write(path, m); err != nil {
+		t.Fatalf("manifest.Write() error = %v", err)
+	}
+
+	// The store backs up by default, but the profile turns that off, so the
+	// existing .zshrc isn't clobbered without --force.
+	if _, err := s.Load(profile, Options{}); err == nil || !strings.Contains(err.Error(), "options.backups.enabled=false") {
+		t.Fatalf("Load() error = %v, want the profile's backups=false to refuse clobbering", err)
+	}
+
+	// The environment wins over the profile.
+	t.Setenv(envBackup, "true")
+	if _, err := s.Load(profile, Options{}); err != nil {
+		t.Fatalf("Load() with %s=true error = %v", envBackup, err)
+	}
+	if _, err := s.Unload(Options{}); err != nil {
+		t.Fatalf("Unload() error = %v", err)
+	}
+
+	// A profile can't turn on automatic pruning over a manual store config.
+	cfg := DefaultConfig()
+	cfg.Options.Backups.Prune = config.PruneManual
+	if err := encodeJSON(s.ConfigPath(), cfg); err != nil {
+		t.Fatalf("encodeJSON() error = %v", err)
+	}
+	m.Options = &manifest.Options{Backups: &manifest.BackupOptions{Prune: "auto"}}
+	if err := manifest.Write(path, m); err != nil {
+		t.Fatalf("manifest.Write() error = %v", err)
+	}
+	if _, err := s.Load(profile, Options{}); err == nil || !strings.Contains(err.Error(), "only the store config can enable") {
+		t.Fatalf("Load() error = %v, want automatic pruning refused", err)
+	}
+}
+
+func TestLoadCountsBytes(t *testing.T) {
+	s, home := newTestStore(t)
+	profile := writeProfile(t, manifest.Root{
+		Source:   "home",
+		Dest:     home,
+		Defaults: &manifest.Defaults{Type: "copy"},
+		Tree: manifest.Tree{
+			".zshrc": manifest.FileNode(),
+			".vimrc": manifest.FileNode("link"),
+			"themes": manifest.DirectoryNode([]string{"copy"}, nil),
+		},
+	})
+	writeTestFile(t, filepath.Join(profile, "home", "dot_zshrc"), "0123456789")
+	writeTestFile(t, filepath.Join(profile, "home", "dot_vimrc"), "ignored, links copy nothing")
+	writeTestFile(t, filepath.Join(profile, "home", "themes", "a"), "abc")
+	writeTestFile(t, filepath.Join(profile, "home", "themes", "b"), "de")
+	writeTestFile(t, filepath.Join(home, ".zshrc"), "existing")
+
+	res, err := s.Load(profile, Options{})
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if res.BytesCopied != 15 || res.BytesBackedUp != int64(len("existing")) {
+		t.Fatalf("BytesCopied, BytesBackedUp = %d, %d, want 15, %d", res.BytesCopied, res.BytesBackedUp, len("existing"))
+	}
+}
+
+func TestLoadBackupOverridePrecedence(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		flags    []string
+		config   bool
+		noBackup bool
+		want     string // the clobber refusal, or "" when the existing file is backed up
+	}{
+		{name: "config", config: true},
+		{name: "config disabled", want: "options.backups.enabled=false"},
+		{name: "no-backup option", config: true, noBackup: true, want: "--no-backup is set"},
+		{name: "entry disables", flags: []string{"no-backup"}, config: true, want: "manifest entry is flagged no-backup"},
+		{name: "entry enables over config", flags: []string{"backup"}},
+		{name: "entry enables over option", flags: []string{"backup"}, config: true, noBackup: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s, home := newTestStore(t)
+			profile := writeProfile(t, manifest.Root{
+				Source:   "home",
+				Dest:     home,
+				Defaults: &manifest.Defaults{Type: "copy"},
+				Tree:     manifest.Tree{".zshrc": manifest.FileNode(tt.flags...)},
+			})
+			writeTestFile(t, filepath.Join(profile, "home", "dot_zshrc"), "managed\n")
+			writeTestFile(t, filepath.Join(home, ".zshrc"), "existing\n")
+
+			cfg := DefaultConfig()
+			cfg.Options.Backups.Enabled = tt.config
+			if err := encodeJSON(s.ConfigPath(), cfg); err != nil {
+				t.Fatalf("encodeJSON() error = %v", err)
+			}
+
+			res, err := s.Load(profile, Options{NoBackup: tt.noBackup})
+			if tt.want != "" {
+				if !errors.Is(err, ErrNeedsForce) || !strings.Contains(err.Error(), tt.want) {
+					t.Fatalf("Load() error = %v, want ErrNeedsForce mentioning %q", err, tt.want)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+			if len(res.Operations) != 1 || res.Operations[0].Backup == "" {
+				t.Fatalf("Operations = %#v, want the existing .zshrc backed up", res.Operations)
+			}
+		})
+	}
+}
+
+func TestLoadGlob(t *testing.T) {
+	s, home := newTestStore(t)
+	profile := writeProfile(t, manifest.Root{
+		Source: "home",
+		Dest:   home,
+		Glob: map[string][]string{
+			".config/**/*.{conf,toml}": {"copy"},
+			"bin/*":                    {"link"},
+		},
+	})
+	src := filepath.Join(profile, "home")
+	writeTestFile(t, filepath.Join(src, "dot_config", "app", "app.conf"), "conf\n")
+	writeTestFile(t, filepath.Join(src, "dot_config", "app", "nested", "deep.toml"), "toml\n")
+	writeTestFile(t, filepath.Join(src, "dot_config", "app", "notes.md"), "notes\n")
+	writeTestFile(t, filepath.Join(src, "bin", "tool"), "#!/bin/sh\n")
+
+	if _, err := s.Load(profile, Options{}); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	for rel, want := range map[string]string{
+		".config/app/app.conf":         "conf\n",
+		".config/app/nested/deep.toml": "toml\n",
+	} {
+		path := filepath.Join(home, filepath.FromSlash(rel))
+		if info, err := os.Lstat(path); err != nil || !info.Mode().IsRegular() {
+			t.Fatalf("Lstat(%s) = %v, %v, want a copied file", rel, info, err)
+		}
+		if raw, err := os.ReadFile(path); err != nil || string(raw) != want {
+			t.Fatalf("%s = %q, %v, want %q", rel, raw, err, want)
+		}
+	}
+	if _, err := os.Lstat(filepath.Join(home, ".config", "app", "notes.md")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Lstat(notes.md) error = %v, want unmatched files left out", err)
+	}
+	tool := filepath.Join(home, "bin", "tool")
+	if target, err := os.Readlink(tool); err != nil || target != filepath.Join(src, "bin", "tool") {
+		t.Fatalf("Readlink(%s) = %q, %v, want a link to the source", tool, target, err)
+	}
+
+	// A file added to the source that matches is picked up on reload.
+	writeTestFile(t, filepath.Join(src, "dot_config", "extra.conf"), "extra\n")
+	if res, err := s.Reload(Options{}); err != nil || res.Skipped {
+		t.Fatalf("Reload() = %+v, %v, want the new match loaded", res, err)
+	}
+	if raw, err := os.ReadFile(filepath.Join(home, ".config", "extra.conf")); err != nil || string(raw) != "extra\n" {
+		t.Fatalf("extra.conf = %q, %v, want copied content", raw, err)
+	}
+
+	collide := writeProfile(t, manifest.Root{
+		Source: "home",
+		Dest:   home,
+		Tree:   manifest.Tree{".zshrc": manifest.FileNode("copy")},
+		Glob:   map[string][]string{".zsh*": {"copy"}},
+	})
+	writeTestFile(t, filepath.Join(collide, "home", "dot_zshrc"), "zsh\n")
+	if _, err := s.ReloadFrom(collide, Options{}); err == nil || !strings.Contains(err.Error(), "duplicate destination") {
+		t.Fatalf("Reload() error = %v, want a glob matching a tree entry refused", err)
+	}
+}
@@ -0,0 +1,243 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/olimci/tohru/pkg/digest"
+	"github.com/olimci/tohru/pkg/store/state"
+	"github.com/olimci/tohru/pkg/utils/fileutils"
+)
+
+// Rehash re-snapshots every tracked path and relabels every backup tracked or
+// stashed paths refer to whose digest was not computed with algorithm, so the
+// state uses one algorithm throughout. Tracked paths are re-snapshotted as
+// they are now: drift that happened before the rehash becomes the new
+// recorded state.
+func (s Store) Rehash(algorithm string) (RehashResult, error) {
+	var result RehashResult
+	guard, err := s.Lock()
+	if err != nil {
+		return result, err
+	}
+	defer guard.Unlock()
+
+	result, err = s.rehashUnlocked(algorithm)
+	if err == nil {
+		result.Warnings = historyWarning(result.Warnings, s.logHistory("rehash", "", result.RehashedCount, result.ChangedPaths))
+	}
+	return result, err
+}
+
+func (s Store) rehashUnlocked(algorithm string) (RehashResult, error) {
+	if !s.IsInstalled() {
+		return RehashResult{}, ErrNotInstalled
+	}
+	algorithm = strings.TrimSpace(algorithm)
+	if algorithm != digest.AlgorithmSHA256 {
+		return RehashResult{}, fmt.Errorf("unsupported digest algorithm %q", algorithm)
+	}
+
+	lck, err := s.LoadState()
+	if err != nil {
+		return RehashResult{}, err
+	}
+
+	changes := newPathRecorder()
+	var result RehashResult
+	if w := newerWriterWarning(lck); w != "" {
+		result.Warnings = append(result.Warnings, w)
+	}
+	// Added profiles' files share their arrays with lck, so updating f
+	// through these pointers updates the state that is saved.
+	files := make([]*state.File, 0, len(lck.Files))
+	for i := range lck.Files {
+		files = append(files, &lck.Files[i])
+	}
+	for _, layer := range lck.Added {
+		for i := range layer.Files {
+			files = append(files, &layer.Files[i])
+		}
+	}
+
+	// Paths backed up with the same content share a backup, so each CID is
+	// relabeled once and every reference to it takes the result. A missing
+	// backup is recorded as nil. The backups are only listed once, when the
+	// first one needs relabeling.
+	relabeled := make(map[string]*state.Object)
+	var dirs map[string][]string
+	relabel := func(backup state.Object, path string) (*state.Object, error) {
+		stale, err := usesOtherAlgorithm(backup.Digest, algorithm)
+		if err != nil {
+			return nil, fmt.Errorf("parse backup digest for %s: %w", path, err)
+		}
+		if !stale {
+			return &backup, nil
+		}
+		next, done := relabeled[backup.Digest]
+		if !done {
+			if dirs == nil {
+				if dirs, err = s.backups().Dirs(); err != nil {
+					return nil, err
+				}
+			}
+			next, err = relabelBackup(backup, dirs[backup.Digest], changes.Add)
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return nil, err
+			}
+			relabeled[backup.Digest] = next
+			if next != nil {
+				result.RelabeledBackupCount++
+			}
+		}
+		if next == nil {
+			result.Warnings = append(result.Warnings, fmt.Sprintf("backup for %s is missing, its digest was left as is", path))
+			return &backup, nil
+		}
+		shared := *next
+		return &shared, nil
+	}
+
+	// Tracked paths are snapshotted as load and status do, leaving out what
+	// is tracked inside a tracked directory.
+	all := lck.AllFiles()
+	for _, f := range files {
+		stale, err := usesOtherAlgorithm(f.Current.Digest, algorithm)
+		if err != nil {
+			return RehashResult{}, fmt.Errorf("parse tracked digest for %s: %w", f.Path, err)
+		}
+		if stale {
+			current, exists, err := snapshotTracked(*f, all)
+			if err != nil {
+				return RehashResult{}, fmt.Errorf("snapshot tracked path %s: %w", f.Path, err)
+			}
+			if !exists {
+				result.Warnings = append(result.Warnings, fmt.Sprintf("tracked path %s is missing, its digest was left as is", f.Path))
+			} else {
+				f.Current = current
+				result.RehashedCount++
+			}
+		}
+
+		if f.Previous == nil {
+			continue
+		}
+		if f.Previous, err = relabel(*f.Previous, f.Path); err != nil {
+			return RehashResult{}, err
+		}
+	}
+	for i := range lck.Stashed {
+		stash := &lck.Stashed[i]
+		backup, err := relabel(stash.Backup, stash.Path)
+		if err != nil {
+			return RehashResult{}, err
+		}
+		stash.Backup = *backup
+	}
+
+	if result.RehashedCount == 0 && result.RelabeledBackupCount == 0 {
+		result.ChangedPaths = changes.Paths()
+		return result, nil
+	}
+	if err := s.SaveState(lck); err != nil {
+		return RehashResult{}, err
+	}
+	changes.Add(s.StatePath())
+	result.ChangedPaths = changes.Paths()
+
+	return result, nil
+}
+
+// relabelBackup re-digests a stored backup object and moves every copy of it,
+// kept in dirs, to the directory named by its new CID, beside the old one so
+// it keeps its layout. It fails with os.ErrNotExist when no copy is there.
+func relabelBackup(prev state.Object, dirs []string, recordPath func(string)) (*state.Object, error) {
+	var next string
+	for _, dir := range dirs {
+		oldPath := filepath.Join(dir, backupObjectFile)
+		if _, err := os.Lstat(oldPath); errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("check backup object %s: %w", oldPath, err)
+		}
+
+		relabeled, err := snapshot(oldPath)
+		if err != nil {
+			return nil, fmt.Errorf("snapshot backup object %s: %w", oldPath, err)
+		}
+		if next != "" && relabeled.Digest != next {
+			return nil, fmt.Errorf("copies of backup %s differ: %s and %s", prev.Digest, next, relabeled.Digest)
+		}
+		next = relabeled.Digest
+
+		newPath := filepath.Join(filepath.Dir(dir), next, backupObjectFile)
+		if _, exists, err := maybeSnapshot(newPath); err != nil {
+			return nil, fmt.Errorf("check backup object at %s: %w", newPath, err)
+		} else if !exists {
+			if err := os.MkdirAll(filepath.Dir(newPath), 0o755); err != nil {
+				return nil, fmt.Errorf("create backup directory for %s: %w", newPath, err)
+			}
+			if err := os.Rename(oldPath, newPath); err != nil {
+				return nil, fmt.Errorf("move backup %s to %s: %w", oldPath, newPath, err)
+			}
+			recordPath(newPath)
+
+			oldMeta, newMeta := backupMetaPath(oldPath), backupMetaPath(newPath)
+			if err := os.Rename(oldMeta, newMeta); err == nil {
+				recordPath(newMeta)
+			} else if !errors.Is(err, os.ErrNotExist) {
+				return nil, fmt.Errorf("move backup metadata %s to %s: %w", oldMeta, newMeta, err)
+			}
+		}
+		if err := fileutils.RemovePath(dir); err != nil {
+			return nil, err
+		}
+		recordPath(dir)
+	}
+	if next == "" {
+		return nil, os.ErrNotExist
+	}
+
+	// Only the CID is recorded, see storeBackup.
+	return &state.Object{Digest: next}, nil
+}
+
+func usesOtherAlgorithm(raw, algorithm string) (bool, error) {
+	d, err := digest.Parse(raw)
+	if err != nil {
+		return false, err
+	}
+	return !d.IsZero() && d.Kind != digest.KindNull && d.Algorithm != algorithm, nil
+}
+
+// stateAlgorithms lists the distinct digest algorithms recorded in st.
+func stateAlgorithms(st state.State) []string {
+	seen := make(map[string]struct{}, 1)
+	add := func(raw string) {
+		d, err := digest.Parse(raw)
+		if err != nil || d.IsZero() || d.Kind == digest.KindNull {
+			return
+		}
+		seen[d.Algorithm] = struct{}{}
+	}
+	for _, f := range st.AllFiles() {
+		add(f.Current.Digest)
+		if f.Previous != nil {
+			add(f.Previous.Digest)
+		}
+	}
+	for _, stash := range st.Stashed {
+		add(stash.Backup.Digest)
+	}
+
+	algorithms := make([]string, 0, len(seen))
+	for algorithm := range seen {
+		algorithms = append(algorithms, algorithm)
+	}
+	slices.Sort(algorithms)
+	return algorithms
+}
@@ -0,0 +1,194 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/olimci/tohru/pkg/manifest"
+	"github.com/olimci/tohru/pkg/store/state"
+	"github.com/olimci/tohru/pkg/utils/fileutils"
+)
+
+func TestRehashRelabelsMixedAlgorithms(t *testing.T) {
+	s, home := newTestStore(t)
+	zshrc := filepath.Join(home, ".zshrc")
+	writeTestFile(t, zshrc, "original\n")
+	original := mustDigest(t, zshrc)
+
+	profile := writeProfile(t, manifest.Root{
+		Source:   "home",
+		Dest:     home,
+		Defaults: &manifest.Defaults{Type: "copy"},
+		Tree: manifest.Tree{
+			".zshrc": manifest.FileNode(),
+		},
+	})
+	writeTestFile(t, filepath.Join(profile, "home", "dot_zshrc"), "managed\n")
+	if _, err := s.Load(profile, Options{}); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	// Simulate a partially migrated state: the backup is labelled with an
+	// algorithm other than the one used for the current digest.
+	lck, err := s.LoadState()
+	if err != nil {
+		t.Fatalf("LoadState() error = %v", err)
+	}
+	legacy := "file:legacy:0123abcd"
+	if err := os.Rename(filepath.Dir(backupPath(s, original, "")), filepath.Dir(backupPath(s, legacy, ""))); err != nil {
+		t.Fatalf("Rename() error = %v", err)
+	}
+	lck.Files[0].Previous.Digest = legacy
+	lck.Files[0].Previous.Path = backupPath(s, legacy, "")
+	if err := s.SaveState(lck); err != nil {
+		t.Fatalf("SaveState() error = %v", err)
+	}
+
+	snapshot, err := s.Status()
+	if err != nil {
+		t.Fatalf("Status() error = %v", err)
+	}
+	if !slices.Equal(snapshot.Algorithms, []string{"legacy", "sha256"}) {
+		t.Fatalf("Algorithms = %v, want [legacy sha256]", snapshot.Algorithms)
+	}
+
+	res, err := s.Rehash("sha256")
+	if err != nil {
+		t.Fatalf("Rehash() error = %v", err)
+	}
+	if res.RehashedCount != 0 || res.RelabeledBackupCount != 1 {
+		t.Fatalf("Rehash() counts = %d rehashed, %d relabeled, want 0, 1", res.RehashedCount, res.RelabeledBackupCount)
+	}
+
+	lck, err = s.LoadState()
+	if err != nil {
+		t.Fatalf("LoadState() error = %v", err)
+	}
+	if got := lck.Files[0].Previous.Digest; got != original {
+		t.Fatalf("Previous.Digest = %s, want %s", got, original)
+	}
+	if _, err := os.Stat(backupPath(s, original, "")); err != nil {
+		t.Fatalf("relabeled backup missing: %v", err)
+	}
+	if _, err := os.Stat(filepath.Dir(backupPath(s, legacy, ""))); !os.IsNotExist(err) {
+		t.Fatalf("legacy backup dir still present: %v", err)
+	}
+
+	if _, err := s.Rehash("md5"); err == nil {
+		t.Fatalf("Rehash(md5) error = nil, want unsupported algorithm")
+	}
+}
+
+func TestRehashRelabelsSharedBackupOnce(t *testing.T) {
+	s, home := newTestStore(t)
+	zshrc := filepath.Join(home, ".zshrc")
+	writeTestFile(t, zshrc, "original\n")
+	original := mustDigest(t, zshrc)
+
+	profile := writeProfile(t, manifest.Root{
+		Source:   "home",
+		Dest:     home,
+		Defaults: &manifest.Defaults{Type: "copy"},
+		Tree:     manifest.Tree{".zshrc": manifest.FileNode()},
+	})
+	writeTestFile(t, filepath.Join(profile, "home", "dot_zshrc"), "managed\n")
+	if _, err := s.Load(profile, Options{}); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	// The tracked path and a stash refer to the same legacy backup.
+	lck, err := s.LoadState()
+	if err != nil {
+		t.Fatalf("LoadState() error = %v", err)
+	}
+	legacy := "file:legacy:0123abcd"
+	if err := os.Rename(filepath.Dir(backupPath(s, original, "")), filepath.Dir(backupPath(s, legacy, ""))); err != nil {
+		t.Fatalf("Rename() error = %v", err)
+	}
+	lck.Files[0].Previous.Digest = legacy
+	lck.Stashed = []state.Stash{{Path: filepath.Join(home, ".bashrc"), Backup: state.Object{Digest: legacy}}}
+	if err := s.SaveState(lck); err != nil {
+		t.Fatalf("SaveState() error = %v", err)
+	}
+	if got := stateAlgorithms(state.State{Stashed: lck.Stashed}); !slices.Equal(got, []string{"legacy"}) {
+		t.Fatalf("stateAlgorithms(stashed only) = %v, want [legacy]", got)
+	}
+
+	res, err := s.Rehash("sha256")
+	if err != nil {
+		t.Fatalf("Rehash() error = %v", err)
+	}
+	if res.RelabeledBackupCount != 1 || len(res.Warnings) != 0 {
+		t.Fatalf("Rehash() relabeled %d, warnings %v, want 1 and none", res.RelabeledBackupCount, res.Warnings)
+	}
+	lck, err = s.LoadState()
+	if err != nil {
+		t.Fatalf("LoadState() error = %v", err)
+	}
+	if lck.Files[0].Previous.Digest != original || lck.Stashed[0].Backup.Digest != original {
+		t.Fatalf("digests after Rehash() = %s and %s, want %s for both", lck.Files[0].Previous.Digest, lck.Stashed[0].Backup.Digest, original)
+	}
+}
+
+func TestRehashRelabelsEveryMirroredCopy(t *testing.T) {
+	s, home := newTestStore(t)
+	zshrc := filepath.Join(home, ".zshrc")
+	writeTestFile(t, zshrc, "original\n")
+	original := mustDigest(t, zshrc)
+
+	profile := writeProfile(t, manifest.Root{
+		Source:   "home",
+		Dest:     home,
+		Defaults: &manifest.Defaults{Type: "copy"},
+		Tree:     manifest.Tree{".zshrc": manifest.FileNode()},
+	})
+	writeTestFile(t, filepath.Join(profile, "home", "dot_zshrc"), "managed\n")
+	if _, err := s.Load(profile, Options{}); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	// The backup is kept twice in the mirror layout, under a legacy label.
+	legacy := "file:legacy:0123abcd"
+	kept := filepath.Dir(backupPath(s, original, ""))
+	var copies []string
+	for _, from := range []string{"a", "b"} {
+		dir := filepath.Join(s.BackupsPath(), mirrorDir, from)
+		if err := fileutils.CopyPath(kept, filepath.Join(dir, legacy)); err != nil {
+			t.Fatalf("CopyPath() error = %v", err)
+		}
+		copies = append(copies, dir)
+	}
+	if err := os.RemoveAll(kept); err != nil {
+		t.Fatalf("RemoveAll() error = %v", err)
+	}
+	lck, err := s.LoadState()
+	if err != nil {
+		t.Fatalf("LoadState() error = %v", err)
+	}
+	lck.Files[0].Previous.Digest = legacy
+	if err := s.SaveState(lck); err != nil {
+		t.Fatalf("SaveState() error = %v", err)
+	}
+
+	res, err := s.Rehash("sha256")
+	if err != nil || res.RelabeledBackupCount != 1 {
+		t.Fatalf("Rehash() = %+v, %v, want one backup relabeled", res, err)
+	}
+	for _, dir := range copies {
+		if _, err := os.Stat(filepath.Join(dir, original, backupObjectFile)); err != nil {
+			t.Fatalf("relabeled copy in %s: %v", dir, err)
+		}
+		if _, err := os.Stat(filepath.Join(dir, legacy)); !os.IsNotExist(err) {
+			t.Fatalf("copy in %s still under the legacy label: %v", dir, err)
+		}
+	}
+	lck, err = s.LoadState()
+	if err != nil {
+		t.Fatalf("LoadState() error = %v", err)
+	}
+	if prev := lck.Files[0].Previous; prev.Digest != original || prev.Path != "" {
+		t.Fatalf("Previous = %+v, want only the CID %s recorded", prev, original)
+	}
+}
@@ -0,0 +1,275 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/olimci/tohru/pkg/digest"
+	"github.com/olimci/tohru/pkg/store/state"
+	"github.com/olimci/tohru/pkg/utils/fileutils"
+)
+
+// Relocate moves the store to dest, which must not exist yet or be an empty
+// directory that can be written to, and rewrites the paths the store records
+// inside itself: profiles loaded from or cached in the store, extracted and
+// downloaded archives and a default source kept there. Source cache entries
+// for profiles in the store are dropped. Managed destinations are absolute
+// and don't depend on where the store is, so they are left alone; symlinks
+// pointing into the old location are reported, since only a reload repoints
+// them. It refuses while another operation holds the store or an interrupted
+// one is pending. The returned store is rooted at dest.
+func (s Store) Relocate(dest string) (Store, RelocateResult, error) {
+	if !s.IsInstalled() {
+		return Store{}, RelocateResult{}, ErrNotInstalled
+	}
+	dest, err := fileutils.AbsPath(dest)
+	if err != nil {
+		return Store{}, RelocateResult{}, fmt.Errorf("resolve %s: %w", dest, err)
+	}
+	if s.holds(dest) {
+		return Store{}, RelocateResult{}, fmt.Errorf("can't move the store in %s to %s, which is inside it", s.Root, dest)
+	}
+	if err := checkRelocateDest(dest); err != nil {
+		return Store{}, RelocateResult{}, err
+	}
+
+	guard, err := s.TryLock()
+	if err != nil {
+		return Store{}, RelocateResult{}, err
+	}
+	if _, err := os.Stat(s.JournalPath()); err == nil {
+		guard.Unlock()
+		return Store{}, RelocateResult{}, fmt.Errorf("an interrupted transaction is pending, run `tohru gc` to recover it before moving the store")
+	}
+
+	entries, err := os.ReadDir(s.Root)
+	if err != nil {
+		guard.Unlock()
+		return Store{}, RelocateResult{}, fmt.Errorf("read store %s: %w", s.Root, err)
+	}
+	result := RelocateResult{From: s.Root, To: dest}
+	for _, entry := range entries {
+		if entry.Name() != lockPath {
+			result.MovedPaths = append(result.MovedPaths, filepath.Join(dest, entry.Name()))
+		}
+	}
+
+	err = moveDir(s.Root, dest)
+	// The lock moved along with the store, so releasing it is the same
+	// either way.
+	guard.Unlock()
+	if err != nil {
+		return Store{}, RelocateResult{}, err
+	}
+
+	moved := s
+	moved.Root = dest
+	guard, err = moved.Lock()
+	if err != nil {
+		return moved, result, err
+	}
+	defer guard.Unlock()
+
+	changed, warnings, err := moved.rebaseRecords(s.Root)
+	result.RewrittenPaths = changed
+	result.Warnings = warnings
+	if err != nil {
+		return moved, result, err
+	}
+	result.Warnings = historyWarning(result.Warnings, moved.logHistory("move-store", "", 0, append(changed, result.MovedPaths...)))
+	return moved, result, nil
+}
+
+// checkRelocateDest fails unless dest is an empty directory, or missing with
+// a parent it can be created in, and a file can be written where it will be.
+func checkRelocateDest(dest string) error {
+	entries, err := os.ReadDir(dest)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		parent := filepath.Dir(dest)
+		if err := os.MkdirAll(parent, 0o755); err != nil {
+			return fmt.Errorf("create parent directory for %s: %w", dest, err)
+		}
+		return checkWritable(parent)
+	case err != nil:
+		return fmt.Errorf("read %s: %w", dest, err)
+	case len(entries) > 0:
+		return fmt.Errorf("can't move the store to %s, which is not empty", dest)
+	}
+	return checkWritable(dest)
+}
+
+// moveDir renames src to dest, an empty directory or nothing, copying and
+// removing src instead when they are on different filesystems.
+func moveDir(src, dest string) error {
+	err := os.Rename(src, dest)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, syscall.EXDEV) {
+		return fmt.Errorf("move %s to %s: %w", src, dest, err)
+	}
+	if err := fileutils.CopyPathWith(src, dest, fileutils.CopyOptions{PreserveTimes: true}); err != nil {
+		return fmt.Errorf("copy %s to %s: %w", src, dest, err)
+	}
+	if err := fileutils.RemovePath(src); err != nil {
+		return fmt.Errorf("remove %s after copying it to %s: %w", src, dest, err)
+	}
+	return nil
+}
+
+// rebaseRecords rewrites the paths under old the store at its new root
+// records, and returns the files it rewrote and warnings about managed links
+// that still point under old.
+func (s Store) rebaseRecords(old string) ([]string, []string, error) {
+	rebase := func(path string) (string, bool) {
+		if path == "" {
+			return path, false
+		}
+		rel, err := filepath.Rel(old, path)
+		if err != nil || fileutils.Escapes(rel) {
+			return path, false
+		}
+		return filepath.Join(s.Root, rel), true
+	}
+	var changed, warnings []string
+
+	for _, path := range []string{s.StatePath(), s.StateBackupPath()} {
+		var lck state.State
+		if err := decodeJSON(path, &lck); errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return changed, warnings, fmt.Errorf("decode %s: %w", path, err)
+		}
+		rewrote := rebaseProfile(&lck.Profile, rebase)
+		for i := range lck.Added {
+			rewrote = rebaseProfile(&lck.Added[i].Profile, rebase) || rewrote
+		}
+		// Target is a pointer, so rebasing it through the copies AllFiles
+		// returns updates lck.
+		for _, f := range lck.AllFiles() {
+			if f.Target == nil {
+				continue
+			}
+			var ok bool
+			if f.Target.Path, ok = rebase(f.Target.Path); ok {
+				rewrote = true
+			}
+		}
+		if path == s.StatePath() {
+			warnings = append(warnings, linksInto(lck, old)...)
+		}
+		if !rewrote {
+			continue
+		}
+		// Written as is, so the state file keeps its writer version and the
+		// backup stays byte-for-byte what it was, apart from the paths.
+		if err := s.retry.Do(func() error { return encodeJSON(path, lck) }); err != nil {
+			return changed, warnings, err
+		}
+		changed = append(changed, path)
+	}
+
+	profiles, err := s.LoadProfiles()
+	if err != nil {
+		return changed, warnings, err
+	}
+	rewrote := false
+	for slug, profile := range profiles {
+		var ok bool
+		if profile.Path, ok = rebase(profile.Path); ok {
+			profiles[slug] = profile
+			rewrote = true
+		}
+	}
+	if rewrote {
+		if err := s.SaveProfiles(profiles); err != nil {
+			return changed, warnings, err
+		}
+		changed = append(changed, s.ProfilesFilePath())
+	}
+
+	// Cached sources in the store are dropped rather than rebased: their
+	// fingerprints cover the old source paths, so a reload would take the
+	// profile for unchanged and keep the links into the old location.
+	cache := s.loadSourceCache()
+	rewrote = false
+	for dir := range cache {
+		if _, ok := rebase(dir); ok {
+			delete(cache, dir)
+			rewrote = true
+		}
+	}
+	if rewrote {
+		if err := encodeJSON(s.SourceCachePath(), cache); err != nil {
+			return changed, warnings, err
+		}
+		changed = append(changed, s.SourceCachePath())
+	}
+
+	if rewrote, err := s.rebaseDefaultSource(rebase); err != nil {
+		return changed, warnings, err
+	} else if rewrote {
+		changed = append(changed, s.ConfigPath())
+	}
+
+	return changed, warnings, nil
+}
+
+// rebaseDefaultSource rebases options.default_source in the config file. The
+// file is rewritten through a map rather than LoadConfig, so options set from
+// the environment aren't written into it.
+func (s Store) rebaseDefaultSource(rebase func(string) (string, bool)) (bool, error) {
+	var cfg map[string]any
+	if err := decodeJSON(s.ConfigPath(), &cfg); errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("decode %s: %w", s.ConfigPath(), err)
+	}
+	options, _ := cfg["options"].(map[string]any)
+	source, _ := options["default_source"].(string)
+	if source == "" || isRemote(source) {
+		return false, nil
+	}
+	path, err := fileutils.AbsPath(source)
+	if err != nil {
+		return false, nil
+	}
+	path, ok := rebase(path)
+	if !ok {
+		return false, nil
+	}
+	options["default_source"] = path
+	return true, encodeJSON(s.ConfigPath(), cfg)
+}
+
+// rebaseProfile rebases the paths of p into the store, and reports whether
+// any changed.
+func rebaseProfile(p *state.Profile, rebase func(string) (string, bool)) bool {
+	var movedPath, movedArchive bool
+	p.Path, movedPath = rebase(p.Path)
+	p.Archive, movedArchive = rebase(p.Archive)
+	return movedPath || movedArchive
+}
+
+// linksInto warns about the managed symlinks in lck that point under old.
+func linksInto(lck state.State, old string) []string {
+	var warnings []string
+	for _, f := range lck.AllFiles() {
+		d, err := digest.Parse(f.Current.Digest)
+		if err != nil || d.Kind != digest.KindSymlink {
+			continue
+		}
+		target, err := os.Readlink(f.Path)
+		if err != nil || !filepath.IsAbs(target) {
+			continue
+		}
+		if rel, err := filepath.Rel(old, target); err == nil && !fileutils.Escapes(rel) {
+			warnings = append(warnings, fmt.Sprintf("%s still links into the old store location, run `tohru reload` to repoint it", f.Path))
+		}
+	}
+	return warnings
+}
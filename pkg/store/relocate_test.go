@@ -0,0 +1,126 @@
+package store
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/olimci/tohru/pkg/manifest"
+	"github.com/olimci/tohru/pkg/store/state"
+)
+
+func TestRelocate(t *testing.T) {
+	s, home := newTestStore(t)
+	if _, err := s.Install(); err != nil {
+		t.Fatalf("Install() error = %v", err)
+	}
+
+	// A profile kept in the store, as `tohru new` makes them, linked into
+	// home and set as the default source.
+	external := writeProfile(t, manifest.Root{
+		Source: "home",
+		Dest:   home,
+		Tree:   manifest.Tree{".zshrc": manifest.FileNode("link")},
+	})
+	writeTestFile(t, filepath.Join(external, "home", "dot_zshrc"), "managed\n")
+	profile := filepath.Join(s.ProfilesPath(), "test")
+	if err := os.Rename(external, profile); err != nil {
+		t.Fatalf("Rename() error = %v", err)
+	}
+	if err := s.SaveProfiles(map[string]state.CachedProfile{"test": {Slug: "test", Path: profile}}); err != nil {
+		t.Fatalf("SaveProfiles() error = %v", err)
+	}
+	if err := encodeJSON(s.ConfigPath(), map[string]any{
+		"schema":  1,
+		"options": map[string]any{"default_source": profile, "backups": map[string]any{"enabled": true, "prune": "auto"}},
+	}); err != nil {
+		t.Fatalf("encodeJSON() error = %v", err)
+	}
+	if _, err := s.Load(profile, Options{}); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	dest := filepath.Join(t.TempDir(), "moved")
+	moved, res, err := s.Relocate(dest)
+	if err != nil {
+		t.Fatalf("Relocate() error = %v", err)
+	}
+	if moved.Root != dest || res.From != s.Root || res.To != dest {
+		t.Fatalf("Relocate() = %s, %+v, want the store moved from %s to %s", moved.Root, res, s.Root, dest)
+	}
+	if _, err := os.Stat(s.Root); !os.IsNotExist(err) {
+		t.Fatalf("old store root still exists: %v", err)
+	}
+	if !slices.Contains(res.MovedPaths, moved.StatePath()) || !slices.Contains(res.MovedPaths, moved.ProfilesPath()) {
+		t.Fatalf("MovedPaths = %v, want the state and profiles at their new location", res.MovedPaths)
+	}
+	for _, path := range []string{moved.StatePath(), moved.ProfilesFilePath(), moved.ConfigPath()} {
+		if !slices.Contains(res.RewrittenPaths, path) {
+			t.Fatalf("RewrittenPaths = %v, want %s", res.RewrittenPaths, path)
+		}
+	}
+
+	newProfile := filepath.Join(moved.ProfilesPath(), "test")
+	lck, err := moved.LoadState()
+	if err != nil {
+		t.Fatalf("LoadState() error = %v", err)
+	}
+	if lck.Profile.Path != newProfile {
+		t.Fatalf("state profile path = %s, want %s", lck.Profile.Path, newProfile)
+	}
+	profiles, err := moved.LoadProfiles()
+	if err != nil || profiles["test"].Path != newProfile {
+		t.Fatalf("LoadProfiles() = %+v, %v, want test at %s", profiles, err, newProfile)
+	}
+	if source, err := moved.DefaultSource(); err != nil || source != newProfile {
+		t.Fatalf("DefaultSource() = %s, %v, want %s", source, err, newProfile)
+	}
+	if len(res.Warnings) != 1 || !strings.Contains(res.Warnings[0], filepath.Join(home, ".zshrc")) {
+		t.Fatalf("Warnings = %v, want the link into the old store reported", res.Warnings)
+	}
+
+	// Reloading repoints the link at the moved profile.
+	if _, err := moved.Reload(Options{}); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	if target, err := os.Readlink(filepath.Join(home, ".zshrc")); err != nil || !strings.HasPrefix(target, dest) {
+		t.Fatalf("link target after reload = %s, %v, want it inside %s", target, err, dest)
+	}
+}
+
+func TestRelocateRefuses(t *testing.T) {
+	s, _ := newTestStore(t)
+	if _, err := s.Install(); err != nil {
+		t.Fatalf("Install() error = %v", err)
+	}
+
+	full := t.TempDir()
+	writeTestFile(t, filepath.Join(full, "keep"), "mine\n")
+	if _, _, err := s.Relocate(full); err == nil || !strings.Contains(err.Error(), "not empty") {
+		t.Fatalf("Relocate() into a non-empty directory error = %v, want it refused", err)
+	}
+	if _, _, err := s.Relocate(filepath.Join(s.Root, "nested")); err == nil || !strings.Contains(err.Error(), "inside it") {
+		t.Fatalf("Relocate() into the store error = %v, want it refused", err)
+	}
+
+	guard, err := s.Lock()
+	if err != nil {
+		t.Fatalf("Lock() error = %v", err)
+	}
+	_, _, err = s.Relocate(filepath.Join(t.TempDir(), "moved"))
+	guard.Unlock()
+	if !errors.Is(err, ErrLocked) {
+		t.Fatalf("Relocate() while locked error = %v, want ErrLocked", err)
+	}
+
+	writeTestFile(t, s.JournalPath(), "{}\n")
+	if _, _, err := s.Relocate(filepath.Join(t.TempDir(), "moved")); err == nil || !strings.Contains(err.Error(), "interrupted transaction") {
+		t.Fatalf("Relocate() with a pending journal error = %v, want it refused", err)
+	}
+	if !s.IsInstalled() {
+		t.Fatal("refused Relocate() moved the store")
+	}
+}
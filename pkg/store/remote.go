@@ -0,0 +1,192 @@
+package store
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/olimci/tohru/pkg/store/state"
+	"github.com/olimci/tohru/pkg/utils/archiveutils"
+	"github.com/olimci/tohru/pkg/utils/fileutils"
+)
+
+const (
+	maxDownloadSize = 256 << 20
+	downloadTimeout = 5 * time.Minute
+)
+
+// downloadClient fetches remote sources. Tests replace it to trust their
+// server's certificate.
+var downloadClient = &http.Client{Timeout: downloadTimeout}
+
+// remoteSource is a profile archive at an http(s) URL, optionally pinned to
+// a sha256 with a "#sha256=<hex>" fragment. A "subdir=<path>" fragment
+// parameter, joined to the pin with "&", names the directory of the archive
+// holding the manifest.
+type remoteSource struct {
+	URL    string // without the fragment
+	SHA256 string
+	Subdir string // manifest directory within the archive
+	ext    string // archive extension of the URL path
+
+	insecure bool // plain http is allowed, redirects to it included
+}
+
+func isRemote(ref string) bool {
+	ref = strings.ToLower(strings.TrimSpace(ref))
+	return strings.HasPrefix(ref, "https://") || strings.HasPrefix(ref, "http://")
+}
+
+// parseRemote validates a remote source reference. Plain http is rejected
+// unless insecure is set.
+func parseRemote(ref string, insecure bool) (remoteSource, error) {
+	u, err := url.Parse(strings.TrimSpace(ref))
+	if err != nil {
+		return remoteSource{}, fmt.Errorf("parse source URL: %w", err)
+	}
+	if u.Scheme == "http" && !insecure {
+		return remoteSource{}, fmt.Errorf("refusing to download %s over plain http, use https or --insecure", u.Redacted())
+	}
+	if !archiveutils.IsArchive(u.Path) {
+		return remoteSource{}, fmt.Errorf("source URL %s does not name a .tar, .tar.gz, .tgz or .zip archive", u.Redacted())
+	}
+
+	src := remoteSource{ext: archiveExt(u.Path), insecure: insecure}
+	for _, param := range strings.Split(u.Fragment, "&") {
+		if param == "" {
+			continue
+		}
+		if sum, ok := strings.CutPrefix(param, "sha256="); ok {
+			sum = strings.ToLower(sum)
+			if _, err := hex.DecodeString(sum); err != nil || len(sum) != sha256.Size*2 {
+				return remoteSource{}, fmt.Errorf("source URL fragment %q: expected sha256=<64 hex characters>", u.Fragment)
+			}
+			src.SHA256 = sum
+		} else if subdir, ok := strings.CutPrefix(param, "subdir="); ok {
+			if src.Subdir, err = cleanSubdir(subdir); err != nil {
+				return remoteSource{}, fmt.Errorf("source URL fragment %q: %w", u.Fragment, err)
+			}
+		} else {
+			return remoteSource{}, fmt.Errorf("source URL fragment %q: expected sha256=<64 hex characters> or subdir=<path>", u.Fragment)
+		}
+	}
+	u.Fragment = ""
+	src.URL = u.String()
+	return src, nil
+}
+
+// download fetches src into the store's download cache and returns the path
+// of the archive and its sha256. Downloads are limited to maxDownloadSize
+// and, when src is pinned, verified before they are used.
+func (s Store) download(src remoteSource) (string, string, error) {
+	client := *downloadClient
+	client.CheckRedirect = checkRedirect(src.insecure)
+	resp, err := client.Get(src.URL)
+	if err != nil {
+		return "", "", fmt.Errorf("download %s: %w", src.URL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", "", fmt.Errorf("download %s: %s", src.URL, resp.Status)
+	}
+	if resp.ContentLength > maxDownloadSize {
+		return "", "", fmt.Errorf("download %s: %d bytes exceeds the %d byte limit", src.URL, resp.ContentLength, maxDownloadSize)
+	}
+
+	if err := os.MkdirAll(s.DownloadsPath(), 0o755); err != nil {
+		return "", "", fmt.Errorf("create %s: %w", s.DownloadsPath(), err)
+	}
+	tmp, err := os.CreateTemp(s.DownloadsPath(), "download"+tempMarker)
+	if err != nil {
+		return "", "", fmt.Errorf("create download file: %w", err)
+	}
+	defer func() { _ = fileutils.RemovePath(tmp.Name()) }()
+
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(tmp, h), io.LimitReader(resp.Body, maxDownloadSize+1))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("download %s: %w", src.URL, err)
+	}
+	if n > maxDownloadSize {
+		return "", "", fmt.Errorf("download %s: exceeds the %d byte limit", src.URL, maxDownloadSize)
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+	if src.SHA256 != "" && sum != src.SHA256 {
+		return "", "", fmt.Errorf("download %s: sha256 is %s, expected %s", src.URL, sum, src.SHA256)
+	}
+
+	// Keep the archive extension so the download is extracted like a local
+	// archive, and name it by content so unchanged downloads share a path.
+	dest := filepath.Join(s.DownloadsPath(), sum+src.ext)
+	if err := os.Rename(tmp.Name(), dest); err != nil {
+		return "", "", fmt.Errorf("move download into %s: %w", dest, err)
+	}
+	return dest, sum, nil
+}
+
+// checkRedirect returns an http.Client.CheckRedirect following at most 10
+// redirects, like the default, and unless insecure, only to https: a
+// redirect mustn't get a download past the refusal of plain http.
+func checkRedirect(insecure bool) func(*http.Request, []*http.Request) error {
+	return func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		if req.URL.Scheme != "https" && !insecure {
+			return fmt.Errorf("refusing to follow a redirect to %s over plain http, use --insecure", req.URL.Redacted())
+		}
+		return nil
+	}
+}
+
+// archiveExt returns the archive extension of name, or "" if it has none.
+func archiveExt(name string) string {
+	for _, ext := range []string{".tar.gz", ".tgz", ".tar", ".zip"} {
+		if strings.HasSuffix(strings.ToLower(name), ext) {
+			return ext
+		}
+	}
+	return ""
+}
+
+// loadedArchives lists the archives the loaded profiles were extracted from.
+func loadedArchives(lck state.State) []string {
+	archives := []string{lck.Profile.Archive}
+	for _, layer := range lck.Added {
+		archives = append(archives, layer.Profile.Archive)
+	}
+	return archives
+}
+
+// staleDownloads lists downloaded archives no loaded profile was extracted
+// from, including partial downloads.
+func (s Store) staleDownloads(archives []string) ([]string, error) {
+	entries, err := os.ReadDir(s.DownloadsPath())
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read downloads %s: %w", s.DownloadsPath(), err)
+	}
+
+	var stale []string
+	for _, entry := range entries {
+		path := filepath.Join(s.DownloadsPath(), entry.Name())
+		if !slices.Contains(archives, path) {
+			stale = append(stale, path)
+		}
+	}
+	return stale, nil
+}
@@ -0,0 +1,107 @@
+package store
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/olimci/tohru/pkg/manifest"
+)
+
+func TestLoadFromURL(t *testing.T) {
+	s, home := newTestStore(t)
+	profile := writeProfile(t, manifest.Root{
+		Source:   "home",
+		Dest:     home,
+		Defaults: &manifest.Defaults{Type: "copy"},
+		Tree:     manifest.Tree{".zshrc": manifest.FileNode()},
+	})
+	writeTestFile(t, filepath.Join(profile, "home", "dot_zshrc"), "remote\n")
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(zw)
+	if err := tw.AddFS(os.DirFS(profile)); err != nil {
+		t.Fatalf("AddFS() error = %v", err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	archive := buf.Bytes()
+	sum := sha256.Sum256(archive)
+	pin := hex.EncodeToString(sum[:])
+
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect.tar.gz" {
+			http.Redirect(w, r, "http://"+r.Host+"/dotfiles.tar.gz", http.StatusFound)
+			return
+		}
+		if r.URL.Path != "/dotfiles.tar.gz" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write(archive)
+	}))
+	t.Cleanup(srv.Close)
+	downloadClient = srv.Client()
+	t.Cleanup(func() { downloadClient = &http.Client{Timeout: downloadTimeout} })
+
+	url := srv.URL + "/dotfiles.tar.gz"
+	for _, tt := range []struct {
+		ref  string
+		want string
+	}{
+		{ref: "http" + strings.TrimPrefix(url, "https"), want: "plain http"},
+		{ref: srv.URL + "/redirect.tar.gz", want: "redirect to http://"},
+		{ref: url + "#sha256=" + strings.Repeat("0", 64), want: "sha256 is " + pin},
+		{ref: url + "#md5=abc", want: "expected sha256="},
+		{ref: url + "#sha256=" + pin + "&subdir=../up", want: "inside the source"},
+		{ref: url + "#subdir=missing", want: "no tohru.json found in missing"},
+		{ref: srv.URL + "/missing.tar.gz", want: "404"},
+	} {
+		if _, err := s.Load(tt.ref, Options{}); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Fatalf("Load(%s) error = %v, want %q", tt.ref, err, tt.want)
+		}
+	}
+
+	if _, err := s.Load(url+"#sha256="+pin, Options{}); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	st, err := s.LoadState()
+	if err != nil {
+		t.Fatalf("LoadState() error = %v", err)
+	}
+	if st.Profile.Kind != remoteKind || st.Profile.URL != url+"#sha256="+pin || st.Profile.SHA256 != pin {
+		t.Fatalf("profile = %+v, want remote kind recording the pinned URL and sha256", st.Profile)
+	}
+	if got, err := os.ReadFile(filepath.Join(home, ".zshrc")); err != nil || string(got) != "remote\n" {
+		t.Fatalf(".zshrc = %q, %v", got, err)
+	}
+
+	res, err := s.Reload(Options{})
+	if err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	if !res.Skipped {
+		t.Fatalf("Reload() Skipped = false, want the unchanged download skipped")
+	}
+
+	gc, err := s.GC(GCOptions{DryRun: true})
+	if err != nil {
+		t.Fatalf("GC() error = %v", err)
+	}
+	if gc.ExtractedCount != 0 {
+		t.Fatalf("GC() ExtractedCount = %d, want the loaded download kept", gc.ExtractedCount)
+	}
+}
@@ -1,5 +1,28 @@
 package store
 
+// AppliedOp records what a load or unload did to one destination path.
+type AppliedOp struct {
+	Path   string
+	Kind   string // link, file, dir or copy
+	Action string // one of the Action constants
+	Backup string // CID of the backup taken, stashed or restored, if any
+
+	RenamedTo string // where the existing object was moved aside to, see ResolveRename
+
+	Bytes       int64 // bytes a file or copy operation wrote to Path
+	BackupBytes int64 // bytes of the backup taken or stashed, not restored
+}
+
+const (
+	ActionCreated  = "created"  // nothing was at the path before
+	ActionReplaced = "replaced" // an existing object was removed first, and backed up if Backup is set, or moved to RenamedTo
+	ActionAdopted  = "adopted"  // an existing symlink already pointed at the source and was kept
+	ActionKept     = "kept"     // an existing directory was left in place
+	ActionRemoved  = "removed"  // a managed object was removed; Backup is set if drifted content was stashed
+	ActionRestored = "restored" // a managed object was removed and its backup restored
+	ActionSkipped  = "skipped"  // an existing object was left in place and the operation not applied
+)
+
 type LoadResult struct {
 	ProfileDir           string
 	ProfileName          string
@@ -9,17 +32,70 @@ type LoadResult struct {
 	RemovedBackupCount   int
 	ChangedPaths         []string
 	Warnings             []string
+	Skipped              bool     // profile was already loaded and unchanged, nothing was applied
+	StashedPaths         []string // drifted paths backed up before being overwritten
+	RewrittenCount       int      // destinations written, rather than kept as they were
+	BytesCopied          int64    // bytes written to destinations by file and copy operations
+	BytesBackedUp        int64    // bytes of existing and drifted destinations backed up
+	Operations           []AppliedOp
 }
 
 type UnloadResult struct {
 	ProfileName        string
 	RemovedCount       int
+	UntrackedCount     int // managed objects left in place by Options.KeepFiles
+	RestoredCount      int // backups restored and verified against their digest
 	RemovedBackupCount int
+	StashedPaths       []string // drifted paths backed up before being removed
+	Operations         []AppliedOp
 	ChangedPaths       []string
 	Warnings           []string
 }
 
+type ExportResult struct {
+	Path        string // absolute path the bundle was written to
+	ProfileName string
+	FileCount   int // files and symlinks in the bundle, config and state included
+	Warnings    []string
+}
+
+type InstallResult struct {
+	CreatedPaths []string // store directories and files that were missing
+}
+
+type UninstallResult struct {
+	RemovedPaths []string // entries of the store root, then the root itself
+}
+
+type RelocateResult struct {
+	From           string   // where the store was
+	To             string   // where it is now
+	MovedPaths     []string // entries of the store root, at their new location
+	RewrittenPaths []string // store files whose recorded paths were moved along
+	Warnings       []string
+}
+
+type RehashResult struct {
+	RehashedCount        int // tracked paths re-snapshotted
+	RelabeledBackupCount int // backups moved to their new CID
+	ChangedPaths         []string
+	Warnings             []string
+}
+
+type GCResult struct {
+	BrokenBackupCount       int      // backup directories missing their object
+	UnreferencedBackupCount int      // backups neither the state nor its backup refers to
+	TempCount               int      // leftover temporary files and rollback snapshots
+	SourceCacheCount        int      // source cache entries whose directory is gone
+	ExtractedCount          int      // extracted and downloaded archives that are no longer loaded
+	RemovedPaths            []string // paths removed, or that would be with GCOptions.DryRun
+	ChangedPaths            []string
+	Warnings                []string
+}
+
 type TidyResult struct {
-	RemovedCount int
-	ChangedPaths []string
+	RemovedCount          int // unreferenced backups
+	RemovedBrokenCount    int // backup directories with no object in them
+	RemovedCorruptedCount int // backups whose object no longer matches its CID
+	ChangedPaths          []string
 }
@@ -0,0 +1,84 @@
+package store
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/olimci/tohru/pkg/store/state"
+)
+
+// RollbackPolicy decides how a failed load or unload is undone.
+type RollbackPolicy string
+
+const (
+	RollbackStrict     RollbackPolicy = "strict"      // stop at the first path that can't be restored
+	RollbackBestEffort RollbackPolicy = "best-effort" // restore every path possible and report the rest
+	RollbackLeave      RollbackPolicy = "leave"       // don't roll back, leave the partial state for manual recovery
+)
+
+func parseRollbackPolicy(policy RollbackPolicy) (RollbackPolicy, error) {
+	switch p := RollbackPolicy(strings.ToLower(strings.TrimSpace(string(policy)))); p {
+	case "":
+		return RollbackStrict, nil
+	case RollbackStrict, RollbackBestEffort, RollbackLeave:
+		return p, nil
+	default:
+		return "", fmt.Errorf("invalid rollback policy %q (expected strict, best-effort or leave)", policy)
+	}
+}
+
+// RollbackError is returned when a load or unload fails after it started
+// changing paths. It wraps the failure and reports what the rollback did.
+// Whenever the previous state isn't fully restored, the transaction journal
+// and its snapshot are kept, so the next load, reload or unload retries the
+// rollback.
+type RollbackError struct {
+	Err        error
+	Policy     RollbackPolicy
+	RolledBack bool     // paths and state are back as they were before
+	Unrestored []string // paths the rollback failed on
+	Changed    []string // with RollbackLeave, the paths changed before the failure
+	Snapshot   string   // with RollbackLeave, where the previous managed paths are kept
+	Cause      error    // why the rollback failed, or what failed after it
+}
+
+func (e *RollbackError) Error() string {
+	switch {
+	case e.Policy == RollbackLeave:
+		return fmt.Sprintf("%v (left as is: %d changed path(s), previous managed paths kept in %s until the next load, reload or unload rolls back)",
+			e.Err, len(e.Changed), e.Snapshot)
+	case !e.RolledBack && len(e.Unrestored) > 1:
+		return fmt.Sprintf("%v (rollback incomplete, could not restore %s: %v)", e.Err, strings.Join(e.Unrestored, ", "), e.Cause)
+	case !e.RolledBack:
+		return fmt.Sprintf("%v (rollback failed: %v)", e.Err, e.Cause)
+	case e.Cause != nil:
+		return fmt.Sprintf("%v (rolled back, but %v)", e.Err, e.Cause)
+	default:
+		return fmt.Sprintf("%v (rolled back to previous state)", e.Err)
+	}
+}
+
+func (e *RollbackError) Unwrap() error {
+	return e.Err
+}
+
+// undo rolls back txn after err according to policy and returns the error
+// to report.
+func (s Store) undo(txn *transaction, previous state.State, snapshot rollbackSnapshot, changed []string, policy RollbackPolicy, err error) error {
+	rbErr := &RollbackError{Err: err, Policy: policy}
+	if policy == RollbackLeave {
+		rbErr.Changed = changed
+		rbErr.Snapshot = snapshot.root
+		return rbErr
+	}
+
+	unrestored, rollbackErr := rollback(s, previous, snapshot, changed, policy == RollbackBestEffort)
+	if rollbackErr != nil {
+		rbErr.Unrestored = unrestored
+		rbErr.Cause = rollbackErr
+		return rbErr
+	}
+	rbErr.RolledBack = true
+	rbErr.Cause = txn.finish()
+	return rbErr
+}
@@ -0,0 +1,99 @@
+package store
+
+import (
+	"github.com/olimci/tohru/pkg/digest"
+	"github.com/olimci/tohru/pkg/manifest"
+)
+
+// sourceCacheEntry is what a profile source directory resolved to the last
+// time it was loaded. Every manifest source must stay inside the directory, so
+// the entry is valid for as long as the directory digest is unchanged.
+type sourceCacheEntry struct {
+	Digest      string            `json:"digest"`
+	Manifest    manifest.Manifest `json:"manifest"`
+	Umask       string            `json:"umask"`
+	Fingerprint string            `json:"fingerprint"`
+}
+
+// sourceCache maps absolute profile source directories to their last resolution.
+type sourceCache map[string]sourceCacheEntry
+
+// loadedSource is a profile manifest loaded through the source cache.
+type loadedSource struct {
+	Manifest manifest.Manifest
+	Dir      string
+	digest   string            // empty when the directory could not be digested
+	cached   *sourceCacheEntry // nil on a cache miss
+}
+
+// loadSourceCache reads the source cache. A missing or unreadable cache is
+// treated as empty, since every entry can be rebuilt from the sources.
+func (s Store) loadSourceCache() sourceCache {
+	cache := sourceCache{}
+	if err := decodeJSON(s.SourceCachePath(), &cache); err != nil || cache == nil {
+		return sourceCache{}
+	}
+	return cache
+}
+
+// loadSource loads the manifest for target, reusing the cached resolution
+// when the source directory's digest matches the cached one.
+func (s Store) loadSource(target string) (loadedSource, error) {
+	_, dir, err := manifest.Locate(target)
+	if err != nil {
+		return loadedSource{}, err
+	}
+
+	var sum string
+	// A store kept inside the profile changes with every load, so it must
+	// not invalidate the cache.
+	var exclude []string
+	if path := s.storeWithin(dir); path != "" {
+		exclude = []string{path}
+	}
+	if d, err := digest.ForPathWith(dir, digest.Options{Exclude: exclude}); err == nil {
+		sum = d.String()
+	}
+
+	if entry, ok := s.loadSourceCache()[dir]; ok && sum != "" && entry.Digest == sum {
+		m := entry.Manifest
+		if err := m.Resolve(); err == nil {
+			return loadedSource{Manifest: m, Dir: dir, digest: sum, cached: &entry}, nil
+		}
+	}
+
+	m, dir, err := manifest.Load(target)
+	if err != nil {
+		return loadedSource{}, err
+	}
+	return loadedSource{Manifest: m, Dir: dir, digest: sum}, nil
+}
+
+// fingerprint returns the fingerprint of ops, skipping the per-source hashing
+// when the cached entry was computed with the same umask.
+func (l loadedSource) fingerprint(ops []op, mask umask) (string, error) {
+	if l.cached != nil && l.cached.Umask == mask.key() {
+		return l.cached.Fingerprint, nil
+	}
+	return fingerprint(ops, mask)
+}
+
+// cacheSource records l's resolution and fingerprint in the source cache,
+// unless the cache already holds exactly that.
+func (s Store) cacheSource(l loadedSource, fp string, mask umask) error {
+	if l.digest == "" {
+		return nil
+	}
+	if l.cached != nil && l.cached.Umask == mask.key() && l.cached.Fingerprint == fp {
+		return nil
+	}
+
+	cache := s.loadSourceCache()
+	cache[l.Dir] = sourceCacheEntry{
+		Digest:      l.digest,
+		Manifest:    l.Manifest,
+		Umask:       mask.key(),
+		Fingerprint: fp,
+	}
+	return encodeJSON(s.SourceCachePath(), cache)
+}
@@ -0,0 +1,106 @@
+package store
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/olimci/tohru/pkg/manifest"
+)
+
+func TestSourceCacheBypassedAfterEdit(t *testing.T) {
+	s, home := newTestStore(t)
+	profile := writeProfile(t, manifest.Root{
+		Source:   "home",
+		Dest:     home,
+		Defaults: &manifest.Defaults{Type: "copy"},
+		Tree:     manifest.Tree{".zshrc": manifest.FileNode()},
+	})
+	writeTestFile(t, filepath.Join(profile, "home", "dot_zshrc"), "managed\n")
+
+	if _, err := s.Load(profile, Options{}); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	source, err := s.loadSource(profile)
+	if err != nil {
+		t.Fatalf("loadSource() error = %v", err)
+	}
+	if source.cached == nil {
+		t.Fatalf("loadSource() missed the cache for an unchanged source")
+	}
+
+	writeTestFile(t, filepath.Join(profile, "home", "dot_zshrc"), "updated\n")
+	source, err = s.loadSource(profile)
+	if err != nil {
+		t.Fatalf("loadSource() error = %v", err)
+	}
+	if source.cached != nil {
+		t.Fatalf("loadSource() hit the cache after the source was edited")
+	}
+
+	res, err := s.Load(profile, Options{})
+	if err != nil {
+		t.Fatalf("second Load() error = %v", err)
+	}
+	if res.Skipped {
+		t.Fatalf("second Load() Skipped = true after source edit, want false")
+	}
+	got, err := os.ReadFile(filepath.Join(home, ".zshrc"))
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if string(got) != "updated\n" {
+		t.Fatalf(".zshrc = %q, want updated content", got)
+	}
+}
+
+func BenchmarkReloadUnchanged(b *testing.B) {
+	for _, cached := range []bool{false, true} {
+		b.Run(fmt.Sprintf("cached=%t", cached), func(b *testing.B) {
+			s, home := newTestStore(b)
+			tree := manifest.Tree{}
+			profile := writeProfile(b)
+			for i := range 200 {
+				name := fmt.Sprintf("file%03d", i)
+				tree[name] = manifest.FileNode()
+				writeTestFile(b, filepath.Join(profile, "home", name), fmt.Sprintf("content %d\n", i))
+			}
+			m := manifest.Manifest{
+				Schema:  manifest.SchemaVersion,
+				Profile: manifest.Profile{Slug: "test", Name: "test"},
+				Roots: []manifest.Root{{
+					Source:   "home",
+					Dest:     home,
+					Defaults: &manifest.Defaults{Type: "copy"},
+					Tree:     tree,
+				}},
+			}
+			if err := manifest.Write(filepath.Join(profile, manifest.Name), m); err != nil {
+				b.Fatalf("manifest.Write() error = %v", err)
+			}
+			if _, err := s.Load(profile, Options{}); err != nil {
+				b.Fatalf("Load() error = %v", err)
+			}
+
+			b.ResetTimer()
+			for range b.N {
+				if !cached {
+					b.StopTimer()
+					if err := os.Remove(s.SourceCachePath()); err != nil {
+						b.Fatalf("Remove() error = %v", err)
+					}
+					b.StartTimer()
+				}
+				res, err := s.Load(profile, Options{})
+				if err != nil {
+					b.Fatalf("Load() error = %v", err)
+				}
+				if !res.Skipped {
+					b.Fatalf("Load() Skipped = false, want true")
+				}
+			}
+		})
+	}
+}
@@ -2,18 +2,57 @@ package state
 
 // State stores the current state of the application.
 type State struct {
-	Profile Profile `json:"profile"`        // current profile state
-	Files   []File  `json:"files"`          // tohru managed files
-	Dirs    []Dir   `json:"dirs,omitempty"` // auto-created parent dirs (cleanup if empty)
+	Profile Profile `json:"profile"`           // current profile state
+	Files   []File  `json:"files"`             // tohru managed files
+	Dirs    []Dir   `json:"dirs,omitempty"`    // auto-created parent dirs (cleanup if empty)
+	Stashed []Stash `json:"stashed,omitempty"` // backups of drifted content, kept across loads
+	Added   []Layer `json:"added,omitempty"`   // profiles loaded alongside this one with load --add
+
+	// WrittenBy is the version of tohru that last saved the state.
+	WrittenBy string `json:"written_by,omitempty"`
+}
+
+// Layer is a profile loaded alongside the main one. It owns its files and
+// auto-created dirs; no two profiles track the same destination.
+type Layer struct {
+	Profile Profile `json:"profile"`
+	Files   []File  `json:"files"`
+	Dirs    []Dir   `json:"dirs,omitempty"`
+}
+
+// AllFiles returns the files tracked by the main profile and every added one.
+func (s State) AllFiles() []File {
+	files := s.Files
+	for _, layer := range s.Added {
+		files = append(files[:len(files):len(files)], layer.Files...)
+	}
+	return files
+}
+
+// AllDirs returns the dirs created for the main profile and every added one.
+func (s State) AllDirs() []Dir {
+	dirs := s.Dirs
+	for _, layer := range s.Added {
+		dirs = append(dirs[:len(dirs):len(dirs)], layer.Dirs...)
+	}
+	return dirs
 }
 
 // Profile references the currently loaded profile.
 type Profile struct {
-	State string `json:"state"` // unloaded|loaded
-	Kind  string `json:"kind"`  // local (remote might be added later)
-	Path  string `json:"path"`  // path to profile directory
-	Slug  string `json:"slug,omitempty"`
-	Name  string `json:"name,omitempty"`
+	State   string `json:"state"`             // unloaded|loaded
+	Kind    string `json:"kind"`              // local|archive|remote
+	Path    string `json:"path"`              // path to profile directory
+	Archive string `json:"archive,omitempty"` // archive the profile directory was extracted from
+	URL     string `json:"url,omitempty"`     // remote: where Archive was downloaded from, with any #sha256= pin
+	SHA256  string `json:"sha256,omitempty"`  // remote: sha256 of the downloaded archive
+	Subdir  string `json:"subdir,omitempty"`  // manifest directory within the source, slash-separated
+	Slug    string `json:"slug,omitempty"`
+	Name    string `json:"name,omitempty"`
+
+	// Fingerprint summarises the applied operations and their sources, so a
+	// repeated load of an unchanged profile can be skipped.
+	Fingerprint string `json:"fingerprint,omitempty"`
 }
 
 // CachedProfile is a cached profile entry used in profiles.json.
@@ -31,6 +70,18 @@ type File struct {
 	Current Object `json:"curr"` // existing object state
 	// Previous exists so we know where the backup object is stored, and what it is.
 	Previous *Object `json:"prev,omitempty"` // state of previous object there
+	// Target is what a managed symlink resolved to when it was loaded, so a
+	// deep status can tell a changed target from a retargeted link. Its
+	// Digest is empty when the link dangled.
+	Target *Object `json:"target,omitempty"`
+}
+
+// Stash is a backup of drifted content that was taken before the managed
+// path was overwritten or removed, or of an unmanaged object that was backed
+// up on request before being replaced.
+type Stash struct {
+	Path   string `json:"path"`   // managed path the content was taken from
+	Backup Object `json:"backup"` // stored backup object
 }
 
 // Dir is an auto-created directory that can be removed if empty.
@@ -3,40 +3,151 @@ package store
 import (
 	"errors"
 	"fmt"
+	"io/fs"
 	"maps"
 	"os"
+	"path/filepath"
 	"slices"
 	"strings"
+	"time"
 
 	"github.com/olimci/tohru/pkg/digest"
+	"github.com/olimci/tohru/pkg/manifest"
 	"github.com/olimci/tohru/pkg/store/state"
 )
 
 type StatusSnapshot struct {
-	Profile         state.Profile
-	Tracked         []TrackedStatus
-	BackupRefs      []BackupRefStatus
-	OrphanedBackups []string
-	BrokenBackups   []string
+	Profile             state.Profile
+	Added               []state.Profile // profiles loaded alongside Profile with load --add
+	Tracked             []TrackedStatus
+	BackupRefs          []BackupRefStatus
+	OrphanedBackups     []string
+	BrokenBackups       []string
+	InaccessibleBackups []string // backup directories that couldn't be read, so are neither present nor broken
+	Algorithms          []string // distinct digest algorithms recorded in state
+	AutoDirs            []AutoDirStatus
+	Stashed             []StashStatus
+	Hardlinks           [][]string // tracked paths that are hard links of one file, which drift together
+}
+
+// StashStatus is a stashed backup of drifted content, see Options.BackupDrifted.
+type StashStatus struct {
+	Path    string
+	Digest  string
+	Present bool
+}
+
+// AutoDirStatus describes a parent directory tohru created while loading.
+// Unload removes it only if it is OnlyManaged: it holds nothing but managed
+// paths and other auto-created dirs, so it is empty once those are unloaded.
+type AutoDirStatus struct {
+	Path         string
+	Missing      bool
+	OnlyManaged  bool     // it has no unmanaged entries, it need not be empty now
+	Inaccessible bool     // it couldn't be read, so whether it is OnlyManaged is unknown
+	Unmanaged    []string // entries unload will leave behind
 }
 
 type TrackedStatus struct {
 	Path          string
+	Profile       string // slug of the added profile tracking Path, empty for the main profile
 	PrevDigest    string
 	BackupPresent bool
 	Drifted       bool
 	Missing       bool
+	Inaccessible  bool        // permission to check it was denied, so whether it drifted is unknown
+	Approximate   bool        // drift judged by modification times, see StatusOptions.SkipDirHash
+	TargetChanged bool        // what a symlink resolves to changed since load, see StatusOptions.DeepLinks
+	TargetMissing bool        // the symlink dangles, see StatusOptions.DeepLinks
 	ManagedKind   digest.Kind `json:"-"`
 	Operation     string      `json:"-"`
 }
 
+// StatusOptions controls how thoroughly StatusWithOptions checks tracked
+// objects.
+type StatusOptions struct {
+	// SkipDirHash checks tracked directories without hashing their contents:
+	// a directory counts as drifted if it is no longer a directory or anything
+	// in it was modified after the state was last written. Files and symlinks
+	// are still hashed.
+	SkipDirHash bool
+	// DeepLinks also checks what each tracked symlink resolves to against
+	// what it resolved to when it was loaded, hashing the target, so a
+	// changed target is reported even when the link itself is untouched.
+	// Links loaded before targets were recorded are not checked.
+	DeepLinks bool
+}
+
+// RootStatus groups tracked objects under the manifest root that declared them.
+// Profile is the slug of the added profile the root belongs to, empty for the
+// loaded profile. Index is -1 for tracked paths the profile's manifest no
+// longer declares, with Source then the profile's path.
+type RootStatus struct {
+	Index   int
+	Profile string
+	Source  string
+	Dest    string
+	Tracked []TrackedStatus
+}
+
 type BackupRefStatus struct {
 	Digest  string
 	Paths   []string
 	Present bool
+	Meta    *BackupMeta // nil for backups taken before metadata was recorded
+}
+
+// StatusSummary aggregates the counts of a StatusSnapshot. Clean is set when
+// nothing is drifted, missing or inaccessible and every referenced backup is
+// intact.
+type StatusSummary struct {
+	Tracked        int
+	Drifted        int
+	Missing        int
+	Inaccessible   int
+	Backups        int
+	MissingBackups int
+	BrokenBackups  int
+	Clean          bool
 }
 
+// Summary counts the tracked objects and backup references in snapshot. A
+// missing path counts as missing rather than drifted.
+func (snapshot StatusSnapshot) Summary() StatusSummary {
+	summary := StatusSummary{
+		Tracked:       len(snapshot.Tracked),
+		Backups:       len(snapshot.BackupRefs),
+		BrokenBackups: len(snapshot.BrokenBackups),
+	}
+	for _, item := range snapshot.Tracked {
+		switch {
+		case item.Inaccessible:
+			summary.Inaccessible++
+		case item.Missing:
+			summary.Missing++
+		case item.Drifted:
+			summary.Drifted++
+		}
+	}
+	for _, ref := range snapshot.BackupRefs {
+		if !ref.Present {
+			summary.MissingBackups++
+		}
+	}
+	summary.Clean = summary.Drifted == 0 && summary.Missing == 0 && summary.Inaccessible == 0 &&
+		summary.MissingBackups == 0 && summary.BrokenBackups == 0
+	return summary
+}
+
+// Status checks every tracked object against its recorded digest. Paths it
+// isn't allowed to read are marked Inaccessible rather than failing it.
 func (s Store) Status() (StatusSnapshot, error) {
+	return s.StatusWithOptions(StatusOptions{})
+}
+
+// StatusWithOptions is Status with control over how tracked objects are
+// checked.
+func (s Store) StatusWithOptions(opts StatusOptions) (StatusSnapshot, error) {
 	if !s.IsInstalled() {
 		return StatusSnapshot{}, ErrNotInstalled
 	}
@@ -46,20 +157,40 @@ func (s Store) Status() (StatusSnapshot, error) {
 		return StatusSnapshot{}, err
 	}
 
-	availableBackups, brokenBackups, err := scanBackupStore(s)
+	scan, err := s.backups().Scan()
 	if err != nil {
 		return StatusSnapshot{}, err
 	}
+	availableBackups := scan.Available
 
-	tracked := make([]TrackedStatus, 0, len(lck.Files))
-	refPaths := make(map[string][]string, len(lck.Files))
-	for _, f := range lck.Files {
+	var stateTime time.Time
+	if opts.SkipDirHash {
+		info, err := os.Stat(s.StatePath())
+		if err != nil {
+			return StatusSnapshot{}, fmt.Errorf("stat state %s: %w", s.StatePath(), err)
+		}
+		stateTime = info.ModTime()
+	}
+
+	files := lck.AllFiles()
+	owners := make(map[string]string)
+	added := make([]state.Profile, 0, len(lck.Added))
+	for _, layer := range lck.Added {
+		added = append(added, layer.Profile)
+		for _, f := range layer.Files {
+			owners[f.Path] = layer.Profile.Slug
+		}
+	}
+
+	tracked := make([]TrackedStatus, 0, len(files))
+	refPaths := make(map[string][]string, len(files))
+	for _, f := range files {
 		path := strings.TrimSpace(f.Path)
 		if path == "" {
 			continue
 		}
 
-		item := TrackedStatus{Path: path}
+		item := TrackedStatus{Path: path, Profile: owners[f.Path]}
 		kind, operation, presentationErr := trackedPresentation(f.Current.Digest)
 		if presentationErr != nil {
 			return StatusSnapshot{}, fmt.Errorf("parse tracked object metadata for %s: %w", f.Path, presentationErr)
@@ -67,11 +198,21 @@ func (s Store) Status() (StatusSnapshot, error) {
 		item.ManagedKind = kind
 		item.Operation = operation
 
-		current, exists, snapshotErr := maybeSnapshot(path)
-		if snapshotErr != nil {
+		if opts.SkipDirHash && kind == digest.KindDir {
+			item.Approximate = true
+			changed, exists, err := changedSince(path, stateTime)
+			if errors.Is(err, fs.ErrPermission) {
+				item.Inaccessible = true
+			} else if err != nil {
+				return StatusSnapshot{}, fmt.Errorf("check tracked path %s: %w", path, err)
+			}
+			item.Missing = !exists && !item.Inaccessible
+			item.Drifted = item.Missing || changed
+		} else if current, exists, snapshotErr := snapshotTracked(f, files); errors.Is(snapshotErr, fs.ErrPermission) {
+			item.Inaccessible = true
+		} else if snapshotErr != nil {
 			return StatusSnapshot{}, fmt.Errorf("snapshot tracked path %s: %w", path, snapshotErr)
-		}
-		if !exists {
+		} else if !exists {
 			item.Drifted = true
 			item.Missing = true
 		} else if strings.TrimSpace(f.Current.Digest) != "" {
@@ -84,9 +225,21 @@ func (s Store) Status() (StatusSnapshot, error) {
 				return StatusSnapshot{}, fmt.Errorf("parse current digest for %s: %w", f.Path, parseActualErr)
 			}
 			item.Drifted = expectedDigest.String() != actualDigest.String()
+			if opts.DeepLinks && kind == digest.KindSymlink && f.Target != nil {
+				target, err := snapshotTarget(path)
+				switch {
+				case errors.Is(err, fs.ErrPermission):
+					item.Inaccessible = true
+				case err != nil:
+					return StatusSnapshot{}, fmt.Errorf("snapshot target of %s: %w", path, err)
+				default:
+					item.TargetMissing = target.Digest == ""
+					item.TargetChanged = target.Digest != f.Target.Digest
+				}
+			}
 		}
 
-		if f.Previous != nil && strings.TrimSpace(f.Previous.Digest) != "" {
+		if hasBackup(f.Previous) && strings.TrimSpace(f.Previous.Digest) != "" {
 			d, parseErr := digest.Parse(f.Previous.Digest)
 			if parseErr != nil {
 				return StatusSnapshot{}, fmt.Errorf("parse previous digest for %s: %w", f.Path, parseErr)
@@ -102,22 +255,53 @@ func (s Store) Status() (StatusSnapshot, error) {
 		tracked = append(tracked, item)
 	}
 
+	stashed := make([]StashStatus, 0, len(lck.Stashed))
+	for _, stash := range lck.Stashed {
+		d, err := digest.Parse(stash.Backup.Digest)
+		if err != nil {
+			return StatusSnapshot{}, fmt.Errorf("parse stashed digest for %s: %w", stash.Path, err)
+		}
+		cid := d.String()
+		_, present := availableBackups[cid]
+		stashed = append(stashed, StashStatus{Path: stash.Path, Digest: cid, Present: present})
+		refPaths[cid] = append(refPaths[cid], stash.Path)
+	}
+
 	slices.SortFunc(tracked, func(a, b TrackedStatus) int {
 		return strings.Compare(a.Path, b.Path)
 	})
+	trackedPaths := make([]string, 0, len(tracked))
+	for _, item := range tracked {
+		if !item.Missing && !item.Inaccessible {
+			trackedPaths = append(trackedPaths, item.Path)
+		}
+	}
 
 	refs := make([]BackupRefStatus, 0, len(refPaths))
 	for _, cid := range slices.Sorted(maps.Keys(refPaths)) {
 		paths := slices.Clone(refPaths[cid])
 		slices.Sort(paths)
 		_, present := availableBackups[cid]
+		var meta *BackupMeta
+		if present {
+			// Metadata that can't be read is shown as if none was recorded.
+			if meta, err = readBackupMeta(backupPath(s, cid, paths[0])); err != nil && !errors.Is(err, fs.ErrPermission) {
+				return StatusSnapshot{}, err
+			}
+		}
 		refs = append(refs, BackupRefStatus{
 			Digest:  cid,
 			Paths:   paths,
 			Present: present,
+			Meta:    meta,
 		})
 	}
 
+	autoDirs, err := autoDirStatus(lck)
+	if err != nil {
+		return StatusSnapshot{}, err
+	}
+
 	orphaned := make([]string, 0, len(availableBackups))
 	for _, cid := range slices.Sorted(maps.Keys(availableBackups)) {
 		if _, referenced := refPaths[cid]; referenced {
@@ -127,41 +311,164 @@ func (s Store) Status() (StatusSnapshot, error) {
 	}
 
 	return StatusSnapshot{
-		Profile:         lck.Profile,
-		Tracked:         tracked,
-		BackupRefs:      refs,
-		OrphanedBackups: orphaned,
-		BrokenBackups:   brokenBackups,
+		Profile:             lck.Profile,
+		Added:               added,
+		Tracked:             tracked,
+		BackupRefs:          refs,
+		OrphanedBackups:     orphaned,
+		BrokenBackups:       scan.Broken,
+		InaccessibleBackups: scan.Inaccessible,
+		Algorithms:          stateAlgorithms(lck),
+		AutoDirs:            autoDirs,
+		Stashed:             stashed,
+		Hardlinks:           hardlinkGroups(trackedPaths),
 	}, nil
 }
 
-func scanBackupStore(store Store) (map[string]struct{}, []string, error) {
-	entries, err := os.ReadDir(store.BackupsPath())
+// changedSince reports whether the directory at path was replaced by
+// something else, or it or anything in it was modified after since.
+func changedSince(path string, since time.Time) (changed, exists bool, err error) {
+	info, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, false, nil
+	}
 	if err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			return map[string]struct{}{}, nil, nil
+		return false, false, err
+	}
+	if !info.IsDir() {
+		return true, true, nil
+	}
+
+	errChanged := errors.New("changed")
+	err = filepath.WalkDir(path, func(_ string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
 		}
-		return nil, nil, fmt.Errorf("read backups directory %s: %w", store.BackupsPath(), err)
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if info.ModTime().After(since) {
+			return errChanged
+		}
+		return nil
+	})
+	if errors.Is(err, errChanged) {
+		return true, true, nil
 	}
+	return false, true, err
+}
+
+func autoDirStatus(lck state.State) ([]AutoDirStatus, error) {
+	files, dirs := lck.AllFiles(), lck.AllDirs()
+	managed := make(map[string]struct{}, len(files)+len(dirs))
+	for _, f := range files {
+		managed[filepath.Clean(f.Path)] = struct{}{}
+	}
+	for _, d := range dirs {
+		managed[filepath.Clean(d.Path)] = struct{}{}
+	}
+
+	statuses := make([]AutoDirStatus, 0, len(dirs))
+	for _, d := range dirs {
+		path := strings.TrimSpace(d.Path)
+		if path == "" {
+			continue
+		}
+		item := AutoDirStatus{Path: path}
 
-	available := make(map[string]struct{}, len(entries))
-	broken := make([]string, 0, len(entries))
-	for _, entry := range entries {
-		cid := entry.Name()
-		path := backupPath(store, cid)
-		if _, statErr := os.Lstat(path); statErr == nil {
-			available[cid] = struct{}{}
+		entries, err := os.ReadDir(path)
+		if errors.Is(err, fs.ErrPermission) {
+			item.Inaccessible = true
+			statuses = append(statuses, item)
 			continue
-		} else if errors.Is(statErr, os.ErrNotExist) {
-			broken = append(broken, cid)
+		}
+		if err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				return nil, fmt.Errorf("read auto dir %s: %w", path, err)
+			}
+			item.Missing = true
+			statuses = append(statuses, item)
 			continue
-		} else {
-			return nil, nil, fmt.Errorf("stat backup object %s: %w", path, statErr)
 		}
+		for _, entry := range entries {
+			child := filepath.Join(path, entry.Name())
+			if _, ok := managed[child]; !ok {
+				item.Unmanaged = append(item.Unmanaged, child)
+			}
+		}
+		item.OnlyManaged = len(item.Unmanaged) == 0
+		statuses = append(statuses, item)
 	}
-	slices.Sort(broken)
 
-	return available, broken, nil
+	slices.SortFunc(statuses, func(a, b AutoDirStatus) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+	return statuses, nil
+}
+
+// StatusByRoot groups the tracked objects in snapshot by the manifest root
+// that declared them: the loaded profile's roots first, then those of each
+// profile loaded with --add. Objects a profile's manifest no longer declares
+// get a group of their own with Index -1.
+func (s Store) StatusByRoot(snapshot StatusSnapshot) ([]RootStatus, error) {
+	byProfile := make(map[string][]TrackedStatus, len(snapshot.Added)+1)
+	for _, tracked := range snapshot.Tracked {
+		byProfile[tracked.Profile] = append(byProfile[tracked.Profile], tracked)
+	}
+
+	var groups []RootStatus
+	if strings.ToLower(snapshot.Profile.State) == "loaded" && strings.TrimSpace(snapshot.Profile.Path) != "" {
+		main, err := groupByRoot(snapshot.Profile.Path, "", byProfile[""])
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, main...)
+	}
+	for _, p := range snapshot.Added {
+		added, err := groupByRoot(p.Path, p.Slug, byProfile[p.Slug])
+		if err != nil {
+			return nil, fmt.Errorf("added profile %s: %w", p.Slug, err)
+		}
+		groups = append(groups, added...)
+	}
+	return groups, nil
+}
+
+// groupByRoot groups tracked, the objects of the profile slug ("" for the
+// main one), by the root of the manifest at path that declared them.
+func groupByRoot(path, slug string, tracked []TrackedStatus) ([]RootStatus, error) {
+	m, profileDir, err := manifest.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	ops, err := plan(m, profileDir)
+	if err != nil {
+		return nil, err
+	}
+
+	rootByDest := make(map[string]int, len(ops))
+	for _, op := range ops {
+		rootByDest[op.Dest] = op.Root
+	}
+
+	groups := make([]RootStatus, len(m.Roots))
+	for i, root := range m.Roots {
+		groups[i] = RootStatus{Index: i, Profile: slug, Source: root.Source, Dest: root.Dest}
+	}
+	unknown := RootStatus{Index: -1, Profile: slug, Source: path}
+	for _, item := range tracked {
+		index, ok := rootByDest[item.Path]
+		if !ok {
+			unknown.Tracked = append(unknown.Tracked, item)
+			continue
+		}
+		groups[index].Tracked = append(groups[index].Tracked, item)
+	}
+	if len(unknown.Tracked) > 0 {
+		groups = append(groups, unknown)
+	}
+	return groups, nil
 }
 
 func trackedPresentation(rawDigest string) (digest.Kind, string, error) {
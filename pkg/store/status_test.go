@@ -1,9 +1,15 @@
 package store
 
 import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/olimci/tohru/pkg/digest"
+	"github.com/olimci/tohru/pkg/manifest"
 )
 
 func TestTrackedPresentation(t *testing.T) {
@@ -16,19 +22,19 @@ func TestTrackedPresentation(t *testing.T) {
 	}{
 		{
 			name:          "file digest maps to copy",
-			rawDigest:     "file:sha256:abc123",
+			rawDigest:     "file:sha256:" + strings.Repeat("a1", 32),
 			wantKind:      digest.KindFile,
 			wantOperation: "copy",
 		},
 		{
 			name:          "dir digest maps to copy",
-			rawDigest:     "dir:sha256:def456",
+			rawDigest:     "dir:sha256:" + strings.Repeat("d4", 32),
 			wantKind:      digest.KindDir,
 			wantOperation: "copy",
 		},
 		{
 			name:          "symlink digest maps to link",
-			rawDigest:     "symlink:sha256:fedcba",
+			rawDigest:     "symlink:sha256:" + strings.Repeat("fe", 32),
 			wantKind:      digest.KindSymlink,
 			wantOperation: "link",
 		},
@@ -66,3 +72,193 @@ func TestTrackedPresentation(t *testing.T) {
 		})
 	}
 }
+
+func TestHardlinkGroups(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a")
+	b := filepath.Join(dir, "b")
+	c := filepath.Join(dir, "c")
+	for _, path := range []string{a, c} {
+		if err := os.WriteFile(path, []byte("same\n"), 0o644); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+	}
+	if err := os.Link(a, b); err != nil {
+		t.Fatalf("Link() error = %v", err)
+	}
+
+	groups := hardlinkGroups([]string{c, b, a, filepath.Join(dir, "missing")})
+	want := [][]string{{a, b}}
+	if !slices.EqualFunc(groups, want, slices.Equal) {
+		t.Fatalf("hardlinkGroups() = %v, want %v", groups, want)
+	}
+}
+
+func TestStatusSkipDirHash(t *testing.T) {
+	s, home := newTestStore(t)
+	profile := writeProfile(t, manifest.Root{
+		Source: "home",
+		Dest:   home,
+		Tree: manifest.Tree{
+			".config": manifest.DirectoryNode([]string{"copy"}, nil),
+			".zshrc":  manifest.FileNode("copy"),
+		},
+	})
+	writeTestFile(t, filepath.Join(profile, "home", "dot_config", "app.toml"), "managed\n")
+	writeTestFile(t, filepath.Join(profile, "home", "dot_zshrc"), "managed\n")
+	if _, err := s.Load(profile, Options{}); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	// Date the load back so later writes are newer than the state.
+	loaded := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(s.StatePath(), loaded, loaded); err != nil {
+		t.Fatalf("Chtimes() error = %v", err)
+	}
+	dir := filepath.Join(home, ".config")
+	for _, path := range []string{dir, filepath.Join(dir, "app.toml")} {
+		if err := os.Chtimes(path, loaded.Add(-time.Minute), loaded.Add(-time.Minute)); err != nil {
+			t.Fatalf("Chtimes() error = %v", err)
+		}
+	}
+
+	drifted := func(opts StatusOptions) map[string]bool {
+		t.Helper()
+		snapshot, err := s.StatusWithOptions(opts)
+		if err != nil {
+			t.Fatalf("StatusWithOptions() error = %v", err)
+		}
+		got := make(map[string]bool, len(snapshot.Tracked))
+		for _, item := range snapshot.Tracked {
+			if item.Approximate != (opts.SkipDirHash && item.ManagedKind == digest.KindDir) {
+				t.Fatalf("%s Approximate = %v with %+v", item.Path, item.Approximate, opts)
+			}
+			got[filepath.Base(item.Path)] = item.Drifted
+		}
+		return got
+	}
+	quick := StatusOptions{SkipDirHash: true}
+
+	if got := drifted(quick); got[".config"] || got[".zshrc"] {
+		t.Fatalf("quick status of a fresh load = %v, want nothing drifted", got)
+	}
+
+	// Rewriting a file with the same content is drift by modification time
+	// only.
+	writeTestFile(t, filepath.Join(dir, "app.toml"), "managed\n")
+	if got := drifted(quick); !got[".config"] {
+		t.Fatalf("quick status after touching .config = %v, want it drifted", got)
+	}
+	if got := drifted(StatusOptions{}); got[".config"] {
+		t.Fatalf("full status after touching .config = %v, want it clean", got)
+	}
+
+	writeTestFile(t, filepath.Join(home, ".zshrc"), "edited\n")
+	if got := drifted(quick); !got[".zshrc"] {
+		t.Fatalf("quick status after editing .zshrc = %v, want it drifted", got)
+	}
+
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatalf("RemoveAll() error = %v", err)
+	}
+	snapshot, err := s.StatusWithOptions(quick)
+	if err != nil {
+		t.Fatalf("StatusWithOptions() error = %v", err)
+	}
+	for _, item := range snapshot.Tracked {
+		if filepath.Base(item.Path) == ".config" && !item.Missing {
+			t.Fatalf("quick status after removing .config = %+v, want it missing", item)
+		}
+	}
+}
+
+func TestStatusDeepLinks(t *testing.T) {
+	s, home := newTestStore(t)
+	profile := writeProfile(t, manifest.Root{
+		Source: "home",
+		Dest:   home,
+		Tree: manifest.Tree{
+			".zshrc": manifest.FileNode("link"),
+		},
+	})
+	source := filepath.Join(profile, "home", "dot_zshrc")
+	writeTestFile(t, source, "managed\n")
+	if _, err := s.Load(profile, Options{}); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	status := func(opts StatusOptions) TrackedStatus {
+		t.Helper()
+		snapshot, err := s.StatusWithOptions(opts)
+		if err != nil {
+			t.Fatalf("StatusWithOptions() error = %v", err)
+		}
+		if len(snapshot.Tracked) != 1 {
+			t.Fatalf("Tracked = %+v, want one entry", snapshot.Tracked)
+		}
+		return snapshot.Tracked[0]
+	}
+	deep := StatusOptions{DeepLinks: true}
+
+	if got := status(deep); got.Drifted || got.TargetChanged || got.TargetMissing {
+		t.Fatalf("deep status of a fresh load = %+v, want it clean", got)
+	}
+
+	// Editing the source leaves the link itself untouched.
+	writeTestFile(t, source, "edited\n")
+	if got := status(StatusOptions{}); got.Drifted || got.TargetChanged {
+		t.Fatalf("status after editing the target = %+v, want it clean", got)
+	}
+	if got := status(deep); got.Drifted || !got.TargetChanged || got.TargetMissing {
+		t.Fatalf("deep status after editing the target = %+v, want the target changed", got)
+	}
+
+	if err := os.Remove(source); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	if got := status(deep); got.Drifted || !got.TargetMissing {
+		t.Fatalf("deep status after removing the target = %+v, want the target missing", got)
+	}
+}
+
+func TestStatusInaccessible(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("permissions are not enforced for root")
+	}
+	s, home := newTestStore(t)
+	profile := writeProfile(t, manifest.Root{
+		Source: "home",
+		Dest:   home,
+		Tree: manifest.Tree{
+			".config": manifest.DirectoryNode([]string{"copy"}, nil),
+			".zshrc":  manifest.FileNode("copy"),
+		},
+	})
+	writeTestFile(t, filepath.Join(profile, "home", "dot_config", "app.toml"), "managed\n")
+	writeTestFile(t, filepath.Join(profile, "home", "dot_zshrc"), "managed\n")
+	if _, err := s.Load(profile, Options{}); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	dir := filepath.Join(home, ".config")
+	if err := os.Chmod(dir, 0o000); err != nil {
+		t.Fatalf("Chmod() error = %v", err)
+	}
+	t.Cleanup(func() { _ = os.Chmod(dir, 0o755) })
+
+	for _, opts := range []StatusOptions{{}, {SkipDirHash: true}} {
+		snapshot, err := s.StatusWithOptions(opts)
+		if err != nil {
+			t.Fatalf("StatusWithOptions(%+v) error = %v, want the unreadable path reported", opts, err)
+		}
+		for _, item := range snapshot.Tracked {
+			wantInaccessible := item.Path == dir
+			if item.Inaccessible != wantInaccessible || item.Missing || item.Drifted {
+				t.Fatalf("StatusWithOptions(%+v) %s = %+v, want only .config inaccessible", opts, item.Path, item)
+			}
+		}
+		if summary := snapshot.Summary(); summary.Inaccessible != 1 || summary.Clean {
+			t.Fatalf("Summary() = %+v, want one inaccessible path and not clean", summary)
+		}
+	}
+}
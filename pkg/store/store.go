@@ -1,25 +1,47 @@
 package store
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 
+	"github.com/olimci/tohru/pkg/manifest"
 	"github.com/olimci/tohru/pkg/store/config"
 	"github.com/olimci/tohru/pkg/store/state"
+	"github.com/olimci/tohru/pkg/utils/fileutils"
+	"github.com/olimci/tohru/pkg/version"
 )
 
 const (
-	dirName      = ".tohru"
-	configFile   = "config.json"
-	stateFile    = "state.json"
-	backupsDir   = "backups"
-	profilesDir  = "profiles"
-	profilesFile = "profiles.json"
-	defaultKind  = "local"
-	envStoreDir  = "TOHRU_STORE_DIR"
+	dirName           = ".tohru"
+	configFile        = "config.json"
+	stateFile         = "state.json"
+	stateBackupFile   = "state.json.bak"
+	backupsDir        = "backups"
+	profilesDir       = "profiles"
+	profilesFile      = "profiles.json"
+	extractedDir      = "extracted"
+	downloadsDir      = "downloads"
+	journalFile       = "transaction.json"
+	journalLogFile    = "transaction.jsonl"
+	sourceCacheFile   = "sourcecache.json"
+	historyFile       = "history.jsonl"
+	rollbackDirPrefix = "switch-rollback-"
+	tempMarker        = ".tmp-"
+	defaultKind       = "local"
+	archiveKind       = "archive"
+	remoteKind        = "remote"
+	envStoreDir       = "TOHRU_STORE_DIR"
+	envBackup         = "TOHRU_BACKUP"         // options.backups.enabled
+	envClean          = "TOHRU_CLEAN"          // options.backups.prune: auto when true, manual when false
+	envBackupLayout   = "TOHRU_BACKUP_LAYOUT"  // options.backups.layout
+	envCacheProfiles  = "TOHRU_CACHE_PROFILES" // options.cache_profiles
+	envSource         = "TOHRU_SOURCE"         // options.default_source
 )
 
 var (
@@ -27,9 +49,68 @@ var (
 	ErrNotInstalled     = errors.New("tohru is not installed")
 )
 
-// Store points to local store files.
+// Store points to local store files. Library code should get one from Open
+// or OpenDefault rather than building it directly.
 type Store struct {
 	Root string
+
+	retry           fileutils.RetryPolicy // applied to filesystem changes, see Options.Retries
+	keepStateBackup bool                  // see Options.KeepStateBackup
+	backupLayout    string                // where new backups are written, see config.Backups.Layout
+}
+
+// Open returns the store rooted at root, which may start with "~" and is made
+// absolute. The root need not exist yet, but must be a directory if it does;
+// use IsInstalled or OpenInstalled to require an installed store.
+func Open(root string) (Store, error) {
+	root = strings.TrimSpace(root)
+	if root == "" {
+		return Store{}, fmt.Errorf("store root is empty")
+	}
+
+	absRoot, err := fileutils.AbsPath(root)
+	if err != nil {
+		return Store{}, fmt.Errorf("resolve store root %s: %w", root, err)
+	}
+	// A symlinked root is resolved so every path the store records sits
+	// under the real directory, and a dangling link fails here rather than
+	// deep inside the first write.
+	if info, err := os.Lstat(absRoot); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		resolved, err := filepath.EvalSymlinks(absRoot)
+		if err != nil {
+			return Store{}, fmt.Errorf("store root %s is a symlink that does not resolve: %w", absRoot, err)
+		}
+		absRoot = resolved
+	}
+	if info, err := os.Stat(absRoot); err == nil && !info.IsDir() {
+		return Store{}, fmt.Errorf("store root %s is not a directory", absRoot)
+	} else if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return Store{}, fmt.Errorf("stat store root %s: %w", absRoot, err)
+	}
+
+	return Store{Root: absRoot}, nil
+}
+
+// OpenInstalled is Open, but fails with ErrNotInstalled unless the store has
+// been installed.
+func OpenInstalled(root string) (Store, error) {
+	s, err := Open(root)
+	if err != nil {
+		return Store{}, err
+	}
+	if !s.IsInstalled() {
+		return Store{}, ErrNotInstalled
+	}
+	return s, nil
+}
+
+// OpenDefault opens the store in $TOHRU_STORE_DIR, or ~/.tohru if unset.
+func OpenDefault() (Store, error) {
+	s, err := DefaultStore()
+	if err != nil {
+		return Store{}, err
+	}
+	return Open(s.Root)
 }
 
 func DefaultStore() (Store, error) {
@@ -40,7 +121,12 @@ func DefaultStore() (Store, error) {
 		}
 		return Store{Root: absRoot}, nil
 	}
+	return HomeStore()
+}
 
+// HomeStore returns the store in ~/.tohru, where DefaultStore looks when
+// $TOHRU_STORE_DIR is unset.
+func HomeStore() (Store, error) {
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
 		return Store{}, fmt.Errorf("resolve user home directory: %w", err)
@@ -57,10 +143,23 @@ func (s Store) StatePath() string {
 	return filepath.Join(s.Root, stateFile)
 }
 
+// StateBackupPath is where the previous state is kept when
+// Options.KeepStateBackup is set.
+func (s Store) StateBackupPath() string {
+	return filepath.Join(s.Root, stateBackupFile)
+}
+
 func (s Store) BackupsPath() string {
 	return filepath.Join(s.Root, backupsDir)
 }
 
+// BackupPath returns where the backup object with the given CID is kept.
+// State records backups by CID alone, so this is the only place their paths
+// come from and a store moved to a new root still finds them.
+func (s Store) BackupPath(cid string) string {
+	return backupPath(s, cid, "")
+}
+
 func (s Store) ProfilesPath() string {
 	return filepath.Join(s.Root, profilesDir)
 }
@@ -69,6 +168,35 @@ func (s Store) ProfilesFilePath() string {
 	return filepath.Join(s.Root, profilesFile)
 }
 
+// ExtractedPath is where profile archives are unpacked while they are loaded.
+func (s Store) ExtractedPath() string {
+	return filepath.Join(s.Root, extractedDir)
+}
+
+// DownloadsPath is where archives loaded from a URL are kept, named by their
+// sha256.
+func (s Store) DownloadsPath() string {
+	return filepath.Join(s.Root, downloadsDir)
+}
+
+func (s Store) JournalPath() string {
+	return filepath.Join(s.Root, journalFile)
+}
+
+// JournalLogPath is where the paths a pending transaction changes are
+// journaled, one JSON string per line.
+func (s Store) JournalLogPath() string {
+	return filepath.Join(s.Root, journalLogFile)
+}
+
+func (s Store) SourceCachePath() string {
+	return filepath.Join(s.Root, sourceCacheFile)
+}
+
+func (s Store) HistoryPath() string {
+	return filepath.Join(s.Root, historyFile)
+}
+
 func (s Store) IsInstalled() bool {
 	if _, err := os.Stat(s.ConfigPath()); err != nil {
 		return false
@@ -86,6 +214,7 @@ func DefaultConfig() config.Config {
 			Backups: config.Backups{
 				Enabled: true,
 				Prune:   config.PruneAuto,
+				Layout:  config.LayoutCID,
 			},
 			CacheProfiles: true,
 		},
@@ -105,68 +234,88 @@ func DefaultState() state.State {
 }
 
 // Install initializes store and fails if store already exists.
-func (s Store) Install() error {
+func (s Store) Install() (InstallResult, error) {
 	lock, err := s.Lock()
 	if err != nil {
-		return err
+		return InstallResult{}, err
 	}
 	defer lock.Unlock()
 
 	if s.IsInstalled() {
-		return ErrAlreadyInstalled
+		return InstallResult{}, ErrAlreadyInstalled
 	}
 
-	_, err = s.installMissing()
-	return err
+	return s.installMissing()
 }
 
-// installMissing creates store directories and any missing store files.
-func (s Store) installMissing() (bool, error) {
-	if err := os.MkdirAll(s.BackupsPath(), 0o755); err != nil {
-		return false, fmt.Errorf("create store directories: %w", err)
-	}
-	if err := os.MkdirAll(s.ProfilesPath(), 0o755); err != nil {
-		return false, fmt.Errorf("create store directories: %w", err)
+// EnsureInstalled is Install, but succeeds on an existing store, creating
+// only the store directories and files it is missing.
+func (s Store) EnsureInstalled() (InstallResult, error) {
+	lock, err := s.Lock()
+	if err != nil {
+		return InstallResult{}, err
 	}
+	defer lock.Unlock()
 
-	var changed bool
+	return s.installMissing()
+}
 
-	if wrote, err := ensureJSONFile(s.ConfigPath(), DefaultConfig()); err != nil {
-		return false, err
-	} else if wrote {
-		changed = true
+// installMissing creates store directories and any missing store files, and
+// reports the ones it created.
+func (s Store) installMissing() (InstallResult, error) {
+	var result InstallResult
+	if err := checkWritable(s.Root); err != nil {
+		return result, err
 	}
-
-	if wrote, err := ensureJSONFile(s.StatePath(), DefaultState()); err != nil {
-		return false, err
-	} else if wrote {
-		changed = true
+	for _, dir := range []string{s.BackupsPath(), s.ProfilesPath()} {
+		if _, err := os.Stat(dir); err == nil {
+			continue
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return result, fmt.Errorf("stat %s: %w", dir, err)
+		}
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return result, fmt.Errorf("create store directories: %w", err)
+		}
+		result.CreatedPaths = append(result.CreatedPaths, dir)
 	}
 
-	if wrote, err := ensureJSONFile(s.ProfilesFilePath(), map[string]any{}); err != nil {
-		return false, err
-	} else if wrote {
-		changed = true
+	initial := DefaultState()
+	initial.WrittenBy = version.Version
+	for _, file := range []struct {
+		path  string
+		value any
+	}{
+		{s.ConfigPath(), DefaultConfig()},
+		{s.StatePath(), initial},
+		{s.ProfilesFilePath(), map[string]any{}},
+	} {
+		wrote, err := ensureJSONFile(file.path, file.value)
+		if err != nil {
+			return result, err
+		}
+		if wrote {
+			result.CreatedPaths = append(result.CreatedPaths, file.path)
+		}
 	}
 
-	return changed, nil
+	return result, nil
 }
 
 func (s Store) LoadConfig() (config.Config, error) {
 	cfg := DefaultConfig()
-	if _, err := os.Stat(s.ConfigPath()); err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			return cfg, nil
+	if _, err := os.Stat(s.ConfigPath()); err == nil {
+		if err := decodeJSON(s.ConfigPath(), &cfg); err != nil {
+			return config.Config{}, fmt.Errorf("decode %s: %w", s.ConfigPath(), err)
 		}
+		if cfg.Schema != config.SchemaVersion {
+			return config.Config{}, fmt.Errorf("unsupported config schema %d", cfg.Schema)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
 		return config.Config{}, fmt.Errorf("stat %s: %w", s.ConfigPath(), err)
 	}
 
-	if err := decodeJSON(s.ConfigPath(), &cfg); err != nil {
-		return config.Config{}, fmt.Errorf("decode %s: %w", s.ConfigPath(), err)
-	}
-
-	if cfg.Schema != config.SchemaVersion {
-		return config.Config{}, fmt.Errorf("unsupported config schema %d", cfg.Schema)
+	if err := applyConfigEnv(&cfg); err != nil {
+		return config.Config{}, err
 	}
 
 	cfg.Options.Backups.Prune = strings.ToLower(strings.TrimSpace(cfg.Options.Backups.Prune))
@@ -179,9 +328,121 @@ func (s Store) LoadConfig() (config.Config, error) {
 		return config.Config{}, fmt.Errorf("unsupported options.backups.prune value %q", cfg.Options.Backups.Prune)
 	}
 
+	cfg.Options.Backups.Layout = strings.ToLower(strings.TrimSpace(cfg.Options.Backups.Layout))
+	if cfg.Options.Backups.Layout == "" {
+		cfg.Options.Backups.Layout = config.LayoutCID
+	}
+	switch cfg.Options.Backups.Layout {
+	case config.LayoutCID, config.LayoutMirror:
+	default:
+		return config.Config{}, fmt.Errorf("unsupported options.backups.layout value %q", cfg.Options.Backups.Layout)
+	}
+
 	return cfg, nil
 }
 
+// applySourceOptions overrides cfg with the options a profile's manifest
+// declares, for loads of that profile. Options set in the environment still
+// win, and a profile can't turn on automatic pruning, which deletes backups,
+// unless the store's config already has it on.
+func applySourceOptions(cfg config.Config, opts *manifest.Options) (config.Config, error) {
+	if opts == nil {
+		return cfg, nil
+	}
+	if backups := opts.Backups; backups != nil {
+		if backups.Enabled != nil && !envSet(envBackup) {
+			cfg.Options.Backups.Enabled = *backups.Enabled
+		}
+		switch prune := strings.ToLower(strings.TrimSpace(backups.Prune)); prune {
+		case "":
+		case config.PruneManual:
+			if !envSet(envClean) {
+				cfg.Options.Backups.Prune = prune
+			}
+		case config.PruneAuto:
+			if cfg.Options.Backups.Prune != config.PruneAuto {
+				return cfg, fmt.Errorf("options.backups.prune: the profile asks to prune backups automatically, which only the store config can enable")
+			}
+		default:
+			return cfg, fmt.Errorf("options.backups.prune: unsupported value %q", backups.Prune)
+		}
+	}
+	if opts.CacheProfiles != nil && !envSet(envCacheProfiles) {
+		cfg.Options.CacheProfiles = *opts.CacheProfiles
+	}
+	return cfg, nil
+}
+
+func envSet(name string) bool {
+	raw, ok := os.LookupEnv(name)
+	return ok && strings.TrimSpace(raw) != ""
+}
+
+// applyConfigEnv overrides config options from the environment, so a store
+// can be configured without a config file. Booleans accept the values
+// strconv.ParseBool does: 1, t, true, 0, f, false and so on.
+func applyConfigEnv(cfg *config.Config) error {
+	bools := []struct {
+		name  string
+		value *bool
+	}{
+		{envBackup, &cfg.Options.Backups.Enabled},
+		{envCacheProfiles, &cfg.Options.CacheProfiles},
+	}
+	for _, b := range bools {
+		raw, ok := os.LookupEnv(b.name)
+		if !ok || strings.TrimSpace(raw) == "" {
+			continue
+		}
+		v, err := strconv.ParseBool(strings.TrimSpace(raw))
+		if err != nil {
+			return fmt.Errorf("%s: invalid boolean %q", b.name, raw)
+		}
+		*b.value = v
+	}
+
+	if raw := strings.TrimSpace(os.Getenv(envSource)); raw != "" {
+		cfg.Options.DefaultSource = raw
+	}
+	if raw := strings.TrimSpace(os.Getenv(envBackupLayout)); raw != "" {
+		cfg.Options.Backups.Layout = raw
+	}
+
+	if raw := strings.TrimSpace(os.Getenv(envClean)); raw != "" {
+		clean, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("%s: invalid boolean %q", envClean, raw)
+		}
+		cfg.Options.Backups.Prune = config.PruneManual
+		if clean {
+			cfg.Options.Backups.Prune = config.PruneAuto
+		}
+	}
+	return nil
+}
+
+// DefaultSource returns the configured default source as an absolute path,
+// or "" when none is configured.
+func (s Store) DefaultSource() (string, error) {
+	cfg, err := s.LoadConfig()
+	if err != nil {
+		return "", err
+	}
+	return defaultSource(cfg)
+}
+
+func defaultSource(cfg config.Config) (string, error) {
+	raw := strings.TrimSpace(cfg.Options.DefaultSource)
+	if raw == "" || isRemote(raw) {
+		return raw, nil
+	}
+	path, err := fileutils.AbsPath(raw)
+	if err != nil {
+		return "", fmt.Errorf("options.default_source: %w", err)
+	}
+	return path, nil
+}
+
 func (s Store) LoadState() (state.State, error) {
 	lck := DefaultState()
 	if _, err := os.Stat(s.StatePath()); err == nil {
@@ -199,6 +460,28 @@ func (s Store) LoadState() (state.State, error) {
 		lck.Profile.State = "unloaded"
 	}
 
+	// Paths are used as map keys against planned destinations, which are
+	// always clean, so they are cleaned too: a hand-edited
+	// "/home/me/.config/" matches "/home/me/.config". Nothing else is
+	// normalized; a "~" or relative path stays as written.
+	for i := range lck.Files {
+		lck.Files[i].Path = filepath.Clean(lck.Files[i].Path)
+	}
+	for i := range lck.Dirs {
+		lck.Dirs[i].Path = filepath.Clean(lck.Dirs[i].Path)
+	}
+	for i := range lck.Stashed {
+		lck.Stashed[i].Path = filepath.Clean(lck.Stashed[i].Path)
+	}
+	for _, layer := range lck.Added {
+		for i := range layer.Files {
+			layer.Files[i].Path = filepath.Clean(layer.Files[i].Path)
+		}
+		for i := range layer.Dirs {
+			layer.Dirs[i].Path = filepath.Clean(layer.Dirs[i].Path)
+		}
+	}
+
 	return lck, nil
 }
 
@@ -210,7 +493,100 @@ func (s Store) SaveState(lck state.State) error {
 		lck.Profile.State = "unloaded"
 	}
 
-	return encodeJSON(s.StatePath(), lck)
+	lck.WrittenBy = version.Version
+
+	if !s.keepStateBackup {
+		return s.retry.Do(func() error {
+			return encodeJSON(s.StatePath(), lck)
+		})
+	}
+
+	payload, err := indentJSON(lck)
+	if err != nil {
+		return fmt.Errorf("encode %s: %w", s.StatePath(), err)
+	}
+	return s.retry.Do(func() error {
+		previous, err := os.ReadFile(s.StatePath())
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+		case err != nil:
+			return fmt.Errorf("read %s: %w", s.StatePath(), err)
+		case bytes.Equal(previous, payload):
+			// Unchanged: keep the backup of the last real change.
+			return nil
+		default:
+			if err := writeAtomic(s.StateBackupPath(), previous); err != nil {
+				return err
+			}
+		}
+		return writeAtomic(s.StatePath(), payload)
+	})
+}
+
+// loadStateBackup reads the state kept by Options.KeepStateBackup, reporting
+// whether there is one.
+func (s Store) loadStateBackup() (state.State, bool, error) {
+	var st state.State
+	if err := decodeJSON(s.StateBackupPath(), &st); errors.Is(err, os.ErrNotExist) {
+		return state.State{}, false, nil
+	} else if err != nil {
+		return state.State{}, false, fmt.Errorf("decode %s: %w", s.StateBackupPath(), err)
+	}
+	return st, true, nil
+}
+
+// RestoreStateBackup swaps the state file with the backup kept by
+// Options.KeepStateBackup, so restoring twice undoes the restore. It only
+// restores the record of what is managed: files on disk are left as they are.
+func (s Store) RestoreStateBackup() error {
+	guard, err := s.Lock()
+	if err != nil {
+		return err
+	}
+	defer guard.Unlock()
+
+	if !s.IsInstalled() {
+		return ErrNotInstalled
+	}
+	if _, err := os.Stat(s.JournalPath()); err == nil {
+		return fmt.Errorf("an interrupted load or unload is pending; run load, reload or unload to recover it first")
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("stat %s: %w", s.JournalPath(), err)
+	}
+
+	backup, err := os.ReadFile(s.StateBackupPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("no state backup at %s", s.StateBackupPath())
+	} else if err != nil {
+		return fmt.Errorf("read %s: %w", s.StateBackupPath(), err)
+	}
+	var st state.State
+	if err := json.Unmarshal(backup, &st); err != nil {
+		return fmt.Errorf("decode %s: %w", s.StateBackupPath(), err)
+	}
+	current, err := os.ReadFile(s.StatePath())
+	if err != nil {
+		return fmt.Errorf("read %s: %w", s.StatePath(), err)
+	}
+
+	if err := writeAtomic(s.StatePath(), backup); err != nil {
+		return err
+	}
+	return writeAtomic(s.StateBackupPath(), current)
+}
+
+// newerWriterWarning returns a warning when the state was last saved by a
+// newer tohru, since whatever only that version records is dropped when this
+// one saves the state. It returns "" otherwise.
+func newerWriterWarning(lck state.State) string {
+	newer, err := version.Newer(lck.WrittenBy)
+	if err != nil {
+		return fmt.Sprintf("state was written by an unrecognised tohru version %q: %v", lck.WrittenBy, err)
+	}
+	if !newer {
+		return ""
+	}
+	return fmt.Sprintf("state was written by tohru %s, newer than this %s; anything only it records is dropped when the state is saved", lck.WrittenBy, version.Version)
 }
 
 func (s Store) LoadProfiles() (map[string]state.CachedProfile, error) {
@@ -234,3 +610,24 @@ func (s Store) SaveProfiles(profiles map[string]state.CachedProfile) error {
 	}
 	return encodeJSON(s.ProfilesFilePath(), profiles)
 }
+
+// checkWritable fails unless root is a directory a file can be created in, so
+// a read-only mount is reported up front instead of as whichever write
+// happened to hit it first.
+func checkWritable(root string) error {
+	info, err := os.Stat(root)
+	if err != nil {
+		return fmt.Errorf("stat store root %s: %w", root, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("store root %s is not a directory", root)
+	}
+
+	probe, err := os.CreateTemp(root, "write-check"+tempMarker+"*")
+	if err != nil {
+		return fmt.Errorf("store root is not writable: %s: %w", root, err)
+	}
+	_ = probe.Close()
+	_ = os.Remove(probe.Name())
+	return nil
+}
@@ -0,0 +1,333 @@
+package store
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/olimci/tohru/pkg/manifest"
+	"github.com/olimci/tohru/pkg/store/config"
+	"github.com/olimci/tohru/pkg/version"
+)
+
+func TestOpen(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	s, err := Open("~/.tohru")
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	if want := filepath.Join(home, ".tohru"); s.Root != want {
+		t.Fatalf("Open() Root = %q, want %q", s.Root, want)
+	}
+
+	if _, err := Open("  "); err == nil {
+		t.Fatalf("Open(empty) succeeded, want error")
+	}
+
+	file := filepath.Join(home, "file")
+	if err := os.WriteFile(file, nil, 0o644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if _, err := Open(file); err == nil {
+		t.Fatalf("Open(file) succeeded, want not a directory error")
+	}
+
+	if _, err := OpenInstalled(s.Root); !errors.Is(err, ErrNotInstalled) {
+		t.Fatalf("OpenInstalled() error = %v, want ErrNotInstalled", err)
+	}
+	if _, err := s.Install(); err != nil {
+		t.Fatalf("Install() error = %v", err)
+	}
+	if _, err := OpenInstalled(s.Root); err != nil {
+		t.Fatalf("OpenInstalled() after install error = %v", err)
+	}
+}
+
+func TestOpenResolvesSymlinkedRoot(t *testing.T) {
+	dir := t.TempDir()
+	real := filepath.Join(dir, "real")
+	if err := os.Mkdir(real, 0o755); err != nil {
+		t.Fatalf("Mkdir() error = %v", err)
+	}
+	link := filepath.Join(dir, "link")
+	if err := os.Symlink(real, link); err != nil {
+		t.Fatalf("Symlink() error = %v", err)
+	}
+
+	s, err := Open(link)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	if want, _ := filepath.EvalSymlinks(real); s.Root != want {
+		t.Fatalf("Open() Root = %q, want %q", s.Root, want)
+	}
+
+	dangling := filepath.Join(dir, "dangling")
+	if err := os.Symlink(filepath.Join(dir, "missing"), dangling); err != nil {
+		t.Fatalf("Symlink() error = %v", err)
+	}
+	if _, err := Open(dangling); err == nil || !strings.Contains(err.Error(), "does not resolve") {
+		t.Fatalf("Open(dangling) error = %v, want does not resolve", err)
+	}
+}
+
+func TestLockRejectsUnusableRoot(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(file, nil, 0o644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if _, err := (Store{Root: file}).Lock(); err == nil || !strings.Contains(err.Error(), "not a directory") {
+		t.Fatalf("Lock() on a file error = %v, want not a directory", err)
+	}
+
+	if os.Geteuid() == 0 {
+		t.Skip("root can write to read-only directories")
+	}
+	root := t.TempDir()
+	if err := os.Chmod(root, 0o555); err != nil {
+		t.Fatalf("Chmod() error = %v", err)
+	}
+	t.Cleanup(func() { _ = os.Chmod(root, 0o755) })
+
+	if _, err := (Store{Root: root}).Lock(); err == nil || !strings.Contains(err.Error(), "store root is not writable: "+root) {
+		t.Fatalf("Lock() on a read-only root error = %v, want not writable", err)
+	}
+	if _, err := (Store{Root: root}).Install(); err == nil || !strings.Contains(err.Error(), "store root is not writable") {
+		t.Fatalf("Install() on a read-only root error = %v, want not writable", err)
+	}
+}
+
+func TestSaveStateIsIndented(t *testing.T) {
+	s, _ := newTestStore(t)
+	if _, err := s.Install(); err != nil {
+		t.Fatalf("Install() error = %v", err)
+	}
+
+	raw, err := os.ReadFile(s.StatePath())
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if !strings.Contains(string(raw), "\n  \"") {
+		t.Fatalf("state file is not indented:\n%s", raw)
+	}
+	if _, err := s.LoadState(); err != nil {
+		t.Fatalf("LoadState() error = %v", err)
+	}
+}
+
+func TestStateWrittenByNewerVersion(t *testing.T) {
+	s, home := newTestStore(t)
+	profile := writeProfile(t, manifest.Root{
+		Source:   "home",
+		Dest:     home,
+		Defaults: &manifest.Defaults{Type: "copy"},
+		Tree:     manifest.Tree{".zshrc": manifest.FileNode()},
+	})
+	writeTestFile(t, filepath.Join(profile, "home", "dot_zshrc"), "managed\n")
+	if _, err := s.Install(); err != nil {
+		t.Fatalf("Install() error = %v", err)
+	}
+	lck, err := s.LoadState()
+	if err != nil {
+		t.Fatalf("LoadState() error = %v", err)
+	}
+	if lck.WrittenBy != version.Version {
+		t.Fatalf("WrittenBy = %q, want %q", lck.WrittenBy, version.Version)
+	}
+
+	// A later tohru saved the state, with a field this one doesn't know.
+	raw, err := os.ReadFile(s.StatePath())
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	newer := strings.Replace(string(raw), `"written_by": "`+version.Version+`"`, `"written_by": "99.0.0", "future": true`, 1)
+	if err := os.WriteFile(s.StatePath(), []byte(newer), 0o644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	res, err := s.Load(profile, Options{})
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if !slices.ContainsFunc(res.Warnings, func(w string) bool { return strings.Contains(w, "written by tohru 99.0.0") }) {
+		t.Fatalf("Load() warnings = %q, want the newer writer reported", res.Warnings)
+	}
+	if lck, err = s.LoadState(); err != nil {
+		t.Fatalf("LoadState() error = %v", err)
+	}
+	if lck.WrittenBy != version.Version {
+		t.Fatalf("WrittenBy after load = %q, want %q", lck.WrittenBy, version.Version)
+	}
+
+	res2, err := s.Unload(Options{})
+	if err != nil {
+		t.Fatalf("Unload() error = %v", err)
+	}
+	if len(res2.Warnings) != 0 {
+		t.Fatalf("Unload() warnings = %q, want none once this version saved the state", res2.Warnings)
+	}
+}
+
+func TestLoadConfigEnvOverrides(t *testing.T) {
+	s, _ := newTestStore(t)
+	if _, err := s.Install(); err != nil {
+		t.Fatalf("Install() error = %v", err)
+	}
+	t.Setenv("TOHRU_BACKUP", "0")
+	t.Setenv("TOHRU_CLEAN", "false")
+
+	cfg, err := s.LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if cfg.Options.Backups.Enabled || cfg.Options.Backups.Prune != config.PruneManual {
+		t.Fatalf("LoadConfig() backups = %+v, want disabled with manual pruning", cfg.Options.Backups)
+	}
+
+	t.Setenv("TOHRU_BACKUP", "maybe")
+	if _, err := s.LoadConfig(); err == nil || !strings.Contains(err.Error(), "TOHRU_BACKUP") {
+		t.Fatalf("LoadConfig() error = %v, want invalid TOHRU_BACKUP", err)
+	}
+}
+
+func TestInstallReportsCreatedPaths(t *testing.T) {
+	s, _ := newTestStore(t)
+	res, err := s.Install()
+	if err != nil {
+		t.Fatalf("Install() error = %v", err)
+	}
+	want := []string{s.BackupsPath(), s.ProfilesPath(), s.ConfigPath(), s.StatePath(), s.ProfilesFilePath()}
+	if !slices.Equal(res.CreatedPaths, want) {
+		t.Fatalf("Install() CreatedPaths = %v, want %v", res.CreatedPaths, want)
+	}
+
+	if _, err := s.Install(); !errors.Is(err, ErrAlreadyInstalled) {
+		t.Fatalf("Install() again error = %v, want ErrAlreadyInstalled", err)
+	}
+	if res, err = s.EnsureInstalled(); err != nil || len(res.CreatedPaths) != 0 {
+		t.Fatalf("EnsureInstalled() = %v, %v, want nothing created on a complete store", res.CreatedPaths, err)
+	}
+	if err := os.Remove(s.StatePath()); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	if res, err = s.EnsureInstalled(); err != nil {
+		t.Fatalf("EnsureInstalled() error = %v", err)
+	}
+	if !slices.Equal(res.CreatedPaths, []string{s.StatePath()}) {
+		t.Fatalf("EnsureInstalled() CreatedPaths = %v, want only the missing state file", res.CreatedPaths)
+	}
+
+	removed, err := s.Uninstall()
+	if err != nil {
+		t.Fatalf("Uninstall() error = %v", err)
+	}
+	if !slices.Contains(removed.RemovedPaths, s.ConfigPath()) || removed.RemovedPaths[len(removed.RemovedPaths)-1] != s.Root {
+		t.Fatalf("Uninstall() RemovedPaths = %v, want the store's entries then its root", removed.RemovedPaths)
+	}
+}
+
+func TestKeepStateBackup(t *testing.T) {
+	s, home := newTestStore(t)
+	profile := writeProfile(t, manifest.Root{
+		Source:   "home",
+		Dest:     home,
+		Defaults: &manifest.Defaults{Type: "copy"},
+		Tree:     manifest.Tree{".zshrc": manifest.FileNode()},
+	})
+	writeTestFile(t, filepath.Join(profile, "home", "dot_zshrc"), "managed\n")
+	if _, err := s.Install(); err != nil {
+		t.Fatalf("Install() error = %v", err)
+	}
+	if _, err := s.Load(profile, Options{}); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if _, err := os.Stat(s.StateBackupPath()); !os.IsNotExist(err) {
+		t.Fatalf("state backup written without KeepStateBackup: %v", err)
+	}
+	if _, err := s.Unload(Options{}); err != nil {
+		t.Fatalf("Unload() error = %v", err)
+	}
+
+	if _, err := s.Load(profile, Options{KeepStateBackup: true}); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	unloaded, err := os.ReadFile(s.StateBackupPath())
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if !strings.Contains(string(unloaded), `"state": "unloaded"`) {
+		t.Fatalf("state backup = %s, want the unloaded state from before the load", unloaded)
+	}
+	loaded, err := os.ReadFile(s.StatePath())
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+
+	// An unchanged save leaves the backup of the last real change alone.
+	lck, err := s.LoadState()
+	if err != nil {
+		t.Fatalf("LoadState() error = %v", err)
+	}
+	if err := s.withStateBackup(true).SaveState(lck); err != nil {
+		t.Fatalf("SaveState() error = %v", err)
+	}
+	if got, _ := os.ReadFile(s.StateBackupPath()); string(got) != string(unloaded) {
+		t.Fatalf("state backup after an unchanged save = %s, want it kept", got)
+	}
+
+	if err := s.RestoreStateBackup(); err != nil {
+		t.Fatalf("RestoreStateBackup() error = %v", err)
+	}
+	if got, _ := os.ReadFile(s.StatePath()); string(got) != string(unloaded) {
+		t.Fatalf("state after restore = %s, want the backup", got)
+	}
+	if got, _ := os.ReadFile(s.StateBackupPath()); string(got) != string(loaded) {
+		t.Fatalf("backup after restore = %s, want the replaced state", got)
+	}
+}
+
+// TestStateBackupKeepsItsBackups restores a state whose backups the unload
+// that replaced it would have pruned.
+func TestStateBackupKeepsItsBackups(t *testing.T) {
+	s, home := newTestStore(t)
+	zshrc := filepath.Join(home, ".zshrc")
+	writeTestFile(t, zshrc, "original\n")
+	original := mustDigest(t, zshrc)
+	profile := writeProfile(t, manifest.Root{
+		Source:   "home",
+		Dest:     home,
+		Defaults: &manifest.Defaults{Type: "copy"},
+		Tree:     manifest.Tree{".zshrc": manifest.FileNode()},
+	})
+	writeTestFile(t, filepath.Join(profile, "home", "dot_zshrc"), "managed\n")
+	if _, err := s.Load(profile, Options{}); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	if _, err := s.Unload(Options{KeepStateBackup: true}); err != nil {
+		t.Fatalf("Unload() error = %v", err)
+	}
+	if _, err := os.Stat(backupPath(s, original, "")); err != nil {
+		t.Fatalf("backup the state backup refers to was pruned: %v", err)
+	}
+	if res, err := s.GC(GCOptions{}); err != nil || res.UnreferencedBackupCount != 0 {
+		t.Fatalf("GC() = %+v, %v, want the backup kept", res, err)
+	}
+
+	if err := s.RestoreStateBackup(); err != nil {
+		t.Fatalf("RestoreStateBackup() error = %v", err)
+	}
+	// The restored state records the managed content, which unload put back
+	// to the original, so removing it takes --force.
+	if _, err := s.Unload(Options{Force: true}); err != nil {
+		t.Fatalf("Unload() after restoring the state error = %v", err)
+	}
+	if got := mustDigest(t, zshrc); got != original {
+		t.Fatalf(".zshrc digest = %s, want the original %s", got, original)
+	}
+}
@@ -0,0 +1,74 @@
+package store
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/olimci/tohru/pkg/store/state"
+	"github.com/olimci/tohru/pkg/utils/fileutils"
+)
+
+// cleanSubdir validates the manifest directory of a source, given relative
+// to the source root, and returns it cleaned in slash form, or "" for the
+// root itself.
+func cleanSubdir(subdir string) (string, error) {
+	raw := strings.TrimSpace(subdir)
+	if raw == "" {
+		return "", nil
+	}
+	clean := filepath.Clean(filepath.FromSlash(raw))
+	if filepath.IsAbs(clean) || fileutils.Escapes(clean) {
+		return "", fmt.Errorf("manifest directory %q must be a path inside the source", subdir)
+	}
+	if clean == "." {
+		return "", nil
+	}
+	return filepath.ToSlash(clean), nil
+}
+
+// sourceSubdir returns the directory subdir names under the source root.
+// Source paths in the manifest are resolved against this directory, so the
+// rest of the source stays out of reach; a symlink leading out of root is
+// rejected for the same reason.
+func sourceSubdir(root, subdir string) (string, error) {
+	clean, err := cleanSubdir(subdir)
+	if err != nil || clean == "" {
+		return root, err
+	}
+	dir := filepath.Join(root, filepath.FromSlash(clean))
+	info, err := os.Stat(dir)
+	if err != nil {
+		return "", fmt.Errorf("manifest directory %q: %w", clean, err)
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("manifest directory %q is not a directory", clean)
+	}
+
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", fmt.Errorf("resolve source %s: %w", root, err)
+	}
+	realDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", fmt.Errorf("resolve manifest directory %s: %w", dir, err)
+	}
+	if rel, err := filepath.Rel(realRoot, realDir); err != nil || fileutils.Escapes(rel) {
+		return "", fmt.Errorf("manifest directory %q leads outside the source through a symlink", clean)
+	}
+	return dir, nil
+}
+
+// subdirRoot returns the source root of a loaded local profile, whose Path
+// is its manifest directory.
+func subdirRoot(p state.Profile) string {
+	if p.Subdir == "" {
+		return p.Path
+	}
+	suffix := string(filepath.Separator) + filepath.FromSlash(p.Subdir)
+	if root, ok := strings.CutSuffix(filepath.Clean(p.Path), suffix); ok {
+		return root
+	}
+	return p.Path
+}
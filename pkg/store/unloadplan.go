@@ -0,0 +1,86 @@
+package store
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+
+	"github.com/olimci/tohru/pkg/store/state"
+	"github.com/olimci/tohru/pkg/utils/fileutils"
+)
+
+// UnloadPlan is what unloading would do, worked out without changing anything.
+type UnloadPlan struct {
+	ProfileName string
+	Paths       []PlannedPath // in the order unload visits them, deepest first
+}
+
+// PlannedPath is what unloading would do to one tracked path.
+type PlannedPath struct {
+	Path    string
+	Kind    string // link, file, dir or copy
+	Remove  bool   // the managed object would be removed; false with KeepFiles or when it is already gone
+	Drifted bool   // the managed object changed since it was loaded
+	Stash   bool   // drifted content would be backed up first
+	Restore string // digest of the backup that would be restored, or its path if none was recorded
+	Problem string // why unloading would fail here, if it would
+}
+
+// Failing reports whether any path in the plan would make unloading fail.
+func (p UnloadPlan) Failing() bool {
+	return slices.ContainsFunc(p.Paths, func(path PlannedPath) bool {
+		return path.Problem != ""
+	})
+}
+
+// UnloadPlan works out what UnloadProfile(name, opts) would do, or Unload
+// when name is empty, running the same checks against every tracked path and
+// its backup without removing or restoring anything.
+func (s Store) UnloadPlan(name string, opts Options) (UnloadPlan, error) {
+	if !s.IsInstalled() {
+		return UnloadPlan{}, ErrNotInstalled
+	}
+
+	st, err := s.LoadState()
+	if err != nil {
+		return UnloadPlan{}, err
+	}
+	target, _, err := unloadTarget(st, strings.TrimSpace(name))
+	if err != nil {
+		return UnloadPlan{}, err
+	}
+
+	files := slices.Clone(target.Files)
+	slices.SortFunc(files, func(a, b state.File) int {
+		return -fileutils.CompareDepth(a.Path, b.Path)
+	})
+
+	plan := UnloadPlan{ProfileName: target.Name, Paths: make([]PlannedPath, 0, len(files))}
+	for _, managed := range files {
+		planned := PlannedPath{Path: managed.Path, Kind: objectKind(managed.Current.Digest)}
+		plan.Paths = append(plan.Paths, planned)
+		if opts.KeepFiles {
+			continue
+		}
+
+		current := &plan.Paths[len(plan.Paths)-1]
+		_, exists, drifted, err := inspectManaged(managed, files, opts)
+		current.Remove = exists
+		current.Drifted = drifted
+		current.Stash = drifted && opts.BackupDrifted
+		if err != nil {
+			current.Problem = err.Error()
+			continue
+		}
+
+		_, _, ok, err := locateBackup(s, managed.Previous, managed.Path, opts.Force)
+		if err != nil {
+			current.Problem = err.Error()
+			continue
+		}
+		if ok {
+			current.Restore = cmp.Or(managed.Previous.Digest, managed.Previous.Path)
+		}
+	}
+	return plan, nil
+}
@@ -1,14 +1,40 @@
 package store
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 )
 
+// encodeJSON atomically writes value to path as indented JSON, so store
+// files stay readable and diffable by hand.
 func encodeJSON(path string, value any) error {
+	payload, err := indentJSON(value)
+	if err != nil {
+		return fmt.Errorf("encode %s: %w", path, err)
+	}
+	return writeAtomic(path, payload)
+}
+
+// indentJSON returns value as the indented JSON encodeJSON writes.
+func indentJSON(value any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(value); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeAtomic replaces path with payload through a temporary file, so readers
+// never see a partial write. The file is synced before it replaces path, so
+// an interruption leaves either the old or the new content.
+func writeAtomic(path string, payload []byte) error {
 	dir := filepath.Dir(path)
 	if err := os.MkdirAll(dir, 0o755); err != nil {
 		return fmt.Errorf("create directory for %s: %w", path, err)
@@ -26,9 +52,13 @@ func encodeJSON(path string, value any) error {
 		return fmt.Errorf("chmod %s: %w", tp, err)
 	}
 
-	if err := json.NewEncoder(f).Encode(value); err != nil {
+	if _, err := f.Write(payload); err != nil {
 		_ = os.Remove(tp)
-		return fmt.Errorf("encode %s: %w", tp, err)
+		return fmt.Errorf("write %s: %w", tp, err)
+	}
+	if err := f.Sync(); err != nil {
+		_ = os.Remove(tp)
+		return fmt.Errorf("sync %s: %w", tp, err)
 	}
 	if err := f.Close(); err != nil {
 		_ = os.Remove(tp)
@@ -39,6 +69,12 @@ func encodeJSON(path string, value any) error {
 		_ = os.Remove(tp)
 		return fmt.Errorf("replace %s: %w", path, err)
 	}
+	// The rename is only durable once the directory is; not every platform
+	// can sync one, so this is best effort.
+	if d, err := os.Open(dir); err == nil {
+		_ = d.Sync()
+		_ = d.Close()
+	}
 
 	return nil
 }
@@ -69,3 +105,20 @@ func decodeJSON(path string, value any) error {
 	}
 	return nil
 }
+
+// resolveExisting resolves symlinks in the longest existing prefix of path
+// and appends the rest unchanged, so paths that don't exist yet can still be
+// compared by where they would really be written.
+func resolveExisting(path string) string {
+	var rest []string
+	for dir := filepath.Clean(path); ; dir = filepath.Dir(dir) {
+		if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+			slices.Reverse(rest)
+			return filepath.Join(append([]string{resolved}, rest...)...)
+		}
+		if parent := filepath.Dir(dir); parent == dir {
+			return filepath.Clean(path)
+		}
+		rest = append(rest, filepath.Base(dir))
+	}
+}
@@ -0,0 +1,327 @@
+package archiveutils
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/olimci/tohru/pkg/utils/fileutils"
+)
+
+// IsArchive reports whether path names a supported archive format.
+func IsArchive(path string) bool {
+	return format(path) != ""
+}
+
+func format(path string) string {
+	name := strings.ToLower(path)
+	switch {
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return "tar.gz"
+	case strings.HasSuffix(name, ".tar"):
+		return "tar"
+	case strings.HasSuffix(name, ".zip"):
+		return "zip"
+	default:
+		return ""
+	}
+}
+
+// ErrTooLarge is returned when an archive holds more than Limits allow.
+var ErrTooLarge = errors.New("archive exceeds the extraction limits")
+
+// Limits bounds what extracting an archive may write, so a malicious or
+// corrupt one can't fill the disk. A zero field means no limit.
+type Limits struct {
+	MaxSize    int64 // total bytes of content read out of the archive
+	MaxEntries int   // number of entries, directories included
+}
+
+// DefaultLimits are the limits Extract applies, far beyond any dotfiles
+// repository.
+var DefaultLimits = Limits{MaxSize: 1 << 30, MaxEntries: 100_000}
+
+// Extract unpacks archive into dest with DefaultLimits, see ExtractWith.
+func Extract(archive, dest string) error {
+	return ExtractWith(archive, dest, DefaultLimits)
+}
+
+// ExtractWith unpacks archive into dest, creating it if needed. Entries whose
+// names or link targets would escape dest are rejected, and an archive past
+// limits fails with ErrTooLarge. On failure, whatever was extracted is
+// removed again, leaving what dest held before.
+func ExtractWith(archive, dest string, limits Limits) error {
+	if err := os.MkdirAll(dest, 0o755); err != nil {
+		return fmt.Errorf("create extraction directory %s: %w", dest, err)
+	}
+	existing, err := os.ReadDir(dest)
+	if err != nil {
+		return fmt.Errorf("read extraction directory %s: %w", dest, err)
+	}
+
+	x := &extraction{dest: dest, limits: limits}
+	switch format(archive) {
+	case "tar", "tar.gz":
+		err = x.tar(archive)
+	case "zip":
+		err = x.zip(archive)
+	default:
+		return fmt.Errorf("unsupported archive format: %s", archive)
+	}
+	if err != nil {
+		if cleanupErr := removeExtracted(dest, existing); cleanupErr != nil {
+			return errors.Join(err, cleanupErr)
+		}
+	}
+	return err
+}
+
+// removeExtracted removes every entry of dest that isn't in existing.
+func removeExtracted(dest string, existing []fs.DirEntry) error {
+	entries, err := os.ReadDir(dest)
+	if err != nil {
+		return fmt.Errorf("read extraction directory %s: %w", dest, err)
+	}
+	kept := make(map[string]struct{}, len(existing))
+	for _, e := range existing {
+		kept[e.Name()] = struct{}{}
+	}
+	var errs []error
+	for _, e := range entries {
+		if _, ok := kept[e.Name()]; !ok {
+			errs = append(errs, fileutils.RemovePath(filepath.Join(dest, e.Name())))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// extraction is one archive being extracted into dest, counting what has
+// been written against limits.
+type extraction struct {
+	dest    string
+	limits  Limits
+	size    int64
+	entries int
+}
+
+// entry counts another entry, failing once there are too many.
+func (x *extraction) entry(name string) error {
+	x.entries++
+	if x.limits.MaxEntries > 0 && x.entries > x.limits.MaxEntries {
+		return fmt.Errorf("%w: more than %d entries at %s", ErrTooLarge, x.limits.MaxEntries, name)
+	}
+	return nil
+}
+
+// reader makes r count what is read through it against MaxSize, failing once
+// more than that has been read. It trusts no size the archive records.
+func (x *extraction) reader(r io.Reader) io.Reader {
+	return &countingReader{r: r, x: x}
+}
+
+type countingReader struct {
+	r io.Reader
+	x *extraction
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.x.size += int64(n)
+	if c.x.limits.MaxSize > 0 && c.x.size > c.x.limits.MaxSize {
+		return n, fmt.Errorf("%w: more than %d bytes", ErrTooLarge, c.x.limits.MaxSize)
+	}
+	return n, err
+}
+
+func (x *extraction) tar(archive string) error {
+	f, err := os.Open(archive)
+	if err != nil {
+		return fmt.Errorf("open archive %s: %w", archive, err)
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if format(archive) == "tar.gz" {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return fmt.Errorf("read gzip stream %s: %w", archive, err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("read archive %s: %w", archive, err)
+		}
+
+		if hdr.Typeflag == tar.TypeXGlobalHeader {
+			continue
+		}
+		if err := x.entry(hdr.Name); err != nil {
+			return err
+		}
+		path, err := entryPath(x.dest, hdr.Name)
+		if err != nil {
+			return err
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(path, 0o755)
+		case tar.TypeReg:
+			err = writeEntry(path, x.reader(tr), hdr.FileInfo().Mode().Perm())
+		case tar.TypeSymlink:
+			err = writeSymlink(x.dest, path, hdr.Linkname)
+		default:
+			return fmt.Errorf("archive entry %s: unsupported type %q", hdr.Name, hdr.Typeflag)
+		}
+		if err != nil {
+			return fmt.Errorf("extract %s: %w", hdr.Name, err)
+		}
+	}
+}
+
+func (x *extraction) zip(archive string) error {
+	zr, err := zip.OpenReader(archive)
+	if err != nil {
+		return fmt.Errorf("open archive %s: %w", archive, err)
+	}
+	defer zr.Close()
+
+	for _, file := range zr.File {
+		if err := x.entry(file.Name); err != nil {
+			return err
+		}
+		path, err := entryPath(x.dest, file.Name)
+		if err != nil {
+			return err
+		}
+		if err := x.zipEntry(path, file); err != nil {
+			return fmt.Errorf("extract %s: %w", file.Name, err)
+		}
+	}
+	return nil
+}
+
+func (x *extraction) zipEntry(path string, file *zip.File) error {
+	mode := file.Mode()
+	if mode.IsDir() {
+		return os.MkdirAll(path, 0o755)
+	}
+
+	rc, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	r := x.reader(rc)
+
+	switch {
+	case mode&fs.ModeSymlink != 0:
+		target, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		return writeSymlink(x.dest, path, string(target))
+	case mode.IsRegular():
+		return writeEntry(path, r, mode.Perm())
+	default:
+		return fmt.Errorf("unsupported file mode %s", mode)
+	}
+}
+
+// entryPath resolves an archive entry name inside dest. Entries are never
+// written through a symlink extracted earlier, so none of the directories
+// leading to the entry, nor the entry itself, may already be one.
+func entryPath(dest, name string) (string, error) {
+	clean := filepath.Clean(filepath.FromSlash(name))
+	if filepath.IsAbs(clean) || fileutils.Escapes(clean) {
+		return "", fmt.Errorf("archive entry %q escapes the extraction directory", name)
+	}
+	path := dest
+	for _, part := range fileutils.SplitPathParts(clean) {
+		path = filepath.Join(path, part)
+		info, err := os.Lstat(path)
+		if errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return "", fmt.Errorf("archive entry %q would be written through the symlink %s", name, path)
+		}
+	}
+	return filepath.Join(dest, clean), nil
+}
+
+func writeEntry(path string, r io.Reader, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	if perm == 0 {
+		perm = 0o644
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// writeSymlink creates a link at path, refusing targets that could resolve
+// outside dest: absolute ones, ones leading out of it, and ones that go
+// through another symlink or back up with ".." after naming a directory,
+// since where those end up depends on links extracted later.
+func writeSymlink(dest, path, target string) error {
+	if filepath.IsAbs(target) {
+		return fmt.Errorf("symlink target %q escapes the extraction directory", target)
+	}
+	resolved := filepath.Dir(path)
+	named := false
+	for _, part := range strings.Split(filepath.ToSlash(target), "/") {
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			if named {
+				return fmt.Errorf("symlink target %q goes back up after naming a directory", target)
+			}
+			resolved = filepath.Dir(resolved)
+			continue
+		}
+		named = true
+		resolved = filepath.Join(resolved, part)
+		info, err := os.Lstat(resolved)
+		if err == nil && info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("symlink target %q goes through the symlink %s", target, resolved)
+		} else if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	rel, err := filepath.Rel(dest, resolved)
+	if err != nil || fileutils.Escapes(rel) {
+		return fmt.Errorf("symlink target %q escapes the extraction directory", target)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.Symlink(target, path)
+}
@@ -0,0 +1,204 @@
+package archiveutils
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type entry struct {
+	name string
+	body string
+	link string
+}
+
+func writeTarGz(t *testing.T, path string, entries []entry) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Mode: 0o644, Size: int64(len(e.body)), Typeflag: tar.TypeReg}
+		if e.link != "" {
+			hdr = &tar.Header{Name: e.name, Linkname: e.link, Typeflag: tar.TypeSymlink}
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatalf("WriteHeader() error = %v", err)
+		}
+		if _, err := tw.Write([]byte(e.body)); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+}
+
+func TestExtractTarGz(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "dotfiles.tar.gz")
+	writeTarGz(t, archive, []entry{
+		{name: "dotfiles/tohru.json", body: "{}"},
+		{name: "dotfiles/home/dot_zshrc", body: "zsh\n"},
+		{name: "dotfiles/home/zshrc", link: "dot_zshrc"},
+	})
+
+	dest := filepath.Join(dir, "out")
+	if err := Extract(archive, dest); err != nil {
+		t.Fatalf("Extract() error = %v", err)
+	}
+	got, err := os.ReadFile(filepath.Join(dest, "dotfiles", "home", "zshrc"))
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if string(got) != "zsh\n" {
+		t.Fatalf("extracted content = %q, want %q", got, "zsh\n")
+	}
+}
+
+func TestExtractZip(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "dotfiles.zip")
+	f, err := os.Create(archive)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	zw := zip.NewWriter(f)
+	w, err := zw.Create("home/dot_vimrc")
+	if err != nil {
+		t.Fatalf("zip Create() error = %v", err)
+	}
+	if _, err := w.Write([]byte("vim\n")); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	f.Close()
+
+	dest := filepath.Join(dir, "out")
+	if err := Extract(archive, dest); err != nil {
+		t.Fatalf("Extract() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dest, "home", "dot_vimrc")); err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+}
+
+func TestExtractLimits(t *testing.T) {
+	entries := []entry{
+		{name: "dotfiles/home/dot_zshrc", body: "zshrc\n"},
+		{name: "dotfiles/home/dot_vimrc", body: "vimrc\n"},
+		{name: "dotfiles/home/vimrc", link: "dot_vimrc"},
+	}
+	tests := []struct {
+		name   string
+		limits Limits
+	}{
+		{name: "size", limits: Limits{MaxSize: 10}},
+		{name: "entries", limits: Limits{MaxEntries: 2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			archive := filepath.Join(dir, "big.tar.gz")
+			writeTarGz(t, archive, entries)
+			dest := filepath.Join(dir, "out")
+			if err := os.MkdirAll(dest, 0o755); err != nil {
+				t.Fatalf("MkdirAll() error = %v", err)
+			}
+			if err := os.WriteFile(filepath.Join(dest, "kept"), nil, 0o644); err != nil {
+				t.Fatalf("WriteFile() error = %v", err)
+			}
+
+			if err := ExtractWith(archive, dest, tt.limits); !errors.Is(err, ErrTooLarge) {
+				t.Fatalf("ExtractWith() error = %v, want ErrTooLarge", err)
+			}
+			// What was extracted before the limit was hit is gone again.
+			left, err := os.ReadDir(dest)
+			if err != nil || len(left) != 1 || left[0].Name() != "kept" {
+				t.Fatalf("extraction directory = %v, %v, want only what was there before", left, err)
+			}
+			if err := ExtractWith(archive, filepath.Join(dir, "all"), Limits{MaxSize: 12, MaxEntries: 3}); err != nil {
+				t.Fatalf("ExtractWith() at the limits error = %v", err)
+			}
+		})
+	}
+}
+
+func TestExtractRejectsEscapes(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []entry
+	}{
+		{name: "parent path", entries: []entry{{name: "../evil", body: "x"}}},
+		{name: "absolute link", entries: []entry{{name: "link", link: "/etc"}}},
+		{name: "escaping link", entries: []entry{{name: "a/link", link: "../../outside"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			archive := filepath.Join(dir, "bad.tar.gz")
+			writeTarGz(t, archive, tt.entries)
+
+			err := Extract(archive, filepath.Join(dir, "out"))
+			if err == nil || !strings.Contains(err.Error(), "escapes the extraction directory") {
+				t.Fatalf("Extract() error = %v, want escape error", err)
+			}
+		})
+	}
+}
+
+func TestExtractRefusesWritingThroughLinks(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []entry
+	}{
+		{name: "chained links", entries: []entry{
+			{name: "a", link: "."},
+			{name: "a/b", link: ".."},
+			{name: "b/escaped.txt", body: "x"},
+		}},
+		{name: "file through link", entries: []entry{
+			{name: "a", link: "."},
+			{name: "a/escaped.txt", body: "x"},
+		}},
+		{name: "link through link", entries: []entry{
+			{name: "c", link: "."},
+			{name: "d", link: "c/.."},
+		}},
+		{name: "up after name", entries: []entry{
+			{name: "d", link: "sub/.."},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			archive := filepath.Join(dir, "bad.tar.gz")
+			writeTarGz(t, archive, tt.entries)
+
+			dest := filepath.Join(dir, "out", "dest")
+			if err := Extract(archive, dest); err == nil {
+				t.Fatalf("Extract() error = nil, want the archive refused")
+			}
+			if _, err := os.Lstat(filepath.Join(dir, "out", "escaped.txt")); !os.IsNotExist(err) {
+				t.Fatalf("Lstat() error = %v, want nothing written outside the extraction directory", err)
+			}
+		})
+	}
+}
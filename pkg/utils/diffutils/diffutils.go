@@ -0,0 +1,164 @@
+package diffutils
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+const (
+	// contextLines is how many unchanged lines surround each hunk.
+	contextLines = 3
+	// maxCells bounds the lines(a) * lines(b) table the diff is computed
+	// from, so huge files are reported as differing instead.
+	maxCells = 4_000_000
+)
+
+type edit struct {
+	op   byte // ' ', '-' or '+'
+	line string
+}
+
+// Unified returns a unified diff from a to b, labelled with the old and new
+// names, or "" when they are equal. Binary content and files too large to
+// diff get a one-line note instead.
+func Unified(oldName, newName string, a, b []byte) string {
+	if bytes.Equal(a, b) {
+		return ""
+	}
+	if bytes.IndexByte(a, 0) >= 0 || bytes.IndexByte(b, 0) >= 0 {
+		return fmt.Sprintf("binary files %s and %s differ\n", oldName, newName)
+	}
+	al, bl := splitLines(a), splitLines(b)
+	if len(al)*len(bl) > maxCells {
+		return fmt.Sprintf("files %s and %s differ (too large to diff)\n", oldName, newName)
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", oldName, newName)
+	edits := diffLines(al, bl)
+	for start := 0; start < len(edits); {
+		// Find the next change and the run of edits its hunk covers: up to
+		// the last change followed by more than 2*contextLines unchanged lines.
+		first := start
+		for first < len(edits) && edits[first].op == ' ' {
+			first++
+		}
+		if first == len(edits) {
+			break
+		}
+		end := first
+		for i, same := first, 0; i < len(edits); i++ {
+			if edits[i].op == ' ' {
+				if same++; same > 2*contextLines {
+					break
+				}
+				continue
+			}
+			same = 0
+			end = i + 1
+		}
+		from, to := max(first-contextLines, start), min(end+contextLines, len(edits))
+		writeHunk(&out, edits, from, to)
+		start = to
+	}
+	return out.String()
+}
+
+// writeHunk writes edits[from:to] with its @@ header.
+func writeHunk(out *strings.Builder, edits []edit, from, to int) {
+	oldStart, newStart := 1, 1
+	for _, e := range edits[:from] {
+		if e.op != '+' {
+			oldStart++
+		}
+		if e.op != '-' {
+			newStart++
+		}
+	}
+	var oldCount, newCount int
+	for _, e := range edits[from:to] {
+		if e.op != '+' {
+			oldCount++
+		}
+		if e.op != '-' {
+			newCount++
+		}
+	}
+	if oldCount == 0 {
+		oldStart--
+	}
+	if newCount == 0 {
+		newStart--
+	}
+
+	fmt.Fprintf(out, "@@ -%s +%s @@\n", hunkRange(oldStart, oldCount), hunkRange(newStart, newCount))
+	for _, e := range edits[from:to] {
+		out.WriteByte(e.op)
+		out.WriteString(e.line)
+		if !strings.HasSuffix(e.line, "\n") {
+			out.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+}
+
+func hunkRange(start, count int) string {
+	if count == 1 {
+		return fmt.Sprint(start)
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
+
+// splitLines splits b after each newline, keeping them.
+func splitLines(b []byte) []string {
+	if len(b) == 0 {
+		return nil
+	}
+	lines := strings.SplitAfter(string(b), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffLines returns the edits turning a into b, from their longest common
+// subsequence of lines.
+func diffLines(a, b []string) []edit {
+	// lcs[i][j] is the length of the LCS of a[i:] and b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	edits := make([]edit, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			edits = append(edits, edit{' ', a[i]})
+			i, j = i+1, j+1
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			edits = append(edits, edit{'-', a[i]})
+			i++
+		default:
+			edits = append(edits, edit{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		edits = append(edits, edit{'-', a[i]})
+	}
+	for ; j < len(b); j++ {
+		edits = append(edits, edit{'+', b[j]})
+	}
+	return edits
+}
@@ -0,0 +1,44 @@
+package diffutils
+
+import "testing"
+
+func TestUnified(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want string
+	}{
+		{name: "equal", a: "a\nb\n", b: "a\nb\n", want: ""},
+		{
+			name: "changed line",
+			a:    "1\n2\n3\n4\n5\n6\n7\n8\n9\n",
+			b:    "1\n2\n3\n4\nfive\n6\n7\n8\n9\n",
+			want: "--- old\n+++ new\n@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+five\n 6\n 7\n 8\n",
+		},
+		{
+			name: "separate hunks",
+			a:    "a\n1\n2\n3\n4\n5\n6\n7\nb\n",
+			b:    "A\n1\n2\n3\n4\n5\n6\n7\nB\n",
+			want: "--- old\n+++ new\n@@ -1,4 +1,4 @@\n-a\n+A\n 1\n 2\n 3\n@@ -6,4 +6,4 @@\n 5\n 6\n 7\n-b\n+B\n",
+		},
+		{
+			name: "from empty",
+			a:    "",
+			b:    "new\n",
+			want: "--- old\n+++ new\n@@ -0,0 +1 @@\n+new\n",
+		},
+		{
+			name: "missing final newline",
+			a:    "x\n",
+			b:    "x",
+			want: "--- old\n+++ new\n@@ -1 +1 @@\n-x\n+x\n\\ No newline at end of file\n",
+		},
+		{name: "binary", a: "a\x00", b: "b\x00", want: "binary files old and new differ\n"},
+	}
+
+	for _, tt := range tests {
+		if got := Unified("old", "new", []byte(tt.a), []byte(tt.b)); got != tt.want {
+			t.Errorf("%s: Unified() =\n%s\nwant\n%s", tt.name, got, tt.want)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package fileutils
 
 import (
 	"cmp"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
@@ -9,6 +10,10 @@ import (
 	"path/filepath"
 	"slices"
 	"strings"
+	"syscall"
+	"time"
+
+	"github.com/olimci/tohru/pkg/digest"
 )
 
 func ExpandHome(path string) string {
@@ -43,7 +48,24 @@ func AbsPath(path string) (string, error) {
 	return filepath.Clean(abs), nil
 }
 
+// CopyOptions controls CopyPathWith and CopyFileWith.
+type CopyOptions struct {
+	// PreserveTimes gives copied files and directories the modification time
+	// of their source. Access times are left alone; reading the source to
+	// copy it has already updated its own.
+	PreserveTimes bool
+	// Exclude lists paths inside a copied directory that are not copied,
+	// along with everything under them. They are matched against the walked
+	// paths, so must be spelled from the source path.
+	Exclude []string
+}
+
 func CopyFile(src, dest string) error {
+	return CopyFileWith(src, dest, CopyOptions{})
+}
+
+// CopyFileWith is CopyFile with options.
+func CopyFileWith(src, dest string, opts CopyOptions) error {
 	srcInfo, err := os.Stat(src)
 	if err != nil {
 		return fmt.Errorf("stat source file %s: %w", src, err)
@@ -84,6 +106,12 @@ func CopyFile(src, dest string) error {
 		_ = os.Remove(tmpDest)
 		return fmt.Errorf("close temporary file %s: %w", tmpDest, closeErr)
 	}
+	if opts.PreserveTimes {
+		if err := os.Chtimes(tmpDest, time.Time{}, srcInfo.ModTime()); err != nil {
+			_ = os.Remove(tmpDest)
+			return fmt.Errorf("set times of %s: %w", tmpDest, err)
+		}
+	}
 
 	if err := os.Rename(tmpDest, dest); err != nil {
 		_ = os.Remove(tmpDest)
@@ -93,9 +121,52 @@ func CopyFile(src, dest string) error {
 	return nil
 }
 
+// WriteFile atomically writes data to path with the given permissions,
+// creating parent directories as needed.
+func WriteFile(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("create parent directory for %s: %w", path, err)
+	}
+
+	f, err := os.CreateTemp(dir, filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("create temporary file for %s: %w", path, err)
+	}
+	tmp := f.Name()
+	if err := f.Chmod(perm); err != nil {
+		_ = f.Close()
+		_ = os.Remove(tmp)
+		return fmt.Errorf("chmod temporary file %s: %w", tmp, err)
+	}
+
+	_, writeErr := f.Write(data)
+	closeErr := f.Close()
+	if writeErr != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("write %s: %w", tmp, writeErr)
+	}
+	if closeErr != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("close temporary file %s: %w", tmp, closeErr)
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("replace %s with %s: %w", path, tmp, err)
+	}
+
+	return nil
+}
+
 // CopyPath copies a filesystem object at src to dest.
 // It preserves symlink targets, regular file modes, and directory structure.
 func CopyPath(src, dest string) error {
+	return CopyPathWith(src, dest, CopyOptions{})
+}
+
+// CopyPathWith is CopyPath with options.
+func CopyPathWith(src, dest string, opts CopyOptions) error {
 	info, err := os.Lstat(src)
 	if err != nil {
 		return fmt.Errorf("stat source path %s: %w", src, err)
@@ -115,14 +186,38 @@ func CopyPath(src, dest string) error {
 		}
 		return nil
 	case info.Mode().IsRegular():
-		return CopyFile(src, dest)
+		return CopyFileWith(src, dest, opts)
 	case info.IsDir():
-		return copyDir(src, dest)
+		return replaceDir(src, dest, opts)
 	default:
 		return fmt.Errorf("unsupported source type at %s (%s)", src, info.Mode().String())
 	}
 }
 
+// Size returns the total size of the regular files at or under path.
+// Symlinks are not followed and count as nothing.
+func Size(path string) (int64, error) {
+	var total int64
+	err := filepath.WalkDir(path, func(_ string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		total += info.Size()
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("size of %s: %w", path, err)
+	}
+	return total, nil
+}
+
 func RemovePath(path string) error {
 	clean := filepath.Clean(path)
 	if clean == "." || clean == string(filepath.Separator) {
@@ -144,6 +239,73 @@ func RemovePath(path string) error {
 	return os.Remove(clean)
 }
 
+// ErrUnexpectedContent is returned by RemovePathExpecting when the path no
+// longer holds the expected object.
+var ErrUnexpectedContent = errors.New("path changed before it could be removed")
+
+// RemovePathExpecting removes path only if it still matches the expected
+// digest. The path is first renamed into a fresh temporary directory beside
+// it, so the object that is checked is the one that gets removed even if
+// something replaces path in the meantime. On a mismatch it is moved back and
+// ErrUnexpectedContent is returned. An empty expected digest is RemovePath.
+func RemovePathExpecting(path, expected string) error {
+	return RemovePathExpectingWith(path, expected, digest.Options{})
+}
+
+// RemovePathExpectingWith is RemovePathExpecting, digesting path with opts.
+// The paths opts excludes are spelled from path, as for digest.ForPathWith.
+func RemovePathExpectingWith(path, expected string, opts digest.Options) error {
+	if expected == "" {
+		return RemovePath(path)
+	}
+	clean := filepath.Clean(path)
+	if clean == "." || clean == string(filepath.Separator) {
+		return fmt.Errorf("refusing to remove unsafe path: %s", path)
+	}
+	if _, err := os.Lstat(clean); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	aside, err := os.MkdirTemp(filepath.Dir(clean), filepath.Base(clean)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("create temporary directory for %s: %w", path, err)
+	}
+	moved := filepath.Join(aside, filepath.Base(clean))
+	if err := os.Rename(clean, moved); err != nil {
+		_ = os.Remove(aside)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("move %s aside: %w", path, err)
+	}
+
+	exclude := make([]string, 0, len(opts.Exclude))
+	for _, excluded := range opts.Exclude {
+		if rel, err := filepath.Rel(clean, excluded); err == nil && !Escapes(rel) {
+			exclude = append(exclude, filepath.Join(moved, rel))
+		}
+	}
+	d, err := digest.ForPathWith(moved, digest.Options{Exclude: exclude})
+	if err != nil || d.String() != expected {
+		// Don't move it back over whatever has appeared at path since.
+		if _, statErr := os.Lstat(clean); !os.IsNotExist(statErr) {
+			return fmt.Errorf("%s: %w, and was replaced again; it is kept at %s", path, ErrUnexpectedContent, moved)
+		}
+		if restoreErr := os.Rename(moved, clean); restoreErr != nil {
+			return fmt.Errorf("move %s back from %s: %w", path, moved, restoreErr)
+		}
+		_ = os.Remove(aside)
+		if err != nil {
+			return fmt.Errorf("digest %s: %w", path, err)
+		}
+		return fmt.Errorf("%s: %w", path, ErrUnexpectedContent)
+	}
+	return os.RemoveAll(aside)
+}
+
 func PathDepth(path string) int {
 	return len(SplitPathParts(path))
 }
@@ -204,11 +366,136 @@ func SplitPathParts(path string) []string {
 	return parts
 }
 
-func copyDir(srcRoot, destRoot string) error {
+// replaceDir copies the directory at src to dest by building the copy in a
+// temporary sibling of dest and renaming it into place, so a failed copy
+// leaves dest as it was rather than half merged with src. Whatever was at
+// dest is moved aside for the swap and removed after it; between the two
+// renames dest briefly doesn't exist, but it never holds a partial tree. A
+// dest that can't be moved, such as a mount point, is refilled in place by
+// replaceDirContents instead.
+func replaceDir(src, dest string, opts CopyOptions) error {
+	parent := filepath.Dir(dest)
+	if err := os.MkdirAll(parent, 0o755); err != nil {
+		return fmt.Errorf("create parent directory for %s: %w", dest, err)
+	}
+	tmp, err := os.MkdirTemp(parent, filepath.Base(dest)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("create temporary directory for %s: %w", dest, err)
+	}
+	keep := false
+	defer func() {
+		if !keep {
+			_ = os.RemoveAll(tmp)
+		}
+	}()
+
+	staged := filepath.Join(tmp, "new")
+	if err := copyDir(src, staged, opts); err != nil {
+		return err
+	}
+
+	old := filepath.Join(tmp, "old")
+	err = os.Rename(dest, old)
+	switch {
+	case errors.Is(err, syscall.EXDEV) || errors.Is(err, syscall.EBUSY):
+		return replaceDirContents(src, dest, opts)
+	case err != nil && !errors.Is(err, os.ErrNotExist):
+		return fmt.Errorf("move %s aside: %w", dest, err)
+	}
+	if err := os.Rename(staged, dest); err != nil {
+		if _, statErr := os.Lstat(old); statErr == nil {
+			if restoreErr := os.Rename(old, dest); restoreErr != nil {
+				keep = true
+				return fmt.Errorf("move copy of %s into %s: %w (previous contents are kept at %s)", src, dest, err, old)
+			}
+		}
+		return fmt.Errorf("move copy of %s into %s: %w", src, dest, err)
+	}
+	return nil
+}
+
+// replaceDirContents is replaceDir for a dest that can't be renamed. The
+// copy is built in a temporary directory inside dest, so it is on dest's
+// filesystem and a failed copy still leaves the old entries alone; only then
+// are they removed and the new ones renamed in. Unlike replaceDir the swap
+// is not a single rename, so an interruption during it can leave a mix.
+func replaceDirContents(src, dest string, opts CopyOptions) error {
+	tmp, err := os.MkdirTemp(dest, ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("create temporary directory in %s: %w", dest, err)
+	}
+	defer os.RemoveAll(tmp)
+
+	staged := filepath.Join(tmp, "new")
+	if err := copyDir(src, staged, opts); err != nil {
+		return err
+	}
+
+	old, err := os.ReadDir(dest)
+	if err != nil {
+		return fmt.Errorf("read %s: %w", dest, err)
+	}
+	for _, entry := range old {
+		path := filepath.Join(dest, entry.Name())
+		if path == tmp {
+			continue
+		}
+		if err := RemovePath(path); err != nil {
+			return fmt.Errorf("remove %s: %w", path, err)
+		}
+	}
+	entries, err := os.ReadDir(staged)
+	if err != nil {
+		return fmt.Errorf("read %s: %w", staged, err)
+	}
+	for _, entry := range entries {
+		if err := os.Rename(filepath.Join(staged, entry.Name()), filepath.Join(dest, entry.Name())); err != nil {
+			return fmt.Errorf("move %s into %s: %w", entry.Name(), dest, err)
+		}
+	}
+
+	info, err := os.Stat(staged)
+	if err != nil {
+		return fmt.Errorf("stat %s: %w", staged, err)
+	}
+	// Removed now rather than deferred, since removing it touches dest.
+	if err := os.RemoveAll(tmp); err != nil {
+		return fmt.Errorf("remove %s: %w", tmp, err)
+	}
+	if err := os.Chmod(dest, info.Mode().Perm()); err != nil {
+		return fmt.Errorf("chmod %s: %w", dest, err)
+	}
+	if opts.PreserveTimes {
+		if err := os.Chtimes(dest, time.Time{}, info.ModTime()); err != nil {
+			return fmt.Errorf("set times of %s: %w", dest, err)
+		}
+	}
+	return nil
+}
+
+func copyDir(srcRoot, destRoot string, opts CopyOptions) error {
+	// Directory times are set once the walk is done, deepest first, since
+	// copying into a directory updates its modification time.
+	type dirTime struct {
+		path    string
+		modTime time.Time
+	}
+	var dirTimes []dirTime
+	excluded := make(map[string]struct{}, len(opts.Exclude))
+	for _, path := range opts.Exclude {
+		excluded[filepath.Clean(path)] = struct{}{}
+	}
+
 	err := filepath.WalkDir(srcRoot, func(srcPath string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
+		if _, ok := excluded[srcPath]; ok && srcPath != srcRoot {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
 
 		rel, err := filepath.Rel(srcRoot, srcPath)
 		if err != nil {
@@ -241,8 +528,9 @@ func copyDir(srcRoot, destRoot string) error {
 			if err := os.MkdirAll(destPath, info.Mode().Perm()); err != nil {
 				return err
 			}
+			dirTimes = append(dirTimes, dirTime{destPath, info.ModTime()})
 		case info.Mode().IsRegular():
-			if err := CopyFile(srcPath, destPath); err != nil {
+			if err := CopyFileWith(srcPath, destPath, opts); err != nil {
 				return err
 			}
 		default:
@@ -255,5 +543,13 @@ func copyDir(srcRoot, destRoot string) error {
 		return fmt.Errorf("copy directory %s to %s: %w", srcRoot, destRoot, err)
 	}
 
+	if opts.PreserveTimes {
+		for _, dir := range slices.Backward(dirTimes) {
+			if err := os.Chtimes(dir.path, time.Time{}, dir.modTime); err != nil {
+				return fmt.Errorf("set times of %s: %w", dir.path, err)
+			}
+		}
+	}
+
 	return nil
 }
@@ -0,0 +1,178 @@
+package fileutils
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/olimci/tohru/pkg/digest"
+)
+
+func TestCopyPathWithPreservesTimes(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	file := filepath.Join(src, "nested", "file")
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		t.Fatalf("MkdirAll() error = %v", err)
+	}
+	if err := os.WriteFile(file, []byte("content\n"), 0o644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	old := time.Date(2020, time.March, 1, 12, 0, 0, 0, time.UTC)
+	for _, path := range []string{file, filepath.Dir(file), src} {
+		if err := os.Chtimes(path, old, old); err != nil {
+			t.Fatalf("Chtimes() error = %v", err)
+		}
+	}
+
+	const tolerance = time.Second
+	modTime := func(path string) time.Time {
+		t.Helper()
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("Stat() error = %v", err)
+		}
+		return info.ModTime()
+	}
+
+	preserved := filepath.Join(dir, "preserved")
+	if err := CopyPathWith(src, preserved, CopyOptions{PreserveTimes: true}); err != nil {
+		t.Fatalf("CopyPathWith() error = %v", err)
+	}
+	for _, rel := range []string{"", "nested", filepath.Join("nested", "file")} {
+		got := modTime(filepath.Join(preserved, rel))
+		if d := got.Sub(old).Abs(); d > tolerance {
+			t.Errorf("mtime of %q = %s, want %s", rel, got, old)
+		}
+	}
+
+	plain := filepath.Join(dir, "plain")
+	if err := CopyPath(src, plain); err != nil {
+		t.Fatalf("CopyPath() error = %v", err)
+	}
+	if got := modTime(filepath.Join(plain, "nested", "file")); got.Sub(old).Abs() <= tolerance {
+		t.Errorf("CopyPath() kept the source mtime %s without PreserveTimes", got)
+	}
+}
+
+func TestCopyPathReplacesDirectories(t *testing.T) {
+	for name, replace := range map[string]func(src, dest string, opts CopyOptions) error{
+		"CopyPath":           CopyPathWith,
+		"replaceDirContents": replaceDirContents,
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			src := filepath.Join(dir, "src")
+			dest := filepath.Join(dir, "dest")
+			for path, content := range map[string]string{
+				filepath.Join(src, "a"):         "new\n",
+				filepath.Join(dest, "a"):        "old\n",
+				filepath.Join(dest, "old"):      "old\n",
+				filepath.Join(dest, "sub", "f"): "old\n",
+			} {
+				if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+					t.Fatalf("MkdirAll() error = %v", err)
+				}
+				if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+					t.Fatalf("WriteFile() error = %v", err)
+				}
+			}
+			listing := func() []string {
+				t.Helper()
+				var paths []string
+				if err := filepath.WalkDir(dir, func(path string, _ os.DirEntry, err error) error {
+					paths = append(paths, path)
+					return err
+				}); err != nil {
+					t.Fatalf("WalkDir() error = %v", err)
+				}
+				return paths
+			}
+
+			// The copy fails on the fifo, after a has been copied.
+			if err := syscall.Mkfifo(filepath.Join(src, "b"), 0o644); err != nil {
+				t.Fatalf("Mkfifo() error = %v", err)
+			}
+			before := listing()
+			if err := replace(src, dest, CopyOptions{}); err == nil {
+				t.Fatalf("copy of a fifo error = nil, want it rejected")
+			}
+			if after := listing(); !slices.Equal(after, before) {
+				t.Fatalf("failed copy left %v, want %v unchanged", after, before)
+			}
+			if raw, err := os.ReadFile(filepath.Join(dest, "a")); err != nil || string(raw) != "old\n" {
+				t.Fatalf("dest/a = %q, %v, want the old contents", raw, err)
+			}
+
+			if err := os.Remove(filepath.Join(src, "b")); err != nil {
+				t.Fatalf("Remove() error = %v", err)
+			}
+			if err := replace(src, dest, CopyOptions{}); err != nil {
+				t.Fatalf("copy error = %v", err)
+			}
+			entries, err := os.ReadDir(dest)
+			if err != nil {
+				t.Fatalf("ReadDir() error = %v", err)
+			}
+			if len(entries) != 1 || entries[0].Name() != "a" {
+				t.Fatalf("dest holds %v, want only a, not merged with the old tree", entries)
+			}
+			if raw, err := os.ReadFile(filepath.Join(dest, "a")); err != nil || string(raw) != "new\n" {
+				t.Fatalf("dest/a = %q, %v, want the new contents", raw, err)
+			}
+			if entries, _ := os.ReadDir(dir); len(entries) != 2 {
+				t.Fatalf("directory holds %d entries, want no temporary directory left behind", len(entries))
+			}
+		})
+	}
+}
+
+func TestRemovePathExpecting(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "managed")
+	if err := os.MkdirAll(filepath.Join(path, "sub"), 0o755); err != nil {
+		t.Fatalf("MkdirAll() error = %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(path, "sub", "file"), []byte("managed\n"), 0o644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	checked, err := digest.ForPath(path)
+	if err != nil {
+		t.Fatalf("ForPath() error = %v", err)
+	}
+
+	// Swap the directory for a file between the check and the remove.
+	if err := os.RemoveAll(path); err != nil {
+		t.Fatalf("RemoveAll() error = %v", err)
+	}
+	if err := os.WriteFile(path, []byte("someone else's\n"), 0o644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if err := RemovePathExpecting(path, checked.String()); !errors.Is(err, ErrUnexpectedContent) {
+		t.Fatalf("RemovePathExpecting() error = %v, want ErrUnexpectedContent", err)
+	}
+	if raw, err := os.ReadFile(path); err != nil || string(raw) != "someone else's\n" {
+		t.Fatalf("swapped file = %q, %v, want it left in place", raw, err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Fatalf("directory holds %d entries, want no temporary directory left behind", len(entries))
+	}
+
+	current, err := digest.ForPath(path)
+	if err != nil {
+		t.Fatalf("ForPath() error = %v", err)
+	}
+	if err := RemovePathExpecting(path, current.String()); err != nil {
+		t.Fatalf("RemovePathExpecting() error = %v", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Fatalf("directory holds %d entries, want the path removed", len(entries))
+	}
+	if err := RemovePathExpecting(path, current.String()); err != nil {
+		t.Fatalf("RemovePathExpecting() on a missing path error = %v, want nil", err)
+	}
+}
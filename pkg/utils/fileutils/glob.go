@@ -0,0 +1,144 @@
+package fileutils
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// MatchGlob reports whether name matches pattern. Patterns use path.Match
+// syntax per segment, a "**" segment matches zero or more whole segments, and
+// "{a,b}" matches either alternative, see ExpandBraces.
+func MatchGlob(pattern, name string) (bool, error) {
+	patterns, err := ExpandBraces(pattern)
+	if err != nil {
+		return false, err
+	}
+	segments := splitGlob(name)
+	for _, p := range patterns {
+		if ok, err := matchSegments(splitGlob(p), segments); err != nil || ok {
+			return ok, err
+		}
+	}
+	return false, nil
+}
+
+// ValidateGlob reports whether pattern is malformed, whatever it would be
+// matched against.
+func ValidateGlob(pattern string) error {
+	patterns, err := ExpandBraces(pattern)
+	if err != nil {
+		return err
+	}
+	for _, p := range patterns {
+		for _, segment := range splitGlob(p) {
+			if _, err := path.Match(segment, ""); err != nil {
+				return fmt.Errorf("%w: %q", err, pattern)
+			}
+		}
+	}
+	return nil
+}
+
+// ExpandBraces expands each "{a,b,...}" group in pattern into one pattern per
+// alternative, left to right, so "{a,b}/{c,d}" gives four. Groups may nest,
+// and a backslash escapes the character after it.
+func ExpandBraces(pattern string) ([]string, error) {
+	depth, open := 0, -1
+	var commas []int
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			i++
+		case '{':
+			if depth == 0 {
+				open = i
+			}
+			depth++
+		case ',':
+			if depth == 1 {
+				commas = append(commas, i)
+			}
+		case '}':
+			if depth == 0 {
+				return nil, fmt.Errorf("%w: unmatched } in %q", path.ErrBadPattern, pattern)
+			}
+			if depth--; depth > 0 {
+				continue
+			}
+			prefix, suffix := pattern[:open], pattern[i+1:]
+			var out []string
+			start := open + 1
+			for _, end := range append(commas, i) {
+				expanded, err := ExpandBraces(prefix + pattern[start:end] + suffix)
+				if err != nil {
+					return nil, err
+				}
+				out = append(out, expanded...)
+				start = end + 1
+			}
+			return out, nil
+		}
+	}
+	if depth > 0 {
+		return nil, fmt.Errorf("%w: unmatched { in %q", path.ErrBadPattern, pattern)
+	}
+	return []string{pattern}, nil
+}
+
+// MatchFilters reports whether name is selected by include and exclude
+// patterns: it must match at least one include (when any are given) and no
+// exclude.
+func MatchFilters(include, exclude []string, name string) (bool, error) {
+	for _, pattern := range exclude {
+		ok, err := MatchGlob(pattern, name)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			return false, nil
+		}
+	}
+	if len(include) == 0 {
+		return true, nil
+	}
+	for _, pattern := range include {
+		ok, err := MatchGlob(pattern, name)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func splitGlob(raw string) []string {
+	return strings.Split(strings.Trim(filepath.ToSlash(raw), "/"), "/")
+}
+
+func matchSegments(pattern, name []string) (bool, error) {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			rest := pattern[1:]
+			for i := 0; i <= len(name); i++ {
+				ok, err := matchSegments(rest, name[i:])
+				if err != nil || ok {
+					return ok, err
+				}
+			}
+			return false, nil
+		}
+		if len(name) == 0 {
+			return false, nil
+		}
+		ok, err := path.Match(pattern[0], name[0])
+		if err != nil || !ok {
+			return false, err
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0, nil
+}
@@ -0,0 +1,88 @@
+package fileutils
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{"/home/u/.config/**", "/home/u/.config/nvim/init.lua", true},
+		{"/home/u/.config/**", "/home/u/.config", true},
+		{"/home/u/.config/*", "/home/u/.config/nvim/init.lua", false},
+		{"/home/u/**/*.lua", "/home/u/.config/nvim/init.lua", true},
+		{"/home/u/**/*.lua", "/home/u/init.lua", true},
+		{"/home/u/.zsh*", "/home/u/.zshrc", true},
+		{"/home/u/.zsh*", "/home/u/.bashrc", false},
+		{"file:*", "file:sha256:abc", true},
+		{"/home/u/.{zsh,bash}rc", "/home/u/.bashrc", true},
+		{"/home/u/.{zsh,bash}rc", "/home/u/.kshrc", false},
+		{"/home/u/**/*.{conf,toml}", "/home/u/.config/app/app.toml", true},
+	}
+
+	for _, tt := range tests {
+		got, err := MatchGlob(tt.pattern, tt.name)
+		if err != nil {
+			t.Fatalf("MatchGlob(%q, %q) error = %v", tt.pattern, tt.name, err)
+		}
+		if got != tt.want {
+			t.Errorf("MatchGlob(%q, %q) = %v, want %v", tt.pattern, tt.name, got, tt.want)
+		}
+	}
+
+	if _, err := MatchGlob("[", "x"); err == nil {
+		t.Fatalf("MatchGlob(\"[\") error = nil, want bad pattern")
+	}
+}
+
+func TestExpandBraces(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    []string
+	}{
+		{"plain", []string{"plain"}},
+		{"a.{conf,toml}", []string{"a.conf", "a.toml"}},
+		{"{a,b}/{c,d}", []string{"a/c", "a/d", "b/c", "b/d"}},
+		{"x{a,b{1,2}}", []string{"xa", "xb1", "xb2"}},
+		{"{,.}zshrc", []string{"zshrc", ".zshrc"}},
+		{`\{a,b\}`, []string{`\{a,b\}`}},
+	}
+	for _, tt := range tests {
+		got, err := ExpandBraces(tt.pattern)
+		if err != nil {
+			t.Fatalf("ExpandBraces(%q) error = %v", tt.pattern, err)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("ExpandBraces(%q) = %q, want %q", tt.pattern, got, tt.want)
+		}
+	}
+
+	for _, pattern := range []string{"{a,b", "a,b}"} {
+		if _, err := ExpandBraces(pattern); err == nil {
+			t.Errorf("ExpandBraces(%q) error = nil, want unmatched brace", pattern)
+		}
+	}
+}
+
+func TestMatchFilters(t *testing.T) {
+	include := []string{"/home/u/.config/**"}
+	exclude := []string{"/home/u/.config/secret/**"}
+
+	for name, want := range map[string]bool{
+		"/home/u/.config/nvim":       true,
+		"/home/u/.config/secret/key": false,
+		"/home/u/.zshrc":             false,
+	} {
+		got, err := MatchFilters(include, exclude, name)
+		if err != nil {
+			t.Fatalf("MatchFilters(%q) error = %v", name, err)
+		}
+		if got != want {
+			t.Errorf("MatchFilters(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package fileutils
+
+import (
+	"errors"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy retries filesystem calls that fail with errors network
+// filesystems return transiently. The zero value makes a single attempt.
+type RetryPolicy struct {
+	Retries int           // attempts after the first
+	Backoff time.Duration // delay before the first retry, doubled for each one after
+}
+
+var sleep = time.Sleep
+
+// Do calls fn until it succeeds, fails with an error that isn't transient, or
+// the retries run out, and returns the last error.
+func (p RetryPolicy) Do(fn func() error) error {
+	err := fn()
+	delay := p.Backoff
+	for i := 0; i < p.Retries && IsTransient(err); i++ {
+		sleep(delay)
+		delay *= 2
+		err = fn()
+	}
+	return err
+}
+
+// IsTransient reports whether err is worth retrying: EAGAIN, EINTR or ESTALE.
+// Anything else, including ENOENT and EACCES, is treated as permanent.
+func IsTransient(err error) bool {
+	return errors.Is(err, syscall.EAGAIN) ||
+		errors.Is(err, syscall.EINTR) ||
+		errors.Is(err, syscall.ESTALE)
+}
@@ -0,0 +1,52 @@
+package fileutils
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyRetriesOnlyTransientErrors(t *testing.T) {
+	var slept []time.Duration
+	sleep = func(d time.Duration) { slept = append(slept, d) }
+	t.Cleanup(func() { sleep = time.Sleep })
+
+	tests := []struct {
+		name      string
+		failures  []error
+		retries   int
+		wantCalls int
+		wantErr   error
+	}{
+		{"recovers from transient errors", []error{syscall.ESTALE, syscall.EAGAIN}, 3, 3, nil},
+		{"gives up after retries", []error{syscall.EAGAIN, syscall.EAGAIN, syscall.EAGAIN}, 2, 3, syscall.EAGAIN},
+		{"zero value makes one attempt", []error{syscall.EAGAIN}, 0, 1, syscall.EAGAIN},
+		{"does not retry ENOENT", []error{syscall.ENOENT}, 3, 1, os.ErrNotExist},
+		{"does not retry EACCES", []error{syscall.EACCES}, 3, 1, os.ErrPermission},
+	}
+
+	for _, tt := range tests {
+		slept = nil
+		calls := 0
+		err := RetryPolicy{Retries: tt.retries, Backoff: time.Millisecond}.Do(func() error {
+			calls++
+			if calls <= len(tt.failures) {
+				return &os.PathError{Op: "rename", Path: "x", Err: tt.failures[calls-1]}
+			}
+			return nil
+		})
+		if calls != tt.wantCalls {
+			t.Errorf("%s: calls = %d, want %d", tt.name, calls, tt.wantCalls)
+		}
+		if tt.wantErr == nil && err != nil || tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: error = %v, want %v", tt.name, err, tt.wantErr)
+		}
+		for i, d := range slept {
+			if want := time.Millisecond << i; d != want {
+				t.Errorf("%s: backoff %d = %s, want %s", tt.name, i, d, want)
+			}
+		}
+	}
+}
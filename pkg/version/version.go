@@ -1,6 +1,8 @@
 package version
 
 import (
+	"cmp"
+	"errors"
 	"fmt"
 	"strconv"
 	"strings"
@@ -8,6 +10,9 @@ import (
 
 const Version = "0.2.0"
 
+// ErrMajorVersion reports a major version mismatch, which is always breaking.
+var ErrMajorVersion = errors.New("unsupported major version")
+
 func Banner(repoLink string) string {
 	return fmt.Sprintf(`░▀█▀░█▀█░█░█░█▀▄░█░█ v%s
 ░░█░░█░█░█▀█░█▀▄░█░█
@@ -19,13 +24,23 @@ type SemVer struct {
 	Major int
 	Minor int
 	Patch int
+	Pre   string // dot-separated pre-release identifiers, e.g. "rc.1"
+	Build string // build metadata, which is ignored when comparing
 }
 
 func (v SemVer) String() string {
-	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if v.Pre != "" {
+		s += "-" + v.Pre
+	}
+	if v.Build != "" {
+		s += "+" + v.Build
+	}
+	return s
 }
 
-// ParseSemVer parses versions in the form "MAJOR.MINOR.PATCH" with an optional "v" prefix.
+// ParseSemVer parses versions in the form "MAJOR.MINOR.PATCH" with an optional
+// "v" prefix, "-PRERELEASE" and "+BUILD" suffix.
 func ParseSemVer(raw string) (SemVer, error) {
 	value := strings.TrimSpace(raw)
 	if value == "" {
@@ -33,6 +48,15 @@ func ParseSemVer(raw string) (SemVer, error) {
 	}
 
 	value = strings.TrimPrefix(value, "v")
+	value, build, hasBuild := strings.Cut(value, "+")
+	value, pre, hasPre := strings.Cut(value, "-")
+	if hasBuild && !validIdentifiers(build, false) {
+		return SemVer{}, fmt.Errorf("invalid build metadata in %q", raw)
+	}
+	if hasPre && !validIdentifiers(pre, true) {
+		return SemVer{}, fmt.Errorf("invalid pre-release version in %q", raw)
+	}
+
 	parts := strings.Split(value, ".")
 	if len(parts) != 3 {
 		return SemVer{}, fmt.Errorf("invalid semantic version %q (expected MAJOR.MINOR.PATCH)", raw)
@@ -55,9 +79,32 @@ func ParseSemVer(raw string) (SemVer, error) {
 		Major: major,
 		Minor: minor,
 		Patch: patch,
+		Pre:   pre,
+		Build: build,
 	}, nil
 }
 
+// validIdentifiers reports whether raw is a non-empty list of dot-separated
+// alphanumeric identifiers. Numeric pre-release identifiers may not have
+// leading zeros.
+func validIdentifiers(raw string, pre bool) bool {
+	for id := range strings.SplitSeq(raw, ".") {
+		if id == "" || strings.ContainsFunc(id, func(r rune) bool {
+			return !(r == '-' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z')
+		}) {
+			return false
+		}
+		if pre && len(id) > 1 && id[0] == '0' && isNumeric(id) {
+			return false
+		}
+	}
+	return true
+}
+
+func isNumeric(id string) bool {
+	return !strings.ContainsFunc(id, func(r rune) bool { return r < '0' || r > '9' })
+}
+
 // EnsureCompatible validates whether a target version is supported by the current app version.
 // Empty versions are treated as compatible for backward compatibility with older configs/manifests.
 func EnsureCompatible(target string) error {
@@ -76,7 +123,7 @@ func EnsureCompatible(target string) error {
 	}
 
 	if required.Major != current.Major {
-		return fmt.Errorf("unsupported major version %d (current major is %d)", required.Major, current.Major)
+		return fmt.Errorf("%w %d (current major is %d)", ErrMajorVersion, required.Major, current.Major)
 	}
 	if compare(current, required) < 0 {
 		return fmt.Errorf("requires tohru >= %s (current %s)", required.String(), current.String())
@@ -85,6 +132,25 @@ func EnsureCompatible(target string) error {
 	return nil
 }
 
+// Newer reports whether v is a later version than the running one. An empty
+// v is not newer.
+func Newer(v string) (bool, error) {
+	value := strings.TrimSpace(v)
+	if value == "" {
+		return false, nil
+	}
+
+	current, err := ParseSemVer(Version)
+	if err != nil {
+		return false, fmt.Errorf("parse current version %q: %w", Version, err)
+	}
+	other, err := ParseSemVer(value)
+	if err != nil {
+		return false, err
+	}
+	return compare(other, current) > 0, nil
+}
+
 func compare(a, b SemVer) int {
 	if a.Major != b.Major {
 		if a.Major < b.Major {
@@ -104,5 +170,43 @@ func compare(a, b SemVer) int {
 		}
 		return 1
 	}
-	return 0
+	return comparePre(a.Pre, b.Pre)
+}
+
+// comparePre orders pre-release versions as semver does: a release is newer
+// than any of its pre-releases, numeric identifiers compare numerically and
+// sort before alphanumeric ones, and a longer list wins a common prefix.
+func comparePre(a, b string) int {
+	switch {
+	case a == b:
+		return 0
+	case a == "":
+		return 1
+	case b == "":
+		return -1
+	}
+
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := range min(len(as), len(bs)) {
+		x, y := as[i], bs[i]
+		xNum, yNum := isNumeric(x), isNumeric(y)
+		switch {
+		case xNum && yNum:
+			if c := cmp.Compare(len(x), len(y)); c != 0 {
+				return c
+			}
+			if c := strings.Compare(x, y); c != 0 {
+				return c
+			}
+		case xNum:
+			return -1
+		case yNum:
+			return 1
+		default:
+			if c := strings.Compare(x, y); c != 0 {
+				return c
+			}
+		}
+	}
+	return cmp.Compare(len(as), len(bs))
 }
@@ -0,0 +1,113 @@
+package version
+
+import "testing"
+
+func TestParseSemVer(t *testing.T) {
+	tests := []struct {
+		raw     string
+		want    SemVer
+		wantErr bool
+	}{
+		{raw: "v1.2.3", want: SemVer{Major: 1, Minor: 2, Patch: 3}},
+		{raw: "1.2.3-rc.1", want: SemVer{Major: 1, Minor: 2, Patch: 3, Pre: "rc.1"}},
+		{raw: "1.2.3+build.5", want: SemVer{Major: 1, Minor: 2, Patch: 3, Build: "build.5"}},
+		{raw: "1.2.3-beta-2+exp.sha.5114f85", want: SemVer{Major: 1, Minor: 2, Patch: 3, Pre: "beta-2", Build: "exp.sha.5114f85"}},
+		{raw: "1.2.3-", wantErr: true},
+		{raw: "1.2.3-rc..1", wantErr: true},
+		{raw: "1.2.3-01", wantErr: true},
+		{raw: "1.2.3+", wantErr: true},
+		{raw: "1.2", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseSemVer(tt.raw)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseSemVer(%q) = %+v, want error", tt.raw, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("ParseSemVer(%q) = %+v, %v, want %+v", tt.raw, got, err, tt.want)
+		}
+	}
+}
+
+func TestCompareOrdersPreReleases(t *testing.T) {
+	// Each version is lower than the one after it.
+	ordered := []string{
+		"1.0.0-alpha",
+		"1.0.0-alpha.1",
+		"1.0.0-alpha.beta",
+		"1.0.0-beta",
+		"1.0.0-beta.2",
+		"1.0.0-beta.11",
+		"1.0.0-rc.1",
+		"1.0.0",
+		"1.0.1-rc.1",
+	}
+	for i := range len(ordered) - 1 {
+		a, _ := ParseSemVer(ordered[i])
+		b, _ := ParseSemVer(ordered[i+1])
+		if got := compare(a, b); got != -1 {
+			t.Errorf("compare(%s, %s) = %d, want -1", a, b, got)
+		}
+		if got := compare(b, a); got != 1 {
+			t.Errorf("compare(%s, %s) = %d, want 1", b, a, got)
+		}
+	}
+
+	a, _ := ParseSemVer("1.2.3+build.1")
+	b, _ := ParseSemVer("1.2.3+build.2")
+	if got := compare(a, b); got != 0 {
+		t.Errorf("compare(%s, %s) = %d, want build metadata ignored", a, b, got)
+	}
+}
+
+func TestEnsureCompatibleAcceptsPreReleases(t *testing.T) {
+	current, err := ParseSemVer(Version)
+	if err != nil {
+		t.Fatalf("ParseSemVer(Version) error = %v", err)
+	}
+	next := SemVer{Major: current.Major, Minor: current.Minor, Patch: current.Patch + 1}
+
+	for _, target := range []string{current.String() + "-rc.1", current.String() + "+build.7"} {
+		if err := EnsureCompatible(target); err != nil {
+			t.Errorf("EnsureCompatible(%q) error = %v", target, err)
+		}
+	}
+	if err := EnsureCompatible(next.String() + "-rc.1"); err == nil {
+		t.Errorf("EnsureCompatible(%q) = nil, want newer version required", next.String()+"-rc.1")
+	}
+}
+
+func TestNewer(t *testing.T) {
+	current, err := ParseSemVer(Version)
+	if err != nil {
+		t.Fatalf("ParseSemVer(Version) error = %v", err)
+	}
+	next := SemVer{Major: current.Major, Minor: current.Minor + 1}
+
+	for _, tt := range []struct {
+		v    string
+		want bool
+	}{
+		{v: "", want: false},
+		{v: Version, want: false},
+		{v: "0.0.1", want: false},
+		{v: current.String() + "-rc.1", want: false},
+		{v: next.String(), want: true},
+		{v: "v" + next.String(), want: true},
+	} {
+		got, err := Newer(tt.v)
+		if err != nil {
+			t.Fatalf("Newer(%q) error = %v", tt.v, err)
+		}
+		if got != tt.want {
+			t.Errorf("Newer(%q) = %v, want %v", tt.v, got, tt.want)
+		}
+	}
+	if _, err := Newer("next"); err == nil {
+		t.Errorf("Newer(%q) error = nil, want unparseable", "next")
+	}
+}